@@ -1,61 +1,349 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/hdpe/mailsling/internal/mailer"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		reconcile(os.Args[2:])
+		return
+	}
+
 	var poll bool
 	var process bool
+	var daemon bool
+	var interval time.Duration
+	var waitTime int64
+	var httpAddr string
+	var dryRun bool
 
 	flag.BoolVar(&poll, "poll", true, "poll SQS for new messages")
 	flag.BoolVar(&process, "process", true, "notify clients of new recipient state")
+	flag.BoolVar(&daemon, "daemon", false, "poll and/or process repeatedly until terminated")
+	flag.DurationVar(&interval, "interval", 10*time.Second, "time to wait between each poll and/or process in daemon mode")
+	flag.Int64Var(&waitTime, "wait-time", 0, "seconds to long poll SQS for new messages, up to 20 (default 20 in daemon mode)")
+	flag.StringVar(&httpAddr, "http", "", "address to accept messages posted to /messages, and MailChimp webhooks "+
+		"posted to /webhooks/mailchimp, on, e.g. :8080 (implies -daemon)")
+	flag.BoolVar(&dryRun, "dry-run", false, "validate polled messages without journaling or deleting them, and log the "+
+		"requests processing would send, without sending them or updating recipients")
 	flag.Parse()
 
-	log := &mailer.Loggers{
+	if httpAddr != "" {
+		daemon = true
+	}
+
+	log := newLoggers()
+
+	if dryRun && daemon {
+		log.Error.Fatalf("-dry-run can't be used with -daemon or -http")
+	}
+
+	if daemon && waitTime == 0 {
+		waitTime = 20
+	}
+
+	var ms mailer.MessageSource
+
+	if sqsURL := os.Getenv("MAILER_SQS_URL"); sqsURL != "" {
+		sqsConfig := mailer.NewSQSConfig(
+			sqsURL,
+			os.Getenv("MAILER_SQS_DEAD_LETTER_URL"),
+			int64(getEnvInt(log, "MAILER_SQS_MAX_MESSAGES", 10)),
+			waitTime,
+			int64(getEnvInt(log, "MAILER_SQS_VISIBILITY_TIMEOUT", 0)),
+		)
+
+		sqsMs, err := mailer.NewSQSMessageSource(log, sqsConfig)
+
+		if err != nil {
+			log.Error.Fatalf("Couldn't create SQS message source: %v", err)
+		}
+
+		ms = sqsMs
+	} else {
+		poll = false
+	}
+
+	// a dry run mustn't change the database, so its schema must already be up to date
+	repo := newRepository(log, !dryRun)
+
+	defer repo.Close()
+
+	lists := newListsConfig(log)
+
+	client, mergeFields := newClient(log, lists)
+
+	defaultListID := os.Getenv("MAILER_MAILCHIMP_DEFAULT_LIST_ID")
+
+	if lists != nil && lists.DefaultListID() != "" {
+		defaultListID = lists.DefaultListID()
+	}
+
+	retry := mailer.NewRetryPolicy(
+		getEnvInt(log, "MAILER_RETRY_MAX_ATTEMPTS", 5),
+		getEnvDuration(log, "MAILER_RETRY_INITIAL_DELAY", time.Minute),
+		getEnvDuration(log, "MAILER_RETRY_MAX_DELAY", 6*time.Hour),
+	)
+
+	confirmation := mailer.NewConfirmationPolicy(
+		getEnvDuration(log, "MAILER_CONFIRMATION_CHECK_INTERVAL", 15*time.Minute),
+		getEnvDuration(log, "MAILER_CONFIRMATION_EXPIRY", 7*24*time.Hour),
+	)
+
+	email := newEmailPolicy(log)
+
+	batch := mailer.NewBatchPolicy(getEnvInt(log, "MAILER_MAILCHIMP_BATCH_THRESHOLD", 0))
+
+	lease := mailer.NewLeasePolicy(getEnvDuration(log, "MAILER_LEASE_DURATION", time.Hour))
+
+	m := mailer.NewMailer(log, ms, defaultListID, lists, repo, client, retry, confirmation, email, batch, lease)
+
+	m.SetWorkers(getEnvInt(log, "MAILER_PROCESS_WORKERS", 1))
+
+	err := m.SetProcessLimits(getEnvInt(log, "MAILER_PROCESS_PAGE_SIZE", 1000),
+		getEnvInt(log, "MAILER_PROCESS_MAX_ITEMS", 0))
+
+	if err != nil {
+		log.Error.Fatalf("Invalid process limits: %v", err)
+	}
+
+	m.SetRunLock(repo, getEnvDuration(log, "MAILER_RUN_LOCK_TTL", time.Hour))
+
+	if dryRun {
+		m.DryRun()
+	}
+
+	if !daemon {
+		m.RunOnce(poll, process)
+		return
+	}
+
+	var server *http.Server
+
+	if httpAddr != "" {
+		mux := http.NewServeMux()
+
+		if secret := os.Getenv("MAILER_HTTP_SECRET"); secret != "" {
+			handler, err := mailer.NewMessageHandler(log, m, secret)
+
+			if err != nil {
+				log.Error.Fatalf("Couldn't create HTTP message handler: %v", err)
+			}
+
+			mux.Handle("/messages", handler)
+		}
+
+		if secret := os.Getenv("MAILER_MAILCHIMP_WEBHOOK_SECRET"); secret != "" {
+			handler, err := mailer.NewWebhookHandler(log, m, secret, mergeFields)
+
+			if err != nil {
+				log.Error.Fatalf("Couldn't create MailChimp webhook handler: %v", err)
+			}
+
+			mux.Handle("/webhooks/mailchimp", handler)
+		}
+
+		if os.Getenv("MAILER_HTTP_SECRET") == "" && os.Getenv("MAILER_MAILCHIMP_WEBHOOK_SECRET") == "" {
+			log.Error.Fatalf("-http requires MAILER_HTTP_SECRET and/or MAILER_MAILCHIMP_WEBHOOK_SECRET")
+		}
+
+		server = &http.Server{Addr: httpAddr, Handler: mux}
+
+		go func() {
+			log.Info.Printf("Serving HTTP on %s", httpAddr)
+
+			err := server.ListenAndServe()
+
+			if err != nil && err != http.ErrServerClosed {
+				log.Error.Fatalf("Couldn't serve HTTP: %v", err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+		log.Info.Printf("Received %v, stopping after work in progress", sig)
+		if server != nil {
+			err := server.Shutdown(context.Background())
+
+			if err != nil {
+				log.Error.Printf("Error shutting down HTTP server: %v", err)
+			}
+		}
+		m.Stop()
+	}()
+
+	log.Info.Printf("Running every %v", interval)
+
+	m.Run(poll, process, interval)
+}
+
+func newLoggers() *mailer.Loggers {
+	return &mailer.Loggers{
 		Info:  log.New(os.Stdout, "", 0),
 		Error: log.New(os.Stderr, "", 0),
 	}
+}
 
-	ms, err := mailer.NewSQSMessageSource(log, os.Getenv("MAILER_SQS_URL"))
+// newRepository opens the repository, migrating its schema if migrateSchema is set.
+func newRepository(log *mailer.Loggers, migrateSchema bool) *mailer.DBRepository {
+	repo, err := mailer.NewRepository(os.Getenv("MAILER_DB_DSN"), migrateSchema)
 
 	if err != nil {
-		log.Error.Fatalf("Couldn't create SQS message source: %v", err)
+		log.Error.Fatalf("Couldn't create repository: %v", err)
 	}
 
-	repo, err := mailer.NewRepository(os.Getenv("MAILER_DB_DSN"))
+	return repo
+}
+
+// newListsConfig loads the lists config file, if any.
+func newListsConfig(log *mailer.Loggers) *mailer.ListsConfig {
+	path := os.Getenv("MAILER_LISTS_CONFIG")
+
+	if path == "" {
+		return nil
+	}
+
+	lists, err := mailer.LoadListsConfig(path)
 
 	if err != nil {
-		log.Error.Fatalf("Couldn't create repository: %v", err)
+		log.Error.Fatalf("Couldn't load lists config: %v", err)
 	}
 
-	defer repo.Close()
+	return lists
+}
+
+// newEmailPolicy creates the email policy, loading the disposable domains file, if any.
+func newEmailPolicy(log *mailer.Loggers) mailer.EmailPolicy {
+	var disposableDomains []string
+
+	if path := os.Getenv("MAILER_EMAIL_DISPOSABLE_DOMAINS"); path != "" {
+		var err error
+		disposableDomains, err = mailer.LoadDisposableDomains(path)
+
+		if err != nil {
+			log.Error.Fatalf("Couldn't load disposable domains: %v", err)
+		}
+	}
+
+	return mailer.NewEmailPolicy(getEnvBool(log, "MAILER_EMAIL_CHECK_MX", false), disposableDomains)
+}
+
+// newClient creates a client routing to the configured lists' destinations' or own clients, then any providers',
+// then MailChimp's.
+func newClient(log *mailer.Loggers, lists *mailer.ListsConfig) (mailer.Client, map[string]mailer.MergeFields) {
+	mergeFields, err := mailer.ParseMergeFields(os.Getenv("MAILER_MAILCHIMP_MERGE_FIELDS"))
+
+	if err != nil {
+		log.Error.Fatalf("Couldn't parse MailChimp merge fields: %v", err)
+	}
 
 	config := mailer.NewClientConfig(
 		os.Getenv("MAILER_MAILCHIMP_API_KEY"),
+		mergeFields,
+		getEnvList("MAILER_MAILCHIMP_DOUBLE_OPT_IN_LISTS"),
 	)
 
-	client := mailer.NewClient(log, config)
+	providerConfigs, err := mailer.ParseProviders(os.Getenv("MAILER_PROVIDERS"))
+
+	if err != nil {
+		log.Error.Fatalf("Couldn't parse providers: %v", err)
+	}
 
-	m := mailer.NewMailer(log, ms, os.Getenv("MAILER_MAILCHIMP_DEFAULT_LIST_ID"), repo, client)
+	clients := make(map[string]mailer.Client)
 
-	if poll {
-		err := m.Poll()
+	for listID, providerConfig := range providerConfigs {
+		clients[listID], err = mailer.NewProviderClient(log, listID, providerConfig)
 
 		if err != nil {
-			log.Error.Printf("Error polling for messages: %v", err)
+			log.Error.Fatalf("Couldn't create client for list %s: %v", listID, err)
 		}
 	}
 
-	if process {
-		err := m.Process()
+	var destinations map[string]map[string]mailer.Client
+
+	if lists != nil {
+		listClients, err := lists.NewClients(log)
 
 		if err != nil {
-			log.Error.Printf("Error processing recipient state: %v ", err)
+			log.Error.Fatalf("Couldn't create clients for lists config: %v", err)
+		}
+
+		for listID, client := range listClients {
+			clients[listID] = client
+		}
+
+		for listID, fields := range lists.MergeFields() {
+			mergeFields[listID] = fields
+		}
+
+		destinations, err = lists.NewDestinationClients(log)
+
+		if err != nil {
+			log.Error.Fatalf("Couldn't create destination clients for lists config: %v", err)
+		}
+	}
+
+	return mailer.NewRoutingClient(clients, destinations, mailer.NewClient(log, config)), mergeFields
+}
+
+func getEnvInt(log *mailer.Loggers, name string, defaultValue int) int {
+	str := os.Getenv(name)
+	if str == "" {
+		return defaultValue
+	}
+	val, err := strconv.Atoi(str)
+	if err != nil {
+		log.Error.Fatalf("Couldn't parse %s: %v", name, err)
+	}
+	return val
+}
+
+func getEnvBool(log *mailer.Loggers, name string, defaultValue bool) bool {
+	str := os.Getenv(name)
+	if str == "" {
+		return defaultValue
+	}
+	val, err := strconv.ParseBool(str)
+	if err != nil {
+		log.Error.Fatalf("Couldn't parse %s: %v", name, err)
+	}
+	return val
+}
+
+func getEnvDuration(log *mailer.Loggers, name string, defaultValue time.Duration) time.Duration {
+	str := os.Getenv(name)
+	if str == "" {
+		return defaultValue
+	}
+	val, err := time.ParseDuration(str)
+	if err != nil {
+		log.Error.Fatalf("Couldn't parse %s: %v", name, err)
+	}
+	return val
+}
+
+func getEnvList(name string) []string {
+	var result []string
+	for _, str := range strings.Split(os.Getenv(name), ",") {
+		if str = strings.TrimSpace(str); str != "" {
+			result = append(result, str)
 		}
 	}
+	return result
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/hdpe/mailsling/internal/mailer"
+)
+
+// reconcile reports, and optionally fixes, differences between the recipients of a list and its members.
+func reconcile(args []string) {
+	var listID string
+	var apply bool
+	var format string
+
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	flags.StringVar(&listID, "list", "", "ID, or configured name, of the list to reconcile")
+	flags.BoolVar(&apply, "apply", false, "update list recipients to match the list's members")
+	flags.StringVar(&format, "format", "text", "format of the report, text or json")
+	flags.Parse(args)
+
+	log := newLoggers()
+
+	if listID == "" {
+		log.Error.Fatalf("-list is required")
+	}
+	if format != "text" && format != "json" {
+		log.Error.Fatalf("Unknown format %q", format)
+	}
+
+	repo := newRepository(log, true)
+
+	defer repo.Close()
+
+	lists := newListsConfig(log)
+
+	if lists != nil {
+		if resolved, ok := lists.Resolve(listID); ok {
+			listID = resolved
+		}
+	}
+
+	client, _ := newClient(log, lists)
+
+	r, err := mailer.NewReconciler(log, repo, client)
+
+	if err != nil {
+		log.Error.Fatalf("Couldn't create reconciler: %v", err)
+	}
+
+	report, err := r.Reconcile(listID, apply)
+
+	if err != nil {
+		log.Error.Fatalf("Couldn't reconcile list %s: %v", listID, err)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+
+	if err != nil {
+		log.Error.Fatalf("Couldn't write report: %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+package mailer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// batcher is implemented by clients able to notify many subscriptions to a list in a single batch of operations, the
+// results of which are got once it has finished.
+type batcher interface {
+	canBatch(listID string) bool
+	// submitBatch submits the operations, getting the batch's ID, and the results of any operations that couldn't
+	// be submitted, e.g. because they were invalid.
+	submitBatch(listID string, ops []batchOperation) (id string, invalid []batchResult, err error)
+	// getBatchResults gets the results of the batch's operations, if it has finished.
+	getBatchResults(listID string, id string) (results []batchResult, finished bool, err error)
+}
+
+// batchOperation is the notification of a subscription's pending status in a batch.
+type batchOperation struct {
+	id            string
+	subscription  subscription
+	currentStatus RecipientStatus
+}
+
+// batchResult is the result of a batch operation: the status its subscription has, or the error it failed with.
+type batchResult struct {
+	operationID string
+	status      RecipientStatus
+	err         error
+}
+
+// BatchPolicy determines which list recipients are notified in batches: those of lists with at least the threshold
+// of them pending, if their clients support batches. A zero threshold notifies them all one at a time.
+type BatchPolicy struct {
+	threshold int
+}
+
+func NewBatchPolicy(threshold int) BatchPolicy {
+	return BatchPolicy{threshold: threshold}
+}
+
+// processBatches updates the list recipients of batches that have finished with the results of their operations.
+// Batches that no longer exist, e.g. because the client has since deleted them, are finished without results.
+func (m *Mailer) processBatches() error {
+	batches, err := m.journal.GetBatches()
+
+	if err != nil {
+		return fmt.Errorf("couldn't get batches: %v", err)
+	}
+
+	for _, b := range batches {
+		if m.isStopping() {
+			break
+		}
+
+		results, finished, err := m.batcher.getBatchResults(b.listID, b.remoteID)
+
+		if isPermanent(err) || isNotFound(err) {
+			m.log.Error.Printf("abandoning batch %s of list %s: %v", b.remoteID, b.listID, err)
+		} else if err != nil {
+			m.log.Error.Printf("couldn't get results of batch %s of list %s: %v", b.remoteID, b.listID, err)
+			continue
+		} else if !finished {
+			continue
+		}
+
+		for _, result := range results {
+			listRecipientID, err := strconv.Atoi(result.operationID)
+			if err != nil {
+				m.log.Error.Printf("ignoring result of unknown operation %q of batch %s", result.operationID, b.remoteID)
+				continue
+			}
+
+			status := getNotifiedStatus(result.status, result.err)
+			if result.err != nil {
+				m.log.Error.Printf("notify of list recipient #%d in batch %s failed: %v", listRecipientID, b.remoteID,
+					result.err)
+			}
+
+			err = m.journal.UpdateBatchedListRecipient(b.id, listRecipientID, status, result.err)
+			if err != nil {
+				return fmt.Errorf("couldn't update recipient: %v", err)
+			}
+		}
+
+		err = m.journal.FinishBatch(b.id)
+		if err != nil {
+			return fmt.Errorf("couldn't finish batch %s: %v", b.remoteID, err)
+		}
+
+		m.log.Info.Printf("Batch %s of list %s finished with %d results", b.remoteID, b.listID, len(results))
+	}
+
+	return nil
+}
+
+// submitBatches submits a batch of the pending list recipients of each list with at least the batch policy's
+// threshold of them, if its client supports batches, getting the remaining list recipients to be notified one at a
+// time.
+func (m *Mailer) submitBatches(rs []listRecipientComposite) ([]listRecipientComposite, error) {
+	return m.forEachBatch(rs, func(listID string, remoteID string, lrs []listRecipientComposite,
+		invalid []batchResult) error {
+		invalidIDs := make(map[int]bool)
+		for _, result := range invalid {
+			listRecipientID, _ := strconv.Atoi(result.operationID)
+			invalidIDs[listRecipientID] = true
+
+			m.log.Error.Printf("notify of new recipient failed: %v", result.err)
+
+			err := m.journal.UpdateListRecipient(listRecipientID, getNotifiedStatus(result.status, result.err),
+				result.err)
+			if err != nil {
+				return fmt.Errorf("couldn't update recipient: %v", err)
+			}
+		}
+
+		if remoteID == "" {
+			return nil
+		}
+
+		var listRecipientIDs []int
+		for _, r := range lrs {
+			if !invalidIDs[r.listRecipientID] {
+				listRecipientIDs = append(listRecipientIDs, r.listRecipientID)
+			}
+		}
+
+		err := m.journal.StartBatch(listID, remoteID, listRecipientIDs)
+		if err != nil {
+			return fmt.Errorf("couldn't record batch %s of list %s: %v", remoteID, listID, err)
+		}
+
+		m.log.Info.Printf("Submitted batch %s of list %s with %d operations", remoteID, listID, len(listRecipientIDs))
+
+		return nil
+	})
+}
+
+// forEachBatch submits batches as submitBatches does, passing each that's submitted, with its list's recipients and
+// the results of those that couldn't be submitted, to the action, rather than recording it.
+func (m *Mailer) forEachBatch(rs []listRecipientComposite, action func(listID string, remoteID string,
+	lrs []listRecipientComposite, invalid []batchResult) error) ([]listRecipientComposite, error) {
+	byList := make(map[string][]listRecipientComposite)
+	var listIDs []string
+	for _, r := range rs {
+		if _, ok := byList[r.listID]; !ok {
+			listIDs = append(listIDs, r.listID)
+		}
+		byList[r.listID] = append(byList[r.listID], r)
+	}
+
+	batched := make(map[string]bool)
+
+	for _, listID := range listIDs {
+		lrs := byList[listID]
+		if len(lrs) < m.batch.threshold || !m.batcher.canBatch(listID) {
+			continue
+		}
+
+		ops := make([]batchOperation, len(lrs))
+		for i, r := range lrs {
+			ops[i] = batchOperation{
+				id:            strconv.Itoa(r.listRecipientID),
+				subscription:  subscription{email: r.email, listID: r.listID, attribs: r.attribs},
+				currentStatus: r.status,
+			}
+		}
+
+		remoteID, invalid, err := m.batcher.submitBatch(listID, ops)
+
+		if err != nil {
+			m.log.Error.Printf("couldn't submit batch of list %s, notifying one at a time: %v", listID, err)
+			continue
+		}
+
+		batched[listID] = true
+
+		err = action(listID, remoteID, lrs, invalid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var remaining []listRecipientComposite
+	for _, r := range rs {
+		if !batched[r.listID] {
+			remaining = append(remaining, r)
+		}
+	}
+	return remaining, nil
+}
+
+// getNotifiedStatus gets the status a list recipient has after notifying its pending status: rejected if that
+// failed permanently, or failed if it failed otherwise.
+func getNotifiedStatus(status RecipientStatus, err error) RecipientStatus {
+	if isPermanent(err) {
+		return RecipientStatuses.Get("rejected")
+	} else if err != nil {
+		return RecipientStatuses.Get("failed")
+	}
+	return status
+}
+
+type batchRequest struct {
+	Operations []batchOperationRequest `json:"operations"`
+}
+
+type batchOperationRequest struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationID string `json:"operation_id"`
+	Body        string `json:"body,omitempty"`
+}
+
+type batchResponse struct {
+	ID              string `json:"id"`
+	Status          string `json:"status"`
+	ResponseBodyURL string `json:"response_body_url"`
+}
+
+type batchOperationResponse struct {
+	StatusCode  int    `json:"status_code"`
+	OperationID string `json:"operation_id"`
+	Response    string `json:"response"`
+}
+
+func (c *mailChimpClient) canBatch(listID string) bool {
+	return true
+}
+
+// submitBatch submits the operations to MailChimp's batch operations endpoint, see
+// https://developer.mailchimp.com/documentation/mailchimp/guides/how-to-use-batch-operations/
+func (c *mailChimpClient) submitBatch(listID string, ops []batchOperation) (string, []batchResult, error) {
+	var request batchRequest
+	var invalid []batchResult
+
+	for _, op := range ops {
+		opRequest, err := c.getBatchOperationRequest(op)
+		if err != nil {
+			invalid = append(invalid, batchResult{operationID: op.id, err: err})
+			continue
+		}
+		request.Operations = append(request.Operations, opRequest)
+	}
+
+	if len(request.Operations) == 0 {
+		return "", invalid, nil
+	}
+
+	var batch batchResponse
+	err := c.ops.execute("POST", "/batches", request, &batch)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return batch.ID, invalid, nil
+}
+
+// unsubscribeOperationSuffix marks the IDs of unsubscribe operations in a batch, whose 404 responses mean the member is
+// already not on the list.
+const unsubscribeOperationSuffix = ":unsubscribe"
+
+func (c *mailChimpClient) getBatchOperationRequest(op batchOperation) (batchOperationRequest, error) {
+	var method, url string
+	var entity interface{}
+	var err error
+	operationID := op.id
+
+	switch op.currentStatus {
+	case RecipientStatuses.Get("new"):
+		method = "PUT"
+		url, entity, err = c.getSubscribeRequest(op.subscription)
+	case RecipientStatuses.Get("unsubscribing"):
+		method = "PATCH"
+		url, entity = c.getUnsubscribeRequest(op.subscription)
+		operationID += unsubscribeOperationSuffix
+	default:
+		err = fmt.Errorf("can't notify status %s", op.currentStatus)
+	}
+
+	if err != nil {
+		return batchOperationRequest{}, err
+	}
+
+	body, err := json.Marshal(entity)
+	if err != nil {
+		panic(err)
+	}
+
+	return batchOperationRequest{Method: method, Path: url, OperationID: operationID, Body: string(body)}, nil
+}
+
+// getBatchResults gets the batch's status, then, if it has finished, the results of its operations from the archive
+// of their responses.
+func (c *mailChimpClient) getBatchResults(listID string, id string) ([]batchResult, bool, error) {
+	var batch batchResponse
+	err := c.ops.execute("GET", fmt.Sprintf("/batches/%s", id), nil, &batch)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if batch.Status != "finished" {
+		return nil, false, nil
+	}
+	if batch.ResponseBodyURL == "" {
+		return nil, true, nil
+	}
+
+	body, err := c.ops.download(batch.ResponseBodyURL)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer body.Close()
+
+	responses, err := readBatchResponses(body)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't read responses of batch %s: %v", id, err)
+	}
+
+	result := make([]batchResult, len(responses))
+	for i, resp := range responses {
+		result[i] = toBatchResult(id, resp)
+	}
+	return result, true, nil
+}
+
+// readBatchResponses reads the responses of a batch's operations from a gzipped tar archive of JSON files, each of
+// an array of responses.
+func readBatchResponses(r io.Reader) ([]batchOperationResponse, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	defer gz.Close()
+
+	var result []batchOperationResponse
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".json") {
+			continue
+		}
+
+		var responses []batchOperationResponse
+		err = json.NewDecoder(archive).Decode(&responses)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode %s: %v", header.Name, err)
+		}
+		result = append(result, responses...)
+	}
+}
+
+// toBatchResult gets the status of an operation's member from a successful response, or the MailChimp error of an
+// unsuccessful one. An unsubscribe of a member that isn't found succeeds, since the member is already not on the list.
+func toBatchResult(batchID string, resp batchOperationResponse) batchResult {
+	operationID := strings.TrimSuffix(resp.OperationID, unsubscribeOperationSuffix)
+	result := batchResult{operationID: operationID}
+
+	if operationID != resp.OperationID && resp.StatusCode == http.StatusNotFound {
+		result.status = RecipientStatuses.Get("unsubscribed")
+		return result
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var member listMemberResponse
+		err := json.Unmarshal([]byte(resp.Response), &member)
+		if err != nil {
+			result.err = fmt.Errorf("error decoding response: %v", err)
+			return result
+		}
+		result.status, result.err = toRecipientStatus(member.Status)
+		return result
+	}
+
+	e := &MailChimpError{}
+	if err := json.Unmarshal([]byte(resp.Response), e); err != nil {
+		e = &MailChimpError{Detail: strings.TrimSpace(resp.Response)}
+	}
+	e.URL = fmt.Sprintf("batch %s operation %s", batchID, operationID)
+	e.Status = resp.StatusCode
+	result.err = e
+	return result
+}
+
+func (c *routingClient) canBatch(listID string) bool {
+	b, ok := c.get(listID).(batcher)
+	return ok && b.canBatch(listID)
+}
+
+func (c *routingClient) submitBatch(listID string, ops []batchOperation) (string, []batchResult, error) {
+	b, ok := c.get(listID).(batcher)
+	if !ok {
+		return "", nil, fmt.Errorf("client of list %s can't batch", listID)
+	}
+	return b.submitBatch(listID, ops)
+}
+
+func (c *routingClient) getBatchResults(listID string, id string) ([]batchResult, bool, error) {
+	b, ok := c.get(listID).(batcher)
+	if !ok {
+		return nil, false, fmt.Errorf("client of list %s can't batch", listID)
+	}
+	return b.getBatchResults(listID, id)
+}
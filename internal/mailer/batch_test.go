@@ -0,0 +1,339 @@
+package mailer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailChimpClient_SubmitBatch(t *testing.T) {
+	var received []string
+	var entity interface{}
+	ops := &testMailChimpOperations{
+		onExecute: func(method string, url string, e interface{}, result interface{}) error {
+			received = append(received, method+" "+url)
+			entity = e
+			result.(*batchResponse).ID = "abc"
+			return nil
+		},
+	}
+	client := &mailChimpClient{ops: ops, mergeFields: map[string]MergeFields{"l": {"first_name": "FNAME"}},
+		doubleOptInLists: map[string]bool{"l": true}}
+
+	id, invalid, err := client.submitBatch("l", []batchOperation{
+		{id: "1", subscription: subscription{email: "a@b.com", listID: "l", attribs: map[string]string{"first_name": "Ron"}},
+			currentStatus: RecipientStatuses.Get("new")},
+		{id: "2", subscription: subscription{email: "c@d.com", listID: "l"},
+			currentStatus: RecipientStatuses.Get("unsubscribing")},
+		{id: "3", subscription: subscription{email: "e@f.com", listID: "l",
+			attribs: map[string]string{"first_name": strings.Repeat("x", 256)}}, currentStatus: RecipientStatuses.Get("new")},
+	})
+
+	if id != "abc" || err != nil {
+		t.Errorf("result got %q, %v, want abc, nil", id, err)
+	}
+	if len(invalid) != 1 || invalid[0].operationID != "3" || !isPermanent(invalid[0].err) {
+		t.Errorf("invalid got %v, want permanent error of operation 3", invalid)
+	}
+	if expected := []string{"POST /batches"}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("requests got %v, want %v", received, expected)
+	}
+	expectedEntity := batchRequest{Operations: []batchOperationRequest{
+		{Method: "PUT", Path: "/lists/l/members/357a20e8c56e69d6f9734d23ef9517e8", OperationID: "1",
+			Body: `{"email_address":"a@b.com","status_if_new":"pending","status":"pending","merge_fields":{"FNAME":"Ron"}}`},
+		{Method: "PATCH", Path: "/lists/l/members/c5d5cc05e15e794cdf17459b53e7a793", OperationID: "2:unsubscribe",
+			Body: `{"status":"unsubscribed"}`},
+	}}
+	if !reflect.DeepEqual(entity, expectedEntity) {
+		t.Errorf("request got %v, want %v", entity, expectedEntity)
+	}
+}
+
+func TestMailChimpClient_GetBatchResults(t *testing.T) {
+	testCases := []struct {
+		label      string
+		batch      batchResponse
+		batchErr   error
+		onDownload func(url string) (io.ReadCloser, error)
+
+		expectedResults  []batchResult
+		expectedFinished bool
+		expectedError    string
+	}{
+		{
+			label: "on unfinished batch",
+			batch: batchResponse{ID: "abc", Status: "started"},
+		},
+		{
+			label: "on finished batch",
+			batch: batchResponse{ID: "abc", Status: "finished", ResponseBodyURL: "https://s3/abc.tar.gz"},
+			onDownload: func(url string) (io.ReadCloser, error) {
+				if url != "https://s3/abc.tar.gz" {
+					return nil, errors.New("unknown url")
+				}
+				return newBatchResponseArchive(t, map[string][]batchOperationResponse{
+					"abc/1.json": {
+						{StatusCode: 200, OperationID: "1", Response: `{"status":"subscribed"}`},
+						{StatusCode: 200, OperationID: "2", Response: `{"status":"pending"}`},
+					},
+					"abc/2.json": {
+						{StatusCode: 200, OperationID: "3", Response: `{"status":"unsubscribed"}`},
+						{StatusCode: 400, OperationID: "4", Response: `{"title":"Invalid Resource"}`},
+					},
+					"abc/3.json": {
+						{StatusCode: 404, OperationID: "5:unsubscribe", Response: `{"title":"Resource Not Found"}`},
+						{StatusCode: 404, OperationID: "6", Response: `{"title":"Resource Not Found"}`},
+					},
+				}), nil
+			},
+
+			expectedResults: []batchResult{
+				{operationID: "1", status: RecipientStatuses.Get("subscribed")},
+				{operationID: "2", status: RecipientStatuses.Get("pending")},
+				{operationID: "3", status: RecipientStatuses.Get("unsubscribed")},
+				{operationID: "4", err: &MailChimpError{URL: "batch abc operation 4", Status: 400,
+					Title: "Invalid Resource"}},
+				{operationID: "5", status: RecipientStatuses.Get("unsubscribed")},
+				{operationID: "6", err: &MailChimpError{URL: "batch abc operation 6", Status: 404,
+					Title: "Resource Not Found"}},
+			},
+			expectedFinished: true,
+		},
+		{
+			label: "on finished batch without operations",
+			batch: batchResponse{ID: "abc", Status: "finished"},
+
+			expectedFinished: true,
+		},
+		{
+			label:    "on batch error",
+			batchErr: errors.New("x"),
+
+			expectedError: "x",
+		},
+		{
+			label: "on download error",
+			batch: batchResponse{ID: "abc", Status: "finished", ResponseBodyURL: "https://s3/abc.tar.gz"},
+			onDownload: func(url string) (io.ReadCloser, error) {
+				return nil, errors.New("x")
+			},
+
+			expectedError: "x",
+		},
+		{
+			label: "on invalid archive",
+			batch: batchResponse{ID: "abc", Status: "finished", ResponseBodyURL: "https://s3/abc.tar.gz"},
+			onDownload: func(url string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(strings.NewReader("x")), nil
+			},
+
+			expectedError: "couldn't read responses of batch abc: ",
+		},
+	}
+
+	for _, tc := range testCases {
+		var received string
+		ops := &testMailChimpOperations{
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				received = method + " " + url
+				*result.(*batchResponse) = tc.batch
+				return tc.batchErr
+			},
+			onDownload: tc.onDownload,
+		}
+		client := &mailChimpClient{ops: ops}
+
+		results, finished, err := client.getBatchResults("l", "abc")
+
+		if received != "GET /batches/abc" {
+			t.Errorf("%v: request got %q, want GET /batches/abc", tc.label, received)
+		}
+		if !reflect.DeepEqual(results, tc.expectedResults) {
+			t.Errorf("%v: results got %v, want %v", tc.label, results, tc.expectedResults)
+		}
+		if finished != tc.expectedFinished {
+			t.Errorf("%v: finished got %v, want %v", tc.label, finished, tc.expectedFinished)
+		}
+		if !errorMessageStartsWith(err, tc.expectedError) {
+			t.Errorf("%v: error got %q, want prefix %q", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestMailer_ProcessWithBatches(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: clock,
+		retry: NewRetryPolicy(3, time.Minute, time.Hour)}
+	for _, email := range []string{"a@b.com", "c@d.com", "e@f.com"} {
+		j.SetRecipientPendingState(email, []string{"a"}, RecipientStatuses.Get("new"), nil, "{}")
+	}
+	j.SetRecipientPendingState("g@h.com", []string{"b"}, RecipientStatuses.Get("unsubscribing"), nil, "{}")
+
+	notifier := &testClientNotifier{
+		onNotify: func(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
+			return RecipientStatuses.Get("unsubscribed"), nil
+		},
+	}
+	b := &testBatcher{
+		onSubmitBatch: func(listID string, ops []batchOperation) (string, []batchResult, error) {
+			return "abc", []batchResult{{operationID: ops[2].id, err: errors.New("invalid")}}, nil
+		},
+		onGetBatchResults: func(listID string, id string) ([]batchResult, bool, error) {
+			return nil, false, nil
+		},
+	}
+	m := &Mailer{log: NOOPLog, journal: j, notifier: notifier, clock: clock, batch: NewBatchPolicy(2), batcher: b,
+		stop: make(chan struct{})}
+
+	if err := m.Process(); err != nil {
+		t.Fatalf("Process() error got %q, want nil", err)
+	}
+
+	if len(b.submitted) != 1 || b.submitted[0].listID != "a" || len(b.submitted[0].ops) != 3 ||
+		b.submitted[0].ops[0].subscription.email != "a@b.com" ||
+		b.submitted[0].ops[0].currentStatus != RecipientStatuses.Get("new") {
+		t.Errorf("submitted got %v, want batch of new recipients of a", b.submitted)
+	}
+	if len(notifier.received) != 1 || notifier.received[0].subscription.email != "g@h.com" {
+		t.Errorf("notified got %v, want g@h.com", notifier.received)
+	}
+	if pending, _ := getRecipientPendingState(j); len(pending) != 0 {
+		t.Errorf("pending after submitting batch got %v, want none", pending)
+	}
+	if batches, _ := j.GetBatches(); len(batches) != 1 || batches[0].remoteID != "abc" || batches[0].listID != "a" {
+		t.Errorf("batches got %v, want abc of a", batches)
+	}
+
+	ids := make([]int, 3)
+	for i, op := range b.submitted[0].ops {
+		ids[i] = mustParseInt(t, op.id)
+	}
+	expectListRecipientStatus(t, j, ids[2], RecipientStatuses.Get("failed"))
+
+	b.onGetBatchResults = func(listID string, id string) ([]batchResult, bool, error) {
+		return []batchResult{
+			{operationID: b.submitted[0].ops[0].id, status: RecipientStatuses.Get("subscribed")},
+			{operationID: b.submitted[0].ops[1].id, err: &MailChimpError{Status: 400}},
+		}, true, nil
+	}
+
+	if err := m.Process(); err != nil {
+		t.Fatalf("Process() error got %q, want nil", err)
+	}
+
+	expectListRecipientStatus(t, j, ids[0], RecipientStatuses.Get("subscribed"))
+	expectListRecipientStatus(t, j, ids[1], RecipientStatuses.Get("rejected"))
+	if batches, _ := j.GetBatches(); len(batches) != 0 {
+		t.Errorf("batches after finishing got %v, want none", batches)
+	}
+	if len(b.submitted) != 1 {
+		t.Errorf("submitted after finishing got %v, want only the first batch", b.submitted)
+	}
+}
+
+func TestMailer_ProcessBatchesAbandonsMissingBatch(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: clock}
+	j.SetRecipientPendingState("a@b.com", []string{"a"}, RecipientStatuses.Get("new"), nil, "{}")
+	pending, _ := getRecipientPendingState(j)
+	j.StartBatch("a", "abc", []int{pending[0].listRecipientID})
+
+	b := &testBatcher{
+		onGetBatchResults: func(listID string, id string) ([]batchResult, bool, error) {
+			return nil, false, &MailChimpError{Status: 404}
+		},
+	}
+	m := &Mailer{log: NOOPLog, journal: j, batcher: b, stop: make(chan struct{})}
+
+	if err := m.processBatches(); err != nil {
+		t.Fatalf("processBatches() error got %q, want nil", err)
+	}
+
+	if batches, _ := j.GetBatches(); len(batches) != 0 {
+		t.Errorf("batches got %v, want none", batches)
+	}
+	if pending, _ := getRecipientPendingState(j); len(pending) != 1 {
+		t.Errorf("pending got %v, want a@b.com", pending)
+	}
+}
+
+func expectListRecipientStatus(t *testing.T, j *repositoryJournal, id int, expected RecipientStatus) {
+	j.repo.DoInTx(func(tx *sql.Tx) error {
+		lr, err := j.repo.GetListRecipient(tx, id)
+		if err != nil || lr.status != expected || lr.batchID != 0 {
+			t.Errorf("list recipient #%d got %v, %v, want %s and in no batch", id, lr, err, expected)
+		}
+		return nil
+	})
+}
+
+func mustParseInt(t *testing.T, s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+// newBatchResponseArchive creates a gzipped tar archive of JSON files of responses, like MailChimp's.
+func newBatchResponseArchive(t *testing.T, files map[string][]batchOperationResponse) io.ReadCloser {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+
+	archive.WriteHeader(&tar.Header{Name: "abc/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, name := range names {
+		b, err := json.Marshal(files[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(b))})
+		archive.Write(b)
+	}
+
+	archive.Close()
+	gz.Close()
+
+	return ioutil.NopCloser(&buf)
+}
+
+type submitBatchParams struct {
+	listID string
+	ops    []batchOperation
+}
+
+type testBatcher struct {
+	submitted         []submitBatchParams
+	onSubmitBatch     func(listID string, ops []batchOperation) (string, []batchResult, error)
+	onGetBatchResults func(listID string, id string) ([]batchResult, bool, error)
+}
+
+func (b *testBatcher) canBatch(listID string) bool {
+	return true
+}
+
+func (b *testBatcher) submitBatch(listID string, ops []batchOperation) (string, []batchResult, error) {
+	b.submitted = append(b.submitted, submitBatchParams{listID: listID, ops: ops})
+	return b.onSubmitBatch(listID, ops)
+}
+
+func (b *testBatcher) getBatchResults(listID string, id string) ([]batchResult, bool, error) {
+	return b.onGetBatchResults(listID, id)
+}
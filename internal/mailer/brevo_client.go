@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// brevoClient manages contacts of Brevo (formerly Sendinblue) lists, see https://developers.brevo.com/reference
+type brevoClient struct {
+	ops         *providerOperations
+	mergeFields MergeFields
+}
+
+type brevoCreateContactRequest struct {
+	Email         string            `json:"email"`
+	ListIDs       []int             `json:"listIds"`
+	UpdateEnabled bool              `json:"updateEnabled"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+}
+
+type brevoRemoveContactsRequest struct {
+	Emails []string `json:"emails"`
+}
+
+type brevoContactResponse struct {
+	ListIDs          []int `json:"listIds"`
+	EmailBlacklisted bool  `json:"emailBlacklisted"`
+}
+
+func newBrevoClient(log *Loggers, listID string, config ProviderConfig) (Client, error) {
+	if config.doubleOptIn {
+		return nil, errDoubleOptInNotSupported
+	}
+	if _, err := strconv.Atoi(listID); err != nil {
+		return nil, fmt.Errorf("invalid Brevo list ID %q", listID)
+	}
+	return &brevoClient{
+		ops: &providerOperations{
+			provider: "brevo",
+			ops:      &http.Client{},
+			baseURL:  getBaseURL(config, "https://api.brevo.com/v3"),
+			authorize: func(req *http.Request, body []byte) {
+				req.Header.Set("api-key", config.apiKey)
+			},
+		},
+		mergeFields: config.mergeFields,
+	}, nil
+}
+
+func (c *brevoClient) Subscribe(s subscription) (RecipientStatus, error) {
+	listID, err := strconv.Atoi(s.listID)
+	if err != nil {
+		return RecipientStatuses.None, fmt.Errorf("invalid Brevo list ID %q", s.listID)
+	}
+
+	// upsert, adding existing contacts to the list
+	request := brevoCreateContactRequest{Email: s.email, ListIDs: []int{listID}, UpdateEnabled: true,
+		Attributes: getProviderAttributes(s, c.mergeFields)}
+
+	err = c.ops.executeJSON("POST", "/contacts", request, nil)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	return RecipientStatuses.Get("subscribed"), nil
+}
+
+// Unsubscribe removes the contact from the list. A contact that isn't found is already not in the list, so is
+// unsubscribed too.
+func (c *brevoClient) Unsubscribe(s subscription) error {
+	path := fmt.Sprintf("/contacts/lists/%s/contacts/remove", url.PathEscape(s.listID))
+
+	err := c.ops.executeJSON("POST", path, brevoRemoveContactsRequest{Emails: []string{s.email}}, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// GetStatus gets whether the contact is in the list, contacts who have unsubscribed from all emails being
+// unsubscribed.
+func (c *brevoClient) GetStatus(s subscription) (RecipientStatus, error) {
+	listID, err := strconv.Atoi(s.listID)
+	if err != nil {
+		return RecipientStatuses.None, fmt.Errorf("invalid Brevo list ID %q", s.listID)
+	}
+
+	var contact brevoContactResponse
+	err = c.ops.executeJSON("GET", "/contacts/"+url.PathEscape(s.email), nil, &contact)
+	if isNotFound(err) {
+		return RecipientStatuses.Get("unsubscribed"), nil
+	} else if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	if !contact.EmailBlacklisted {
+		for _, id := range contact.ListIDs {
+			if id == listID {
+				return RecipientStatuses.Get("subscribed"), nil
+			}
+		}
+	}
+
+	return RecipientStatuses.Get("unsubscribed"), nil
+}
@@ -0,0 +1,154 @@
+package mailer
+
+import "testing"
+
+func TestBrevoClient(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		testMethod   func(c Client, s subscription) (RecipientStatus, error)
+		subscription subscription
+		responses    []providerTestResponse
+
+		expectedRequests  []providerTestRequest
+		expectedStatus    RecipientStatus
+		expectedError     string
+		expectedPermanent bool
+	}{
+		{
+			label: "subscribe creates contact",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "12", attribs: map[string]string{"first_name": "Ron", "x": "y"}},
+			responses:    []providerTestResponse{{status: 204}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/contacts",
+					body: `{"email":"a@b.com","listIds":[12],"updateEnabled":true,"attributes":{"FIRSTNAME":"Ron"}}`},
+			},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "subscribe returns permanent error on bad request",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a", listID: "12"},
+			responses:    []providerTestResponse{{status: 400, body: `{"code":"invalid_parameter"}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/contacts", body: `{"email":"a","listIds":[12],"updateEnabled":true}`},
+			},
+			expectedStatus:    RecipientStatuses.None,
+			expectedError:     "HTTP status 400",
+			expectedPermanent: true,
+		},
+		{
+			label: "subscribe returns transient error on list not found",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "12"},
+			responses:    []providerTestResponse{{status: 404, body: `{"code":"document_not_found"}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/contacts", body: `{"email":"a@b.com","listIds":[12],"updateEnabled":true}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "HTTP status 404",
+		},
+		{
+			label: "unsubscribe removes contact from list",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "12"},
+			responses:    []providerTestResponse{{status: 201, body: `{"contacts":{"success":["a@b.com"]}}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/contacts/lists/12/contacts/remove", body: `{"emails":["a@b.com"]}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "unsubscribe succeeds on contact not found",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "12"},
+			responses:    []providerTestResponse{{status: 404, body: `{"code":"document_not_found"}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/contacts/lists/12/contacts/remove", body: `{"emails":["a@b.com"]}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "get status of contact in list",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "12"},
+			responses:    []providerTestResponse{{status: 200, body: `{"listIds":[3,12],"emailBlacklisted":false}`}},
+
+			expectedRequests: []providerTestRequest{{method: "GET", uri: "/contacts/a@b.com"}},
+			expectedStatus:   RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "get status of blacklisted contact",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "12"},
+			responses:    []providerTestResponse{{status: 200, body: `{"listIds":[12],"emailBlacklisted":true}`}},
+
+			expectedRequests: []providerTestRequest{{method: "GET", uri: "/contacts/a@b.com"}},
+			expectedStatus:   RecipientStatuses.Get("unsubscribed"),
+		},
+		{
+			label: "get status of unknown contact",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "12"},
+			responses:    []providerTestResponse{{status: 404}},
+
+			expectedRequests: []providerTestRequest{{method: "GET", uri: "/contacts/a@b.com"}},
+			expectedStatus:   RecipientStatuses.Get("unsubscribed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		s := newProviderTestServer(tc.responses...)
+		c := newProviderTestClient(t, "brevo", "12", s)
+
+		status, err := tc.testMethod(c, tc.subscription)
+		s.Close()
+
+		assertProviderTestRequests(t, tc.label, s.requests, tc.expectedRequests)
+		if status != tc.expectedStatus {
+			t.Errorf("%v: result status got %v, want %v", tc.label, status, tc.expectedStatus)
+		}
+		assertProviderTestError(t, tc.label, err, tc.expectedError)
+		if isPermanent(err) != tc.expectedPermanent {
+			t.Errorf("%v: permanent got %v, want %v", tc.label, isPermanent(err), tc.expectedPermanent)
+		}
+	}
+
+	s := newProviderTestServer()
+	defer s.Close()
+	newProviderTestClient(t, "brevo", "12", s).Subscribe(subscription{email: "a@b.com", listID: "12"})
+
+	if actual := s.requests[0].header.Get("api-key"); actual != "key" {
+		t.Errorf("api-key header got %q, want %q", actual, "key")
+	}
+}
@@ -5,120 +5,517 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type Client interface {
-	Subscribe(s subscription) error
+	// Subscribe subscribes the recipient, returning pending if they must confirm their subscription first.
+	Subscribe(s subscription) (RecipientStatus, error)
 	Unsubscribe(s subscription) error
+	// GetStatus gets the recipient's current status on the list.
+	GetStatus(s subscription) (RecipientStatus, error)
 }
 
+// subscription is a recipient of a list, to be notified to the list's client, or the named destination.
 type subscription struct {
-	email  string
-	listID string
+	email       string
+	listID      string
+	attribs     map[string]string
+	destination string
 }
 
-type postListMemberRequest struct {
-	Email  string `json:"email_address"`
-	Status string `json:"status"`
+type putListMemberRequest struct {
+	Email       string            `json:"email_address"`
+	StatusIfNew string            `json:"status_if_new"`
+	Status      string            `json:"status"`
+	MergeFields map[string]string `json:"merge_fields,omitempty"`
 }
 
 type patchListMemberStatusRequest struct {
 	Status string `json:"status"`
 }
 
+type listMemberResponse struct {
+	Email       string                 `json:"email_address"`
+	Status      string                 `json:"status"`
+	MergeFields map[string]interface{} `json:"merge_fields"`
+}
+
+type listMembersResponse struct {
+	Members    []listMemberResponse `json:"members"`
+	TotalItems int                  `json:"total_items"`
+}
+
+// listMember is a recipient's membership of a list as known by the client.
+type listMember struct {
+	email   string
+	status  RecipientStatus
+	attribs map[string]string
+}
+
+// memberLister is implemented by clients able to list all the members of a list, a page at a time.
+type memberLister interface {
+	ForEachMember(listID string, action func([]listMember) error) error
+}
+
 type clientOperations interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
 type mailChimpExecutor interface {
-	execute(method string, url string, entity interface{}) error
+	execute(method string, url string, entity interface{}, result interface{}) error
+	// download gets the body of a URL outside the API, e.g. a batch's response archive.
+	download(url string) (io.ReadCloser, error)
 }
 
 type mailChimpOperations struct {
 	log    *Loggers
 	ops    clientOperations
 	config MailChimpConfig
+	// limiter, if any, limits the requests made with the API key, and pauses them when rate limited.
+	limiter *rateLimiter
 }
 
-func (o *mailChimpOperations) execute(method string, url string, entity interface{}) error {
-	// https://developer.mailchimp.com/documentation/mailchimp/guides/manage-subscribers-with-the-mailchimp-api/
-	keyParts := strings.Split(o.config.apiKey, "-")
+// getMailChimpDC gets the data center of the API key, the suffix following its dash, see
+// https://developer.mailchimp.com/documentation/mailchimp/guides/manage-subscribers-with-the-mailchimp-api/
+func getMailChimpDC(apiKey string) (string, error) {
+	keyParts := strings.Split(apiKey, "-")
 	if len(keyParts) < 2 {
-		return fmt.Errorf("API key has no DC suffix")
+		return "", errors.New("API key has no DC suffix")
 	}
-	dc := keyParts[1]
+	return keyParts[1], nil
+}
 
-	url = fmt.Sprintf("https://%s.api.mailchimp.com/3.0%s", dc, url)
+// mailChimpMaxRateLimitedAttempts is how many times a request is sent while MailChimp responds that it is rate
+// limited.
+const mailChimpMaxRateLimitedAttempts = 3
 
-	b, err := json.Marshal(entity)
+// execute sends the entity, if any, as JSON, decoding the JSON response into the result, if any. If rate limited,
+// requests with the API key are paused as long as the response's Retry-After header says, and the request repeated.
+func (o *mailChimpOperations) execute(method string, url string, entity interface{}, result interface{}) error {
+	dc, err := getMailChimpDC(o.config.apiKey)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	url = fmt.Sprintf("https://%s.api.mailchimp.com/3.0%s", dc, url)
+
+	var b []byte
+	if entity != nil {
+		var err error
+		b, err = json.Marshal(entity)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		delay, err := o.send(method, url, b, result, attempt < mailChimpMaxRateLimitedAttempts)
+		if err != nil || delay < 0 {
+			return err
+		}
+
+		o.log.Info.Printf("Rate limited by %s, retrying in %v", url, delay)
+		o.limiter.pause(delay)
 	}
+}
 
-	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+// send sends the body, if any, once the limiter, if any, allows, decoding the response into the result, if any. If
+// rate limited and retry is true, it gets the delay the response says to wait before retrying, otherwise -1.
+func (o *mailChimpOperations) send(method string, url string, b []byte, result interface{},
+	retry bool) (time.Duration, error) {
+	var body io.Reader
+	if b != nil {
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+		return -1, fmt.Errorf("error creating request: %v", err)
+	}
+	if b != nil {
+		req.Header["Content-Type"] = []string{"application/json"}
 	}
-	req.Header["Content-Type"] = []string{"application/json"}
 	req.SetBasicAuth("IGNORED", o.config.apiKey)
 
+	if o.limiter != nil {
+		release := o.limiter.acquire()
+		defer release()
+	}
+
 	resp, err := o.ops.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+		return -1, fmt.Errorf("error sending request: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests && retry && o.limiter != nil {
+		delay, ok := getRetryAfter(resp, o.limiter.clock.now())
+		if ok && delay <= mailChimpMaxRetryAfterDelay {
+			return delay, nil
+		}
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b := &bytes.Buffer{}
-		b.ReadFrom(resp.Body)
-		o.log.Error.Println(string(b.Bytes()))
+		return -1, newMailChimpError(url, resp)
+	}
 
-		return fmt.Errorf("error received from %s: HTTP status %d", url, resp.StatusCode)
+	if result != nil {
+		err = json.NewDecoder(resp.Body).Decode(result)
+		if err != nil {
+			return -1, fmt.Errorf("error decoding response: %v", err)
+		}
 	}
 
-	return err
+	return -1, nil
+}
+
+func (o *mailChimpOperations) download(url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, err := o.ops.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, newMailChimpError(url, resp)
+	}
+
+	return resp.Body, nil
+}
+
+// MailChimpError is an error response from the MailChimp API, see
+// https://developer.mailchimp.com/documentation/mailchimp/guides/error-glossary/
+type MailChimpError struct {
+	URL      string                `json:"-"`
+	Type     string                `json:"type"`
+	Title    string                `json:"title"`
+	Status   int                   `json:"status"`
+	Detail   string                `json:"detail"`
+	Instance string                `json:"instance"`
+	Errors   []MailChimpFieldError `json:"errors"`
+}
+
+type MailChimpFieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func newMailChimpError(url string, resp *http.Response) *MailChimpError {
+	e := &MailChimpError{}
+
+	b := &bytes.Buffer{}
+	b.ReadFrom(resp.Body)
+
+	if err := json.Unmarshal(b.Bytes(), e); err != nil {
+		e = &MailChimpError{Detail: strings.TrimSpace(b.String())}
+	}
+
+	e.URL = url
+	e.Status = resp.StatusCode
+
+	return e
+}
+
+func (e *MailChimpError) Error() string {
+	msg := fmt.Sprintf("error received from %s: HTTP status %d", e.URL, e.Status)
+	if e.Title != "" {
+		msg += ": " + e.Title
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, fe := range e.Errors {
+		msg += fmt.Sprintf(" (%s: %s)", fe.Field, fe.Message)
+	}
+	return msg
+}
+
+// Permanent is true if the request was rejected, e.g. because the email address is invalid or has been forgotten,
+// so repeating it would be pointless. Rate limiting and server errors are transient, as is not finding the list,
+// which may be misconfigured, so that its recipients are retried once it's fixed.
+func (e *MailChimpError) Permanent() bool {
+	return e.Status == http.StatusBadRequest
+}
+
+func (e *MailChimpError) HTTPStatus() int {
+	return e.Status
+}
+
+// httpStatusError is implemented by errors caused by an unsuccessful HTTP response.
+type httpStatusError interface {
+	HTTPStatus() int
+}
+
+// permanentError is implemented by errors that may recur no matter how many times an operation is retried.
+type permanentError interface {
+	Permanent() bool
+}
+
+func isPermanent(err error) bool {
+	p, ok := err.(permanentError)
+	return ok && p.Permanent()
+}
+
+// validationError is a request found invalid before it's sent, so repeating it would be pointless.
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+func (e *validationError) Permanent() bool {
+	return true
+}
+
+// isNotFound is true if the error is a 404 response, e.g. for a member that isn't in the list.
+func isNotFound(err error) bool {
+	e, ok := err.(httpStatusError)
+	return ok && e.HTTPStatus() == http.StatusNotFound
 }
 
 type MailChimpConfig struct {
-	apiKey string
+	apiKey           string
+	mergeFields      map[string]MergeFields
+	doubleOptInLists map[string]bool
 }
 
-func NewClientConfig(apiKey string) MailChimpConfig {
-	return MailChimpConfig{apiKey: apiKey}
+// NewClientConfig creates MailChimp configuration. Recipients subscribed to any of the double opt-in lists
+// must confirm their subscription before they are subscribed.
+func NewClientConfig(apiKey string, mergeFields map[string]MergeFields, doubleOptInLists []string) MailChimpConfig {
+	doubleOptIn := make(map[string]bool)
+	for _, listID := range doubleOptInLists {
+		doubleOptIn[listID] = true
+	}
+	return MailChimpConfig{apiKey: apiKey, mergeFields: mergeFields, doubleOptInLists: doubleOptIn}
+}
+
+// MergeFields maps list recipient attribute keys to MailChimp merge tags.
+type MergeFields map[string]string
+
+// https://mailchimp.com/help/getting-started-with-merge-tags/
+var mergeTagPattern = regexp.MustCompile("^[A-Z0-9_]{1,10}$")
+
+const maxMergeFieldValueLength = 255
+
+// ParseMergeFields parses per-list merge field mappings from JSON of the form
+// {"listID": {"attribute": "TAG"}}.
+func ParseMergeFields(str string) (map[string]MergeFields, error) {
+	result := make(map[string]MergeFields)
+	if str == "" {
+		return result, nil
+	}
+
+	err := json.Unmarshal([]byte(str), &result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+
+	for listID, fields := range result {
+		for key, tag := range fields {
+			if !mergeTagPattern.MatchString(tag) {
+				return nil, fmt.Errorf("list %s: invalid merge tag %q for attribute %q", listID, tag, key)
+			}
+		}
+	}
+
+	return result, nil
 }
 
 type mailChimpClient struct {
-	ops mailChimpExecutor
+	ops              mailChimpExecutor
+	mergeFields      map[string]MergeFields
+	doubleOptInLists map[string]bool
+}
+
+func (c *mailChimpClient) Subscribe(s subscription) (RecipientStatus, error) {
+	url, request, err := c.getSubscribeRequest(s)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	var member listMemberResponse
+	err = c.ops.execute("PUT", url, request, &member)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	if request.Status == "pending" && member.Status != "subscribed" {
+		return RecipientStatuses.Get("pending"), nil
+	}
+	return RecipientStatuses.Get("subscribed"), nil
 }
 
-func (c *mailChimpClient) Subscribe(s subscription) error {
-	url := fmt.Sprintf("/lists/%s/members", s.listID)
-	request := postListMemberRequest{Email: s.email, Status: "subscribed"}
+// getSubscribeRequest gets the URL and request of a PUT upserting the subscription's member, so that previously
+// unsubscribed members can be resubscribed.
+func (c *mailChimpClient) getSubscribeRequest(s subscription) (string, putListMemberRequest, error) {
+	mergeFields, err := c.getMergeFields(s)
+	if err != nil {
+		return "", putListMemberRequest{}, err
+	}
 
-	return c.ops.execute("POST", url, request)
+	// MailChimp sends a confirmation email to pending members
+	status := "subscribed"
+	if c.doubleOptInLists[s.listID] {
+		status = "pending"
+	}
+
+	url := fmt.Sprintf("/lists/%s/members/%s", s.listID, getSubscriberID(s))
+	return url, putListMemberRequest{Email: s.email, StatusIfNew: status, Status: status, MergeFields: mergeFields}, nil
 }
 
+func (c *mailChimpClient) getMergeFields(s subscription) (map[string]string, error) {
+	fields := c.mergeFields[s.listID]
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[string]string)
+	for key, value := range s.attribs {
+		tag, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if len(value) > maxMergeFieldValueLength {
+			return nil, &validationError{fmt.Sprintf("invalid merge field %s: value of attribute %q exceeds %d characters",
+				tag, key, maxMergeFieldValueLength)}
+		}
+		result[tag] = value
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	return result, nil
+}
+
+// Unsubscribe unsubscribes the subscription's member. A member that isn't found is already not in the list, so is
+// unsubscribed too.
 func (c *mailChimpClient) Unsubscribe(s subscription) error {
+	url, request := c.getUnsubscribeRequest(s)
+
+	err := c.ops.execute("PATCH", url, request, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// getUnsubscribeRequest gets the URL and request of a PATCH unsubscribing the subscription's member.
+func (c *mailChimpClient) getUnsubscribeRequest(s subscription) (string, patchListMemberStatusRequest) {
+	url := fmt.Sprintf("/lists/%s/members/%s", s.listID, getSubscriberID(s))
+	return url, patchListMemberStatusRequest{Status: "unsubscribed"}
+}
+
+// GetStatus gets the member's status, a member no longer in the list being unsubscribed.
+func (c *mailChimpClient) GetStatus(s subscription) (RecipientStatus, error) {
 	id := getSubscriberID(s)
 
-	url := fmt.Sprintf("/lists/%s/members/%s", s.listID, id)
-	request := patchListMemberStatusRequest{Status: "unsubscribed"}
+	url := fmt.Sprintf("/lists/%s/members/%s?fields=status", s.listID, id)
 
-	return c.ops.execute("PATCH", url, request)
+	var member listMemberResponse
+	err := c.ops.execute("GET", url, nil, &member)
+	if isNotFound(err) {
+		return RecipientStatuses.Get("unsubscribed"), nil
+	} else if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	return toRecipientStatus(member.Status)
 }
 
+const membersPageSize = 1000
+
+// ForEachMember passes pages of the members of the list, with the attributes mapped to their merge fields, to the
+// action, until there are no more or the action returns an error.
+func (c *mailChimpClient) ForEachMember(listID string, action func([]listMember) error) error {
+	for offset := 0; ; offset += membersPageSize {
+		url := fmt.Sprintf("/lists/%s/members?offset=%d&count=%d&fields=total_items,members.email_address,"+
+			"members.status,members.merge_fields", listID, offset, membersPageSize)
+
+		var page listMembersResponse
+		err := c.ops.execute("GET", url, nil, &page)
+		if err != nil {
+			return err
+		}
+
+		members := make([]listMember, len(page.Members))
+		for i, m := range page.Members {
+			status, err := toRecipientStatus(m.Status)
+			if err != nil {
+				return fmt.Errorf("member %s: %v", m.Email, err)
+			}
+			members[i] = listMember{email: m.Email, status: status, attribs: c.getAttributes(listID, m)}
+		}
+
+		if len(members) > 0 {
+			err = action(members)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(page.Members) == 0 || offset+len(page.Members) >= page.TotalItems {
+			return nil
+		}
+	}
+}
+
+// getAttributes gets the attributes mapped to the member's merge fields.
+func (c *mailChimpClient) getAttributes(listID string, m listMemberResponse) map[string]string {
+	result := make(map[string]string)
+	for key, tag := range c.mergeFields[listID] {
+		if value, ok := m.MergeFields[tag]; ok {
+			result[key] = fmt.Sprint(value)
+		}
+	}
+	return result
+}
+
+func toRecipientStatus(memberStatus string) (RecipientStatus, error) {
+	switch memberStatus {
+	case "subscribed":
+		return RecipientStatuses.Get("subscribed"), nil
+	case "pending":
+		return RecipientStatuses.Get("pending"), nil
+	case "cleaned":
+		return RecipientStatuses.Get("cleaned"), nil
+	case "unsubscribed", "archived":
+		return RecipientStatuses.Get("unsubscribed"), nil
+	}
+	return RecipientStatuses.None, fmt.Errorf("unknown member status %q", memberStatus)
+}
+
+// getSubscriberID gets the MailChimp subscriber ID of a subscription: the MD5 hash of its lowercased email.
 func getSubscriberID(s subscription) string {
 	h := md5.New()
-	io.WriteString(h, s.email)
+	io.WriteString(h, strings.ToLower(s.email))
 	id := hex.EncodeToString(h.Sum(nil))
 	return id
 }
 
 func NewClient(log *Loggers, config MailChimpConfig) Client {
-	return &mailChimpClient{ops: &mailChimpOperations{log: log, ops: &http.Client{}, config: config}}
+	return &mailChimpClient{
+		ops: &mailChimpOperations{log: log, ops: &http.Client{}, config: config,
+			limiter: getMailChimpLimiter(config.apiKey)},
+		mergeFields:      config.mergeFields,
+		doubleOptInLists: config.doubleOptInLists,
+	}
 }
 
 type clientNotifier struct {
@@ -128,10 +525,7 @@ type clientNotifier struct {
 func (n *clientNotifier) Notify(s subscription, currentStatus RecipientStatus) (result RecipientStatus, err error) {
 
 	if currentStatus == RecipientStatuses.Get("new") {
-		err = n.client.Subscribe(s)
-		if err == nil {
-			result = RecipientStatuses.Get("subscribed")
-		}
+		result, err = n.client.Subscribe(s)
 	} else if currentStatus == RecipientStatuses.Get("unsubscribing") {
 		err = n.client.Unsubscribe(s)
 		if err == nil {
@@ -139,9 +533,10 @@ func (n *clientNotifier) Notify(s subscription, currentStatus RecipientStatus) (
 		}
 	}
 
-	if err != nil {
-		result = RecipientStatuses.None
-	}
+	return getNotifiedStatus(result, err), err
+}
 
-	return
+// CheckConfirmation gets the status of a recipient pending confirmation of their subscription.
+func (n *clientNotifier) CheckConfirmation(s subscription) (RecipientStatus, error) {
+	return n.client.GetStatus(s)
 }
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMailChimpOperations_Execute(t *testing.T) {
@@ -15,7 +16,7 @@ func TestMailChimpOperations_Execute(t *testing.T) {
 
 	ops := &mailChimpOperations{ops: clientOps, config: config}
 
-	ops.execute("POST", "/path", postListMemberRequest{Email: "a@b.com", Status: "c"})
+	ops.execute("PUT", "/path", putListMemberRequest{Email: "a@b.com", StatusIfNew: "c", Status: "c"}, nil)
 
 	if num := len(clientOps.received); num != 1 {
 		t.Fatalf("invoked Do %d times, want 1", num)
@@ -23,7 +24,7 @@ func TestMailChimpOperations_Execute(t *testing.T) {
 
 	req := clientOps.received[0]
 
-	if actual, expected := req.Method, "POST"; actual != expected {
+	if actual, expected := req.Method, "PUT"; actual != expected {
 		t.Errorf("method got %q, want %q", actual, expected)
 	}
 	if actual, expected := req.URL.String(), "https://dc.api.mailchimp.com/3.0/path"; actual != expected {
@@ -33,7 +34,7 @@ func TestMailChimpOperations_Execute(t *testing.T) {
 	if err != nil {
 		t.Errorf("read error got %q, want nil", err)
 	}
-	if expected := `{"email_address":"a@b.com","status":"c"}`; body != expected {
+	if expected := `{"email_address":"a@b.com","status_if_new":"c","status":"c"}`; body != expected {
 		t.Errorf("request body got %v, want %v", body, expected)
 	}
 	if actual, expected := req.Header["Content-Type"], []string{"application/json"}; !reflect.DeepEqual(actual, expected) {
@@ -47,6 +48,34 @@ func TestMailChimpOperations_Execute(t *testing.T) {
 	}
 }
 
+func TestMailChimpOperations_ExecuteWithoutEntity(t *testing.T) {
+	clientOps := &testClientOperations{onDo: func() (*http.Response, error) {
+		return newClientTestResponseWithBody(200, `{"status":"pending","email_address":"a@b.com"}`), nil
+	}}
+	config := MailChimpConfig{apiKey: "APIKEY-dc"}
+
+	ops := &mailChimpOperations{ops: clientOps, config: config}
+
+	var result listMemberResponse
+	err := ops.execute("GET", "/path", nil, &result)
+
+	if err != nil {
+		t.Fatalf("result error got %q, want nil", err)
+	}
+
+	req := clientOps.received[0]
+
+	if req.Body != nil {
+		t.Errorf("request body got %v, want nil", req.Body)
+	}
+	if actual := req.Header["Content-Type"]; actual != nil {
+		t.Errorf("Content-Type header got %q, want nil", actual)
+	}
+	if expected := (listMemberResponse{Email: "a@b.com", Status: "pending"}); !reflect.DeepEqual(result, expected) {
+		t.Errorf("result got %v, want %v", result, expected)
+	}
+}
+
 func TestMailChimpOperations_ExecuteErrors(t *testing.T) {
 	testCases := []struct {
 		label    string
@@ -86,6 +115,14 @@ func TestMailChimpOperations_ExecuteErrors(t *testing.T) {
 			},
 			expected: "error received from",
 		},
+		{
+			label:  "on invalid response",
+			apiKey: "-x",
+			onDo: func() (*http.Response, error) {
+				return newClientTestResponseWithBody(200, "x"), nil
+			},
+			expected: "error decoding response",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -94,7 +131,8 @@ func TestMailChimpOperations_ExecuteErrors(t *testing.T) {
 
 		ops := &mailChimpOperations{log: NOOPLog, ops: clientOps, config: config}
 
-		err := ops.execute("POST", "/path", subscription{email: "a@b.com"})
+		var result listMemberResponse
+		err := ops.execute("POST", "/path", putListMemberRequest{Email: "a@b.com"}, &result)
 
 		if !errorMessageStartsWith(err, tc.expected) {
 			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expected)
@@ -102,6 +140,199 @@ func TestMailChimpOperations_ExecuteErrors(t *testing.T) {
 	}
 }
 
+func TestMailChimpOperations_ExecuteMailChimpError(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		statusCode int
+		body       string
+
+		expected          *MailChimpError
+		expectedAsString  string
+		expectedPermanent bool
+	}{
+		{
+			label:      "on problem detail",
+			statusCode: 400,
+			body: `{"type":"http://developer.mailchimp.com/documentation/mailchimp/guides/error-glossary/",` +
+				`"title":"Invalid Resource","status":400,"detail":"Your merge fields were invalid.",` +
+				`"instance":"x","errors":[{"field":"FNAME","message":"Please enter a value"}]}`,
+
+			expected: &MailChimpError{
+				URL:      "https://x.api.mailchimp.com/3.0/path",
+				Type:     "http://developer.mailchimp.com/documentation/mailchimp/guides/error-glossary/",
+				Title:    "Invalid Resource",
+				Status:   400,
+				Detail:   "Your merge fields were invalid.",
+				Instance: "x",
+				Errors:   []MailChimpFieldError{{Field: "FNAME", Message: "Please enter a value"}},
+			},
+			expectedAsString: "error received from https://x.api.mailchimp.com/3.0/path: HTTP status 400: " +
+				"Invalid Resource: Your merge fields were invalid. (FNAME: Please enter a value)",
+			expectedPermanent: true,
+		},
+		{
+			label:      "on not found",
+			statusCode: 404,
+			body:       `{"title":"Resource Not Found","status":404}`,
+
+			expected: &MailChimpError{
+				URL:    "https://x.api.mailchimp.com/3.0/path",
+				Title:  "Resource Not Found",
+				Status: 404,
+			},
+			expectedAsString:  "error received from https://x.api.mailchimp.com/3.0/path: HTTP status 404: Resource Not Found",
+			expectedPermanent: false,
+		},
+		{
+			label:      "on too many requests",
+			statusCode: 429,
+			body:       `{"title":"Too Many Requests","status":429}`,
+
+			expected: &MailChimpError{
+				URL:    "https://x.api.mailchimp.com/3.0/path",
+				Title:  "Too Many Requests",
+				Status: 429,
+			},
+			expectedAsString:  "error received from https://x.api.mailchimp.com/3.0/path: HTTP status 429: Too Many Requests",
+			expectedPermanent: false,
+		},
+		{
+			label:      "on server error without problem detail",
+			statusCode: 502,
+			body:       "Bad Gateway\n",
+
+			expected: &MailChimpError{
+				URL:    "https://x.api.mailchimp.com/3.0/path",
+				Status: 502,
+				Detail: "Bad Gateway",
+			},
+			expectedAsString:  "error received from https://x.api.mailchimp.com/3.0/path: HTTP status 502: Bad Gateway",
+			expectedPermanent: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		clientOps := &testClientOperations{onDo: func() (*http.Response, error) {
+			return newClientTestResponseWithBody(tc.statusCode, tc.body), nil
+		}}
+		config := MailChimpConfig{apiKey: "-x"}
+
+		ops := &mailChimpOperations{log: NOOPLog, ops: clientOps, config: config}
+
+		err := ops.execute("PUT", "/path", nil, nil)
+
+		if !reflect.DeepEqual(err, tc.expected) {
+			t.Errorf("%v: result error got %#v, want %#v", tc.label, err, tc.expected)
+		}
+		if !errorMessageEquals(err, tc.expectedAsString) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expectedAsString)
+		}
+		if res := isPermanent(err); res != tc.expectedPermanent {
+			t.Errorf("%v: permanent got %v, want %v", tc.label, res, tc.expectedPermanent)
+		}
+	}
+}
+
+func TestMailChimpOperations_ExecuteRateLimited(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		retryAfter []string
+
+		expectedRequests int
+		expectedSlept    []time.Duration
+		expectedStatus   int
+	}{
+		{
+			label:      "on retry after seconds",
+			retryAfter: []string{"2"},
+
+			expectedRequests: 2,
+			expectedSlept:    []time.Duration{2 * time.Second},
+		},
+		{
+			label:      "on retry after date",
+			retryAfter: []string{"Wed, 28 Mar 2018 01:02:08 GMT"},
+
+			expectedRequests: 2,
+			expectedSlept:    []time.Duration{5 * time.Second},
+		},
+		{
+			label:      "on still rate limited",
+			retryAfter: []string{"1", "1", "1"},
+
+			expectedRequests: 3,
+			expectedSlept:    []time.Duration{time.Second, time.Second},
+			expectedStatus:   429,
+		},
+		{
+			label:      "on retry after too long",
+			retryAfter: []string{"120"},
+
+			expectedRequests: 1,
+			expectedStatus:   429,
+		},
+		{
+			label:      "on no retry after",
+			retryAfter: []string{""},
+
+			expectedRequests: 1,
+			expectedStatus:   429,
+		},
+	}
+
+	for _, tc := range testCases {
+		clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+		limiter := newRateLimiter(1, 1000, 1000)
+		limiter.clock = clock
+		var slept []time.Duration
+		limiter.sleep = func(d time.Duration) {
+			slept = append(slept, d)
+			clock.time = clock.time.Add(d)
+		}
+
+		var bodies []string
+		clientOps := &testClientOperations{}
+		clientOps.onDo = func() (*http.Response, error) {
+			body, _ := read(clientOps.received[len(clientOps.received)-1].Body)
+			bodies = append(bodies, body)
+
+			if n := len(clientOps.received); n <= len(tc.retryAfter) {
+				resp := newClientTestResponseWithBody(429, `{"title":"Too Many Requests","status":429}`)
+				resp.Header = http.Header{}
+				if tc.retryAfter[n-1] != "" {
+					resp.Header.Set("Retry-After", tc.retryAfter[n-1])
+				}
+				return resp, nil
+			}
+			return newClientTestResponse(200), nil
+		}
+		config := MailChimpConfig{apiKey: "-x"}
+
+		ops := &mailChimpOperations{log: NOOPLog, ops: clientOps, config: config, limiter: limiter}
+
+		err := ops.execute("PUT", "/path", patchListMemberStatusRequest{Status: "a"}, nil)
+
+		if num := len(clientOps.received); num != tc.expectedRequests {
+			t.Errorf("%v: invoked Do %d times, want %d", tc.label, num, tc.expectedRequests)
+		}
+		for _, body := range bodies {
+			if expected := `{"status":"a"}`; body != expected {
+				t.Errorf("%v: request body got %v, want %v", tc.label, body, expected)
+			}
+		}
+		if !reflect.DeepEqual(slept, tc.expectedSlept) {
+			t.Errorf("%v: slept got %v, want %v", tc.label, slept, tc.expectedSlept)
+		}
+		if tc.expectedStatus == 0 && err != nil {
+			t.Errorf("%v: result error got %q, want nil", tc.label, err)
+		} else if e, _ := err.(*MailChimpError); tc.expectedStatus != 0 && (e == nil || e.Status != tc.expectedStatus) {
+			t.Errorf("%v: result error got %#v, want status %d", tc.label, err, tc.expectedStatus)
+		}
+	}
+}
+
 func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 	testCases := []struct {
 		label string
@@ -110,7 +341,7 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 		subscription subscription
 
 		executeInvoked bool
-		onExecute      func(method string, url string, entity interface{}) error
+		onExecute      func(method string, url string, entity interface{}, result interface{}) error
 
 		expected error
 	}{
@@ -118,20 +349,22 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 			label: "subscribe invokes execute",
 
 			testMethod: func(c *mailChimpClient, s subscription) error {
-				return c.Subscribe(s)
+				_, err := c.Subscribe(s)
+				return err
 			},
 			subscription: subscription{email: "a@b.com", listID: "c"},
 
 			executeInvoked: true,
-			onExecute: func(method string, url string, entity interface{}) error {
-				if expected := "POST"; method != expected {
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				if expected := "PUT"; method != expected {
 					t.Errorf("subscribe invokes execute: ops Execute got %q, want %q", method, expected)
 				}
-				if expected := "/lists/c/members"; url != expected {
+				// 357a20e8c56e69d6f9734d23ef9517e8 = md5 of a@b.com
+				if expected := "/lists/c/members/357a20e8c56e69d6f9734d23ef9517e8"; url != expected {
 					t.Errorf("subscribe invokes execute: ops Execute got %q, want %q", url, expected)
 				}
-				expectedEntity := postListMemberRequest{Email: "a@b.com", Status: "subscribed"}
-				if entity != expectedEntity {
+				expectedEntity := putListMemberRequest{Email: "a@b.com", StatusIfNew: "subscribed", Status: "subscribed"}
+				if !reflect.DeepEqual(entity, expectedEntity) {
 					t.Errorf("subscribe invokes execute: ops Execute got %q, want %q", entity, expectedEntity)
 				}
 				return nil
@@ -139,16 +372,51 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 
 			expected: nil,
 		},
+		{
+			label: "subscribe invokes execute with merge fields",
+
+			testMethod: func(c *mailChimpClient, s subscription) error {
+				_, err := c.Subscribe(s)
+				return err
+			},
+			subscription: subscription{email: "a@b.com", listID: "c", attribs: map[string]string{"k1": "v1", "k2": "v2"}},
+
+			executeInvoked: true,
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				expectedEntity := putListMemberRequest{Email: "a@b.com", StatusIfNew: "subscribed", Status: "subscribed",
+					MergeFields: map[string]string{"FNAME": "v1"}}
+				if !reflect.DeepEqual(entity, expectedEntity) {
+					t.Errorf("subscribe invokes execute with merge fields: ops Execute got %v, want %v", entity, expectedEntity)
+				}
+				return nil
+			},
+
+			expected: nil,
+		},
+		{
+			label: "returns error on invalid merge field",
+
+			testMethod: func(c *mailChimpClient, s subscription) error {
+				_, err := c.Subscribe(s)
+				return err
+			},
+			subscription: subscription{email: "a@b.com", listID: "c", attribs: map[string]string{"k1": strings.Repeat("x", 256)}},
+
+			executeInvoked: false,
+
+			expected: &validationError{`invalid merge field FNAME: value of attribute "k1" exceeds 255 characters`},
+		},
 		{
 			label: "returns error on subscribe error",
 
 			testMethod: func(c *mailChimpClient, s subscription) error {
-				return c.Subscribe(s)
+				_, err := c.Subscribe(s)
+				return err
 			},
 			subscription: subscription{email: "a@b.com", listID: "c"},
 
 			executeInvoked: true,
-			onExecute: func(method string, url string, entity interface{}) error {
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
 				return errors.New("x")
 			},
 
@@ -163,7 +431,7 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 			subscription: subscription{email: "a@b.com", listID: "c"},
 
 			executeInvoked: true,
-			onExecute: func(method string, url string, entity interface{}) error {
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
 				if expected := "PATCH"; method != expected {
 					t.Errorf("unsubscribe invokes execute: ops Execute got %q, want %q", method, expected)
 				}
@@ -172,7 +440,7 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 					t.Errorf("unsubscribe invokes execute: ops Execute got %q, want %q", url, expected)
 				}
 				expectedEntity := patchListMemberStatusRequest{Status: "unsubscribed"}
-				if entity != expectedEntity {
+				if !reflect.DeepEqual(entity, expectedEntity) {
 					t.Errorf("unsubscribe invokes execute: ops Execute got %q, want %q", entity, expectedEntity)
 				}
 				return nil
@@ -180,6 +448,37 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 
 			expected: nil,
 		},
+		{
+			label: "unsubscribe succeeds on member not found",
+
+			testMethod: func(c *mailChimpClient, s subscription) error {
+				return c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "c"},
+
+			executeInvoked: true,
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				return &MailChimpError{Status: 404}
+			},
+
+			expected: nil,
+		},
+		{
+			label: "returns error on subscribe to list not found",
+
+			testMethod: func(c *mailChimpClient, s subscription) error {
+				_, err := c.Subscribe(s)
+				return err
+			},
+			subscription: subscription{email: "a@b.com", listID: "c"},
+
+			executeInvoked: true,
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				return &MailChimpError{Status: 404}
+			},
+
+			expected: &MailChimpError{Status: 404},
+		},
 		{
 			label: "returns error on unsubscribe error",
 
@@ -189,7 +488,7 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 			subscription: subscription{email: "a@b.com", listID: "c"},
 
 			executeInvoked: true,
-			onExecute: func(method string, url string, entity interface{}) error {
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
 				return errors.New("x")
 			},
 
@@ -200,7 +499,7 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 	for _, tc := range testCases {
 		ops := &testMailChimpOperations{onExecute: tc.onExecute}
 
-		client := &mailChimpClient{ops: ops}
+		client := &mailChimpClient{ops: ops, mergeFields: map[string]MergeFields{"c": {"k1": "FNAME"}}}
 
 		err := tc.testMethod(client, tc.subscription)
 
@@ -213,6 +512,260 @@ func TestMailChimpClient_SubscribeAndUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestMailChimpClient_SubscribeDoubleOptIn(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		listID         string
+		responseStatus string
+
+		expectedEntity putListMemberRequest
+		expectedStatus RecipientStatus
+	}{
+		{
+			label: "subscribes to single opt-in list",
+
+			listID:         "c",
+			responseStatus: "subscribed",
+
+			expectedEntity: putListMemberRequest{Email: "a@b.com", StatusIfNew: "subscribed", Status: "subscribed"},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "subscribes pending to double opt-in list",
+
+			listID:         "d",
+			responseStatus: "pending",
+
+			expectedEntity: putListMemberRequest{Email: "a@b.com", StatusIfNew: "pending", Status: "pending"},
+			expectedStatus: RecipientStatuses.Get("pending"),
+		},
+		{
+			label: "returns subscribed if already confirmed on double opt-in list",
+
+			listID:         "d",
+			responseStatus: "subscribed",
+
+			expectedEntity: putListMemberRequest{Email: "a@b.com", StatusIfNew: "pending", Status: "pending"},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		ops := &testMailChimpOperations{onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+			if !reflect.DeepEqual(entity, tc.expectedEntity) {
+				t.Errorf("%v: ops Execute got %v, want %v", tc.label, entity, tc.expectedEntity)
+			}
+			result.(*listMemberResponse).Status = tc.responseStatus
+			return nil
+		}}
+
+		client := &mailChimpClient{ops: ops, doubleOptInLists: map[string]bool{"d": true}}
+
+		status, err := client.Subscribe(subscription{email: "a@b.com", listID: tc.listID})
+
+		if err != nil {
+			t.Errorf("%v: result error got %q, want nil", tc.label, err)
+		}
+		if status != tc.expectedStatus {
+			t.Errorf("%v: result status got %v, want %v", tc.label, status, tc.expectedStatus)
+		}
+	}
+}
+
+func TestMailChimpClient_GetStatus(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		onExecute func(method string, url string, entity interface{}, result interface{}) error
+
+		expectedStatus RecipientStatus
+		expectedError  error
+	}{
+		{
+			label: "on subscribed",
+
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				if expected := "GET"; method != expected {
+					t.Errorf("on subscribed: ops Execute got %q, want %q", method, expected)
+				}
+				// 357a20e8c56e69d6f9734d23ef9517e8 = md5 of a@b.com
+				if expected := "/lists/c/members/357a20e8c56e69d6f9734d23ef9517e8?fields=status"; url != expected {
+					t.Errorf("on subscribed: ops Execute got %q, want %q", url, expected)
+				}
+				if entity != nil {
+					t.Errorf("on subscribed: ops Execute got %v, want nil", entity)
+				}
+				result.(*listMemberResponse).Status = "subscribed"
+				return nil
+			},
+
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "on pending",
+
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				result.(*listMemberResponse).Status = "pending"
+				return nil
+			},
+
+			expectedStatus: RecipientStatuses.Get("pending"),
+		},
+		{
+			label: "on cleaned",
+
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				result.(*listMemberResponse).Status = "cleaned"
+				return nil
+			},
+
+			expectedStatus: RecipientStatuses.Get("cleaned"),
+		},
+		{
+			label: "on not found",
+
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				return &MailChimpError{Status: 404}
+			},
+
+			expectedStatus: RecipientStatuses.Get("unsubscribed"),
+		},
+		{
+			label: "on unknown status",
+
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				result.(*listMemberResponse).Status = "x"
+				return nil
+			},
+
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  errors.New(`unknown member status "x"`),
+		},
+		{
+			label: "on error",
+
+			onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+				return errors.New("x")
+			},
+
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  errors.New("x"),
+		},
+	}
+
+	for _, tc := range testCases {
+		client := &mailChimpClient{ops: &testMailChimpOperations{onExecute: tc.onExecute}}
+
+		status, err := client.GetStatus(subscription{email: "a@b.com", listID: "c"})
+
+		if status != tc.expectedStatus {
+			t.Errorf("%v: result status got %v, want %v", tc.label, status, tc.expectedStatus)
+		}
+		if !errorEquals(err, tc.expectedError) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestMailChimpClient_ForEachMember(t *testing.T) {
+	var urls []string
+	ops := &testMailChimpOperations{onExecute: func(method string, url string, entity interface{}, result interface{}) error {
+		urls = append(urls, url)
+		page := result.(*listMembersResponse)
+		page.TotalItems = membersPageSize + 1
+		if len(urls) == 1 {
+			page.Members = make([]listMemberResponse, membersPageSize)
+			for i := range page.Members {
+				page.Members[i] = listMemberResponse{Email: "x", Status: "subscribed"}
+			}
+		} else {
+			page.Members = []listMemberResponse{
+				{Email: "a@b.com", Status: "cleaned", MergeFields: map[string]interface{}{"FNAME": "Ron", "AGE": 50.0}},
+			}
+		}
+		return nil
+	}}
+
+	client := &mailChimpClient{ops: ops, mergeFields: map[string]MergeFields{"c": {"first_name": "FNAME", "age": "AGE"}}}
+
+	var pages [][]listMember
+	err := client.ForEachMember("c", func(members []listMember) error {
+		pages = append(pages, members)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("result error got %v, want nil", err)
+	}
+	expectedURLs := []string{
+		"/lists/c/members?offset=0&count=1000&fields=total_items,members.email_address,members.status,members.merge_fields",
+		"/lists/c/members?offset=1000&count=1000&fields=total_items,members.email_address,members.status,members.merge_fields",
+	}
+	if !reflect.DeepEqual(urls, expectedURLs) {
+		t.Errorf("ops Execute urls got %v, want %v", urls, expectedURLs)
+	}
+	if len(pages) != 2 || len(pages[0]) != membersPageSize || len(pages[1]) != 1 {
+		t.Fatalf("result got %d pages, want pages of %d and 1 members", len(pages), membersPageSize)
+	}
+	expected := listMember{email: "a@b.com", status: RecipientStatuses.Get("cleaned"),
+		attribs: map[string]string{"first_name": "Ron", "age": "50"}}
+	if last := pages[1][0]; !reflect.DeepEqual(last, expected) {
+		t.Errorf("last member got %v, want %v", last, expected)
+	}
+
+	urls = nil
+	err = client.ForEachMember("c", func(members []listMember) error {
+		return errors.New("x")
+	})
+
+	if !errorMessageEquals(err, "x") || len(urls) != 1 {
+		t.Errorf("result of action error got %v after %d pages, want x after 1", err, len(urls))
+	}
+}
+
+func TestParseMergeFields(t *testing.T) {
+	testCases := []struct {
+		label string
+		str   string
+
+		expectedResult map[string]MergeFields
+		expectedError  string
+	}{
+		{
+			label:          "on empty",
+			str:            "",
+			expectedResult: map[string]MergeFields{},
+		},
+		{
+			label:          "on valid json",
+			str:            `{"a":{"first_name":"FNAME","last_name":"LNAME"}}`,
+			expectedResult: map[string]MergeFields{"a": {"first_name": "FNAME", "last_name": "LNAME"}},
+		},
+		{
+			label:         "on invalid json",
+			str:           "{",
+			expectedError: "invalid json",
+		},
+		{
+			label:         "on invalid merge tag",
+			str:           `{"a":{"first_name":"fname"}}`,
+			expectedError: `list a: invalid merge tag "fname" for attribute "first_name"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		res, err := ParseMergeFields(tc.str)
+
+		if !reflect.DeepEqual(res, tc.expectedResult) {
+			t.Errorf("%v: result got %v, want %v", tc.label, res, tc.expectedResult)
+		}
+		if !errorMessageStartsWith(err, tc.expectedError) {
+			t.Errorf("%v: result error got %q, want prefix %q", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
 func TestClientNotifier_Notify(t *testing.T) {
 	testSubscription := subscription{email: "x", listID: "y"}
 
@@ -222,7 +775,7 @@ func TestClientNotifier_Notify(t *testing.T) {
 		status RecipientStatus
 
 		subscribeInvoked bool
-		onSubscribe      func(s subscription) error
+		onSubscribe      func(s subscription) (RecipientStatus, error)
 
 		unsubscribeInvoked bool
 		onUnsubscribe      func(s subscription) error
@@ -236,29 +789,68 @@ func TestClientNotifier_Notify(t *testing.T) {
 			status: RecipientStatuses.Get("new"),
 
 			subscribeInvoked: true,
-			onSubscribe: func(s subscription) error {
-				if s != testSubscription {
+			onSubscribe: func(s subscription) (RecipientStatus, error) {
+				if !reflect.DeepEqual(s, testSubscription) {
 					t.Errorf("on status = new: client Subscribe got %q, want %q", s, testSubscription)
 				}
-				return nil
+				return RecipientStatuses.Get("subscribed"), nil
 			},
 
 			expectedStatus: RecipientStatuses.Get("subscribed"),
 			expectedError:  nil,
 		},
+		{
+			label: "returns pending on status = new for double opt-in",
+
+			status: RecipientStatuses.Get("new"),
+
+			subscribeInvoked: true,
+			onSubscribe: func(s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.Get("pending"), nil
+			},
+
+			expectedStatus: RecipientStatuses.Get("pending"),
+			expectedError:  nil,
+		},
 		{
 			label: "returns error on subscribe error",
 
 			status: RecipientStatuses.Get("new"),
 
 			subscribeInvoked: true,
-			onSubscribe: func(s subscription) error {
-				return errors.New("x")
+			onSubscribe: func(s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, errors.New("x")
 			},
 
-			expectedStatus: RecipientStatuses.None,
+			expectedStatus: RecipientStatuses.Get("failed"),
 			expectedError:  errors.New("x"),
 		},
+		{
+			label: "returns rejected on permanent subscribe error",
+
+			status: RecipientStatuses.Get("new"),
+
+			subscribeInvoked: true,
+			onSubscribe: func(s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, &MailChimpError{Status: 400}
+			},
+
+			expectedStatus: RecipientStatuses.Get("rejected"),
+			expectedError:  &MailChimpError{Status: 400},
+		},
+		{
+			label: "returns rejected on invalid subscribe",
+
+			status: RecipientStatuses.Get("new"),
+
+			subscribeInvoked: true,
+			onSubscribe: func(s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, &validationError{"x"}
+			},
+
+			expectedStatus: RecipientStatuses.Get("rejected"),
+			expectedError:  &validationError{"x"},
+		},
 		{
 			label: "on status = unsubscribing",
 
@@ -266,7 +858,7 @@ func TestClientNotifier_Notify(t *testing.T) {
 
 			unsubscribeInvoked: true,
 			onUnsubscribe: func(s subscription) error {
-				if s != testSubscription {
+				if !reflect.DeepEqual(s, testSubscription) {
 					t.Errorf("on status = unsubscribing: client Unsubscribe got %q, want %q", s, testSubscription)
 				}
 				return nil
@@ -285,9 +877,22 @@ func TestClientNotifier_Notify(t *testing.T) {
 				return errors.New("x")
 			},
 
-			expectedStatus: RecipientStatuses.None,
+			expectedStatus: RecipientStatuses.Get("failed"),
 			expectedError:  errors.New("x"),
 		},
+		{
+			label: "returns rejected on permanent unsubscribe error",
+
+			status: RecipientStatuses.Get("unsubscribing"),
+
+			unsubscribeInvoked: true,
+			onUnsubscribe: func(s subscription) error {
+				return &MailChimpError{Status: 400}
+			},
+
+			expectedStatus: RecipientStatuses.Get("rejected"),
+			expectedError:  &MailChimpError{Status: 400},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -329,7 +934,11 @@ func read(r io.Reader) (string, error) {
 }
 
 func newClientTestResponse(statusCode int) *http.Response {
-	return &http.Response{StatusCode: statusCode, Body: &clientTestResponseBody{strings.NewReader("")}}
+	return newClientTestResponseWithBody(statusCode, "")
+}
+
+func newClientTestResponseWithBody(statusCode int, body string) *http.Response {
+	return &http.Response{StatusCode: statusCode, Body: &clientTestResponseBody{strings.NewReader(body)}}
 }
 
 type clientTestResponseBody struct {
@@ -342,26 +951,33 @@ func (r *clientTestResponseBody) Close() error {
 
 type notifierTestClient struct {
 	subscribeInvoked bool
-	onSubscribe      func(s subscription) error
+	onSubscribe      func(s subscription) (RecipientStatus, error)
 
 	unsubscribeInvoked bool
 	onUnsubscribe      func(s subscription) error
+
+	onGetStatus func(s subscription) (RecipientStatus, error)
 }
 
-func (c *notifierTestClient) Subscribe(s subscription) error {
+func (c *notifierTestClient) Subscribe(s subscription) (RecipientStatus, error) {
 	c.subscribeInvoked = true
 	return c.onSubscribe(s)
 }
 
+func (c *notifierTestClient) GetStatus(s subscription) (RecipientStatus, error) {
+	return c.onGetStatus(s)
+}
+
 func (c *notifierTestClient) Unsubscribe(s subscription) error {
 	c.unsubscribeInvoked = true
 	return c.onUnsubscribe(s)
 }
 
-func newNotifierTestClient(onSubscribe func(s subscription) error, onUnsubscribe func(s subscription) error) *notifierTestClient {
+func newNotifierTestClient(onSubscribe func(s subscription) (RecipientStatus, error),
+	onUnsubscribe func(s subscription) error) *notifierTestClient {
 	c := &notifierTestClient{
-		onSubscribe: func(s subscription) error {
-			return nil
+		onSubscribe: func(s subscription) (RecipientStatus, error) {
+			return RecipientStatuses.Get("subscribed"), nil
 		},
 		onUnsubscribe: func(s subscription) error {
 			return nil
@@ -378,10 +994,15 @@ func newNotifierTestClient(onSubscribe func(s subscription) error, onUnsubscribe
 
 type testMailChimpOperations struct {
 	executeInvoked bool
-	onExecute      func(method string, url string, entity interface{}) error
+	onExecute      func(method string, url string, entity interface{}, result interface{}) error
+	onDownload     func(url string) (io.ReadCloser, error)
 }
 
-func (o *testMailChimpOperations) execute(method string, url string, entity interface{}) error {
+func (o *testMailChimpOperations) execute(method string, url string, entity interface{}, result interface{}) error {
 	o.executeInvoked = true
-	return o.onExecute(method, url, entity)
+	return o.onExecute(method, url, entity, result)
+}
+
+func (o *testMailChimpOperations) download(url string) (io.ReadCloser, error) {
+	return o.onDownload(url)
 }
@@ -0,0 +1,207 @@
+package mailer
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/mattes/migrate/database"
+	"github.com/mattes/migrate/database/mysql"
+	"github.com/mattes/migrate/database/postgres"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dialect is the SQL of a database repositories can be stored in. Repository queries are written with ?
+// placeholders and unquoted identifiers, and are rewritten by the dialect.
+type dialect interface {
+	// name is the database/sql driver name, which is also the schema directory of the dialect's migrations.
+	name() string
+	open(dsn string) (*sql.DB, error)
+	rebind(query string) string
+	bind(args []interface{}) []interface{}
+	quote(identifier string) string
+	// lockRows gets the clause ending a select to lock the rows it selects of the aliased table for update, skipping
+	// those locked by others, if the dialect can.
+	lockRows(alias string) string
+	// tryLock takes the named lock for the owner, unless another holds it, getting a function to release it, see
+	// DBRepository.TryLock.
+	tryLock(db *sql.DB, name string, owner string, now time.Time, ttl time.Duration) (unlock func() error,
+		locked bool, err error)
+	// insert executes an insert statement, getting the ID of the inserted row.
+	insert(tx *sql.Tx, query string, args ...interface{}) (int64, error)
+	newMigrationDriver(db *sql.DB) (database.Driver, error)
+}
+
+// parseDSN gets the dialect of a DSN from its scheme, and the data source name to open with it.
+// postgres:// and postgresql:// URLs are opened by PostgreSQL unchanged; sqlite:// and sqlite3:// URLs are followed
+// by the path of an SQLite database file, e.g. sqlite:///var/lib/mailsling.db; and other DSNs are MySQL DSNs, with
+// an optional mysql:// prefix.
+func parseDSN(dsn string) (dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return postgresDialect{}, dsn
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return sqliteDialect{}, strings.TrimPrefix(dsn, "sqlite://")
+	case strings.HasPrefix(dsn, "sqlite3://"):
+		return sqliteDialect{}, strings.TrimPrefix(dsn, "sqlite3://")
+	default:
+		return mysqlDialect{}, strings.TrimPrefix(dsn, "mysql://")
+	}
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string {
+	return "mysql"
+}
+
+func (d mysqlDialect) open(dsn string) (*sql.DB, error) {
+	return sql.Open(d.name(), dsn)
+}
+
+func (mysqlDialect) rebind(query string) string {
+	return query
+}
+
+func (mysqlDialect) bind(args []interface{}) []interface{} {
+	return args
+}
+
+func (mysqlDialect) quote(identifier string) string {
+	return "`" + identifier + "`"
+}
+
+// lockRows doesn't lock rows, since MySQL 5 doesn't support SKIP LOCKED, so locking them would wait for others'
+// locks to be released, and lock the rows of joined tables too. Instead rows are leased with conditional updates,
+// which skip those others have leased since they were selected, so a page may have fewer rows than its limit,
+// the rest being left to later runs.
+func (mysqlDialect) lockRows(alias string) string {
+	return ""
+}
+
+func (mysqlDialect) insert(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	return insertReturningLastInsertID(tx, query, args...)
+}
+
+func (mysqlDialect) newMigrationDriver(db *sql.DB) (database.Driver, error) {
+	return mysql.WithInstance(db, &mysql.Config{})
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() string {
+	return "postgres"
+}
+
+func (d postgresDialect) open(dsn string) (*sql.DB, error) {
+	return sql.Open(d.name(), dsn)
+}
+
+// rebind numbers the placeholders, e.g. "a = ? and b = ?" becomes "a = $1 and b = $2".
+func (postgresDialect) rebind(query string) string {
+	parts := strings.Split(query, "?")
+	result := parts[0]
+	for i, part := range parts[1:] {
+		result += "$" + strconv.Itoa(i+1) + part
+	}
+	return result
+}
+
+func (postgresDialect) bind(args []interface{}) []interface{} {
+	return args
+}
+
+func (postgresDialect) quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+func (postgresDialect) lockRows(alias string) string {
+	return " for update of " + alias + " skip locked"
+}
+
+// insert gets the inserted ID with a returning clause, since the driver doesn't support LastInsertId.
+func (d postgresDialect) insert(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := tx.QueryRow(d.rebind(query)+" returning id", args...).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't perform insert: %v", err)
+	}
+	return id, nil
+}
+
+func (postgresDialect) newMigrationDriver(db *sql.DB) (database.Driver, error) {
+	return postgres.WithInstance(db, &postgres.Config{})
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string {
+	return "sqlite3"
+}
+
+// open enables foreign keys, and limits the pool to one connection, since SQLite allows only one writer, and each
+// connection to an in-memory database has a database of its own. Transactions lock the database as they begin, so
+// rows read in a transaction can't be changed by another process before it ends.
+func (d sqliteDialect) open(dsn string) (*sql.DB, error) {
+	if strings.Contains(dsn, "?") {
+		dsn += "&_foreign_keys=1&_txlock=immediate"
+	} else {
+		dsn += "?_foreign_keys=1&_txlock=immediate"
+	}
+	db, err := sql.Open(d.name(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func (sqliteDialect) rebind(query string) string {
+	return query
+}
+
+// bind converts times to UTC, since they're stored as text, and so compared as text.
+func (sqliteDialect) bind(args []interface{}) []interface{} {
+	result := make([]interface{}, len(args))
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			arg = t.UTC()
+		}
+		result[i] = arg
+	}
+	return result
+}
+
+func (sqliteDialect) quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+// lockRows doesn't lock rows, since SQLite doesn't support row locks. Instead transactions lock the database
+// throughout.
+func (sqliteDialect) lockRows(alias string) string {
+	return ""
+}
+
+func (sqliteDialect) insert(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	return insertReturningLastInsertID(tx, query, args...)
+}
+
+func (sqliteDialect) newMigrationDriver(db *sql.DB) (database.Driver, error) {
+	return newSQLiteMigrationDriver(db)
+}
+
+func insertReturningLastInsertID(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't perform insert: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("couldn't get inserted row ID: %v", err)
+	}
+	return id, nil
+}
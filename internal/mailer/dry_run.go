@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// dryRunOperations logs requests rather than sending them, responding to each with an empty JSON object, as if it
+// had succeeded.
+type dryRunOperations struct {
+	log *Loggers
+}
+
+func (o *dryRunOperations) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read request body: %v", err)
+		}
+	}
+
+	o.log.Info.Printf("Dry run: %s %s %s", req.Method, req.URL, body)
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+// dryRunnable is implemented by clients whose HTTP operations can be replaced, e.g. by dryRunOperations.
+type dryRunnable interface {
+	setOperations(ops clientOperations)
+}
+
+func (c *mailChimpClient) setOperations(ops clientOperations) {
+	if o, ok := c.ops.(*mailChimpOperations); ok {
+		o.ops = ops
+	}
+}
+
+func (c *brevoClient) setOperations(ops clientOperations) {
+	c.ops.ops = ops
+}
+
+func (c *mailgunClient) setOperations(ops clientOperations) {
+	c.ops.ops = ops
+}
+
+func (c *sendGridClient) setOperations(ops clientOperations) {
+	c.ops.ops = ops
+}
+
+func (c *webhookClient) setOperations(ops clientOperations) {
+	c.ops.ops = ops
+}
+
+func (c *listIDClient) setOperations(ops clientOperations) {
+	setClientOperations(c.client, ops)
+}
+
+func (c *routingClient) setOperations(ops clientOperations) {
+	for _, client := range c.clients {
+		setClientOperations(client, ops)
+	}
+	for _, clients := range c.destinations {
+		for _, client := range clients {
+			setClientOperations(client, ops)
+		}
+	}
+	setClientOperations(c.fallback, ops)
+}
+
+func setClientOperations(client Client, ops clientOperations) {
+	if c, ok := client.(dryRunnable); ok {
+		c.setOperations(ops)
+	}
+}
+
+// dryRunSummary counts the list recipients and deliveries a dry run would have notified, by list, destination and
+// pending status.
+type dryRunSummary map[string]int
+
+func (s dryRunSummary) add(r listRecipientComposite) {
+	key := "list " + r.listID
+	if r.destination != "" {
+		key += " destination " + r.destination
+	}
+	s[key+": "+string(r.status)]++
+}
+
+func (s dryRunSummary) print(log *Loggers) {
+	var keys []string
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	total := 0
+	for _, key := range keys {
+		total += s[key]
+	}
+
+	log.Info.Printf("Dry run: would notify %d list recipients and deliveries", total)
+	for _, key := range keys {
+		log.Info.Printf("Dry run: %s %d", key, s[key])
+	}
+}
@@ -0,0 +1,121 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMailer_DryRun(t *testing.T) {
+	b := &bytes.Buffer{}
+	logs := &Loggers{Info: log.New(b, "", 0), Error: NOOPLog.Error}
+
+	ms := &testMessageSource{messageResults: []messageResult{
+		{msg: &testMessage{Text: `{"type":"subscribe","email":"a@b.com","listIds":["x","y"]}`}},
+		{msg: &testMessage{Text: `{"type":"unsubscribe"}`}},
+		{},
+	}}
+	j := &testJournal{
+		onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+			return []listRecipientComposite{
+				{listRecipientID: 1, email: "a@b.com", listID: "x", status: RecipientStatuses.Get("new")},
+				{listRecipientID: 2, email: "c@d.com", listID: "x", status: RecipientStatuses.Get("new")},
+				{listRecipientID: 3, email: "a@b.com", listID: "y", status: RecipientStatuses.Get("unsubscribing")},
+			}, nil
+		},
+		onGetPendingDeliveries: func() ([]listRecipientComposite, error) {
+			return []listRecipientComposite{
+				{listRecipientID: 1, email: "a@b.com", listID: "x", status: RecipientStatuses.Get("new"), deliveryID: 4,
+					destination: "crm"},
+			}, nil
+		},
+	}
+	client := NewRoutingClient(
+		map[string]Client{"y": &webhookClient{ops: &providerOperations{provider: "webhook", ops: &testClientOperations{},
+			baseURL: "https://hooks"}, maxAttempts: 1}},
+		map[string]map[string]Client{"x": {"crm": &webhookClient{ops: &providerOperations{provider: "webhook",
+			ops: &testClientOperations{}, baseURL: "https://crm"}, maxAttempts: 1}}},
+		&mailChimpClient{ops: &mailChimpOperations{log: NOOPLog, ops: &testClientOperations{},
+			config: MailChimpConfig{apiKey: "key-us1"}}})
+
+	m := &Mailer{log: logs, ms: ms, journal: j, notifier: &clientNotifier{client: client}, clock: &testClock{},
+		stop: make(chan struct{})}
+	m.DryRun()
+	m.RunOnce(true, true)
+
+	if len(ms.processed) != 0 || len(ms.rejected) != 0 {
+		t.Errorf("messages processed/rejected got %v/%v, want none", ms.processed, ms.rejected)
+	}
+	if len(j.pendingStateReceived) != 0 || len(j.rejectedMessagesReceived) != 0 {
+		t.Errorf("journaled got %v/%v, want none", j.pendingStateReceived, j.rejectedMessagesReceived)
+	}
+	if len(j.updateListRecipientReceived) != 0 || len(j.updateDeliveryReceived) != 0 {
+		t.Errorf("updated got %v/%v, want none", j.updateListRecipientReceived, j.updateDeliveryReceived)
+	}
+	if j.getRecipientsPendingConfirmationInvocations != 0 {
+		t.Errorf("checked confirmation got %v times, want 0", j.getRecipientsPendingConfirmationInvocations)
+	}
+
+	expected := []string{
+		`Dry run: would set a@b.com new on lists x, y`,
+		`Dry run: would reject message "{\"type\":\"unsubscribe\"}": couldn't parse sign up: message has no email`,
+		`Dry run: PUT https://us1.api.mailchimp.com/3.0/lists/x/members/357a20e8c56e69d6f9734d23ef9517e8 ` +
+			`{"email_address":"a@b.com","status_if_new":"subscribed","status":"subscribed"}`,
+		`Dry run: PUT https://us1.api.mailchimp.com/3.0/lists/x/members/c5d5cc05e15e794cdf17459b53e7a793 ` +
+			`{"email_address":"c@d.com","status_if_new":"subscribed","status":"subscribed"}`,
+		`Dry run: POST https://hooks {"type":"unsubscribe","email":"a@b.com","listId":"y"}`,
+		`Dry run: POST https://crm {"type":"subscribe","email":"a@b.com","listId":"x"}`,
+		`Dry run: would notify 4 list recipients and deliveries`,
+		`Dry run: list x destination crm: new 1`,
+		`Dry run: list x: new 2`,
+		`Dry run: list y: unsubscribing 1`,
+	}
+	if actual := strings.Split(strings.TrimSpace(b.String()), "\n"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("logged got\n%v\nwant\n%v", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestMailer_DryRunBatches(t *testing.T) {
+	b := &bytes.Buffer{}
+	logs := &Loggers{Info: log.New(b, "", 0), Error: NOOPLog.Error}
+
+	j := &testJournal{
+		onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+			return []listRecipientComposite{
+				{listRecipientID: 1, email: "a@b.com", listID: "x", status: RecipientStatuses.Get("new")},
+				{listRecipientID: 2, email: "c@d.com", listID: "x", status: RecipientStatuses.Get("unsubscribing")},
+				{listRecipientID: 3, email: "a@b.com", listID: "y", status: RecipientStatuses.Get("new")},
+			}, nil
+		},
+	}
+	client := &mailChimpClient{ops: &mailChimpOperations{log: NOOPLog, ops: &testClientOperations{},
+		config: MailChimpConfig{apiKey: "key-us1"}}}
+
+	m := &Mailer{log: logs, ms: &testMessageSource{}, journal: j, notifier: &clientNotifier{client: client},
+		batch: NewBatchPolicy(2), batcher: client, clock: &testClock{}, stop: make(chan struct{})}
+	m.DryRun()
+	m.RunOnce(false, true)
+
+	if len(j.updateListRecipientReceived) != 0 {
+		t.Errorf("updated got %v, want none", j.updateListRecipientReceived)
+	}
+
+	expected := []string{
+		`Dry run: POST https://us1.api.mailchimp.com/3.0/batches {"operations":[` +
+			`{"method":"PUT","path":"/lists/x/members/357a20e8c56e69d6f9734d23ef9517e8","operation_id":"1",` +
+			`"body":"{\"email_address\":\"a@b.com\",\"status_if_new\":\"subscribed\",\"status\":\"subscribed\"}"},` +
+			`{"method":"PATCH","path":"/lists/x/members/c5d5cc05e15e794cdf17459b53e7a793",` +
+			`"operation_id":"2:unsubscribe","body":"{\"status\":\"unsubscribed\"}"}]}`,
+		`Dry run: PUT https://us1.api.mailchimp.com/3.0/lists/y/members/357a20e8c56e69d6f9734d23ef9517e8 ` +
+			`{"email_address":"a@b.com","status_if_new":"subscribed","status":"subscribed"}`,
+		`Dry run: would notify 3 list recipients and deliveries`,
+		`Dry run: list x: new 1`,
+		`Dry run: list x: unsubscribing 1`,
+		`Dry run: list y: new 1`,
+	}
+	if actual := strings.Split(strings.TrimSpace(b.String()), "\n"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("logged got\n%v\nwant\n%v", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
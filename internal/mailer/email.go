@@ -0,0 +1,96 @@
+package mailer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// normalizeEmail trims an email address and lowercases it, as MailChimp does to get the subscriber ID, so that
+// case variants of an address are the same recipient.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateEmail checks that an email address is a bare RFC 5322 address with a domain, e.g. "a@b.com" rather than
+// "A <a@b.com>".
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if addr.Name != "" || addr.Address != email {
+		return errors.New("not a bare address")
+	}
+	if strings.LastIndex(email, "@") == len(email)-1 {
+		return errors.New("no domain")
+	}
+	return nil
+}
+
+// EmailPolicy determines which syntactically valid email addresses are accepted: optionally only those of domains
+// with mail servers, and not those of disposable domains.
+type EmailPolicy struct {
+	checkMX           bool
+	disposableDomains map[string]bool
+	lookupMX          func(domain string) ([]*net.MX, error)
+}
+
+// NewEmailPolicy creates an email policy. If checkMX, addresses are only accepted if their domains have MX records.
+func NewEmailPolicy(checkMX bool, disposableDomains []string) EmailPolicy {
+	domains := make(map[string]bool)
+	for _, domain := range disposableDomains {
+		domains[strings.ToLower(domain)] = true
+	}
+	return EmailPolicy{checkMX: checkMX, disposableDomains: domains, lookupMX: net.LookupMX}
+}
+
+// LoadDisposableDomains reads a list of disposable email domains, one per line, ignoring blank lines and those
+// starting with #.
+func LoadDisposableDomains(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var result []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			result = append(result, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %v", path, err)
+	}
+	return result, nil
+}
+
+// check checks a normalized email address against the policy. A messageRejection is returned if the address
+// isn't accepted, and any other error if that couldn't be determined, e.g. because DNS was unavailable.
+func (p EmailPolicy) check(email string) error {
+	domain := email[strings.LastIndex(email, "@")+1:]
+
+	if p.disposableDomains[domain] {
+		return &messageRejection{fmt.Sprintf("email domain %s is disposable", domain)}
+	}
+
+	if !p.checkMX {
+		return nil
+	}
+
+	mxs, err := p.lookupMX(domain)
+	if e, ok := err.(*net.DNSError); ok && (e.Temporary() || e.Timeout()) {
+		return fmt.Errorf("couldn't look up MX records of %s: %v", domain, err)
+	}
+	if err != nil || len(mxs) == 0 {
+		return &messageRejection{fmt.Sprintf("email domain %s has no MX records", domain)}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	testCases := []struct {
+		email         string
+		expectedError string
+	}{
+		{email: "a@b.com"},
+		{email: "a.b+c@d.co.uk"},
+		{email: "a", expectedError: "mail: missing '@' or angle-addr"},
+		{email: "a@", expectedError: "mail: "},
+		{email: "@b.com", expectedError: "mail: "},
+		{email: "a b@c.com", expectedError: "mail: "},
+		{email: "A <a@b.com>", expectedError: "not a bare address"},
+		{email: "<a@b.com>", expectedError: "not a bare address"},
+	}
+
+	for _, tc := range testCases {
+		err := validateEmail(tc.email)
+
+		if !errorMessageStartsWith(err, tc.expectedError) {
+			t.Errorf("on %q: result error got %q, want prefix %q", tc.email, err, tc.expectedError)
+		}
+	}
+}
+
+func TestEmailPolicy_Check(t *testing.T) {
+	testCases := []struct {
+		label             string
+		checkMX           bool
+		disposableDomains []string
+		mxs               []*net.MX
+		mxErr             error
+
+		expectedLookup    string
+		expectedRejection string
+		expectedError     string
+	}{
+		{
+			label: "on no checks",
+		},
+		{
+			label:             "on disposable domain",
+			disposableDomains: []string{"Mailinator.com"},
+			checkMX:           true,
+
+			expectedRejection: "email domain mailinator.com is disposable",
+		},
+		{
+			label:   "on MX records",
+			checkMX: true,
+			mxs:     []*net.MX{{Host: "mx.mailinator.com."}},
+
+			expectedLookup: "mailinator.com",
+		},
+		{
+			label:   "on no MX records",
+			checkMX: true,
+
+			expectedLookup:    "mailinator.com",
+			expectedRejection: "email domain mailinator.com has no MX records",
+		},
+		{
+			label:   "on domain not found",
+			checkMX: true,
+			mxErr:   &net.DNSError{Err: "no such host", Name: "mailinator.com"},
+
+			expectedLookup:    "mailinator.com",
+			expectedRejection: "email domain mailinator.com has no MX records",
+		},
+		{
+			label:   "on temporary DNS error",
+			checkMX: true,
+			mxErr:   &net.DNSError{Err: "i/o timeout", Name: "mailinator.com", IsTimeout: true},
+
+			expectedLookup: "mailinator.com",
+			expectedError:  "couldn't look up MX records of mailinator.com: ",
+		},
+	}
+
+	for _, tc := range testCases {
+		policy := NewEmailPolicy(tc.checkMX, tc.disposableDomains)
+		var lookup string
+		policy.lookupMX = func(domain string) ([]*net.MX, error) {
+			lookup = domain
+			return tc.mxs, tc.mxErr
+		}
+
+		err := policy.check("a@mailinator.com")
+
+		if lookup != tc.expectedLookup {
+			t.Errorf("%v: looked up MX records of %q, want %q", tc.label, lookup, tc.expectedLookup)
+		}
+		rejection, _ := err.(*messageRejection)
+		if tc.expectedRejection != "" {
+			if rejection == nil || rejection.reason != tc.expectedRejection {
+				t.Errorf("%v: result error got %v, want rejection %q", tc.label, err, tc.expectedRejection)
+			}
+		} else if rejection != nil {
+			t.Errorf("%v: result error got rejection %q, want none", tc.label, rejection.reason)
+		} else if !errorMessageStartsWith(err, tc.expectedError) {
+			t.Errorf("%v: result error got %q, want prefix %q", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestLoadDisposableDomains(t *testing.T) {
+	f, err := ioutil.TempFile("", "disposable-domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("# disposable domains\nmailinator.com\n\n  guerrillamail.com  \n")
+	f.Close()
+
+	domains, err := LoadDisposableDomains(f.Name())
+
+	if err != nil {
+		t.Errorf("result error got %v, want nil", err)
+	}
+	if expected := []string{"mailinator.com", "guerrillamail.com"}; !reflect.DeepEqual(domains, expected) {
+		t.Errorf("result got %v, want %v", domains, expected)
+	}
+
+	_, err = LoadDisposableDomains(f.Name() + "-missing")
+
+	if !errorMessageStartsWith(err, "couldn't open ") {
+		t.Errorf("result error got %q, want prefix %q", err, "couldn't open ")
+	}
+}
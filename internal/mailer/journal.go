@@ -1,18 +1,85 @@
 package mailer
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"os"
 	"time"
 )
 
 type repositoryJournal struct {
-	log   *Loggers
-	repo  Repository
-	clock clock
+	log          *Loggers
+	repo         Repository
+	clock        clock
+	retry        RetryPolicy
+	destinations map[string][]string
+	lease        LeasePolicy
 }
 
-func (j *repositoryJournal) SetRecipientPendingState(email string, lists []string, status RecipientStatus, attribs map[string]string) error {
+// RetryPolicy determines when, and how many times, failed list recipients are retried.
+type RetryPolicy struct {
+	maxAttempts  int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+func NewRetryPolicy(maxAttempts int, initialDelay time.Duration, maxDelay time.Duration) RetryPolicy {
+	return RetryPolicy{maxAttempts: maxAttempts, initialDelay: initialDelay, maxDelay: maxDelay}
+}
+
+// getDelay gets the exponential backoff delay before the next attempt, given the number of failed attempts
+// so far.
+func (p RetryPolicy) getDelay(attempts int) time.Duration {
+	delay := p.initialDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= p.maxDelay {
+			return p.maxDelay
+		}
+	}
+	if delay > p.maxDelay {
+		return p.maxDelay
+	}
+	return delay
+}
+
+// LeasePolicy determines how long the list recipients and deliveries a process gets to notify are leased to it, so
+// that other processes don't notify them too. A zero duration doesn't lease them.
+type LeasePolicy struct {
+	owner    string
+	duration time.Duration
+}
+
+// NewLeasePolicy creates a lease policy for this process, the owner of its leases being identified by newOwnerID.
+func NewLeasePolicy(duration time.Duration) LeasePolicy {
+	if duration <= 0 {
+		return LeasePolicy{}
+	}
+	return LeasePolicy{owner: newOwnerID(), duration: duration}
+}
+
+// newOwnerID gets an ID for this process as the owner of leases and locks: its host and PID, and a random suffix in
+// case it's in a container.
+func newOwnerID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return truncate(host, 40) + fmt.Sprintf("-%d-", os.Getpid()) + hex.EncodeToString(suffix)
+}
+
+func (p LeasePolicy) isEnabled() bool {
+	return p.duration > 0
+}
+
+// SetRecipientPendingState sets the status a recipient is pending on each of the lists, adding the recipient and list
+// recipients if they aren't known. Like the journal's other methods taking email addresses, it normalizes them first,
+// so that case variants are the same recipient wherever they come from.
+func (j *repositoryJournal) SetRecipientPendingState(email string, lists []string, status RecipientStatus,
+	attribs map[string]string, source string) error {
+	email = normalizeEmail(email)
+
 	return j.repo.DoInTx(func(tx *sql.Tx) error {
 		var recipientID int
 
@@ -40,17 +107,38 @@ func (j *repositoryJournal) SetRecipientPendingState(email string, lists []strin
 			if err != nil {
 				return fmt.Errorf("couldn't check for existing list recipient: %v", err)
 			} else if lrFound {
+				fromStatus := lr.status
+
 				lr.status = status
 				lr.lastModified = j.clock.now()
 				lr.attribs = attribs
+				lr.statusReason = ""
+				lr.retryStatus = RecipientStatuses.None
+				lr.attempts = 0
+				lr.nextAttempt = time.Time{}
+				lr.batchID = 0
 
 				err = j.repo.UpdateListRecipient(tx, lr)
 
 				if err != nil {
 					return fmt.Errorf("couldn't update list recipient: %v", err)
 				}
+
+				err = j.recordEvent(tx, ListRecipientEvent{listRecipientID: lr.id, fromStatus: fromStatus,
+					toStatus: status, sourceMessage: source})
+
+				if err != nil {
+					return err
+				}
+
+				err = j.setDeliveriesPendingState(tx, lr.id, listID, status, source)
+
+				if err != nil {
+					return err
+				}
 			} else {
-				_, err = j.repo.InsertListRecipient(tx, ListRecipient{
+				var listRecipientID int
+				listRecipientID, err = j.repo.InsertListRecipient(tx, ListRecipient{
 					recipientID:  recipientID,
 					listID:       listID,
 					status:       status,
@@ -61,40 +149,650 @@ func (j *repositoryJournal) SetRecipientPendingState(email string, lists []strin
 				if err != nil {
 					return fmt.Errorf("couldn't insert list recipient: %v", err)
 				}
+
+				err = j.recordEvent(tx, ListRecipientEvent{listRecipientID: listRecipientID, toStatus: status,
+					sourceMessage: source})
+
+				if err != nil {
+					return err
+				}
+
+				err = j.setDeliveriesPendingState(tx, listRecipientID, listID, status, source)
+
+				if err != nil {
+					return err
+				}
 			}
 		}
 		return nil
 	})
 }
 
-func (j *repositoryJournal) GetRecipientPendingState() ([]listRecipientComposite, error) {
+// setDeliveriesPendingState sets the pending state of a list recipient's deliveries to each of its list's
+// destinations.
+func (j *repositoryJournal) setDeliveriesPendingState(tx *sql.Tx, listRecipientID int, listID string,
+	status RecipientStatus, source string) error {
+	for _, destination := range j.destinations[listID] {
+		d, found, err := j.repo.GetListRecipientDeliveryByDestination(tx, listRecipientID, destination)
+
+		if err != nil {
+			return fmt.Errorf("couldn't check for existing delivery: %v", err)
+		}
+
+		fromStatus := d.status
+
+		if found {
+			d.status = status
+			d.statusReason = ""
+			d.lastModified = j.clock.now()
+			d.retryStatus = RecipientStatuses.None
+			d.attempts = 0
+			d.nextAttempt = time.Time{}
+
+			err = j.repo.UpdateListRecipientDelivery(tx, d)
+
+			if err != nil {
+				return fmt.Errorf("couldn't update delivery: %v", err)
+			}
+		} else {
+			_, err = j.repo.InsertListRecipientDelivery(tx, ListRecipientDelivery{
+				listRecipientID: listRecipientID,
+				destination:     destination,
+				status:          status,
+				lastModified:    j.clock.now(),
+			})
+
+			if err != nil {
+				return fmt.Errorf("couldn't insert delivery: %v", err)
+			}
+		}
+
+		err = j.recordEvent(tx, ListRecipientEvent{listRecipientID: listRecipientID, destination: destination,
+			fromStatus: fromStatus, toStatus: status, sourceMessage: source})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ForEachRecipientPendingState passes pages of list recipients that are new, or unsubscribing, then of those failed
+// and due to be retried, to the action, getting up to the max items, as forEachPage does. Each page is got in a
+// transaction of its own, so none is held open while the action notifies them. If the lease policy is enabled, only
+// those not leased by others are got, and they're leased until they're updated.
+func (j *repositoryJournal) ForEachRecipientPendingState(pageSize int, maxItems int,
+	action func([]listRecipientComposite) error) error {
+	statuses := []RecipientStatus{RecipientStatuses.Get("new"), RecipientStatuses.Get("unsubscribing")}
+	now := j.clock.now()
+
+	return forEachPendingPage(pageSize, maxItems, func(r listRecipientComposite) int { return r.listRecipientID },
+		j.getPage(func(tx *sql.Tx, page Page) ([]listRecipientComposite, error) {
+			if j.lease.isEnabled() {
+				return j.repo.ClaimRecipientDataByStatus(tx, statuses, now, j.newLease(), page)
+			}
+			return j.repo.GetRecipientDataByStatus(tx, statuses, page)
+		}),
+		j.getPage(func(tx *sql.Tx, page Page) ([]listRecipientComposite, error) {
+			if j.lease.isEnabled() {
+				return j.repo.ClaimRecipientDataForRetry(tx, now, j.newLease(), page)
+			}
+			return j.repo.GetRecipientDataForRetry(tx, now, page)
+		}), action)
+}
+
+// ForEachPendingDelivery passes pages of deliveries to destinations that are new, or unsubscribing, then of those
+// failed and due to be retried, to the action, like ForEachRecipientPendingState.
+func (j *repositoryJournal) ForEachPendingDelivery(pageSize int, maxItems int,
+	action func([]listRecipientComposite) error) error {
+	statuses := []RecipientStatus{RecipientStatuses.Get("new"), RecipientStatuses.Get("unsubscribing")}
+	now := j.clock.now()
+
+	return forEachPendingPage(pageSize, maxItems, func(r listRecipientComposite) int { return r.deliveryID },
+		j.getPage(func(tx *sql.Tx, page Page) ([]listRecipientComposite, error) {
+			if j.lease.isEnabled() {
+				return j.repo.ClaimDeliveryDataByStatus(tx, statuses, now, j.newLease(), page)
+			}
+			return j.repo.GetDeliveryDataByStatus(tx, statuses, page)
+		}),
+		j.getPage(func(tx *sql.Tx, page Page) ([]listRecipientComposite, error) {
+			if j.lease.isEnabled() {
+				return j.repo.ClaimDeliveryDataForRetry(tx, now, j.newLease(), page)
+			}
+			return j.repo.GetDeliveryDataForRetry(tx, now, page)
+		}), action)
+}
+
+// forEachPendingPage passes the pages got with getPending, then those got with getRetries, to the action, up to the
+// max items between them.
+func forEachPendingPage(pageSize int, maxItems int, id func(listRecipientComposite) int,
+	getPending func(Page) ([]listRecipientComposite, error), getRetries func(Page) ([]listRecipientComposite, error),
+	action func([]listRecipientComposite) error) error {
+	got, err := forEachPage(pageSize, maxItems, id, getPending, action)
+
+	if err == nil && maxItems == 0 {
+		_, err = forEachPage(pageSize, 0, id, getRetries, action)
+	} else if err == nil && got < maxItems {
+		_, err = forEachPage(pageSize, maxItems-got, id, getRetries, action)
+	}
+
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+// getPage gets a function getting a page with the query in a transaction of its own.
+func (j *repositoryJournal) getPage(query func(tx *sql.Tx, page Page) ([]listRecipientComposite, error)) func(
+	Page) ([]listRecipientComposite, error) {
+	return func(page Page) ([]listRecipientComposite, error) {
+		var result []listRecipientComposite
+
+		err := j.repo.DoInTx(func(tx *sql.Tx) error {
+			var innerErr error
+			result, innerErr = query(tx, page)
+			return innerErr
+		})
+
+		return result, err
+	}
+}
+
+// newLease creates a lease to this process from now. Each page gets a new one, so those got later in a long run are
+// leased for as long as the first.
+func (j *repositoryJournal) newLease() Lease {
+	return Lease{owner: j.lease.owner, expiry: j.clock.now().Add(j.lease.duration)}
+}
+
+// ForEachRecipientPendingConfirmation passes pages of list recipients who have yet to confirm their subscription to
+// the action, until there are no more or the action returns errStopIteration. Each page is got in a transaction of
+// its own, like ForEachRecipientPendingState.
+func (j *repositoryJournal) ForEachRecipientPendingConfirmation(pageSize int,
+	action func([]listRecipientComposite) error) error {
+	statuses := []RecipientStatus{RecipientStatuses.Get("pending")}
+
+	_, err := forEachPage(pageSize, 0, func(r listRecipientComposite) int { return r.listRecipientID },
+		j.getPage(func(tx *sql.Tx, page Page) ([]listRecipientComposite, error) {
+			return j.repo.GetRecipientDataByStatus(tx, statuses, page)
+		}), action)
+
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+// ForEachListRecipient passes pages of the recipients of a list to the action, until there are no more or the action
+// returns an error. Each page is got in a transaction of its own, like ForEachRecipientPendingState.
+func (j *repositoryJournal) ForEachListRecipient(listID string, pageSize int,
+	action func([]listRecipientComposite) error) error {
+	_, err := forEachPage(pageSize, 0, func(r listRecipientComposite) int { return r.listRecipientID },
+		j.getPage(func(tx *sql.Tx, page Page) ([]listRecipientComposite, error) {
+			return j.repo.GetRecipientDataByListID(tx, listID, page)
+		}), action)
+
+	return err
+}
+
+// GetListRecipientsByEmail gets the recipients of a list with any of the emails, which must be normalized.
+func (j *repositoryJournal) GetListRecipientsByEmail(listID string, emails []string) ([]listRecipientComposite,
+	error) {
 	var result []listRecipientComposite
-	var err error
 
-	err = j.repo.DoInTx(func(tx *sql.Tx) error {
+	err := j.repo.DoInTx(func(tx *sql.Tx) error {
 		var innerErr error
-		result, innerErr = j.repo.GetRecipientDataByStatus(tx, []RecipientStatus{
-			RecipientStatuses.Get("new"),
-			RecipientStatuses.Get("unsubscribing")})
-
+		result, innerErr = j.repo.GetRecipientDataByEmails(tx, listID, emails)
 		return innerErr
 	})
 
 	return result, err
 }
 
-func (j *repositoryJournal) UpdateListRecipient(listRecipientID int, status RecipientStatus) error {
+// UpdateListRecipient updates a list recipient with the result of notifying its pending state, the cause being
+// recorded as the reason for its status if that failed.
+func (j *repositoryJournal) UpdateListRecipient(listRecipientID int, status RecipientStatus, cause error) error {
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		lr, err := j.repo.GetListRecipient(tx, listRecipientID)
+
+		if err != nil {
+			return fmt.Errorf("couldn't get existing list recipient: %v", err)
+		}
+
+		return j.updateListRecipient(tx, listRecipientID, lr, status, cause)
+	})
+}
+
+// UpdateBatchedListRecipient updates a list recipient with the result of its operation in a batch, like
+// UpdateListRecipient, unless it's since been removed from the batch, e.g. by a new message.
+func (j *repositoryJournal) UpdateBatchedListRecipient(batchID int, listRecipientID int, status RecipientStatus,
+	cause error) error {
 	return j.repo.DoInTx(func(tx *sql.Tx) error {
 		lr, err := j.repo.GetListRecipient(tx, listRecipientID)
 
 		if err != nil {
 			return fmt.Errorf("couldn't get existing list recipient: %v", err)
+		} else if lr.batchID != batchID {
+			j.log.Info.Printf("ignoring result of list recipient #%d no longer in batch #%d", listRecipientID, batchID)
+			return nil
 		}
 
+		return j.updateListRecipient(tx, listRecipientID, lr, status, cause)
+	})
+}
+
+func (j *repositoryJournal) updateListRecipient(tx *sql.Tx, listRecipientID int, lr ListRecipient,
+	status RecipientStatus, cause error) error {
+	fromStatus := lr.status
+
+	lr.statusReason = ""
+	if cause != nil {
+		lr.statusReason = truncate(cause.Error(), maxStatusReasonLength)
+	}
+
+	if status == RecipientStatuses.Get("failed") {
+		j.setFailed(&lr)
+	} else {
 		lr.status = status
+		lr.retryStatus = RecipientStatuses.None
+		lr.attempts = 0
+		lr.nextAttempt = time.Time{}
+	}
+	lr.batchID = 0
+	lr.leaseOwner = ""
+	lr.leaseExpiry = time.Time{}
+
+	err := j.repo.UpdateListRecipient(tx, lr)
+
+	if err != nil {
+		return err
+	}
+
+	event := ListRecipientEvent{listRecipientID: listRecipientID, fromStatus: fromStatus, toStatus: lr.status}
+	if cause != nil {
+		event.detail = cause.Error()
+	}
+	if httpErr, ok := cause.(httpStatusError); ok {
+		event.httpStatus = httpErr.HTTPStatus()
+	}
+
+	return j.recordEvent(tx, event)
+}
+
+// ReleaseLeases releases the leases of any list recipients and deliveries got by ForEachRecipientPendingState and
+// ForEachPendingDelivery that haven't been updated since, e.g. because processing stopped, so they can be notified by
+// others without waiting for the leases to expire.
+func (j *repositoryJournal) ReleaseLeases() error {
+	if !j.lease.isEnabled() {
+		return nil
+	}
+
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		return j.repo.ReleaseLeases(tx, j.lease.owner)
+	})
+}
+
+// StartTaskRun records that the named task runs now if it's due, i.e. it has never run, or last ran at least the
+// interval ago, getting whether it was. The last run is kept in the repository, so the interval holds across runs
+// of separate processes, e.g. from cron.
+func (j *repositoryJournal) StartTaskRun(name string, interval time.Duration) (bool, error) {
+	var due bool
+
+	err := j.repo.DoInTx(func(tx *sql.Tx) error {
+		now := j.clock.now()
+
+		lastRun, found, err := j.repo.GetTaskLastRun(tx, name)
+		if err != nil {
+			return fmt.Errorf("couldn't get last run: %v", err)
+		}
+		if found && now.Before(lastRun.Add(interval)) {
+			return nil
+		}
+
+		due = true
+		err = j.repo.SetTaskLastRun(tx, name, now)
+		if err != nil {
+			return fmt.Errorf("couldn't set last run: %v", err)
+		}
+		return nil
+	})
+
+	return due && err == nil, err
+}
 
-		return j.repo.UpdateListRecipient(tx, lr)
+// GetBatches gets the batches that have yet to finish.
+func (j *repositoryJournal) GetBatches() ([]Batch, error) {
+	var result []Batch
+
+	err := j.repo.DoInTx(func(tx *sql.Tx) error {
+		var innerErr error
+		result, innerErr = j.repo.GetBatches(tx)
+		return innerErr
 	})
+
+	return result, err
+}
+
+// StartBatch records a batch submitted to the client of a list, adding the list recipients of its operations to it
+// so that they aren't pending until it has finished.
+func (j *repositoryJournal) StartBatch(listID string, remoteID string, listRecipientIDs []int) error {
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		batchID, err := j.repo.InsertBatch(tx, Batch{listID: listID, remoteID: remoteID, created: j.clock.now()})
+
+		if err != nil {
+			return fmt.Errorf("couldn't insert batch: %v", err)
+		}
+
+		for _, id := range listRecipientIDs {
+			lr, err := j.repo.GetListRecipient(tx, id)
+
+			if err != nil {
+				return fmt.Errorf("couldn't get existing list recipient: %v", err)
+			}
+
+			lr.batchID = batchID
+			lr.leaseOwner = ""
+			lr.leaseExpiry = time.Time{}
+
+			err = j.repo.UpdateListRecipient(tx, lr)
+
+			if err != nil {
+				return fmt.Errorf("couldn't update list recipient: %v", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// FinishBatch deletes a batch, any list recipients without results being pending again.
+func (j *repositoryJournal) FinishBatch(batchID int) error {
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		err := j.repo.DeleteBatch(tx, batchID)
+
+		if err != nil {
+			return fmt.Errorf("couldn't delete batch: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// UpdateDelivery updates a delivery with the result of notifying its destination, the cause being recorded as
+// the reason for its status if that failed.
+func (j *repositoryJournal) UpdateDelivery(deliveryID int, status RecipientStatus, cause error) error {
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		d, err := j.repo.GetListRecipientDelivery(tx, deliveryID)
+
+		if err != nil {
+			return fmt.Errorf("couldn't get existing delivery: %v", err)
+		}
+
+		fromStatus := d.status
+
+		d.statusReason = ""
+		if cause != nil {
+			d.statusReason = truncate(cause.Error(), maxStatusReasonLength)
+		}
+
+		if status == RecipientStatuses.Get("failed") {
+			j.setDeliveryFailed(&d)
+		} else {
+			d.status = status
+			d.retryStatus = RecipientStatuses.None
+			d.attempts = 0
+			d.nextAttempt = time.Time{}
+		}
+		d.leaseOwner = ""
+		d.leaseExpiry = time.Time{}
+
+		err = j.repo.UpdateListRecipientDelivery(tx, d)
+
+		if err != nil {
+			return err
+		}
+
+		event := ListRecipientEvent{listRecipientID: d.listRecipientID, destination: d.destination,
+			fromStatus: fromStatus, toStatus: d.status}
+		if cause != nil {
+			event.detail = cause.Error()
+		}
+		if httpErr, ok := cause.(httpStatusError); ok {
+			event.httpStatus = httpErr.HTTPStatus()
+		}
+
+		return j.recordEvent(tx, event)
+	})
+}
+
+// SetListRecipientStatus sets a list recipient's status as changed by the client itself, e.g. on the recipient
+// unsubscribing from a campaign, adding the list recipient if it isn't known. List recipients yet to be notified of
+// their pending state are left as they are, since that is the recipient's latest intent.
+func (j *repositoryJournal) SetListRecipientStatus(email string, listID string, status RecipientStatus, reason string,
+	source string) error {
+	email = normalizeEmail(email)
+
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		lr, found, err := j.repo.GetListRecipientByEmailAndListID(tx, email, listID)
+
+		if err != nil {
+			return fmt.Errorf("couldn't check for existing list recipient: %v", err)
+		} else if found && lr.status == status {
+			return nil
+		} else if found && isInProgress(lr.status) {
+			j.log.Info.Printf("ignoring %s status of list recipient %s on list %s, since it's %s", status, email, listID,
+				lr.status)
+			return nil
+		}
+
+		fromStatus := lr.status
+
+		if found {
+			lr.status = status
+			lr.statusReason = truncate(reason, maxStatusReasonLength)
+			lr.lastModified = j.clock.now()
+			lr.retryStatus = RecipientStatuses.None
+			lr.attempts = 0
+			lr.nextAttempt = time.Time{}
+			lr.batchID = 0
+
+			err = j.repo.UpdateListRecipient(tx, lr)
+
+			if err != nil {
+				return fmt.Errorf("couldn't update list recipient: %v", err)
+			}
+		} else {
+			recipientID, err := j.getOrInsertRecipient(tx, email)
+
+			if err != nil {
+				return err
+			}
+
+			lr.id, err = j.repo.InsertListRecipient(tx, ListRecipient{
+				recipientID:  recipientID,
+				listID:       listID,
+				status:       status,
+				lastModified: j.clock.now(),
+			})
+
+			if err != nil {
+				return fmt.Errorf("couldn't insert list recipient: %v", err)
+			}
+		}
+
+		return j.recordEvent(tx, ListRecipientEvent{listRecipientID: lr.id, fromStatus: fromStatus, toStatus: status,
+			sourceMessage: source, detail: reason})
+	})
+}
+
+// UpdateListRecipientAttributes updates a list recipient's attributes as changed by the client itself, leaving
+// those not given unchanged. Unknown list recipients are ignored.
+func (j *repositoryJournal) UpdateListRecipientAttributes(email string, listID string, attribs map[string]string) error {
+	email = normalizeEmail(email)
+
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		lr, found, err := j.repo.GetListRecipientByEmailAndListID(tx, email, listID)
+
+		if err != nil {
+			return fmt.Errorf("couldn't check for existing list recipient: %v", err)
+		} else if !found {
+			j.log.Info.Printf("ignoring attributes of unknown list recipient %s on list %s", email, listID)
+			return nil
+		}
+
+		merged := make(map[string]string)
+		for k, v := range lr.attribs {
+			merged[k] = v
+		}
+		for k, v := range attribs {
+			merged[k] = v
+		}
+		lr.attribs = merged
+
+		err = j.repo.UpdateListRecipient(tx, lr)
+
+		if err != nil {
+			return fmt.Errorf("couldn't update list recipient: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// RenameRecipient changes a recipient's email address. Unknown recipients are ignored, as are changes to the address
+// of another recipient, which are logged, since they can't be made, and retrying them won't help.
+func (j *repositoryJournal) RenameRecipient(oldEmail string, newEmail string) error {
+	oldEmail, newEmail = normalizeEmail(oldEmail), normalizeEmail(newEmail)
+
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		rec, found, err := j.repo.GetRecipientByEmail(tx, oldEmail)
+
+		if err != nil {
+			return fmt.Errorf("couldn't check for existing recipient: %v", err)
+		} else if !found {
+			j.log.Info.Printf("ignoring change of email of unknown recipient %s", oldEmail)
+			return nil
+		}
+
+		_, found, err = j.repo.GetRecipientByEmail(tx, newEmail)
+
+		if err != nil {
+			return fmt.Errorf("couldn't check for existing recipient: %v", err)
+		} else if found {
+			j.log.Error.Printf("ignoring change of email of recipient %s, since recipient %s already exists", oldEmail,
+				newEmail)
+			return nil
+		}
+
+		rec.Email = newEmail
+
+		err = j.repo.UpdateRecipient(tx, rec)
+
+		if err != nil {
+			return fmt.Errorf("couldn't update recipient: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func (j *repositoryJournal) getOrInsertRecipient(tx *sql.Tx, email string) (int, error) {
+	rec, found, err := j.repo.GetRecipientByEmail(tx, email)
+
+	if err != nil {
+		return 0, fmt.Errorf("couldn't check for existing recipient: %v", err)
+	} else if found {
+		return rec.ID, nil
+	}
+
+	id, err := j.repo.InsertRecipient(tx, Recipient{Email: email})
+
+	if err != nil {
+		return 0, fmt.Errorf("couldn't insert recipient: %v", err)
+	}
+
+	return id, nil
+}
+
+// RecordRejectedMessage records a message that couldn't be accepted, and the reason why.
+func (j *repositoryJournal) RecordRejectedMessage(text string, reason string) error {
+	return j.repo.DoInTx(func(tx *sql.Tx) error {
+		_, err := j.repo.InsertRejectedMessage(tx, RejectedMessage{
+			created: j.clock.now(),
+			text:    text,
+			reason:  truncate(reason, maxRejectedMessageReasonLength),
+		})
+
+		if err != nil {
+			return fmt.Errorf("couldn't insert rejected message: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func (j *repositoryJournal) recordEvent(tx *sql.Tx, event ListRecipientEvent) error {
+	event.created = j.clock.now()
+	event.detail = truncate(event.detail, maxEventDetailLength)
+
+	_, err := j.repo.InsertListRecipientEvent(tx, event)
+
+	if err != nil {
+		return fmt.Errorf("couldn't record list recipient event: %v", err)
+	}
+
+	return nil
+}
+
+// setFailed schedules the next attempt for a list recipient whose notification failed, remembering the status
+// it should be retried in, or abandons it if it has no attempts remaining.
+func (j *repositoryJournal) setFailed(lr *ListRecipient) {
+	if lr.status != RecipientStatuses.Get("failed") {
+		lr.retryStatus = lr.status
+	}
+	lr.attempts++
+	lr.status, lr.nextAttempt = j.getRetry(lr.attempts)
+
+	if lr.status == RecipientStatuses.Get("abandoned") {
+		j.log.Error.Printf("list recipient #%d failed after %d attempts, abandoning", lr.id, lr.attempts)
+	}
+}
+
+// setDeliveryFailed schedules the next attempt for a delivery that failed, like setFailed.
+func (j *repositoryJournal) setDeliveryFailed(d *ListRecipientDelivery) {
+	if d.status != RecipientStatuses.Get("failed") {
+		d.retryStatus = d.status
+	}
+	d.attempts++
+	d.status, d.nextAttempt = j.getRetry(d.attempts)
+
+	if d.status == RecipientStatuses.Get("abandoned") {
+		j.log.Error.Printf("delivery #%d to %s failed after %d attempts, abandoning", d.id, d.destination, d.attempts)
+	}
+}
+
+// getRetry gets the status and next attempt time after the given number of failed attempts, abandoning once
+// there are no attempts remaining.
+func (j *repositoryJournal) getRetry(attempts int) (RecipientStatus, time.Time) {
+	if attempts >= j.retry.maxAttempts {
+		return RecipientStatuses.Get("abandoned"), time.Time{}
+	}
+	return RecipientStatuses.Get("failed"), j.clock.now().Add(j.retry.getDelay(attempts))
+}
+
+const (
+	maxStatusReasonLength = 1024
+	maxEventDetailLength  = 4096
+
+	maxRejectedMessageReasonLength = 1024
+)
+
+func truncate(s string, length int) string {
+	r := []rune(s)
+	if len(r) <= length {
+		return s
+	}
+	return string(r[:length])
 }
 
 type clock interface {
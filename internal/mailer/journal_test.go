@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -34,6 +35,9 @@ func TestRepositoryJournal_SetRecipientPendingState(t *testing.T) {
 		expectedUpdateListRecipients []ListRecipient
 		onUpdateListRecipient        func(ListRecipient) error
 
+		expectedInsertListRecipientEvents []ListRecipientEvent
+		onInsertListRecipientEvent        func(ListRecipientEvent) (int, error)
+
 		expectedAsString string
 	}{
 		{
@@ -59,6 +63,17 @@ func TestRepositoryJournal_SetRecipientPendingState(t *testing.T) {
 				{recipientID: 1, listID: "a", status: RecipientStatuses.Get("new"), attribs: map[string]string{"k": "v"}, lastModified: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)},
 				{recipientID: 1, listID: "b", status: RecipientStatuses.Get("new"), attribs: map[string]string{"k": "v"}, lastModified: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)},
 			},
+			onInsertListRecipient: func(lr ListRecipient) (int, error) {
+				if lr.listID == "a" {
+					return 1, nil
+				}
+				return 2, nil
+			},
+
+			expectedInsertListRecipientEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), toStatus: RecipientStatuses.Get("new"), sourceMessage: "m"},
+				{listRecipientID: 2, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), toStatus: RecipientStatuses.Get("new"), sourceMessage: "m"},
+			},
 
 			expectedAsString: "",
 		},
@@ -80,6 +95,10 @@ func TestRepositoryJournal_SetRecipientPendingState(t *testing.T) {
 				{recipientID: 1, listID: "a", status: RecipientStatuses.Get("failed")},
 			},
 
+			expectedInsertListRecipientEvents: []ListRecipientEvent{
+				{toStatus: RecipientStatuses.Get("failed"), sourceMessage: "m"},
+			},
+
 			expectedAsString: "",
 		},
 		{
@@ -105,6 +124,10 @@ func TestRepositoryJournal_SetRecipientPendingState(t *testing.T) {
 
 			expectedUpdateListRecipients: []ListRecipient{{id: 1, status: RecipientStatuses.Get("unsubscribing"), lastModified: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)}},
 
+			expectedInsertListRecipientEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), toStatus: RecipientStatuses.Get("unsubscribing"), sourceMessage: "m"},
+			},
+
 			expectedAsString: "",
 		},
 		{
@@ -177,6 +200,31 @@ func TestRepositoryJournal_SetRecipientPendingState(t *testing.T) {
 
 			expectedAsString: "couldn't insert list recipient",
 		},
+		{
+			label:   "on error on record event",
+			email:   "x",
+			listIDs: []string{"a"},
+			status:  RecipientStatuses.Get("new"),
+
+			getRecipientByEmailResult: map[string]recipientResult{
+				"x": {found: true},
+			},
+
+			getListRecipientByEmailAndListIDInvoked: true,
+
+			expectedInsertListRecipients: []ListRecipient{
+				{listID: "a", status: RecipientStatuses.Get("new")},
+			},
+
+			expectedInsertListRecipientEvents: []ListRecipientEvent{
+				{toStatus: RecipientStatuses.Get("new"), sourceMessage: "m"},
+			},
+			onInsertListRecipientEvent: func(event ListRecipientEvent) (int, error) {
+				return 0, errors.New("")
+			},
+
+			expectedAsString: "couldn't record list recipient event",
+		},
 		{
 			label:   "on error on update list recipient",
 			email:   "x",
@@ -215,11 +263,12 @@ func TestRepositoryJournal_SetRecipientPendingState(t *testing.T) {
 			onInsertRecipient:                  tc.onInsertRecipient,
 			onInsertListRecipient:              tc.onInsertListRecipient,
 			onUpdateListRecipient:              tc.onUpdateListRecipient,
+			onInsertListRecipientEvent:         tc.onInsertListRecipientEvent,
 		})
 
 		j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: tc.time}}
 
-		res := j.SetRecipientPendingState(tc.email, tc.listIDs, tc.status, tc.attribs)
+		res := j.SetRecipientPendingState(tc.email, tc.listIDs, tc.status, tc.attribs, "m")
 
 		if r.insertRecipientInvoked != tc.insertRecipientInvoked {
 			t.Errorf("%v: invoked InsertRecipient got %v, want %v", tc.label, r.insertRecipientInvoked, tc.insertRecipientInvoked)
@@ -239,17 +288,22 @@ func TestRepositoryJournal_SetRecipientPendingState(t *testing.T) {
 			t.Errorf("%v: invoked UpdateListRecipient got %v, want %v", tc.label,
 				r.updateListRecipients, tc.expectedUpdateListRecipients)
 		}
+		if !reflect.DeepEqual(r.insertListRecipientEvents, tc.expectedInsertListRecipientEvents) {
+			t.Errorf("%v: invoked InsertListRecipientEvent got %v, want %v", tc.label,
+				r.insertListRecipientEvents, tc.expectedInsertListRecipientEvents)
+		}
 		if !errorMessageStartsWith(res, tc.expectedAsString) {
 			t.Errorf("%v: result error got %q, want %q", tc.label, res, tc.expectedAsString)
 		}
 	}
 }
 
-func TestRepositoryJournal_GetRecipientPendingState(t *testing.T) {
+func TestRepositoryJournal_ForEachRecipientPendingState(t *testing.T) {
 	testCases := []struct {
 		label string
 
 		onGetRecipientDataByStatus func([]RecipientStatus) ([]listRecipientComposite, error)
+		onGetRecipientDataForRetry func(now time.Time) ([]listRecipientComposite, error)
 
 		expectedResult []listRecipientComposite
 		expectedError  error
@@ -269,6 +323,26 @@ func TestRepositoryJournal_GetRecipientPendingState(t *testing.T) {
 			expectedResult: []listRecipientComposite{{email: "a@b.com"}},
 			expectedError:  nil,
 		},
+		{
+			label: "gets recipients due for retry",
+
+			onGetRecipientDataByStatus: func(statuses []RecipientStatus) ([]listRecipientComposite, error) {
+				return []listRecipientComposite{{email: "a@b.com"}}, nil
+			},
+			onGetRecipientDataForRetry: func(now time.Time) ([]listRecipientComposite, error) {
+				if expected := time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local); now != expected {
+					t.Errorf("gets recipients due for retry: GetRecipientDataForRetry "+
+						"now got %v, want %v", now, expected)
+				}
+				return []listRecipientComposite{{email: "c@d.com", status: RecipientStatuses.Get("unsubscribing")}}, nil
+			},
+
+			expectedResult: []listRecipientComposite{
+				{email: "a@b.com"},
+				{email: "c@d.com", status: RecipientStatuses.Get("unsubscribing")},
+			},
+			expectedError: nil,
+		},
 		{
 			label: "returns error on error",
 
@@ -276,6 +350,19 @@ func TestRepositoryJournal_GetRecipientPendingState(t *testing.T) {
 				return nil, errors.New("x")
 			},
 
+			expectedResult: nil,
+			expectedError:  errors.New("x"),
+		},
+		{
+			label: "returns error on retry error",
+
+			onGetRecipientDataByStatus: func(statuses []RecipientStatus) ([]listRecipientComposite, error) {
+				return nil, nil
+			},
+			onGetRecipientDataForRetry: func(now time.Time) ([]listRecipientComposite, error) {
+				return nil, errors.New("x")
+			},
+
 			expectedResult: nil,
 			expectedError:  errors.New("x"),
 		},
@@ -284,10 +371,11 @@ func TestRepositoryJournal_GetRecipientPendingState(t *testing.T) {
 	for _, tc := range testCases {
 		r := &simpleTestRepository{
 			onGetRecipientDataByStatus: tc.onGetRecipientDataByStatus,
+			onGetRecipientDataForRetry: tc.onGetRecipientDataForRetry,
 		}
-		j := &repositoryJournal{log: NOOPLog, repo: r}
+		j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)}}
 
-		res, err := j.GetRecipientPendingState()
+		res, err := getRecipientPendingState(j)
 
 		if !r.getRecipientDataByStatusInvoked {
 			t.Errorf("%v: invoked GetRecipientDataByStatus got %v, want %v", tc.label,
@@ -302,17 +390,81 @@ func TestRepositoryJournal_GetRecipientPendingState(t *testing.T) {
 	}
 }
 
+func TestRepositoryJournal_ForEachRecipientPendingConfirmation(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: clock}
+
+	for _, email := range []string{"a@b.com", "c@d.com", "e@f.com", "g@h.com"} {
+		j.SetRecipientPendingState(email, []string{"a"}, RecipientStatuses.Get("new"), nil, "{}")
+	}
+	pending, _ := getRecipientPendingState(j)
+	for _, r := range pending[:3] {
+		j.UpdateListRecipient(r.listRecipientID, RecipientStatuses.Get("pending"), nil)
+	}
+
+	testCases := []struct {
+		label  string
+		action func(page []listRecipientComposite) error
+
+		expectedPages []string
+		expectedError error
+	}{
+		{
+			label:         "gets pending recipients in pages",
+			expectedPages: []string{"a@b.com c@d.com", "e@f.com"},
+		},
+		{
+			label:         "stops iteration",
+			action:        func(page []listRecipientComposite) error { return errStopIteration },
+			expectedPages: []string{"a@b.com c@d.com"},
+		},
+		{
+			label:         "returns action error",
+			action:        func(page []listRecipientComposite) error { return errors.New("x") },
+			expectedPages: []string{"a@b.com c@d.com"},
+			expectedError: errors.New("x"),
+		},
+	}
+
+	for _, tc := range testCases {
+		var pages []string
+
+		err := j.ForEachRecipientPendingConfirmation(2, func(page []listRecipientComposite) error {
+			emails := make([]string, len(page))
+			for i, r := range page {
+				emails[i] = r.email
+			}
+			pages = append(pages, strings.Join(emails, " "))
+
+			if tc.action != nil {
+				return tc.action(page)
+			}
+			return nil
+		})
+
+		if !reflect.DeepEqual(pages, tc.expectedPages) {
+			t.Errorf("%v: pages got %q, want %q", tc.label, pages, tc.expectedPages)
+		}
+		if !errorEquals(err, tc.expectedError) {
+			t.Errorf("%v: error got %v, want %v", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
 func TestRepositoryJournal_UpdateListRecipient(t *testing.T) {
 	testCases := []struct {
 		label string
 
 		listRecipientID int
 		status          RecipientStatus
+		cause           error
 
 		onGetListRecipient         func(listRecipientID int) (ListRecipient, error)
 		updateListRecipientInvoked bool
 		onUpdateListRecipient      func(lr ListRecipient) error
 
+		expectedEvents []ListRecipientEvent
+
 		expected error
 	}{
 		{
@@ -337,6 +489,170 @@ func TestRepositoryJournal_UpdateListRecipient(t *testing.T) {
 				return nil
 			},
 
+			expectedEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), toStatus: RecipientStatuses.Get("subscribed")},
+			},
+
+			expected: nil,
+		},
+		{
+			label: "resets retry on success",
+
+			listRecipientID: 1,
+			status:          RecipientStatuses.Get("subscribed"),
+
+			onGetListRecipient: func(listRecipientID int) (ListRecipient, error) {
+				return ListRecipient{recipientID: 2, status: RecipientStatuses.Get("failed"),
+					retryStatus: RecipientStatuses.Get("new"), attempts: 2,
+					nextAttempt: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)}, nil
+			},
+
+			updateListRecipientInvoked: true,
+			onUpdateListRecipient: func(lr ListRecipient) error {
+				expected := ListRecipient{recipientID: 2, status: RecipientStatuses.Get("subscribed")}
+				if !reflect.DeepEqual(lr, expected) {
+					t.Errorf("resets retry on success: UpdateListRecipient got %v, want %v", lr, expected)
+				}
+				return nil
+			},
+
+			expectedEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), fromStatus: RecipientStatuses.Get("failed"), toStatus: RecipientStatuses.Get("subscribed")},
+			},
+
+			expected: nil,
+		},
+		{
+			label: "schedules retry on first failure",
+
+			listRecipientID: 1,
+			status:          RecipientStatuses.Get("failed"),
+
+			onGetListRecipient: func(listRecipientID int) (ListRecipient, error) {
+				return ListRecipient{recipientID: 2, status: RecipientStatuses.Get("unsubscribing")}, nil
+			},
+
+			updateListRecipientInvoked: true,
+			onUpdateListRecipient: func(lr ListRecipient) error {
+				expected := ListRecipient{recipientID: 2, status: RecipientStatuses.Get("failed"),
+					retryStatus: RecipientStatuses.Get("unsubscribing"), attempts: 1,
+					nextAttempt: time.Date(2018, 03, 28, 1, 3, 3, 4, time.Local)}
+				if !reflect.DeepEqual(lr, expected) {
+					t.Errorf("schedules retry on first failure: UpdateListRecipient got %v, want %v", lr, expected)
+				}
+				return nil
+			},
+
+			expectedEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), fromStatus: RecipientStatuses.Get("unsubscribing"), toStatus: RecipientStatuses.Get("failed")},
+			},
+
+			expected: nil,
+		},
+		{
+			label: "records reason on failure",
+
+			listRecipientID: 1,
+			status:          RecipientStatuses.Get("rejected"),
+			cause:           errors.New("x"),
+
+			onGetListRecipient: func(listRecipientID int) (ListRecipient, error) {
+				return ListRecipient{recipientID: 2, status: RecipientStatuses.Get("new")}, nil
+			},
+
+			updateListRecipientInvoked: true,
+			onUpdateListRecipient: func(lr ListRecipient) error {
+				expected := ListRecipient{recipientID: 2, status: RecipientStatuses.Get("rejected"), statusReason: "x"}
+				if !reflect.DeepEqual(lr, expected) {
+					t.Errorf("records reason on failure: UpdateListRecipient got %v, want %v", lr, expected)
+				}
+				return nil
+			},
+
+			expectedEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), fromStatus: RecipientStatuses.Get("new"), toStatus: RecipientStatuses.Get("rejected"), detail: "x"},
+			},
+
+			expected: nil,
+		},
+		{
+			label: "records HTTP status on failure",
+
+			listRecipientID: 1,
+			status:          RecipientStatuses.Get("rejected"),
+			cause:           &MailChimpError{URL: "u", Status: 404},
+
+			onGetListRecipient: func(listRecipientID int) (ListRecipient, error) {
+				return ListRecipient{recipientID: 2, status: RecipientStatuses.Get("unsubscribing")}, nil
+			},
+
+			updateListRecipientInvoked: true,
+			onUpdateListRecipient: func(lr ListRecipient) error {
+				return nil
+			},
+
+			expectedEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), fromStatus: RecipientStatuses.Get("unsubscribing"), toStatus: RecipientStatuses.Get("rejected"), httpStatus: 404,
+					detail: "error received from u: HTTP status 404"},
+			},
+
+			expected: nil,
+		},
+		{
+			label: "schedules retry with backoff on subsequent failure",
+
+			listRecipientID: 1,
+			status:          RecipientStatuses.Get("failed"),
+
+			onGetListRecipient: func(listRecipientID int) (ListRecipient, error) {
+				return ListRecipient{recipientID: 2, status: RecipientStatuses.Get("failed"),
+					retryStatus: RecipientStatuses.Get("new"), attempts: 1}, nil
+			},
+
+			updateListRecipientInvoked: true,
+			onUpdateListRecipient: func(lr ListRecipient) error {
+				expected := ListRecipient{recipientID: 2, status: RecipientStatuses.Get("failed"),
+					retryStatus: RecipientStatuses.Get("new"), attempts: 2,
+					nextAttempt: time.Date(2018, 03, 28, 1, 4, 3, 4, time.Local)}
+				if !reflect.DeepEqual(lr, expected) {
+					t.Errorf("schedules retry with backoff on subsequent failure: UpdateListRecipient got %v, want %v",
+						lr, expected)
+				}
+				return nil
+			},
+
+			expectedEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), fromStatus: RecipientStatuses.Get("failed"), toStatus: RecipientStatuses.Get("failed")},
+			},
+
+			expected: nil,
+		},
+		{
+			label: "abandons after max attempts",
+
+			listRecipientID: 1,
+			status:          RecipientStatuses.Get("failed"),
+
+			onGetListRecipient: func(listRecipientID int) (ListRecipient, error) {
+				return ListRecipient{recipientID: 2, status: RecipientStatuses.Get("failed"),
+					retryStatus: RecipientStatuses.Get("new"), attempts: 2,
+					nextAttempt: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)}, nil
+			},
+
+			updateListRecipientInvoked: true,
+			onUpdateListRecipient: func(lr ListRecipient) error {
+				expected := ListRecipient{recipientID: 2, status: RecipientStatuses.Get("abandoned"),
+					retryStatus: RecipientStatuses.Get("new"), attempts: 3}
+				if !reflect.DeepEqual(lr, expected) {
+					t.Errorf("abandons after max attempts: UpdateListRecipient got %v, want %v", lr, expected)
+				}
+				return nil
+			},
+
+			expectedEvents: []ListRecipientEvent{
+				{listRecipientID: 1, created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), fromStatus: RecipientStatuses.Get("failed"), toStatus: RecipientStatuses.Get("abandoned")},
+			},
+
 			expected: nil,
 		},
 		{
@@ -371,9 +687,11 @@ func TestRepositoryJournal_UpdateListRecipient(t *testing.T) {
 			onGetListRecipient:    tc.onGetListRecipient,
 			onUpdateListRecipient: tc.onUpdateListRecipient,
 		}
-		j := &repositoryJournal{log: NOOPLog, repo: r}
+		j := &repositoryJournal{log: NOOPLog, repo: r,
+			clock: &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)},
+			retry: NewRetryPolicy(3, time.Minute, time.Hour)}
 
-		err := j.UpdateListRecipient(tc.listRecipientID, tc.status)
+		err := j.UpdateListRecipient(tc.listRecipientID, tc.status, tc.cause)
 
 		if !r.getListRecipientInvoked {
 			t.Errorf("%v: GetListRecipient invoked got %v, want %v", tc.label, r.getListRecipientInvoked, true)
@@ -381,12 +699,431 @@ func TestRepositoryJournal_UpdateListRecipient(t *testing.T) {
 		if r.updateListRecipientInvoked != tc.updateListRecipientInvoked {
 			t.Errorf("%v: UpdateListRecipient invoked got %v, want %v", tc.label, r.updateListRecipientInvoked, tc.updateListRecipientInvoked)
 		}
+		if !reflect.DeepEqual(r.insertListRecipientEvents, tc.expectedEvents) {
+			t.Errorf("%v: InsertListRecipientEvent got %v, want %v", tc.label, r.insertListRecipientEvents, tc.expectedEvents)
+		}
 		if !errorEquals(err, tc.expected) {
 			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expected)
 		}
 	}
 }
 
+func TestRepositoryJournal_SetRecipientPendingStateOfDeliveries(t *testing.T) {
+	now := time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)
+
+	r := newJournalTestRepository(journalTestRepositoryParams{
+		getRecipientByEmailResults: map[string]recipientResult{"x": {recipient: Recipient{ID: 1}, found: true}},
+		onGetListRecipientByEmailAndListID: func(email string, listID string) (ListRecipient, bool, error) {
+			return ListRecipient{id: 2, listID: listID, status: RecipientStatuses.Get("subscribed")}, true, nil
+		},
+		existingDeliveries: map[string]ListRecipientDelivery{
+			"crm": {id: 3, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("failed"),
+				retryStatus: RecipientStatuses.Get("new"), attempts: 1, nextAttempt: now},
+		},
+	})
+	j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: now},
+		destinations: map[string][]string{"a": {"crm", "slack"}}}
+
+	err := j.SetRecipientPendingState("x", []string{"a", "b"}, RecipientStatuses.Get("unsubscribing"), nil, "m")
+
+	if err != nil {
+		t.Errorf("result error got %q, want nil", err)
+	}
+	if expected := []ListRecipientDelivery{
+		{id: 3, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("unsubscribing"), lastModified: now},
+	}; !reflect.DeepEqual(r.updateDeliveries, expected) {
+		t.Errorf("UpdateListRecipientDelivery got %v, want %v", r.updateDeliveries, expected)
+	}
+	if expected := []ListRecipientDelivery{
+		{listRecipientID: 2, destination: "slack", status: RecipientStatuses.Get("unsubscribing"), lastModified: now},
+	}; !reflect.DeepEqual(r.insertDeliveries, expected) {
+		t.Errorf("InsertListRecipientDelivery got %v, want %v", r.insertDeliveries, expected)
+	}
+	if expected := []ListRecipientEvent{
+		{listRecipientID: 2, created: now, fromStatus: RecipientStatuses.Get("subscribed"), toStatus: RecipientStatuses.Get("unsubscribing"), sourceMessage: "m"},
+		{listRecipientID: 2, destination: "crm", created: now, fromStatus: RecipientStatuses.Get("failed"), toStatus: RecipientStatuses.Get("unsubscribing"), sourceMessage: "m"},
+		{listRecipientID: 2, destination: "slack", created: now, toStatus: RecipientStatuses.Get("unsubscribing"), sourceMessage: "m"},
+		{listRecipientID: 2, created: now, fromStatus: RecipientStatuses.Get("subscribed"), toStatus: RecipientStatuses.Get("unsubscribing"), sourceMessage: "m"},
+	}; !reflect.DeepEqual(r.insertListRecipientEvents, expected) {
+		t.Errorf("InsertListRecipientEvent got %v, want %v", r.insertListRecipientEvents, expected)
+	}
+}
+
+func TestRepositoryJournal_ForEachPendingDelivery(t *testing.T) {
+	now := time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)
+
+	r := &simpleTestRepository{
+		onGetDeliveryDataByStatus: func(statuses []RecipientStatus) ([]listRecipientComposite, error) {
+			if expected := []RecipientStatus{"new", "unsubscribing"}; !reflect.DeepEqual(statuses, expected) {
+				t.Errorf("GetDeliveryDataByStatus got %v, want %v", statuses, expected)
+			}
+			return []listRecipientComposite{{deliveryID: 1}}, nil
+		},
+		onGetDeliveryDataForRetry: func(actual time.Time) ([]listRecipientComposite, error) {
+			if actual != now {
+				t.Errorf("GetDeliveryDataForRetry got %v, want %v", actual, now)
+			}
+			return []listRecipientComposite{{deliveryID: 2}}, nil
+		},
+	}
+	j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: now}}
+
+	res, err := getPendingDeliveries(j)
+
+	if expected := []listRecipientComposite{{deliveryID: 1}, {deliveryID: 2}}; !reflect.DeepEqual(res, expected) {
+		t.Errorf("result got %v, want %v", res, expected)
+	}
+	if err != nil {
+		t.Errorf("result error got %q, want nil", err)
+	}
+}
+
+func TestRepositoryJournal_UpdateDelivery(t *testing.T) {
+	now := time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)
+
+	testCases := []struct {
+		label string
+
+		status RecipientStatus
+		cause  error
+
+		existing ListRecipientDelivery
+
+		expectedUpdate ListRecipientDelivery
+		expectedEvent  ListRecipientEvent
+	}{
+		{
+			label: "updated with status",
+
+			status: RecipientStatuses.Get("subscribed"),
+
+			existing: ListRecipientDelivery{id: 1, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("failed"),
+				retryStatus: RecipientStatuses.Get("new"), attempts: 1, nextAttempt: now},
+
+			expectedUpdate: ListRecipientDelivery{id: 1, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("subscribed")},
+			expectedEvent: ListRecipientEvent{listRecipientID: 2, destination: "crm", created: now,
+				fromStatus: RecipientStatuses.Get("failed"), toStatus: RecipientStatuses.Get("subscribed")},
+		},
+		{
+			label: "schedules retry on failure",
+
+			status: RecipientStatuses.Get("failed"),
+			cause:  &ProviderError{URL: "u", Status: 503},
+
+			existing: ListRecipientDelivery{id: 1, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("new")},
+
+			expectedUpdate: ListRecipientDelivery{id: 1, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("failed"),
+				statusReason: "error received from u: HTTP status 503", retryStatus: RecipientStatuses.Get("new"), attempts: 1,
+				nextAttempt: now.Add(time.Minute)},
+			expectedEvent: ListRecipientEvent{listRecipientID: 2, destination: "crm", created: now,
+				fromStatus: RecipientStatuses.Get("new"), toStatus: RecipientStatuses.Get("failed"), httpStatus: 503,
+				detail: "error received from u: HTTP status 503"},
+		},
+		{
+			label: "abandons after max attempts",
+
+			status: RecipientStatuses.Get("failed"),
+
+			existing: ListRecipientDelivery{id: 1, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("failed"),
+				retryStatus: RecipientStatuses.Get("unsubscribing"), attempts: 2, nextAttempt: now},
+
+			expectedUpdate: ListRecipientDelivery{id: 1, listRecipientID: 2, destination: "crm", status: RecipientStatuses.Get("abandoned"),
+				retryStatus: RecipientStatuses.Get("unsubscribing"), attempts: 3},
+			expectedEvent: ListRecipientEvent{listRecipientID: 2, destination: "crm", created: now,
+				fromStatus: RecipientStatuses.Get("failed"), toStatus: RecipientStatuses.Get("abandoned")},
+		},
+	}
+
+	for _, tc := range testCases {
+		r := &simpleTestRepository{
+			onGetListRecipientDelivery: func(deliveryID int) (ListRecipientDelivery, error) {
+				if deliveryID != 1 {
+					t.Errorf("%v: GetListRecipientDelivery got %v, want 1", tc.label, deliveryID)
+				}
+				return tc.existing, nil
+			},
+		}
+		j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: now},
+			retry: NewRetryPolicy(3, time.Minute, time.Hour)}
+
+		err := j.UpdateDelivery(1, tc.status, tc.cause)
+
+		if expected := []ListRecipientDelivery{tc.expectedUpdate}; !reflect.DeepEqual(r.updateDeliveries, expected) {
+			t.Errorf("%v: UpdateListRecipientDelivery got %v, want %v", tc.label, r.updateDeliveries, expected)
+		}
+		if expected := []ListRecipientEvent{tc.expectedEvent}; !reflect.DeepEqual(r.insertListRecipientEvents, expected) {
+			t.Errorf("%v: InsertListRecipientEvent got %v, want %v", tc.label, r.insertListRecipientEvents, expected)
+		}
+		if err != nil {
+			t.Errorf("%v: result error got %q, want nil", tc.label, err)
+		}
+	}
+
+	r := &simpleTestRepository{
+		onGetListRecipientDelivery: func(deliveryID int) (ListRecipientDelivery, error) {
+			return ListRecipientDelivery{}, errors.New("x")
+		},
+	}
+	j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: now}}
+
+	if err := j.UpdateDelivery(1, RecipientStatuses.Get("subscribed"), nil); !errorEquals(err, errors.New("couldn't get existing delivery: x")) {
+		t.Errorf("on get delivery error: result error got %q", err)
+	}
+}
+
+func TestRepositoryJournal_SetListRecipientStatus(t *testing.T) {
+	now := time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)
+
+	testCases := []struct {
+		label string
+
+		getRecipientByEmailResult          map[string]recipientResult
+		onGetListRecipientByEmailAndListID func(email string, listID string) (ListRecipient, bool, error)
+
+		expectedInsertRecipient           Recipient
+		expectedInsertListRecipients      []ListRecipient
+		expectedUpdateListRecipients      []ListRecipient
+		expectedInsertListRecipientEvents []ListRecipientEvent
+	}{
+		{
+			label: "updates existing list recipient",
+
+			onGetListRecipientByEmailAndListID: func(email string, listID string) (ListRecipient, bool, error) {
+				return ListRecipient{id: 2, listID: "a", recipientID: 1, status: RecipientStatuses.Get("subscribed"),
+					statusReason: "x"}, true, nil
+			},
+
+			expectedUpdateListRecipients: []ListRecipient{
+				{id: 2, listID: "a", recipientID: 1, status: RecipientStatuses.Get("unsubscribed"), statusReason: "r",
+					lastModified: now},
+			},
+			expectedInsertListRecipientEvents: []ListRecipientEvent{
+				{listRecipientID: 2, created: now, fromStatus: RecipientStatuses.Get("subscribed"),
+					toStatus: RecipientStatuses.Get("unsubscribed"), sourceMessage: "m", detail: "r"},
+			},
+		},
+		{
+			label: "ignores list recipient pending subscription",
+
+			onGetListRecipientByEmailAndListID: func(email string, listID string) (ListRecipient, bool, error) {
+				return ListRecipient{id: 2, status: RecipientStatuses.Get("new")}, true, nil
+			},
+		},
+		{
+			label: "ignores list recipient pending unsubscription",
+
+			onGetListRecipientByEmailAndListID: func(email string, listID string) (ListRecipient, bool, error) {
+				return ListRecipient{id: 2, status: RecipientStatuses.Get("unsubscribing")}, true, nil
+			},
+		},
+		{
+			label: "ignores failed list recipient pending retry",
+
+			onGetListRecipientByEmailAndListID: func(email string, listID string) (ListRecipient, bool, error) {
+				return ListRecipient{id: 2, status: RecipientStatuses.Get("failed"),
+					retryStatus: RecipientStatuses.Get("new"), attempts: 1, nextAttempt: now}, true, nil
+			},
+		},
+		{
+			label: "ignores unchanged status",
+
+			onGetListRecipientByEmailAndListID: func(email string, listID string) (ListRecipient, bool, error) {
+				return ListRecipient{id: 2, status: RecipientStatuses.Get("unsubscribed")}, true, nil
+			},
+		},
+		{
+			label: "inserts unknown recipient",
+
+			getRecipientByEmailResult: map[string]recipientResult{},
+
+			expectedInsertRecipient: Recipient{Email: "x"},
+			expectedInsertListRecipients: []ListRecipient{
+				{recipientID: 1, listID: "a", status: RecipientStatuses.Get("unsubscribed"), lastModified: now},
+			},
+			expectedInsertListRecipientEvents: []ListRecipientEvent{
+				{listRecipientID: 3, created: now, toStatus: RecipientStatuses.Get("unsubscribed"), sourceMessage: "m",
+					detail: "r"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		r := newJournalTestRepository(journalTestRepositoryParams{
+			getRecipientByEmailResults:         tc.getRecipientByEmailResult,
+			onGetListRecipientByEmailAndListID: tc.onGetListRecipientByEmailAndListID,
+			onInsertRecipient: func(recipient Recipient) (int, error) {
+				return 1, nil
+			},
+			onInsertListRecipient: func(lr ListRecipient) (int, error) {
+				return 3, nil
+			},
+		})
+		j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: now}}
+
+		err := j.SetListRecipientStatus("x", "a", RecipientStatuses.Get("unsubscribed"), "r", "m")
+
+		if err != nil {
+			t.Errorf("%v: result error got %v, want nil", tc.label, err)
+		}
+		if r.insertRecipient != tc.expectedInsertRecipient {
+			t.Errorf("%v: inserted recipient got %v, want %v", tc.label, r.insertRecipient, tc.expectedInsertRecipient)
+		}
+		if !reflect.DeepEqual(r.insertListRecipients, tc.expectedInsertListRecipients) {
+			t.Errorf("%v: inserted list recipients got %v, want %v", tc.label, r.insertListRecipients, tc.expectedInsertListRecipients)
+		}
+		if !reflect.DeepEqual(r.updateListRecipients, tc.expectedUpdateListRecipients) {
+			t.Errorf("%v: updated list recipients got %v, want %v", tc.label, r.updateListRecipients, tc.expectedUpdateListRecipients)
+		}
+		if !reflect.DeepEqual(r.insertListRecipientEvents, tc.expectedInsertListRecipientEvents) {
+			t.Errorf("%v: inserted events got %v, want %v", tc.label, r.insertListRecipientEvents, tc.expectedInsertListRecipientEvents)
+		}
+	}
+}
+
+func TestRepositoryJournal_UpdateListRecipientAttributes(t *testing.T) {
+	r := newJournalTestRepository(journalTestRepositoryParams{
+		onGetListRecipientByEmailAndListID: func(email string, listID string) (ListRecipient, bool, error) {
+			return ListRecipient{id: 2, attribs: map[string]string{"k1": "v1", "k2": "v2"}}, true, nil
+		},
+	})
+	j := &repositoryJournal{log: NOOPLog, repo: r}
+
+	err := j.UpdateListRecipientAttributes("x", "a", map[string]string{"k2": "v3"})
+
+	if err != nil {
+		t.Errorf("result error got %v, want nil", err)
+	}
+	expected := []ListRecipient{{id: 2, attribs: map[string]string{"k1": "v1", "k2": "v3"}}}
+	if !reflect.DeepEqual(r.updateListRecipients, expected) {
+		t.Errorf("updated list recipients got %v, want %v", r.updateListRecipients, expected)
+	}
+}
+
+func TestRepositoryJournal_RenameRecipient(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		getRecipientByEmailResult map[string]recipientResult
+
+		expectedUpdateRecipients []Recipient
+		expectedError            error
+	}{
+		{
+			label: "renames recipient",
+
+			getRecipientByEmailResult: map[string]recipientResult{
+				"x": {recipient: Recipient{ID: 1, Email: "x"}, found: true},
+			},
+
+			expectedUpdateRecipients: []Recipient{{ID: 1, Email: "y"}},
+		},
+		{
+			label: "ignores unknown recipient",
+
+			getRecipientByEmailResult: map[string]recipientResult{},
+		},
+		{
+			label: "ignores existing new email",
+
+			getRecipientByEmailResult: map[string]recipientResult{
+				"x": {recipient: Recipient{ID: 1, Email: "x"}, found: true},
+				"y": {recipient: Recipient{ID: 2, Email: "y"}, found: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		r := newJournalTestRepository(journalTestRepositoryParams{getRecipientByEmailResults: tc.getRecipientByEmailResult})
+		j := &repositoryJournal{log: NOOPLog, repo: r}
+
+		err := j.RenameRecipient("x", "y")
+
+		if !reflect.DeepEqual(r.updateRecipients, tc.expectedUpdateRecipients) {
+			t.Errorf("%v: updated recipients got %v, want %v", tc.label, r.updateRecipients, tc.expectedUpdateRecipients)
+		}
+		if !errorEquals(err, tc.expectedError) {
+			t.Errorf("%v: result error got %v, want %v", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestRepositoryJournal_RecordRejectedMessage(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		reason string
+
+		onInsertRejectedMessage func(msg RejectedMessage) (int, error)
+
+		expectedInsertRejectedMessage RejectedMessage
+		expected                      string
+	}{
+		{
+			label:  "inserts rejected message",
+			reason: "y",
+
+			expectedInsertRejectedMessage: RejectedMessage{
+				created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), text: "x", reason: "y"},
+			expected: "",
+		},
+		{
+			label:  "truncates reason",
+			reason: strings.Repeat("y", 1025),
+
+			expectedInsertRejectedMessage: RejectedMessage{
+				created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), text: "x", reason: strings.Repeat("y", 1024)},
+			expected: "",
+		},
+		{
+			label:  "returns error on insert error",
+			reason: "y",
+
+			onInsertRejectedMessage: func(msg RejectedMessage) (int, error) {
+				return 0, errors.New("z")
+			},
+
+			expectedInsertRejectedMessage: RejectedMessage{
+				created: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local), text: "x", reason: "y"},
+			expected: "couldn't insert rejected message: z",
+		},
+	}
+
+	for _, tc := range testCases {
+		r := &simpleTestRepository{onInsertRejectedMessage: tc.onInsertRejectedMessage}
+		j := &repositoryJournal{log: NOOPLog, repo: r, clock: &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 4, time.Local)}}
+
+		err := j.RecordRejectedMessage("x", tc.reason)
+
+		if !reflect.DeepEqual(r.insertRejectedMessage, tc.expectedInsertRejectedMessage) {
+			t.Errorf("%v: invoked InsertRejectedMessage got %v, want %v", tc.label, r.insertRejectedMessage, tc.expectedInsertRejectedMessage)
+		}
+		if !errorMessageEquals(err, tc.expected) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expected)
+		}
+	}
+}
+
+func TestRetryPolicy_GetDelay(t *testing.T) {
+	testCases := []struct {
+		label    string
+		attempts int
+		expected time.Duration
+	}{
+		{label: "after 1 attempt", attempts: 1, expected: time.Minute},
+		{label: "after 2 attempts", attempts: 2, expected: 2 * time.Minute},
+		{label: "after 3 attempts", attempts: 3, expected: 4 * time.Minute},
+		{label: "after many attempts", attempts: 100, expected: 10 * time.Minute},
+	}
+
+	p := NewRetryPolicy(0, time.Minute, 10*time.Minute)
+
+	for _, tc := range testCases {
+		if res := p.getDelay(tc.attempts); res != tc.expected {
+			t.Errorf("%v: result got %v, want %v", tc.label, res, tc.expected)
+		}
+	}
+}
+
 type journalTestRepositoryParams struct {
 	getRecipientByEmailResults map[string]recipientResult
 
@@ -402,6 +1139,15 @@ type journalTestRepositoryParams struct {
 
 	updateListRecipients  []ListRecipient
 	onUpdateListRecipient func(ListRecipient) error
+
+	insertListRecipientEvents  []ListRecipientEvent
+	onInsertListRecipientEvent func(ListRecipientEvent) (int, error)
+
+	updateRecipients []Recipient
+
+	existingDeliveries map[string]ListRecipientDelivery
+	insertDeliveries   []ListRecipientDelivery
+	updateDeliveries   []ListRecipientDelivery
 }
 
 type journalTestRepository struct {
@@ -427,6 +1173,11 @@ func newJournalTestRepository(params journalTestRepositoryParams) *journalTestRe
 			return nil
 		}
 	}
+	if r.onInsertListRecipientEvent == nil {
+		r.onInsertListRecipientEvent = func(event ListRecipientEvent) (int, error) {
+			return 0, nil
+		}
+	}
 
 	return r
 }
@@ -442,6 +1193,11 @@ func (r *journalTestRepository) InsertRecipient(tx *sql.Tx, rec Recipient) (int,
 	return r.onInsertRecipient(rec)
 }
 
+func (r *journalTestRepository) UpdateRecipient(tx *sql.Tx, rec Recipient) error {
+	r.updateRecipients = append(r.updateRecipients, rec)
+	return nil
+}
+
 func (r *journalTestRepository) GetListRecipientByEmailAndListID(tx *sql.Tx, email string, listID string) (
 	listRecipient ListRecipient, found bool, err error) {
 	r.getListRecipientByEmailAndListIDInvoked = true
@@ -458,6 +1214,27 @@ func (r *journalTestRepository) UpdateListRecipient(tx *sql.Tx, lr ListRecipient
 	return r.onUpdateListRecipient(lr)
 }
 
+func (r *journalTestRepository) InsertListRecipientEvent(tx *sql.Tx, event ListRecipientEvent) (int, error) {
+	r.insertListRecipientEvents = append(r.insertListRecipientEvents, event)
+	return r.onInsertListRecipientEvent(event)
+}
+
+func (r *journalTestRepository) GetListRecipientDeliveryByDestination(tx *sql.Tx, listRecipientID int,
+	destination string) (ListRecipientDelivery, bool, error) {
+	d, found := r.existingDeliveries[destination]
+	return d, found, nil
+}
+
+func (r *journalTestRepository) InsertListRecipientDelivery(tx *sql.Tx, d ListRecipientDelivery) (int, error) {
+	r.insertDeliveries = append(r.insertDeliveries, d)
+	return 0, nil
+}
+
+func (r *journalTestRepository) UpdateListRecipientDelivery(tx *sql.Tx, d ListRecipientDelivery) error {
+	r.updateDeliveries = append(r.updateDeliveries, d)
+	return nil
+}
+
 func (r *journalTestRepository) DoInTx(action func(*sql.Tx) error) error {
 	return action(nil)
 }
@@ -468,18 +1245,40 @@ type simpleTestRepository struct {
 	getRecipientDataByStatusInvoked bool
 	onGetRecipientDataByStatus      func([]RecipientStatus) ([]listRecipientComposite, error)
 
+	onGetRecipientDataForRetry func(now time.Time) ([]listRecipientComposite, error)
+
 	getListRecipientInvoked bool
 	onGetListRecipient      func(listRecipientID int) (ListRecipient, error)
 
 	updateListRecipientInvoked bool
 	onUpdateListRecipient      func(listRecipient ListRecipient) error
+
+	insertListRecipientEvents []ListRecipientEvent
+
+	insertRejectedMessage   RejectedMessage
+	onInsertRejectedMessage func(msg RejectedMessage) (int, error)
+
+	onGetDeliveryDataByStatus func([]RecipientStatus) ([]listRecipientComposite, error)
+	onGetDeliveryDataForRetry func(now time.Time) ([]listRecipientComposite, error)
+
+	onGetListRecipientDelivery func(deliveryID int) (ListRecipientDelivery, error)
+	updateDeliveries           []ListRecipientDelivery
 }
 
-func (r *simpleTestRepository) GetRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus) ([]listRecipientComposite, error) {
+func (r *simpleTestRepository) GetRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) (
+	[]listRecipientComposite, error) {
 	r.getRecipientDataByStatusInvoked = true
 	return r.onGetRecipientDataByStatus(statuses)
 }
 
+func (r *simpleTestRepository) GetRecipientDataForRetry(tx *sql.Tx, now time.Time, page Page) (
+	[]listRecipientComposite, error) {
+	if r.onGetRecipientDataForRetry == nil {
+		return nil, nil
+	}
+	return r.onGetRecipientDataForRetry(now)
+}
+
 func (r *simpleTestRepository) GetListRecipient(tx *sql.Tx, listRecipientID int) (ListRecipient, error) {
 	r.getListRecipientInvoked = true
 	return r.onGetListRecipient(listRecipientID)
@@ -490,10 +1289,72 @@ func (r *simpleTestRepository) UpdateListRecipient(tx *sql.Tx, lr ListRecipient)
 	return r.onUpdateListRecipient(lr)
 }
 
+func (r *simpleTestRepository) InsertListRecipientEvent(tx *sql.Tx, event ListRecipientEvent) (int, error) {
+	r.insertListRecipientEvents = append(r.insertListRecipientEvents, event)
+	return 0, nil
+}
+
+func (r *simpleTestRepository) InsertRejectedMessage(tx *sql.Tx, msg RejectedMessage) (int, error) {
+	r.insertRejectedMessage = msg
+	if r.onInsertRejectedMessage == nil {
+		return 0, nil
+	}
+	return r.onInsertRejectedMessage(msg)
+}
+
+func (r *simpleTestRepository) GetDeliveryDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) (
+	[]listRecipientComposite, error) {
+	return r.onGetDeliveryDataByStatus(statuses)
+}
+
+func (r *simpleTestRepository) GetDeliveryDataForRetry(tx *sql.Tx, now time.Time, page Page) (
+	[]listRecipientComposite, error) {
+	return r.onGetDeliveryDataForRetry(now)
+}
+
+func (r *simpleTestRepository) GetListRecipientDelivery(tx *sql.Tx, deliveryID int) (ListRecipientDelivery, error) {
+	return r.onGetListRecipientDelivery(deliveryID)
+}
+
+func (r *simpleTestRepository) UpdateListRecipientDelivery(tx *sql.Tx, d ListRecipientDelivery) error {
+	r.updateDeliveries = append(r.updateDeliveries, d)
+	return nil
+}
+
 func (r *simpleTestRepository) DoInTx(action func(*sql.Tx) error) error {
 	return action(nil)
 }
 
+// getRecipientPendingState gets all the list recipients the journal passes to an action in pages.
+func getRecipientPendingState(j *repositoryJournal) ([]listRecipientComposite, error) {
+	var result []listRecipientComposite
+	err := j.ForEachRecipientPendingState(0, 0, func(page []listRecipientComposite) error {
+		result = append(result, page...)
+		return nil
+	})
+	return result, err
+}
+
+// getPendingDeliveries gets all the deliveries the journal passes to an action in pages.
+func getPendingDeliveries(j *repositoryJournal) ([]listRecipientComposite, error) {
+	var result []listRecipientComposite
+	err := j.ForEachPendingDelivery(0, 0, func(page []listRecipientComposite) error {
+		result = append(result, page...)
+		return nil
+	})
+	return result, err
+}
+
+// getListRecipients gets all the recipients of a list the journal passes to an action in pages.
+func getListRecipients(j *repositoryJournal, listID string) ([]listRecipientComposite, error) {
+	var result []listRecipientComposite
+	err := j.ForEachListRecipient(listID, 0, func(page []listRecipientComposite) error {
+		result = append(result, page...)
+		return nil
+	})
+	return result, err
+}
+
 type testClock struct {
 	time time.Time
 }
@@ -501,3 +1362,160 @@ type testClock struct {
 func (c *testClock) now() time.Time {
 	return c.time
 }
+
+func TestRepositoryJournal_WithMemoryRepository(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: clock,
+		retry: NewRetryPolicy(3, time.Minute, time.Hour), destinations: map[string][]string{"b": {"crm"}}}
+
+	err := j.SetRecipientPendingState("a@b.com", []string{"a", "b"}, RecipientStatuses.Get("new"),
+		map[string]string{"x": "y"}, "{}")
+	if err != nil {
+		t.Fatalf("SetRecipientPendingState() error got %q, want nil", err)
+	}
+
+	pending, err := getRecipientPendingState(j)
+	if err != nil || len(pending) != 2 || pending[0].listID != "a" || pending[1].listID != "b" ||
+		!reflect.DeepEqual(pending[0].attribs, map[string]string{"x": "y"}) {
+		t.Fatalf("ForEachRecipientPendingState() got %v, %v, want new recipients of a and b", pending, err)
+	}
+	if deliveries, err := getPendingDeliveries(j); err != nil || len(deliveries) != 1 ||
+		deliveries[0].destination != "crm" {
+		t.Errorf("ForEachPendingDelivery() got %v, %v, want delivery to crm", deliveries, err)
+	}
+
+	err = j.UpdateListRecipient(pending[0].listRecipientID, RecipientStatuses.Get("failed"), errors.New("timeout"))
+	if err != nil {
+		t.Fatalf("UpdateListRecipient() error got %q, want nil", err)
+	}
+	if pending, _ := getRecipientPendingState(j); len(pending) != 1 || pending[0].listID != "b" {
+		t.Errorf("ForEachRecipientPendingState() before retry got %v, want recipient of b", pending)
+	}
+
+	clock.time = clock.time.Add(time.Minute)
+	if pending, _ := getRecipientPendingState(j); len(pending) != 2 || pending[1].listID != "a" ||
+		pending[1].status != RecipientStatuses.Get("new") {
+		t.Errorf("ForEachRecipientPendingState() after retry delay got %v, want new recipients of a and b", pending)
+	}
+}
+
+func TestRepositoryJournal_ForEachRecipientPendingStatePages(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: clock,
+		retry: NewRetryPolicy(3, time.Minute, time.Hour)}
+
+	for _, email := range []string{"a@b.com", "c@d.com", "e@f.com", "g@h.com", "i@j.com"} {
+		j.SetRecipientPendingState(email, []string{"a"}, RecipientStatuses.Get("new"), nil, "{}")
+	}
+	pending, _ := getRecipientPendingState(j)
+	j.UpdateListRecipient(pending[0].listRecipientID, RecipientStatuses.Get("failed"), errors.New("timeout"))
+	clock.time = clock.time.Add(time.Minute)
+
+	testCases := []struct {
+		label    string
+		maxItems int
+		action   func(page []listRecipientComposite) error
+
+		expectedPages []string
+		expectedError error
+	}{
+		{
+			label:         "gets pending, then retries, in pages",
+			expectedPages: []string{"c@d.com e@f.com", "g@h.com i@j.com", "a@b.com"},
+		},
+		{
+			label:         "gets up to max items",
+			maxItems:      3,
+			expectedPages: []string{"c@d.com e@f.com", "g@h.com"},
+		},
+		{
+			label:         "gets up to max items including retries",
+			maxItems:      5,
+			expectedPages: []string{"c@d.com e@f.com", "g@h.com i@j.com", "a@b.com"},
+		},
+		{
+			label:         "stops iteration",
+			action:        func(page []listRecipientComposite) error { return errStopIteration },
+			expectedPages: []string{"c@d.com e@f.com"},
+		},
+		{
+			label:         "returns action error",
+			action:        func(page []listRecipientComposite) error { return errors.New("x") },
+			expectedPages: []string{"c@d.com e@f.com"},
+			expectedError: errors.New("x"),
+		},
+	}
+
+	for _, tc := range testCases {
+		var pages []string
+
+		err := j.ForEachRecipientPendingState(2, tc.maxItems, func(page []listRecipientComposite) error {
+			emails := make([]string, len(page))
+			for i, r := range page {
+				emails[i] = r.email
+			}
+			pages = append(pages, strings.Join(emails, " "))
+
+			if tc.action != nil {
+				return tc.action(page)
+			}
+			return nil
+		})
+
+		if !reflect.DeepEqual(pages, tc.expectedPages) {
+			t.Errorf("%v: pages got %q, want %q", tc.label, pages, tc.expectedPages)
+		}
+		if !errorEquals(err, tc.expectedError) {
+			t.Errorf("%v: error got %v, want %v", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestRepositoryJournal_Leases(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	repo := NewMemoryRepository()
+	retry := NewRetryPolicy(3, time.Minute, time.Hour)
+	destinations := map[string][]string{"b": {"crm"}}
+	j := &repositoryJournal{log: NOOPLog, repo: repo, clock: clock, retry: retry, destinations: destinations,
+		lease: LeasePolicy{owner: "x", duration: time.Hour}}
+	other := &repositoryJournal{log: NOOPLog, repo: repo, clock: clock, retry: retry, destinations: destinations,
+		lease: LeasePolicy{owner: "y", duration: time.Hour}}
+
+	j.SetRecipientPendingState("a@b.com", []string{"a", "b"}, RecipientStatuses.Get("new"), nil, "{}")
+
+	pending, err := getRecipientPendingState(j)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("ForEachRecipientPendingState() got %v, %v, want new recipients of a and b", pending, err)
+	}
+	if deliveries, err := getPendingDeliveries(j); err != nil || len(deliveries) != 1 {
+		t.Fatalf("ForEachPendingDelivery() got %v, %v, want delivery to crm", deliveries, err)
+	}
+	if pending, _ := getRecipientPendingState(other); len(pending) != 0 {
+		t.Errorf("ForEachRecipientPendingState() of other got %v, want none leased by another", pending)
+	}
+	if deliveries, _ := getPendingDeliveries(other); len(deliveries) != 0 {
+		t.Errorf("ForEachPendingDelivery() of other got %v, want none leased by another", deliveries)
+	}
+
+	err = j.UpdateListRecipient(pending[0].listRecipientID, RecipientStatuses.Get("failed"), errors.New("timeout"))
+	if err != nil {
+		t.Fatalf("UpdateListRecipient() error got %q, want nil", err)
+	}
+
+	clock.time = clock.time.Add(time.Minute)
+	if pending, _ := getRecipientPendingState(other); len(pending) != 1 || pending[0].listID != "a" {
+		t.Errorf("ForEachRecipientPendingState() of other after update got %v, want recipient of a", pending)
+	}
+
+	if err := j.ReleaseLeases(); err != nil {
+		t.Fatalf("ReleaseLeases() error got %q, want nil", err)
+	}
+	if deliveries, _ := getPendingDeliveries(other); len(deliveries) != 1 {
+		t.Errorf("ForEachPendingDelivery() of other after release got %v, want delivery to crm", deliveries)
+	}
+
+	clock.time = clock.time.Add(time.Hour)
+	if pending, _ := getRecipientPendingState(j); len(pending) != 2 {
+		t.Errorf("ForEachRecipientPendingState() after leases expire got %v, want recipients of a and b", pending)
+	}
+}
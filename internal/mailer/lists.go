@@ -0,0 +1,260 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ListsConfig declares named lists, so that messages can refer to lists by name rather than ID.
+type ListsConfig struct {
+	defaultList string
+	lists       map[string]ListConfig
+}
+
+// ListConfig configures a named list: its ID at its provider, the provider's client configuration, and any
+// other destinations its recipients are notified to, which are configured likewise.
+type ListConfig struct {
+	listID       string
+	provider     ProviderConfig
+	destinations map[string]ListConfig
+}
+
+type listsConfigYAML struct {
+	Default string                    `yaml:"default"`
+	Lists   map[string]listConfigYAML `yaml:"lists"`
+}
+
+type listConfigYAML struct {
+	Provider    string              `yaml:"provider"`
+	APIKey      string              `yaml:"apiKey"`
+	BaseURL     string              `yaml:"baseUrl"`
+	ListID      string              `yaml:"listId"`
+	MergeFields MergeFields         `yaml:"mergeFields"`
+	DoubleOptIn bool                `yaml:"doubleOptIn"`
+	Webhook     webhookConfigFields `yaml:"webhook"`
+
+	Destinations map[string]listConfigYAML `yaml:"destinations"`
+}
+
+// LoadListsConfig reads and parses a lists configuration file.
+func LoadListsConfig(path string) (*ListsConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %v", path, err)
+	}
+	return ParseListsConfig(b)
+}
+
+// ParseListsConfig parses YAML of the form
+//
+//	default: newsletter
+//	lists:
+//	  newsletter:
+//	    provider: mailchimp
+//	    apiKey: ${MAILCHIMP_API_KEY}
+//	    listId: 12345abcde
+//	    mergeFields:
+//	      first_name: FNAME
+//	    doubleOptIn: true
+//	    destinations:
+//	      crm:
+//	        provider: webhook
+//	        baseUrl: https://crm.example.com/hooks
+//
+// The provider defaults to mailchimp, a destination's list ID to its list's, and environment variables are
+// expanded in API keys and webhook secrets.
+func ParseListsConfig(b []byte) (*ListsConfig, error) {
+	var parsed listsConfigYAML
+	err := yaml.UnmarshalStrict(b, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid yaml: %v", err)
+	}
+
+	var names []string
+	for name := range parsed.Lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := &ListsConfig{defaultList: parsed.Default, lists: make(map[string]ListConfig)}
+	namesByListID := make(map[string]string)
+
+	for _, name := range names {
+		list, err := parseListConfig(parsed.Lists[name], "")
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %v", name, err)
+		}
+		if other, ok := namesByListID[list.listID]; ok {
+			return nil, fmt.Errorf("list %s: list ID %s is already used by list %s", name, list.listID, other)
+		}
+		namesByListID[list.listID] = name
+
+		var destinationNames []string
+		for destination := range parsed.Lists[name].Destinations {
+			destinationNames = append(destinationNames, destination)
+		}
+		sort.Strings(destinationNames)
+
+		for _, destination := range destinationNames {
+			c := parsed.Lists[name].Destinations[destination]
+			if len(c.Destinations) > 0 {
+				return nil, fmt.Errorf("list %s: destination %s: destinations can't have destinations", name,
+					destination)
+			}
+			if c.DoubleOptIn {
+				return nil, fmt.Errorf("list %s: destination %s: %v", name, destination, errDoubleOptInNotSupported)
+			}
+			d, err := parseListConfig(c, list.listID)
+			if err != nil {
+				return nil, fmt.Errorf("list %s: destination %s: %v", name, destination, err)
+			}
+			if list.destinations == nil {
+				list.destinations = make(map[string]ListConfig)
+			}
+			list.destinations[destination] = d
+		}
+
+		result.lists[name] = list
+	}
+
+	if _, ok := result.lists[parsed.Default]; parsed.Default != "" && !ok {
+		return nil, fmt.Errorf("unknown default list %s", parsed.Default)
+	}
+
+	return result, nil
+}
+
+// parseListConfig parses the configuration of a list or destination, the list ID of which defaults to the
+// given ID.
+func parseListConfig(c listConfigYAML, defaultListID string) (ListConfig, error) {
+	if c.Provider == "" {
+		c.Provider = "mailchimp"
+	}
+	if _, ok := providers[c.Provider]; !ok {
+		return ListConfig{}, fmt.Errorf("unknown provider %q", c.Provider)
+	}
+	if c.ListID == "" {
+		c.ListID = defaultListID
+	}
+	if c.ListID == "" {
+		return ListConfig{}, errors.New("no list ID")
+	}
+	apiKey := os.ExpandEnv(c.APIKey)
+	if c.Provider == "mailchimp" {
+		if err := validateMailChimpConfig(apiKey, c.MergeFields); err != nil {
+			return ListConfig{}, err
+		}
+	}
+	return ListConfig{
+		listID: c.ListID,
+		provider: NewProviderConfig(c.Provider, apiKey, c.BaseURL, c.MergeFields, c.DoubleOptIn,
+			NewWebhookConfig(os.ExpandEnv(c.Webhook.Secret), c.Webhook.Template, c.Webhook.MaxAttempts)),
+	}, nil
+}
+
+// validateMailChimpConfig checks a MailChimp list has an API key with a data center, and valid merge tags, as
+// ParseMergeFields does, so that misconfigured lists fail when loaded rather than when first used.
+func validateMailChimpConfig(apiKey string, mergeFields MergeFields) error {
+	if _, err := getMailChimpDC(apiKey); err != nil {
+		return fmt.Errorf("invalid MailChimp API key: %v", err)
+	}
+	for key, tag := range mergeFields {
+		if !mergeTagPattern.MatchString(tag) {
+			return fmt.Errorf("invalid merge tag %q for attribute %q", tag, key)
+		}
+	}
+	return nil
+}
+
+// Resolve gets the ID of the named list.
+func (c *ListsConfig) Resolve(name string) (listID string, ok bool) {
+	list, ok := c.lists[name]
+	return list.listID, ok
+}
+
+// DefaultListID gets the ID of the default list, or an empty string if there is none.
+func (c *ListsConfig) DefaultListID() string {
+	return c.lists[c.defaultList].listID
+}
+
+// NewClients creates a client for each list, keyed by list ID.
+func (c *ListsConfig) NewClients(log *Loggers) (map[string]Client, error) {
+	result := make(map[string]Client)
+	for name, list := range c.lists {
+		client, err := NewProviderClient(log, list.listID, list.provider)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create client for list %s: %v", name, err)
+		}
+		result[list.listID] = client
+	}
+	return result, nil
+}
+
+// Destinations gets the names of the destinations of each list with any, keyed by list ID.
+func (c *ListsConfig) Destinations() map[string][]string {
+	result := make(map[string][]string)
+	for _, list := range c.lists {
+		for destination := range list.destinations {
+			result[list.listID] = append(result[list.listID], destination)
+		}
+		sort.Strings(result[list.listID])
+	}
+	return result
+}
+
+// NewDestinationClients creates a client for each destination of each list, keyed by list ID then destination
+// name.
+func (c *ListsConfig) NewDestinationClients(log *Loggers) (map[string]map[string]Client, error) {
+	result := make(map[string]map[string]Client)
+	for name, list := range c.lists {
+		for destination, d := range list.destinations {
+			client, err := NewProviderClient(log, d.listID, d.provider)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't create client for destination %s of list %s: %v", destination,
+					name, err)
+			}
+			if result[list.listID] == nil {
+				result[list.listID] = make(map[string]Client)
+			}
+			result[list.listID][destination] = &listIDClient{client: client, listID: d.listID}
+		}
+	}
+	return result, nil
+}
+
+// MergeFields gets the merge fields of each MailChimp list, keyed by list ID.
+func (c *ListsConfig) MergeFields() map[string]MergeFields {
+	result := make(map[string]MergeFields)
+	for _, list := range c.lists {
+		if list.provider.provider == "mailchimp" && list.provider.mergeFields != nil {
+			result[list.listID] = list.provider.mergeFields
+		}
+	}
+	return result
+}
+
+// listIDClient notifies subscriptions to the client's list of another ID, e.g. that of a destination's own list.
+type listIDClient struct {
+	client Client
+	listID string
+}
+
+func (c *listIDClient) Subscribe(s subscription) (RecipientStatus, error) {
+	s.listID = c.listID
+	return c.client.Subscribe(s)
+}
+
+func (c *listIDClient) Unsubscribe(s subscription) error {
+	s.listID = c.listID
+	return c.client.Unsubscribe(s)
+}
+
+func (c *listIDClient) GetStatus(s subscription) (RecipientStatus, error) {
+	s.listID = c.listID
+	return c.client.GetStatus(s)
+}
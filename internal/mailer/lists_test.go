@@ -0,0 +1,245 @@
+package mailer
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseListsConfig(t *testing.T) {
+	os.Setenv("LISTS_TEST_API_KEY", "secret-us1")
+	defer os.Unsetenv("LISTS_TEST_API_KEY")
+
+	testCases := []struct {
+		label string
+
+		str string
+
+		expected      *ListsConfig
+		expectedError string
+	}{
+		{
+			label: "on empty",
+
+			str: "",
+
+			expected: &ListsConfig{lists: map[string]ListConfig{}},
+		},
+		{
+			label: "on valid lists",
+
+			str: `
+default: news
+lists:
+  news:
+    apiKey: ${LISTS_TEST_API_KEY}
+    listId: 12345abcde
+    mergeFields:
+      first_name: FNAME
+    doubleOptIn: true
+  offers:
+    provider: brevo
+    apiKey: k
+    listId: "12"
+`,
+
+			expected: &ListsConfig{defaultList: "news", lists: map[string]ListConfig{
+				"news": {listID: "12345abcde", provider: ProviderConfig{provider: "mailchimp", apiKey: "secret-us1",
+					mergeFields: MergeFields{"first_name": "FNAME"}, doubleOptIn: true}},
+				"offers": {listID: "12", provider: ProviderConfig{provider: "brevo", apiKey: "k"}},
+			}},
+		},
+		{
+			label: "on destinations",
+
+			str: `
+lists:
+  news:
+    apiKey: k-us1
+    listId: 12345abcde
+    destinations:
+      crm:
+        provider: webhook
+        baseUrl: https://crm
+      offers:
+        provider: brevo
+        listId: "12"
+`,
+
+			expected: &ListsConfig{lists: map[string]ListConfig{
+				"news": {listID: "12345abcde", provider: ProviderConfig{provider: "mailchimp", apiKey: "k-us1"},
+					destinations: map[string]ListConfig{
+						"crm":    {listID: "12345abcde", provider: ProviderConfig{provider: "webhook", baseURL: "https://crm"}},
+						"offers": {listID: "12", provider: ProviderConfig{provider: "brevo"}},
+					}},
+			}},
+		},
+		{
+			label: "on destination with double opt-in",
+
+			str: "lists: {a: {listId: b, apiKey: k-us1, destinations: {c: {doubleOptIn: true}}}}",
+
+			expectedError: "list a: destination c: double opt-in isn't supported",
+		},
+		{
+			label: "on destination with destinations",
+
+			str: "lists: {a: {listId: b, apiKey: k-us1, destinations: {c: {provider: webhook, destinations: {d: {}}}}}}",
+
+			expectedError: "list a: destination c: destinations can't have destinations",
+		},
+		{
+			label: "on unknown provider",
+
+			str: "lists: {a: {provider: x, listId: b}}",
+
+			expectedError: `list a: unknown provider "x"`,
+		},
+		{
+			label: "on no list ID",
+
+			str: "lists: {a: {apiKey: k}}",
+
+			expectedError: "list a: no list ID",
+		},
+		{
+			label: "on MailChimp list without API key",
+
+			str: "lists: {a: {listId: b}}",
+
+			expectedError: "list a: invalid MailChimp API key: API key has no DC suffix",
+		},
+		{
+			label: "on MailChimp destination with API key without DC suffix",
+
+			str: "lists: {a: {listId: b, apiKey: k-us1, destinations: {c: {apiKey: k}}}}",
+
+			expectedError: "list a: destination c: invalid MailChimp API key: API key has no DC suffix",
+		},
+		{
+			label: "on invalid merge tag",
+
+			str: "lists: {a: {listId: b, apiKey: k-us1, mergeFields: {first_name: fname}}}",
+
+			expectedError: `list a: invalid merge tag "fname" for attribute "first_name"`,
+		},
+		{
+			label: "on duplicate list ID",
+
+			str: "lists: {a: {listId: c, apiKey: k-us1}, b: {listId: c, apiKey: k-us1}}",
+
+			expectedError: "list b: list ID c is already used by list a",
+		},
+		{
+			label: "on unknown default list",
+
+			str: "default: b\nlists: {a: {listId: c, apiKey: k-us1}}",
+
+			expectedError: "unknown default list b",
+		},
+		{
+			label: "on unknown field",
+
+			str: "lists: {a: {listId: c, apikey: k}}",
+
+			expectedError: "invalid yaml: yaml: unmarshal errors:\n  line 1: field apikey not found in type mailer.listConfigYAML",
+		},
+	}
+
+	for _, tc := range testCases {
+		res, err := ParseListsConfig([]byte(tc.str))
+
+		if !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("%v: result got %v, want %v", tc.label, res, tc.expected)
+		}
+		if tc.expectedError == "" && err != nil || tc.expectedError != "" && !errorMessageEquals(err, tc.expectedError) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestListsConfig(t *testing.T) {
+	c := &ListsConfig{defaultList: "news", lists: map[string]ListConfig{
+		"news": {listID: "12345abcde", provider: ProviderConfig{provider: "mailchimp",
+			mergeFields: MergeFields{"first_name": "FNAME"}}},
+		"offers": {listID: "12", provider: ProviderConfig{provider: "brevo",
+			mergeFields: MergeFields{"first_name": "FIRSTNAME"}}},
+	}}
+
+	if listID, ok := c.Resolve("offers"); listID != "12" || !ok {
+		t.Errorf("Resolve(offers) got %q, %v, want %q, true", listID, ok, "12")
+	}
+	if listID, ok := c.Resolve("12"); listID != "" || ok {
+		t.Errorf("Resolve(12) got %q, %v, want \"\", false", listID, ok)
+	}
+	if listID := c.DefaultListID(); listID != "12345abcde" {
+		t.Errorf("DefaultListID() got %q, want %q", listID, "12345abcde")
+	}
+	if listID := (&ListsConfig{}).DefaultListID(); listID != "" {
+		t.Errorf("DefaultListID() with no default got %q, want \"\"", listID)
+	}
+
+	expectedMergeFields := map[string]MergeFields{"12345abcde": {"first_name": "FNAME"}}
+	if actual := c.MergeFields(); !reflect.DeepEqual(actual, expectedMergeFields) {
+		t.Errorf("MergeFields() got %v, want %v", actual, expectedMergeFields)
+	}
+
+	clients, err := c.NewClients(NOOPLog)
+	if err != nil {
+		t.Fatalf("NewClients() error got %q, want nil", err)
+	}
+	if _, ok := clients["12345abcde"].(*mailChimpClient); !ok {
+		t.Errorf("NewClients() client of 12345abcde got %T, want *mailChimpClient", clients["12345abcde"])
+	}
+	if _, ok := clients["12"].(*brevoClient); !ok {
+		t.Errorf("NewClients() client of 12 got %T, want *brevoClient", clients["12"])
+	}
+
+	c.lists["offers"] = ListConfig{listID: "x", provider: ProviderConfig{provider: "brevo"}}
+	if _, err := c.NewClients(NOOPLog); !errorMessageEquals(err, `couldn't create client for list offers: invalid Brevo list ID "x"`) {
+		t.Errorf("NewClients() with invalid list error got %q", err)
+	}
+}
+
+func TestListsConfig_Destinations(t *testing.T) {
+	c := &ListsConfig{lists: map[string]ListConfig{
+		"news": {listID: "12345abcde", provider: ProviderConfig{provider: "mailchimp"},
+			destinations: map[string]ListConfig{
+				"slack": {listID: "12345abcde", provider: ProviderConfig{provider: "webhook", baseURL: "https://slack"}},
+				"crm":   {listID: "crm-news", provider: ProviderConfig{provider: "webhook", baseURL: "https://crm"}},
+			}},
+		"offers": {listID: "12", provider: ProviderConfig{provider: "brevo"}},
+	}}
+
+	expected := map[string][]string{"12345abcde": {"crm", "slack"}}
+	if actual := c.Destinations(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Destinations() got %v, want %v", actual, expected)
+	}
+
+	clients, err := c.NewDestinationClients(NOOPLog)
+	if err != nil {
+		t.Fatalf("NewDestinationClients() error got %q, want nil", err)
+	}
+	if len(clients) != 1 || len(clients["12345abcde"]) != 2 {
+		t.Fatalf("NewDestinationClients() got %v, want clients of crm and slack of 12345abcde", clients)
+	}
+	if c, ok := clients["12345abcde"]["crm"].(*listIDClient); !ok || c.listID != "crm-news" {
+		t.Errorf("NewDestinationClients() client of crm got %v, want of list crm-news", clients["12345abcde"]["crm"])
+	}
+}
+
+func TestListIDClient(t *testing.T) {
+	var received []subscription
+	c := &listIDClient{listID: "b", client: &notifierTestClient{
+		onSubscribe: func(s subscription) (RecipientStatus, error) {
+			received = append(received, s)
+			return RecipientStatuses.Get("subscribed"), nil
+		},
+	}}
+
+	c.Subscribe(subscription{email: "x", listID: "a", destination: "crm"})
+
+	if expected := []subscription{{email: "x", listID: "b", destination: "crm"}}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("Subscribe received got %v, want %v", received, expected)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,46 +27,225 @@ func (m setRecipientStateMessage) GetTargetStatus() (RecipientStatus, error) {
 }
 
 type journal interface {
-	SetRecipientPendingState(email string, lists []string, status RecipientStatus, attribs map[string]string) error
-	GetRecipientPendingState() ([]listRecipientComposite, error)
-	UpdateListRecipient(listRecipientID int, status RecipientStatus) error
+	SetRecipientPendingState(email string, lists []string, status RecipientStatus, attribs map[string]string,
+		source string) error
+	ForEachRecipientPendingState(pageSize int, maxItems int, action func([]listRecipientComposite) error) error
+	UpdateListRecipient(listRecipientID int, status RecipientStatus, cause error) error
+	RecordRejectedMessage(text string, reason string) error
+	ForEachRecipientPendingConfirmation(pageSize int, action func([]listRecipientComposite) error) error
+	SetListRecipientStatus(email string, listID string, status RecipientStatus, reason string, source string) error
+	UpdateListRecipientAttributes(email string, listID string, attribs map[string]string) error
+	RenameRecipient(oldEmail string, newEmail string) error
+	ForEachListRecipient(listID string, pageSize int, action func([]listRecipientComposite) error) error
+	GetListRecipientsByEmail(listID string, emails []string) ([]listRecipientComposite, error)
+	ForEachPendingDelivery(pageSize int, maxItems int, action func([]listRecipientComposite) error) error
+	UpdateDelivery(deliveryID int, status RecipientStatus, cause error) error
+	GetBatches() ([]Batch, error)
+	StartTaskRun(name string, interval time.Duration) (bool, error)
+	StartBatch(listID string, remoteID string, listRecipientIDs []int) error
+	UpdateBatchedListRecipient(batchID int, listRecipientID int, status RecipientStatus, cause error) error
+	FinishBatch(batchID int) error
+	ReleaseLeases() error
 }
 
 type notifier interface {
 	Notify(s subscription, currentStatus RecipientStatus) (RecipientStatus, error)
+	CheckConfirmation(s subscription) (RecipientStatus, error)
+}
+
+// ConfirmationPolicy determines how often recipients pending confirmation of their subscription are checked,
+// and how long after signing up they have to confirm before they expire. A zero expiry never expires them.
+type ConfirmationPolicy struct {
+	checkInterval time.Duration
+	expiry        time.Duration
+}
+
+// confirmationCheckTask is the name of the task checking pending confirmations, whose last run is journaled.
+const confirmationCheckTask = "confirmation_check"
+
+func NewConfirmationPolicy(checkInterval time.Duration, expiry time.Duration) ConfirmationPolicy {
+	return ConfirmationPolicy{checkInterval: checkInterval, expiry: expiry}
 }
 
 type Mailer struct {
 	log           *Loggers
 	ms            MessageSource
 	defaultlistID string
+	lists         *ListsConfig
 	journal       journal
 	notifier      notifier
+	clock         clock
+	confirmation  ConfirmationPolicy
+	email         EmailPolicy
+	batch         BatchPolicy
+	batcher       batcher
+	dryRun        bool
+	workers       int
+	pageSize      int
+	maxItems      int
+	runLocker     RunLocker
+	runLockTTL    time.Duration
+	stop          chan struct{}
+	stopOnce      sync.Once
 }
 
-func (m *Mailer) Poll() error {
+// Run polls and/or processes repeatedly, waiting interval between each run, until stopped.
+func (m *Mailer) Run(poll bool, process bool, interval time.Duration) {
 	for {
+		m.RunOnce(poll, process)
+
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RunOnce polls and/or processes, logging any error. If the mailer has a run locker, it skips the run if another
+// mailer's run holds the lock.
+func (m *Mailer) RunOnce(poll bool, process bool) {
+	if m.runLocker != nil && !m.dryRun {
+		unlock, locked, err := m.runLocker.TryLock(runLockName, m.runLockTTL)
+
+		if err != nil {
+			m.log.Error.Printf("Error locking run: %v", err)
+			return
+		} else if !locked {
+			m.log.Info.Printf("Skipping run, since another is in progress")
+			return
+		}
+
+		defer func() {
+			if err := unlock(); err != nil {
+				m.log.Error.Printf("Error unlocking run: %v", err)
+			}
+		}()
+	}
+
+	if poll && !m.isStopping() {
+		err := m.Poll()
+
+		if err != nil {
+			m.log.Error.Printf("Error polling for messages: %v", err)
+		}
+	}
+
+	if process && !m.isStopping() {
+		err := m.Process()
+
+		if err != nil {
+			m.log.Error.Printf("Error processing recipient state: %v ", err)
+		}
+	}
+
+	if process && !m.dryRun && !m.isStopping() {
+		m.confirmPendingIfDue()
+	}
+}
+
+// confirmPendingIfDue checks pending confirmations if the confirmation policy's check interval has passed since
+// they were last checked, by this or any other mailer sharing its repository.
+func (m *Mailer) confirmPendingIfDue() {
+	due, err := m.journal.StartTaskRun(confirmationCheckTask, m.confirmation.checkInterval)
+
+	if err != nil {
+		m.log.Error.Printf("Error getting whether pending confirmations are due to be checked: %v", err)
+		return
+	} else if !due {
+		return
+	}
+
+	err = m.ConfirmPending()
+
+	if err != nil {
+		m.log.Error.Printf("Error checking pending confirmations: %v", err)
+	}
+}
+
+// DryRun switches the mailer to a dry run: Poll validates messages without journaling or removing them from the
+// message source, and Process logs the requests it would send to providers without sending them or updating or
+// leasing recipients, then a summary of the recipients it would have notified. Pending confirmations aren't checked.
+func (m *Mailer) DryRun() {
+	m.dryRun = true
+	if j, ok := m.journal.(*repositoryJournal); ok {
+		j.lease = LeasePolicy{}
+	}
+	if n, ok := m.notifier.(*clientNotifier); ok {
+		setClientOperations(n.client, &dryRunOperations{log: m.log})
+	}
+}
+
+// SetWorkers sets how many list recipients and deliveries Process notifies at once. A recipient's are notified one
+// at a time, in order.
+func (m *Mailer) SetWorkers(workers int) {
+	m.workers = workers
+}
+
+// SetProcessLimits sets how many list recipients, then deliveries, Process gets from the journal at a time, and the
+// most of each it notifies in a run, the rest being left to later runs. Zero gets them all at once, or notifies them
+// all. Lists are submitted in batches if they have at least the batch threshold pending in a page, so limits that
+// keep pages smaller than the threshold are an error, since no list would be.
+func (m *Mailer) SetProcessLimits(pageSize int, maxItems int) error {
+	largestPage := pageSize
+	if maxItems > 0 && (largestPage == 0 || maxItems < largestPage) {
+		largestPage = maxItems
+	}
+	if largestPage > 0 && largestPage < m.batch.threshold {
+		return fmt.Errorf("pages of at most %d recipients are smaller than the batch threshold of %d, so no list "+
+			"would be batched", largestPage, m.batch.threshold)
+	}
+
+	m.pageSize = pageSize
+	m.maxItems = maxItems
+	return nil
+}
+
+// SetRunLock sets the locker of runs, so that RunOnce skips runs that would overlap those of other mailers sharing
+// it, the lock expiring after the TTL if the locker can't otherwise release it when its holder crashes.
+func (m *Mailer) SetRunLock(l RunLocker, ttl time.Duration) {
+	m.runLocker = l
+	m.runLockTTL = ttl
+}
+
+// Stop stops polling and processing once the message or recipient in progress is complete.
+func (m *Mailer) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+func (m *Mailer) isStopping() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Mailer) Poll() error {
+	for !m.isStopping() {
 		msg, err := m.ms.GetNextMessage()
 		if err != nil {
+			if flushErr := m.flush(); flushErr != nil {
+				m.log.Error.Printf("%v", flushErr)
+			}
 			return fmt.Errorf("couldn't get next message from queue: %v", err)
 		} else if msg == nil {
 			break
 		}
 
-		parsed, err := parseMessage(msg.GetText())
-		if err != nil {
-			m.log.Error.Printf("couldn't parse sign up from message %q: %v", msg.GetText(), err)
+		if m.dryRun {
+			m.dryRunMessage(msg.GetText())
 			continue
 		}
 
-		status, err := parsed.GetTargetStatus()
-		if err != nil {
-			m.log.Error.Printf("couldn't determine required status from message %q: %v", msg.GetText(), err)
+		err = m.journalMessage(msg.GetText())
+		if rejection, ok := err.(*messageRejection); ok {
+			m.rejectMessage(msg, rejection)
 			continue
-		}
-
-		err = m.journal.SetRecipientPendingState(parsed.Email, m.getListIDs(parsed), status, parsed.Attributes)
-		if err != nil {
+		} else if err != nil {
 			m.log.Error.Printf("%v", err)
 			continue
 		}
@@ -75,43 +256,414 @@ func (m *Mailer) Poll() error {
 		}
 	}
 
+	return m.flush()
+}
+
+// messageRejection is an error caused by a message being invalid, so that it can never be journaled.
+type messageRejection struct {
+	reason string
+}
+
+func (e *messageRejection) Error() string {
+	return e.reason
+}
+
+func (m *Mailer) journalMessage(text string) error {
+	parsed, status, listIDs, err := m.validateMessage(text)
+	if err != nil {
+		return err
+	}
+
+	return m.journal.SetRecipientPendingState(parsed.Email, listIDs, status, parsed.Attributes, text)
+}
+
+// validateMessage parses a message, getting the status and lists its recipient should have. A messageRejection is
+// returned if the message is invalid, and any other error if that couldn't be determined.
+func (m *Mailer) validateMessage(text string) (parsed setRecipientStateMessage, status RecipientStatus,
+	listIDs []string, err error) {
+	parsed, err = parseMessage(text)
+	if err != nil {
+		err = &messageRejection{fmt.Sprintf("couldn't parse sign up: %v", err)}
+		return
+	}
+
+	err = m.email.check(parsed.Email)
+	if err != nil {
+		return
+	}
+
+	status, err = parsed.GetTargetStatus()
+	if err != nil {
+		err = &messageRejection{fmt.Sprintf("couldn't determine required status: %v", err)}
+		return
+	}
+
+	listIDs, err = m.getListIDs(parsed)
+	if err != nil {
+		err = &messageRejection{fmt.Sprintf("couldn't determine lists: %v", err)}
+		return
+	}
+
+	return parsed, status, listIDs, nil
+}
+
+// dryRunMessage logs what journaling a message would do, leaving it in the message source.
+func (m *Mailer) dryRunMessage(text string) {
+	parsed, status, listIDs, err := m.validateMessage(text)
+	if rejection, ok := err.(*messageRejection); ok {
+		m.log.Info.Printf("Dry run: would reject message %q: %v", text, rejection)
+		return
+	} else if err != nil {
+		m.log.Error.Printf("%v", err)
+		return
+	}
+	m.log.Info.Printf("Dry run: would set %s %s on lists %s", parsed.Email, status, strings.Join(listIDs, ", "))
+}
+
+// rejectMessage removes a rejected message from the message source so it isn't received again, then records it.
+// It's only recorded once removed, so a message redelivered after a failed removal isn't recorded twice.
+func (m *Mailer) rejectMessage(msg Message, rejection *messageRejection) {
+	m.log.Error.Printf("rejecting message %q: %v", msg.GetText(), rejection)
+
+	err := m.ms.MessageRejected(msg, rejection.reason)
+	if err != nil {
+		m.log.Error.Printf("couldn't mark message rejected: %v", err)
+		return
+	}
+
+	err = m.journal.RecordRejectedMessage(msg.GetText(), rejection.reason)
+	if err != nil {
+		m.log.Error.Printf("couldn't record rejected message: %v", err)
+	}
+}
+
+func (m *Mailer) flush() error {
+	err := m.ms.Flush()
+	if err != nil {
+		return fmt.Errorf("couldn't mark messages processed: %v", err)
+	}
 	return nil
 }
 
 func (m *Mailer) Process() error {
-	rs, err := m.journal.GetRecipientPendingState()
+	if m.dryRun {
+		return m.dryRunProcess()
+	}
 
+	defer func() {
+		if err := m.journal.ReleaseLeases(); err != nil {
+			m.log.Error.Printf("couldn't release leases: %v", err)
+		}
+	}()
+
+	if m.batcher != nil {
+		err := m.processBatches()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := m.forEachPage(m.journal.ForEachRecipientPendingState, "recipients to be subscribed",
+		func(rs []listRecipientComposite) error {
+			var err error
+
+			if m.batcher != nil && !m.isStopping() {
+				rs, err = m.submitBatches(rs)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return m.notifyAll(rs, func(r listRecipientComposite, status RecipientStatus, cause error) error {
+				err := m.journal.UpdateListRecipient(r.listRecipientID, status, cause)
+				if err != nil {
+					return fmt.Errorf("couldn't update recipient: %v", err)
+				}
+				return nil
+			})
+		})
+
+	if err != nil || m.isStopping() {
+		return err
+	}
+
+	return m.forEachPage(m.journal.ForEachPendingDelivery, "deliveries to destinations",
+		func(ds []listRecipientComposite) error {
+			return m.notifyAll(ds, func(r listRecipientComposite, status RecipientStatus, cause error) error {
+				err := m.journal.UpdateDelivery(r.deliveryID, status, cause)
+				if err != nil {
+					return fmt.Errorf("couldn't update delivery: %v", err)
+				}
+				return nil
+			})
+		})
+}
+
+// dryRunProcess submits batches of, and notifies, the pending list recipients and deliveries with the dry run's
+// client, counting them rather than updating them. Batches already submitted aren't checked.
+func (m *Mailer) dryRunProcess() error {
+	summary := make(dryRunSummary)
+
+	count := func(rs []listRecipientComposite) error {
+		return m.notifyAll(rs, func(r listRecipientComposite, status RecipientStatus, cause error) error {
+			summary.add(r)
+			return nil
+		})
+	}
+
+	err := m.forEachPage(m.journal.ForEachRecipientPendingState, "recipients to be subscribed",
+		func(rs []listRecipientComposite) error {
+			var err error
+
+			if m.batcher != nil && !m.isStopping() {
+				rs, err = m.forEachBatch(rs, func(listID string, remoteID string, lrs []listRecipientComposite,
+					invalid []batchResult) error {
+					for _, r := range lrs {
+						summary.add(r)
+					}
+					return nil
+				})
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return count(rs)
+		})
+
+	if err == nil && !m.isStopping() {
+		err = m.forEachPage(m.journal.ForEachPendingDelivery, "deliveries to destinations", count)
+	}
+
+	summary.print(m.log)
+
+	return err
+}
+
+// forEachPage passes pages of the list recipients or deliveries described, got with forEach, to the action, up to
+// the mailer's limits, until it's stopping. Errors getting them are wrapped, but those of the action aren't.
+func (m *Mailer) forEachPage(forEach func(int, int, func([]listRecipientComposite) error) error, description string,
+	action func([]listRecipientComposite) error) error {
+	var actionErr error
+
+	err := forEach(m.pageSize, m.maxItems, func(rs []listRecipientComposite) error {
+		actionErr = action(rs)
+		if actionErr != nil {
+			return actionErr
+		}
+		if m.isStopping() {
+			return errStopIteration
+		}
+		return nil
+	})
+
+	if actionErr != nil {
+		return actionErr
+	}
 	if err != nil {
-		return fmt.Errorf("couldn't get recipients to be subscribed: %v", err)
+		return fmt.Errorf("couldn't get %v: %v", description, err)
 	}
+	return nil
+}
 
-	for _, r := range rs {
-		status, err := m.notifier.Notify(subscription{email: r.email, listID: r.listID}, r.status)
+// notifyAll notifies each list recipient's or delivery's pending state, then updates it with the result, with the
+// mailer's workers, if more than one. It stops at the first update that fails.
+func (m *Mailer) notifyAll(rs []listRecipientComposite,
+	update func(r listRecipientComposite, status RecipientStatus, cause error) error) error {
+	if m.workers <= 1 {
+		for _, r := range rs {
+			if m.isStopping() {
+				break
+			}
 
-		if err != nil {
-			m.log.Error.Printf("notify of new recipient failed: %v", err)
+			status, cause := m.notify(r)
+
+			err := update(r, status, cause)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// Each worker notifies all of a recipient's list recipients in turn, so they're notified in order, and updates
+	// are made one at a time.
+	work := make(chan []listRecipientComposite)
+	var mu sync.Mutex
+	var updateErr error
+	var wg sync.WaitGroup
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return updateErr != nil
+	}
+
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range work {
+				for _, r := range group {
+					if m.isStopping() || failed() {
+						break
+					}
+
+					status, cause := m.notify(r)
+
+					mu.Lock()
+					if updateErr == nil {
+						updateErr = update(r, status, cause)
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, group := range groupByRecipient(rs) {
+		if m.isStopping() || failed() {
+			break
+		}
+		work <- group
+	}
+
+	close(work)
+	wg.Wait()
+
+	return updateErr
+}
+
+// notify notifies the list recipient or delivery's pending status, getting the status it then has, and the error
+// if notifying failed.
+func (m *Mailer) notify(r listRecipientComposite) (RecipientStatus, error) {
+	status, err := m.notifier.Notify(subscription{email: r.email, listID: r.listID, attribs: r.attribs,
+		destination: r.destination}, r.status)
+
+	if err != nil {
+		m.log.Error.Printf("notify of new recipient failed: %v", err)
+		if status == RecipientStatuses.None {
 			status = RecipientStatuses.Get("failed")
 		}
+	}
 
-		err = m.journal.UpdateListRecipient(r.listRecipientID, status)
-		if err != nil {
-			return fmt.Errorf("couldn't update recipient: %v", err)
+	return status, err
+}
+
+// groupByRecipient groups list recipients or deliveries by their recipient's email, in the order each recipient
+// first appears, keeping their order within each group.
+func groupByRecipient(rs []listRecipientComposite) [][]listRecipientComposite {
+	var groups [][]listRecipientComposite
+	index := make(map[string]int)
+	for _, r := range rs {
+		i, ok := index[r.email]
+		if !ok {
+			i = len(groups)
+			index[r.email] = i
+			groups = append(groups, nil)
 		}
+		groups[i] = append(groups[i], r)
 	}
+	return groups
+}
 
-	return nil
+// ConfirmPending checks whether recipients pending confirmation of their subscription have since confirmed or
+// unsubscribed, expiring those who haven't confirmed in time.
+// Recipients still pending are got again by the next check, so they aren't limited to the mailer's max items, which
+// would leave any after them unchecked.
+func (m *Mailer) ConfirmPending() error {
+	forEach := func(pageSize int, maxItems int, action func([]listRecipientComposite) error) error {
+		return m.journal.ForEachRecipientPendingConfirmation(pageSize, action)
+	}
+
+	return m.forEachPage(forEach, "recipients pending confirmation", func(rs []listRecipientComposite) error {
+		for _, r := range rs {
+			if m.isStopping() {
+				break
+			}
+
+			status, err := m.notifier.CheckConfirmation(subscription{email: r.email, listID: r.listID,
+				attribs: r.attribs})
+
+			if err != nil {
+				m.log.Error.Printf("couldn't check confirmation of list recipient #%d: %v", r.listRecipientID, err)
+				continue
+			}
+
+			if status == RecipientStatuses.Get("pending") && m.isConfirmationExpired(r) {
+				status = RecipientStatuses.Get("expired")
+			}
+
+			if status == r.status {
+				continue
+			}
+
+			err = m.journal.UpdateListRecipient(r.listRecipientID, status, nil)
+			if err != nil {
+				return fmt.Errorf("couldn't update recipient: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Mailer) isConfirmationExpired(r listRecipientComposite) bool {
+	return m.confirmation.expiry > 0 && !m.clock.now().Before(r.lastModified.Add(m.confirmation.expiry))
 }
 
-func (m *Mailer) getListIDs(msg setRecipientStateMessage) []string {
-	if len(msg.ListIDs) > 0 {
-		return msg.ListIDs
+// getListIDs gets the IDs of the lists of the message, or the default list if it has none. If lists are
+// configured, the message's lists are names of configured lists.
+func (m *Mailer) getListIDs(msg setRecipientStateMessage) ([]string, error) {
+	if len(msg.ListIDs) == 0 {
+		return []string{m.defaultlistID}, nil
+	}
+	if m.lists == nil {
+		return msg.ListIDs, nil
+	}
+
+	var result []string
+	for _, name := range msg.ListIDs {
+		listID, ok := m.lists.Resolve(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown list %s", name)
+		}
+		result = append(result, listID)
 	}
-	return []string{m.defaultlistID}
+	return result, nil
 }
 
-func NewMailer(log *Loggers, ms MessageSource, listID string, repo Repository, client Client) *Mailer {
-	return &Mailer{log, ms, listID,
-		&repositoryJournal{log: log, repo: repo, clock: &stdClock{}}, &clientNotifier{client: client}}
+// NewMailer creates a mailer. The lists, if not nil, are the configured lists messages' lists are names of, and
+// their destinations.
+func NewMailer(log *Loggers, ms MessageSource, listID string, lists *ListsConfig, repo Repository, client Client,
+	retry RetryPolicy, confirmation ConfirmationPolicy, email EmailPolicy, batch BatchPolicy, lease LeasePolicy) *Mailer {
+	var destinations map[string][]string
+	if lists != nil {
+		destinations = lists.Destinations()
+	}
+	var b batcher
+	if batch.threshold > 0 {
+		b, _ = client.(batcher)
+	}
+	clock := &stdClock{}
+	return &Mailer{
+		log:           log,
+		ms:            ms,
+		defaultlistID: listID,
+		lists:         lists,
+		journal: &repositoryJournal{log: log, repo: repo, clock: clock, retry: retry, destinations: destinations,
+			lease: lease},
+		notifier:     &clientNotifier{client: client},
+		clock:        clock,
+		confirmation: confirmation,
+		email:        email,
+		batch:        batch,
+		batcher:      b,
+		stop:         make(chan struct{}),
+	}
 }
 
 func parseMessage(str string) (msg setRecipientStateMessage, err error) {
@@ -124,11 +676,18 @@ func parseMessage(str string) (msg setRecipientStateMessage, err error) {
 		return
 	}
 
+	parsed.Email = normalizeEmail(parsed.Email)
+
 	if parsed.Email == "" {
 		err = fmt.Errorf("message has no email")
 		return
 	}
 
+	if emailErr := validateEmail(parsed.Email); emailErr != nil {
+		err = fmt.Errorf("invalid email %q: %v", parsed.Email, emailErr)
+		return
+	}
+
 	return parsed, nil
 }
 
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSetRecipientStateMessage_GetTargetStatus(t *testing.T) {
@@ -58,6 +61,7 @@ func TestMailer_Poll(t *testing.T) {
 	testCases := []struct {
 		label         string
 		defaultListID string
+		lists         *ListsConfig
 
 		getNextMessageResults []messageResult
 
@@ -66,6 +70,11 @@ func TestMailer_Poll(t *testing.T) {
 
 		expectedMessageSourceProcessed []Message
 
+		expectedRejectedMessages      []rejectedMessageParams
+		onRecordRejectedMessage       func(text string, reason string) error
+		onMessageRejected             func(msg Message, reason string) error
+		expectedMessageSourceRejected []Message
+
 		expected string
 	}{
 		{
@@ -73,19 +82,21 @@ func TestMailer_Poll(t *testing.T) {
 			defaultListID: "a",
 
 			getNextMessageResults: []messageResult{
-				{msg: &testMessage{Text: `{"type":"unsubscribe","email":"x","attributes":{"k1":"v1"}}`}},
-				{msg: &testMessage{Text: `{"type":"unsubscribe","email":"y","attributes":{"k2":"v2"}}`}},
+				{msg: &testMessage{Text: `{"type":"unsubscribe","email":"x@a.com","attributes":{"k1":"v1"}}`}},
+				{msg: &testMessage{Text: `{"type":"unsubscribe","email":"y@a.com","attributes":{"k2":"v2"}}`}},
 				{},
 			},
 
 			expectedPendingState: []journalPendingState{
-				{email: "x", lists: []string{"a"}, status: RecipientStatuses.Get("unsubscribing"), attribs: map[string]string{"k1": "v1"}},
-				{email: "y", lists: []string{"a"}, status: RecipientStatuses.Get("unsubscribing"), attribs: map[string]string{"k2": "v2"}},
+				{email: "x@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("unsubscribing"), attribs: map[string]string{"k1": "v1"},
+					source: `{"type":"unsubscribe","email":"x@a.com","attributes":{"k1":"v1"}}`},
+				{email: "y@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("unsubscribing"), attribs: map[string]string{"k2": "v2"},
+					source: `{"type":"unsubscribe","email":"y@a.com","attributes":{"k2":"v2"}}`},
 			},
 
 			expectedMessageSourceProcessed: []Message{
-				&testMessage{Text: `{"type":"unsubscribe","email":"x","attributes":{"k1":"v1"}}`},
-				&testMessage{Text: `{"type":"unsubscribe","email":"y","attributes":{"k2":"v2"}}`},
+				&testMessage{Text: `{"type":"unsubscribe","email":"x@a.com","attributes":{"k1":"v1"}}`},
+				&testMessage{Text: `{"type":"unsubscribe","email":"y@a.com","attributes":{"k2":"v2"}}`},
 			},
 
 			expected: "",
@@ -95,18 +106,60 @@ func TestMailer_Poll(t *testing.T) {
 			defaultListID: "",
 
 			getNextMessageResults: []messageResult{
-				{msg: &testMessage{Text: `{"type":"subscribe","email":"x","listIds":["a","b"]}`}},
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"x@a.com","listIds":["a","b"]}`}},
+				{},
+			},
+
+			expectedPendingState: []journalPendingState{
+				{email: "x@a.com", lists: []string{"a", "b"}, status: RecipientStatuses.Get("new"),
+					source: `{"type":"subscribe","email":"x@a.com","listIds":["a","b"]}`},
+			},
+
+			expectedMessageSourceProcessed: []Message{
+				&testMessage{Text: `{"type":"subscribe","email":"x@a.com","listIds":["a","b"]}`},
+			},
+
+			expected: "",
+		},
+		{
+			label:         "on messages polled successfully with configured list names specified",
+			defaultListID: "",
+			lists: &ListsConfig{lists: map[string]ListConfig{
+				"news":   {listID: "a"},
+				"offers": {listID: "b"},
+			}},
+
+			getNextMessageResults: []messageResult{
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"x@a.com","listIds":["news","offers"]}`}},
 				{},
 			},
 
 			expectedPendingState: []journalPendingState{
-				{email: "x", lists: []string{"a", "b"}, status: RecipientStatuses.Get("new")},
+				{email: "x@a.com", lists: []string{"a", "b"}, status: RecipientStatuses.Get("new"),
+					source: `{"type":"subscribe","email":"x@a.com","listIds":["news","offers"]}`},
 			},
 
 			expectedMessageSourceProcessed: []Message{
-				&testMessage{Text: `{"type":"subscribe","email":"x","listIds":["a","b"]}`},
+				&testMessage{Text: `{"type":"subscribe","email":"x@a.com","listIds":["news","offers"]}`},
+			},
+
+			expected: "",
+		},
+		{
+			label:         "on unknown list name",
+			defaultListID: "a",
+			lists:         &ListsConfig{lists: map[string]ListConfig{"news": {listID: "a"}}},
+
+			getNextMessageResults: []messageResult{
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"x@a.com","listIds":["news","a"]}`}},
+				{},
 			},
 
+			expectedRejectedMessages: []rejectedMessageParams{
+				{text: `{"type":"subscribe","email":"x@a.com","listIds":["news","a"]}`, reason: "couldn't determine lists: unknown list a"},
+			},
+			expectedMessageSourceRejected: []Message{&testMessage{Text: `{"type":"subscribe","email":"x@a.com","listIds":["news","a"]}`}},
+
 			expected: "",
 		},
 		{
@@ -115,7 +168,7 @@ func TestMailer_Poll(t *testing.T) {
 
 			getNextMessageResults: []messageResult{
 				{err: errors.New("")},
-				{msg: &testMessage{Text: `{"type":"subscribe","email":"x"}`}},
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"x@a.com"}`}},
 			},
 
 			expectedPendingState: nil,
@@ -130,15 +183,56 @@ func TestMailer_Poll(t *testing.T) {
 
 			getNextMessageResults: []messageResult{
 				{msg: &testMessage{Text: "!"}},
-				{msg: &testMessage{Text: `{"type":"subscribe","email":"x"}`}},
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"x@a.com"}`}},
 				{},
 			},
 
 			expectedPendingState: []journalPendingState{
-				{email: "x", lists: []string{"a"}, status: RecipientStatuses.Get("new")},
+				{email: "x@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("new"), source: `{"type":"subscribe","email":"x@a.com"}`},
+			},
+
+			expectedMessageSourceProcessed: []Message{&testMessage{Text: `{"type":"subscribe","email":"x@a.com"}`}},
+
+			expectedRejectedMessages: []rejectedMessageParams{
+				{text: "!", reason: "couldn't parse sign up: invalid json: '!': invalid character '!' looking for beginning of value"},
+			},
+			expectedMessageSourceRejected: []Message{&testMessage{Text: "!"}},
+
+			expected: "",
+		},
+		{
+			label:         "on couldn't record rejected message",
+			defaultListID: "a",
+
+			getNextMessageResults: []messageResult{
+				{msg: &testMessage{Text: "!"}},
+				{},
+			},
+
+			expectedRejectedMessages: []rejectedMessageParams{
+				{text: "!", reason: "couldn't parse sign up: invalid json: '!': invalid character '!' looking for beginning of value"},
+			},
+			onRecordRejectedMessage: func(text string, reason string) error {
+				return errors.New("")
+			},
+			expectedMessageSourceRejected: []Message{&testMessage{Text: "!"}},
+
+			expected: "",
+		},
+		{
+			label:         "on couldn't mark message rejected",
+			defaultListID: "a",
+
+			getNextMessageResults: []messageResult{
+				{msg: &testMessage{Text: "!"}},
+				{},
 			},
 
-			expectedMessageSourceProcessed: []Message{&testMessage{Text: `{"type":"subscribe","email":"x"}`}},
+			onMessageRejected: func(msg Message, reason string) error {
+				return errors.New("")
+			},
+			expectedMessageSourceRejected: []Message{&testMessage{Text: "!"}},
+			expectedRejectedMessages:      nil,
 
 			expected: "",
 		},
@@ -147,16 +241,21 @@ func TestMailer_Poll(t *testing.T) {
 			defaultListID: "a",
 
 			getNextMessageResults: []messageResult{
-				{msg: &testMessage{Text: `{"type":"_","email":"x"}`}},
-				{msg: &testMessage{Text: `{"type":"subscribe","email":"y"}`}},
+				{msg: &testMessage{Text: `{"type":"_","email":"x@a.com"}`}},
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"y@a.com"}`}},
 				{},
 			},
 
 			expectedPendingState: []journalPendingState{
-				{email: "y", lists: []string{"a"}, status: RecipientStatuses.Get("new")},
+				{email: "y@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("new"), source: `{"type":"subscribe","email":"y@a.com"}`},
 			},
 
-			expectedMessageSourceProcessed: []Message{&testMessage{Text: `{"type":"subscribe","email":"y"}`}},
+			expectedMessageSourceProcessed: []Message{&testMessage{Text: `{"type":"subscribe","email":"y@a.com"}`}},
+
+			expectedRejectedMessages: []rejectedMessageParams{
+				{text: `{"type":"_","email":"x@a.com"}`, reason: "couldn't determine required status: unknown type: _"},
+			},
+			expectedMessageSourceRejected: []Message{&testMessage{Text: `{"type":"_","email":"x@a.com"}`}},
 
 			expected: "",
 		},
@@ -165,33 +264,33 @@ func TestMailer_Poll(t *testing.T) {
 			defaultListID: "a",
 
 			getNextMessageResults: []messageResult{
-				{msg: &testMessage{Text: `{"type":"subscribe","email":"x"}`}},
-				{msg: &testMessage{Text: `{"type":"subscribe","email":"y"}`}},
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"x@a.com"}`}},
+				{msg: &testMessage{Text: `{"type":"subscribe","email":"y@a.com"}`}},
 				{},
 			},
 
 			expectedPendingState: []journalPendingState{
-				{email: "x", lists: []string{"a"}, status: RecipientStatuses.Get("new")},
-				{email: "y", lists: []string{"a"}, status: RecipientStatuses.Get("new")},
+				{email: "x@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("new"), source: `{"type":"subscribe","email":"x@a.com"}`},
+				{email: "y@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("new"), source: `{"type":"subscribe","email":"y@a.com"}`},
 			},
 			pendingStateResults: func(email string, lists []string) error {
-				if email == "x" {
+				if email == "x@a.com" {
 					return errors.New("")
 				}
 				return nil
 			},
 
-			expectedMessageSourceProcessed: []Message{&testMessage{Text: `{"type":"subscribe","email":"y"}`}},
+			expectedMessageSourceProcessed: []Message{&testMessage{Text: `{"type":"subscribe","email":"y@a.com"}`}},
 
 			expected: "",
 		},
 	}
 
 	for _, tc := range testCases {
-		ms := &testMessageSource{messageResults: tc.getNextMessageResults}
-		j := &testJournal{pendingStateResults: tc.pendingStateResults}
+		ms := &testMessageSource{messageResults: tc.getNextMessageResults, onMessageRejected: tc.onMessageRejected}
+		j := &testJournal{pendingStateResults: tc.pendingStateResults, onRecordRejectedMessage: tc.onRecordRejectedMessage}
 
-		mailer := &Mailer{log: NOOPLog, ms: ms, defaultlistID: tc.defaultListID, journal: j}
+		mailer := &Mailer{log: NOOPLog, ms: ms, defaultlistID: tc.defaultListID, lists: tc.lists, journal: j}
 
 		err := mailer.Poll()
 
@@ -201,12 +300,188 @@ func TestMailer_Poll(t *testing.T) {
 		if actual, expected := sliceVals(ms.processed), sliceVals(tc.expectedMessageSourceProcessed); !reflect.DeepEqual(actual, expected) {
 			t.Errorf("%v: invoked MessageProcessed got %v, got %v", tc.label, actual, expected)
 		}
+		if !reflect.DeepEqual(j.rejectedMessagesReceived, tc.expectedRejectedMessages) {
+			t.Errorf("%v: invoked RecordRejectedMessage got %v, want %v", tc.label, j.rejectedMessagesReceived, tc.expectedRejectedMessages)
+		}
+		if actual, expected := sliceVals(ms.rejected), sliceVals(tc.expectedMessageSourceRejected); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%v: invoked MessageRejected got %v, want %v", tc.label, actual, expected)
+		}
+		if ms.flushed != 1 {
+			t.Errorf("%v: invoked Flush %d times, want 1", tc.label, ms.flushed)
+		}
 		if !errorMessageStartsWith(err, tc.expected) {
 			t.Errorf("%v: result error got %q, want prefix %q", tc.label, err, tc.expected)
 		}
 	}
 }
 
+func TestMailer_PollStopsAfterMessageInProgress(t *testing.T) {
+	ms := &testMessageSource{messageResults: []messageResult{
+		{msg: &testMessage{Text: `{"type":"subscribe","email":"x@a.com"}`}},
+		{msg: &testMessage{Text: `{"type":"subscribe","email":"y@a.com"}`}},
+		{},
+	}}
+	mailer := &Mailer{log: NOOPLog, ms: ms, defaultlistID: "a", stop: make(chan struct{})}
+	mailer.journal = &testJournal{pendingStateResults: func(email string, lists []string) error {
+		mailer.Stop()
+		return nil
+	}}
+
+	err := mailer.Poll()
+
+	if err != nil {
+		t.Errorf("result error got %q, want nil", err)
+	}
+	if actual, expected := sliceVals(ms.processed), []string{`{{"type":"subscribe","email":"x@a.com"}}`}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("invoked MessageProcessed got %v, want %v", actual, expected)
+	}
+}
+
+func TestMailer_Run(t *testing.T) {
+	ms := &testMessageSource{messageResults: []messageResult{{}, {}}}
+	j := &testJournal{}
+	clock := &testClock{time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	mailer := &Mailer{log: NOOPLog, ms: ms, journal: j, clock: clock, confirmation: NewConfirmationPolicy(time.Hour, 0),
+		stop: make(chan struct{})}
+
+	runs := 0
+	j.onGetRecipientPendingState = func() ([]listRecipientComposite, error) {
+		runs++
+		if runs == 2 {
+			mailer.Stop()
+		}
+		return nil, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		mailer.Run(true, true, time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run didn't return after Stop")
+	}
+
+	if ms.idx != 2 {
+		t.Errorf("invoked GetNextMessage %d times, want 2", ms.idx)
+	}
+	if runs != 2 {
+		t.Errorf("invoked GetRecipientPendingState %d times, want 2", runs)
+	}
+	if j.getRecipientsPendingConfirmationInvocations != 1 {
+		t.Errorf("invoked ForEachRecipientPendingConfirmation %d times, want 1", j.getRecipientsPendingConfirmationInvocations)
+	}
+}
+
+func TestMailer_RunOnceChecksConfirmationsAtInterval(t *testing.T) {
+	clock := &testClock{time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	repo := NewMemoryRepository()
+	j := &repositoryJournal{log: NOOPLog, repo: repo, clock: clock}
+	j.SetRecipientPendingState("a@b.com", []string{"a"}, RecipientStatuses.Get("new"), nil, "{}")
+	pending, _ := getRecipientPendingState(j)
+	j.UpdateListRecipient(pending[0].listRecipientID, RecipientStatuses.Get("pending"), nil)
+
+	checks := 0
+	notifier := &testClientNotifier{onCheckConfirmation: func(s subscription) (RecipientStatus, error) {
+		checks++
+		return RecipientStatuses.Get("pending"), nil
+	}}
+
+	testCases := []struct {
+		label   string
+		elapsed time.Duration
+
+		expectedChecks int
+	}{
+		{label: "checks on first run", expectedChecks: 1},
+		{label: "skips check before interval", elapsed: 59 * time.Minute, expectedChecks: 1},
+		{label: "checks once interval has passed", elapsed: time.Minute, expectedChecks: 2},
+	}
+
+	for _, tc := range testCases {
+		clock.time = clock.time.Add(tc.elapsed)
+		// a new mailer for each run, as from cron
+		mailer := &Mailer{log: NOOPLog, journal: &repositoryJournal{log: NOOPLog, repo: repo, clock: clock},
+			notifier: notifier, clock: clock, confirmation: NewConfirmationPolicy(time.Hour, 0),
+			stop: make(chan struct{})}
+
+		mailer.RunOnce(false, true)
+
+		if checks != tc.expectedChecks {
+			t.Errorf("%v: confirmation checks got %d, want %d", tc.label, checks, tc.expectedChecks)
+		}
+	}
+}
+
+func TestMailer_RunOnceWithRunLock(t *testing.T) {
+	testCases := []struct {
+		label  string
+		locked bool
+		err    error
+
+		expectedRun    bool
+		expectedUnlock bool
+	}{
+		{label: "on lock taken", locked: true, expectedRun: true, expectedUnlock: true},
+		{label: "on lock held by another"},
+		{label: "on lock error", err: errors.New("x")},
+	}
+
+	for _, tc := range testCases {
+		ms := &testMessageSource{messageResults: []messageResult{{}}}
+		j := &testJournal{onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+			return nil, nil
+		}}
+		clock := &testClock{time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+		mailer := &Mailer{log: NOOPLog, ms: ms, journal: j, clock: clock,
+			confirmation: NewConfirmationPolicy(time.Hour, 0), stop: make(chan struct{})}
+		locker := &testRunLocker{locked: tc.locked, err: tc.err}
+		mailer.SetRunLock(locker, time.Minute)
+
+		mailer.RunOnce(true, true)
+
+		if expected := []string{runLockName}; !reflect.DeepEqual(locker.names, expected) {
+			t.Errorf("%v: invoked TryLock with %v, want %v", tc.label, locker.names, expected)
+		}
+		if locker.ttl != time.Minute {
+			t.Errorf("%v: invoked TryLock with TTL %v, want %v", tc.label, locker.ttl, time.Minute)
+		}
+		if run := ms.idx > 0; run != tc.expectedRun {
+			t.Errorf("%v: polled got %v, want %v", tc.label, run, tc.expectedRun)
+		}
+		if j.getRecipientPendingStateInvoked != tc.expectedRun {
+			t.Errorf("%v: processed got %v, want %v", tc.label, j.getRecipientPendingStateInvoked, tc.expectedRun)
+		}
+		if locker.unlocked != tc.expectedUnlock {
+			t.Errorf("%v: unlocked got %v, want %v", tc.label, locker.unlocked, tc.expectedUnlock)
+		}
+	}
+}
+
+type testRunLocker struct {
+	locked bool
+	err    error
+
+	names    []string
+	ttl      time.Duration
+	unlocked bool
+}
+
+func (l *testRunLocker) TryLock(name string, ttl time.Duration) (func() error, bool, error) {
+	l.names = append(l.names, name)
+	l.ttl = ttl
+	if l.err != nil || !l.locked {
+		return nil, false, l.err
+	}
+	return func() error {
+		l.unlocked = true
+		return nil
+	}, true, nil
+}
+
 func sliceVals(msgs []Message) []string {
 	res := make([]string, len(msgs))
 	for i, m := range msgs {
@@ -225,7 +500,11 @@ func TestMailer_Process(t *testing.T) {
 		onGetRecipientPendingState func() ([]listRecipientComposite, error)
 
 		expectedUpdateListRecipientReceived []updateListRecipientParams
-		onUpdateListRecipient               func(listRecipientID int, status RecipientStatus) error
+		onUpdateListRecipient               func(listRecipientID int, status RecipientStatus, cause error) error
+
+		onGetPendingDeliveries         func() ([]listRecipientComposite, error)
+		expectedUpdateDeliveryReceived []updateListRecipientParams
+		onUpdateDelivery               func(deliveryID int, status RecipientStatus, cause error) error
 
 		expected error
 	}{
@@ -234,13 +513,13 @@ func TestMailer_Process(t *testing.T) {
 
 			onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
 				return []listRecipientComposite{
-					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("new")},
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("new"), attribs: map[string]string{"k": "v"}},
 					{listRecipientID: 2, email: "y", listID: "b", status: RecipientStatuses.Get("new")},
 				}, nil
 			},
 
 			expectedNotifierReceived: []notifyParams{
-				{subscription: subscription{email: "x", listID: "a"}, currentStatus: RecipientStatuses.Get("new")},
+				{subscription: subscription{email: "x", listID: "a", attribs: map[string]string{"k": "v"}}, currentStatus: RecipientStatuses.Get("new")},
 				{subscription: subscription{email: "y", listID: "b"}, currentStatus: RecipientStatuses.Get("new")},
 			},
 			onNotify: func(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
@@ -251,7 +530,7 @@ func TestMailer_Process(t *testing.T) {
 				{listRecipientID: 1, status: RecipientStatuses.Get("subscribed")},
 				{listRecipientID: 2, status: RecipientStatuses.Get("subscribed")},
 			},
-			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus) error {
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
 				return nil
 			},
 
@@ -283,13 +562,38 @@ func TestMailer_Process(t *testing.T) {
 				{subscription: subscription{email: "x", listID: "a"}, currentStatus: RecipientStatuses.Get("new")},
 			},
 			onNotify: func(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
-				return RecipientStatuses.None, errors.New("")
+				return RecipientStatuses.None, errors.New("x")
+			},
+
+			expectedUpdateListRecipientReceived: []updateListRecipientParams{
+				{listRecipientID: 1, status: RecipientStatuses.Get("failed"), cause: errors.New("x")},
+			},
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
+				return nil
+			},
+
+			expected: nil,
+		},
+		{
+			label: "on notifier permanent error",
+
+			onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("new")},
+				}, nil
+			},
+
+			expectedNotifierReceived: []notifyParams{
+				{subscription: subscription{email: "x", listID: "a"}, currentStatus: RecipientStatuses.Get("new")},
+			},
+			onNotify: func(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
+				return RecipientStatuses.Get("rejected"), errors.New("x")
 			},
 
 			expectedUpdateListRecipientReceived: []updateListRecipientParams{
-				{listRecipientID: 1, status: RecipientStatuses.Get("failed")},
+				{listRecipientID: 1, status: RecipientStatuses.Get("rejected"), cause: errors.New("x")},
 			},
-			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus) error {
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
 				return nil
 			},
 
@@ -314,18 +618,106 @@ func TestMailer_Process(t *testing.T) {
 			expectedUpdateListRecipientReceived: []updateListRecipientParams{
 				{listRecipientID: 1, status: RecipientStatuses.Get("subscribed")},
 			},
-			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus) error {
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
 				return errors.New("x")
 			},
 
 			expected: errors.New("couldn't update recipient: x"),
 		},
+		{
+			label: "on pending deliveries, independently of list recipients",
+
+			onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("new")},
+				}, nil
+			},
+			onGetPendingDeliveries: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("new"), deliveryID: 3,
+						destination: "crm"},
+					{listRecipientID: 2, email: "y", listID: "a", status: RecipientStatuses.Get("unsubscribing"),
+						deliveryID: 4, destination: "slack"},
+				}, nil
+			},
+
+			expectedNotifierReceived: []notifyParams{
+				{subscription: subscription{email: "x", listID: "a"}, currentStatus: RecipientStatuses.Get("new")},
+				{subscription: subscription{email: "x", listID: "a", destination: "crm"}, currentStatus: RecipientStatuses.Get("new")},
+				{subscription: subscription{email: "y", listID: "a", destination: "slack"}, currentStatus: RecipientStatuses.Get("unsubscribing")},
+			},
+			onNotify: func(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
+				if s.destination == "" {
+					return RecipientStatuses.Get("failed"), errors.New("x")
+				} else if currentStatus == RecipientStatuses.Get("unsubscribing") {
+					return RecipientStatuses.Get("unsubscribed"), nil
+				}
+				return RecipientStatuses.Get("subscribed"), nil
+			},
+
+			expectedUpdateListRecipientReceived: []updateListRecipientParams{
+				{listRecipientID: 1, status: RecipientStatuses.Get("failed"), cause: errors.New("x")},
+			},
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
+				return nil
+			},
+
+			expectedUpdateDeliveryReceived: []updateListRecipientParams{
+				{listRecipientID: 3, status: RecipientStatuses.Get("subscribed")},
+				{listRecipientID: 4, status: RecipientStatuses.Get("unsubscribed")},
+			},
+
+			expected: nil,
+		},
+		{
+			label: "on get deliveries error",
+
+			onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+				return nil, nil
+			},
+			onGetPendingDeliveries: func() ([]listRecipientComposite, error) {
+				return nil, errors.New("x")
+			},
+
+			expected: errors.New("couldn't get deliveries to destinations: x"),
+		},
+		{
+			label: "on journal delivery update error",
+
+			onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+				return nil, nil
+			},
+			onGetPendingDeliveries: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("new"), deliveryID: 3,
+						destination: "crm"},
+				}, nil
+			},
+
+			expectedNotifierReceived: []notifyParams{
+				{subscription: subscription{email: "x", listID: "a", destination: "crm"}, currentStatus: RecipientStatuses.Get("new")},
+			},
+			onNotify: func(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
+				return RecipientStatuses.Get("subscribed"), nil
+			},
+
+			expectedUpdateDeliveryReceived: []updateListRecipientParams{
+				{listRecipientID: 3, status: RecipientStatuses.Get("subscribed")},
+			},
+			onUpdateDelivery: func(deliveryID int, status RecipientStatus, cause error) error {
+				return errors.New("x")
+			},
+
+			expected: errors.New("couldn't update delivery: x"),
+		},
 	}
 
 	for _, tc := range testCases {
 		j := &testJournal{
 			onGetRecipientPendingState: tc.onGetRecipientPendingState,
 			onUpdateListRecipient:      tc.onUpdateListRecipient,
+			onGetPendingDeliveries:     tc.onGetPendingDeliveries,
+			onUpdateDelivery:           tc.onUpdateDelivery,
 		}
 		notifier := &testClientNotifier{onNotify: tc.onNotify}
 
@@ -336,9 +728,299 @@ func TestMailer_Process(t *testing.T) {
 		if !j.getRecipientPendingStateInvoked {
 			t.Errorf("%v: invoked GetRecipientPendingState got %v, want %v", tc.label, j.getRecipientPendingStateInvoked, true)
 		}
+		if !j.releaseLeasesInvoked {
+			t.Errorf("%v: invoked ReleaseLeases got %v, want %v", tc.label, j.releaseLeasesInvoked, true)
+		}
 		if !reflect.DeepEqual(notifier.received, tc.expectedNotifierReceived) {
 			t.Errorf("%v: invoked Notify got %v, want %v", tc.label, notifier.received, tc.expectedNotifierReceived)
 		}
+		if !reflect.DeepEqual(j.updateListRecipientReceived, tc.expectedUpdateListRecipientReceived) {
+			t.Errorf("%v: invoked UpdateListRecipient params got %v, want %v", tc.label, j.updateListRecipientReceived, tc.expectedUpdateListRecipientReceived)
+		}
+		if !reflect.DeepEqual(j.updateDeliveryReceived, tc.expectedUpdateDeliveryReceived) {
+			t.Errorf("%v: invoked UpdateDelivery params got %v, want %v", tc.label, j.updateDeliveryReceived, tc.expectedUpdateDeliveryReceived)
+		}
+		if !errorEquals(err, tc.expected) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expected)
+		}
+	}
+}
+
+func TestMailer_ProcessWithWorkers(t *testing.T) {
+	var rs []listRecipientComposite
+	for i, email := range []string{"x", "y", "z", "x", "y", "z"} {
+		rs = append(rs, listRecipientComposite{listRecipientID: i + 1, email: email, listID: strconv.Itoa(i / 3),
+			status: RecipientStatuses.Get("new")})
+	}
+
+	j := &testJournal{
+		onGetRecipientPendingState: func() ([]listRecipientComposite, error) {
+			return rs, nil
+		},
+		onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
+			return nil
+		},
+		onGetPendingDeliveries: func() ([]listRecipientComposite, error) {
+			return nil, nil
+		},
+	}
+	notifier := &concurrentTestNotifier{delay: 10 * time.Millisecond}
+
+	mailer := &Mailer{log: NOOPLog, journal: j, notifier: notifier, workers: 2}
+
+	err := mailer.Process()
+
+	if err != nil {
+		t.Errorf("result error got %q, want nil", err)
+	}
+	if num := len(j.updateListRecipientReceived); num != len(rs) {
+		t.Errorf("invoked UpdateListRecipient %d times, want %d", num, len(rs))
+	}
+	for _, email := range []string{"x", "y", "z"} {
+		if actual, expected := notifier.listIDs[email], []string{"0", "1"}; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("notified lists of %s got %v, want %v", email, actual, expected)
+		}
+	}
+	if notifier.maxInProgress != 2 {
+		t.Errorf("max notifications in progress got %d, want 2", notifier.maxInProgress)
+	}
+
+	j.onUpdateListRecipient = func(listRecipientID int, status RecipientStatus, cause error) error {
+		return errors.New("x")
+	}
+
+	err = mailer.Process()
+
+	if expected := "couldn't update recipient: x"; !errorMessageEquals(err, expected) {
+		t.Errorf("result error on update error got %q, want %q", err, expected)
+	}
+}
+
+func TestMailer_SetProcessLimits(t *testing.T) {
+	testCases := []struct {
+		label     string
+		threshold int
+		pageSize  int
+		maxItems  int
+
+		expected error
+	}{
+		{label: "accepts pages of the batch threshold", threshold: 2, pageSize: 2, maxItems: 3},
+		{label: "accepts pages of any size without batches", pageSize: 1, maxItems: 1},
+		{label: "accepts unlimited pages", threshold: 2},
+		{
+			label: "rejects page size smaller than batch threshold", threshold: 3, pageSize: 2,
+
+			expected: errors.New("pages of at most 2 recipients are smaller than the batch threshold of 3, so no " +
+				"list would be batched"),
+		},
+		{
+			label: "rejects max items smaller than batch threshold", threshold: 3, maxItems: 2,
+
+			expected: errors.New("pages of at most 2 recipients are smaller than the batch threshold of 3, so no " +
+				"list would be batched"),
+		},
+	}
+
+	for _, tc := range testCases {
+		mailer := &Mailer{log: NOOPLog, batch: NewBatchPolicy(tc.threshold)}
+
+		err := mailer.SetProcessLimits(tc.pageSize, tc.maxItems)
+
+		if !errorEquals(err, tc.expected) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expected)
+		}
+		if tc.expected == nil && (mailer.pageSize != tc.pageSize || mailer.maxItems != tc.maxItems) {
+			t.Errorf("%v: limits got %d, %d, want %d, %d", tc.label, mailer.pageSize, mailer.maxItems, tc.pageSize,
+				tc.maxItems)
+		}
+	}
+}
+
+func TestMailer_ProcessWithLimits(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: clock,
+		destinations: map[string][]string{"a": {"crm"}}}
+	for _, email := range []string{"a@b.com", "c@d.com", "e@f.com", "g@h.com", "i@j.com"} {
+		j.SetRecipientPendingState(email, []string{"a"}, RecipientStatuses.Get("new"), nil, "{}")
+	}
+	notifier := &concurrentTestNotifier{}
+
+	mailer := &Mailer{log: NOOPLog, journal: j, notifier: notifier, clock: clock, stop: make(chan struct{})}
+	if err := mailer.SetProcessLimits(2, 3); err != nil {
+		t.Fatalf("SetProcessLimits() error got %q, want nil", err)
+	}
+
+	if err := mailer.Process(); err != nil {
+		t.Fatalf("result error got %q, want nil", err)
+	}
+
+	if len(notifier.listIDs) != 3 || len(notifier.listIDs["a@b.com"]) != 2 || len(notifier.listIDs["e@f.com"]) != 2 {
+		t.Errorf("notified got %v, want first 3 recipients and their deliveries", notifier.listIDs)
+	}
+	if pending, _ := getRecipientPendingState(j); len(pending) != 2 || pending[0].email != "g@h.com" {
+		t.Errorf("pending after first run got %v, want last 2 recipients", pending)
+	}
+	if deliveries, _ := getPendingDeliveries(j); len(deliveries) != 2 || deliveries[0].email != "g@h.com" {
+		t.Errorf("pending deliveries after first run got %v, want last 2", deliveries)
+	}
+
+	if err := mailer.Process(); err != nil {
+		t.Fatalf("result error of second run got %q, want nil", err)
+	}
+
+	if pending, _ := getRecipientPendingState(j); len(pending) != 0 {
+		t.Errorf("pending after second run got %v, want none", pending)
+	}
+	if deliveries, _ := getPendingDeliveries(j); len(deliveries) != 0 {
+		t.Errorf("pending deliveries after second run got %v, want none", deliveries)
+	}
+}
+
+func TestMailer_ConfirmPending(t *testing.T) {
+	signedUp := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		label string
+
+		onGetRecipientsPendingConfirmation func() ([]listRecipientComposite, error)
+
+		now                 time.Time
+		onCheckConfirmation func(s subscription) (RecipientStatus, error)
+
+		expectedUpdateListRecipientReceived []updateListRecipientParams
+		onUpdateListRecipient               func(listRecipientID int, status RecipientStatus, cause error) error
+
+		expected error
+	}{
+		{
+			label: "on confirmed and unconfirmed recipients",
+
+			onGetRecipientsPendingConfirmation: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("pending"), lastModified: signedUp},
+					{listRecipientID: 2, email: "y", listID: "a", status: RecipientStatuses.Get("pending"), lastModified: signedUp},
+					{listRecipientID: 3, email: "z", listID: "a", status: RecipientStatuses.Get("pending"), lastModified: signedUp},
+				}, nil
+			},
+
+			now: signedUp.Add(time.Hour),
+			onCheckConfirmation: func(s subscription) (RecipientStatus, error) {
+				switch s.email {
+				case "x":
+					return RecipientStatuses.Get("subscribed"), nil
+				case "y":
+					return RecipientStatuses.Get("unsubscribed"), nil
+				}
+				return RecipientStatuses.Get("pending"), nil
+			},
+
+			expectedUpdateListRecipientReceived: []updateListRecipientParams{
+				{listRecipientID: 1, status: RecipientStatuses.Get("subscribed")},
+				{listRecipientID: 2, status: RecipientStatuses.Get("unsubscribed")},
+			},
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
+				return nil
+			},
+
+			expected: nil,
+		},
+		{
+			label: "expires recipients unconfirmed after expiry",
+
+			onGetRecipientsPendingConfirmation: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("pending"), lastModified: signedUp},
+				}, nil
+			},
+
+			now: signedUp.Add(24 * time.Hour),
+			onCheckConfirmation: func(s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.Get("pending"), nil
+			},
+
+			expectedUpdateListRecipientReceived: []updateListRecipientParams{
+				{listRecipientID: 1, status: RecipientStatuses.Get("expired")},
+			},
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
+				return nil
+			},
+
+			expected: nil,
+		},
+		{
+			label: "on get pending confirmation error",
+
+			onGetRecipientsPendingConfirmation: func() ([]listRecipientComposite, error) {
+				return nil, errors.New("x")
+			},
+
+			expected: errors.New("couldn't get recipients pending confirmation: x"),
+		},
+		{
+			label: "skips recipient on check error",
+
+			onGetRecipientsPendingConfirmation: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("pending"), lastModified: signedUp},
+					{listRecipientID: 2, email: "y", listID: "a", status: RecipientStatuses.Get("pending"), lastModified: signedUp},
+				}, nil
+			},
+
+			now: signedUp.Add(24 * time.Hour),
+			onCheckConfirmation: func(s subscription) (RecipientStatus, error) {
+				if s.email == "x" {
+					return RecipientStatuses.None, errors.New("x")
+				}
+				return RecipientStatuses.Get("subscribed"), nil
+			},
+
+			expectedUpdateListRecipientReceived: []updateListRecipientParams{
+				{listRecipientID: 2, status: RecipientStatuses.Get("subscribed")},
+			},
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
+				return nil
+			},
+
+			expected: nil,
+		},
+		{
+			label: "on journal update error",
+
+			onGetRecipientsPendingConfirmation: func() ([]listRecipientComposite, error) {
+				return []listRecipientComposite{
+					{listRecipientID: 1, email: "x", listID: "a", status: RecipientStatuses.Get("pending"), lastModified: signedUp},
+				}, nil
+			},
+
+			now: signedUp.Add(time.Hour),
+			onCheckConfirmation: func(s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.Get("subscribed"), nil
+			},
+
+			expectedUpdateListRecipientReceived: []updateListRecipientParams{
+				{listRecipientID: 1, status: RecipientStatuses.Get("subscribed")},
+			},
+			onUpdateListRecipient: func(listRecipientID int, status RecipientStatus, cause error) error {
+				return errors.New("x")
+			},
+
+			expected: errors.New("couldn't update recipient: x"),
+		},
+	}
+
+	for _, tc := range testCases {
+		j := &testJournal{
+			onGetRecipientsPendingConfirmation: tc.onGetRecipientsPendingConfirmation,
+			onUpdateListRecipient:              tc.onUpdateListRecipient,
+		}
+		notifier := &testClientNotifier{onCheckConfirmation: tc.onCheckConfirmation}
+
+		mailer := &Mailer{log: NOOPLog, journal: j, notifier: notifier, clock: &testClock{tc.now},
+			confirmation: NewConfirmationPolicy(time.Minute, 24*time.Hour)}
+
+		err := mailer.ConfirmPending()
+
 		if !reflect.DeepEqual(j.updateListRecipientReceived, tc.expectedUpdateListRecipientReceived) {
 			t.Errorf("%v: invoked UpdateListRecipient params got %v, want %v", tc.label, j.updateListRecipientReceived, tc.expectedUpdateListRecipientReceived)
 		}
@@ -357,13 +1039,21 @@ func TestParseMessage(t *testing.T) {
 	}{
 		{
 			label: "on valid json",
-			json:  `{"type":"subscribe","email":"x","attributes":{"key":"value"}}`,
+			json:  `{"type":"subscribe","email":"x@a.com","attributes":{"key":"value"}}`,
 			expectedMessage: setRecipientStateMessage{
 				Type:       "subscribe",
-				Email:      "x",
+				Email:      "x@a.com",
 				Attributes: map[string]string{"key": "value"},
 			},
 		},
+		{
+			label: "on email to normalize",
+			json:  `{"type":"subscribe","email":" X@A.com "}`,
+			expectedMessage: setRecipientStateMessage{
+				Type:  "subscribe",
+				Email: "x@a.com",
+			},
+		},
 		{
 			label:         "on invalid json",
 			json:          "{",
@@ -374,6 +1064,16 @@ func TestParseMessage(t *testing.T) {
 			json:          `{"type":"sign_up"}`,
 			expectedError: "message has no email",
 		},
+		{
+			label:         "on invalid email",
+			json:          `{"type":"subscribe","email":"x"}`,
+			expectedError: `invalid email "x": `,
+		},
+		{
+			label:         "on email with name",
+			json:          `{"type":"subscribe","email":"x <x@a.com>"}`,
+			expectedError: `invalid email "x <x@a.com>": not a bare address`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -402,9 +1102,12 @@ type recipientResult struct {
 }
 
 type testMessageSource struct {
-	idx            int
-	messageResults []messageResult
-	processed      []Message
+	idx               int
+	messageResults    []messageResult
+	processed         []Message
+	rejected          []Message
+	onMessageRejected func(msg Message, reason string) error
+	flushed           int
 }
 
 func (ms *testMessageSource) GetNextMessage() (Message, error) {
@@ -418,6 +1121,19 @@ func (ms *testMessageSource) MessageProcessed(msg Message) error {
 	return nil
 }
 
+func (ms *testMessageSource) MessageRejected(msg Message, reason string) error {
+	ms.rejected = append(ms.rejected, msg)
+	if ms.onMessageRejected == nil {
+		return nil
+	}
+	return ms.onMessageRejected(msg, reason)
+}
+
+func (ms *testMessageSource) Flush() error {
+	ms.flushed++
+	return nil
+}
+
 type testMessage struct {
 	Text string
 }
@@ -431,6 +1147,7 @@ type journalPendingState struct {
 	lists   []string
 	status  RecipientStatus
 	attribs map[string]string
+	source  string
 }
 
 type testJournal struct {
@@ -442,24 +1159,48 @@ type testJournal struct {
 	onGetRecipientPendingState      func() ([]listRecipientComposite, error)
 
 	updateListRecipientReceived []updateListRecipientParams
-	onUpdateListRecipient       func(listRecipientID int, status RecipientStatus) error
+	onUpdateListRecipient       func(listRecipientID int, status RecipientStatus, cause error) error
+
+	rejectedMessagesReceived []rejectedMessageParams
+	onRecordRejectedMessage  func(text string, reason string) error
+
+	getRecipientsPendingConfirmationInvocations int
+	onGetRecipientsPendingConfirmation          func() ([]listRecipientComposite, error)
+	onStartTaskRun                              func(name string, interval time.Duration) (bool, error)
+
+	listRecipientStatusReceived []listRecipientStatusParams
+	attributesReceived          []listRecipientAttributesParams
+	renamesReceived             []renameRecipientParams
+	onRenameRecipient           func(oldEmail string, newEmail string) error
+
+	onGetListRecipients func(listID string) ([]listRecipientComposite, error)
+
+	onGetPendingDeliveries func() ([]listRecipientComposite, error)
+	updateDeliveryReceived []updateListRecipientParams
+	onUpdateDelivery       func(deliveryID int, status RecipientStatus, cause error) error
+
+	releaseLeasesInvoked bool
 }
 
-func (j *testJournal) GetRecipientPendingState() ([]listRecipientComposite, error) {
+// ForEachRecipientPendingState passes the list recipients onGetRecipientPendingState gets to the action in one page.
+func (j *testJournal) ForEachRecipientPendingState(pageSize int, maxItems int,
+	action func([]listRecipientComposite) error) error {
 	j.getRecipientPendingStateInvoked = true
-	return j.onGetRecipientPendingState()
+	return forEachTestPage(j.onGetRecipientPendingState, action)
 }
 
-func (j *testJournal) UpdateListRecipient(listRecipientID int, status RecipientStatus) error {
+func (j *testJournal) UpdateListRecipient(listRecipientID int, status RecipientStatus, cause error) error {
 	j.updateListRecipientReceived = append(j.updateListRecipientReceived, updateListRecipientParams{
 		listRecipientID: listRecipientID,
 		status:          status,
+		cause:           cause,
 	})
-	return j.onUpdateListRecipient(listRecipientID, status)
+	return j.onUpdateListRecipient(listRecipientID, status, cause)
 }
 
-func (j *testJournal) SetRecipientPendingState(email string, lists []string, status RecipientStatus, attribs map[string]string) error {
-	state := journalPendingState{email: email, lists: lists, status: status, attribs: attribs}
+func (j *testJournal) SetRecipientPendingState(email string, lists []string, status RecipientStatus,
+	attribs map[string]string, source string) error {
+	state := journalPendingState{email: email, lists: lists, status: status, attribs: attribs, source: source}
 	j.pendingStateReceived = append(j.pendingStateReceived, state)
 	if j.pendingStateResults == nil {
 		return nil
@@ -467,6 +1208,138 @@ func (j *testJournal) SetRecipientPendingState(email string, lists []string, sta
 	return j.pendingStateResults(email, lists)
 }
 
+func (j *testJournal) RecordRejectedMessage(text string, reason string) error {
+	j.rejectedMessagesReceived = append(j.rejectedMessagesReceived, rejectedMessageParams{text: text, reason: reason})
+	if j.onRecordRejectedMessage == nil {
+		return nil
+	}
+	return j.onRecordRejectedMessage(text, reason)
+}
+
+// StartTaskRun gets whether the task is due with onStartTaskRun, or that it is if there's none.
+func (j *testJournal) StartTaskRun(name string, interval time.Duration) (bool, error) {
+	if j.onStartTaskRun == nil {
+		return true, nil
+	}
+	return j.onStartTaskRun(name, interval)
+}
+
+// ForEachRecipientPendingConfirmation passes the list recipients onGetRecipientsPendingConfirmation gets to the
+// action in one page.
+func (j *testJournal) ForEachRecipientPendingConfirmation(pageSize int,
+	action func([]listRecipientComposite) error) error {
+	j.getRecipientsPendingConfirmationInvocations++
+	if j.onGetRecipientsPendingConfirmation == nil {
+		return nil
+	}
+	return forEachTestPage(j.onGetRecipientsPendingConfirmation, action)
+}
+
+func (j *testJournal) SetListRecipientStatus(email string, listID string, status RecipientStatus, reason string,
+	source string) error {
+	j.listRecipientStatusReceived = append(j.listRecipientStatusReceived, listRecipientStatusParams{
+		email: email, listID: listID, status: status, reason: reason, source: source})
+	return nil
+}
+
+func (j *testJournal) UpdateListRecipientAttributes(email string, listID string, attribs map[string]string) error {
+	j.attributesReceived = append(j.attributesReceived, listRecipientAttributesParams{
+		email: email, listID: listID, attribs: attribs})
+	return nil
+}
+
+func (j *testJournal) RenameRecipient(oldEmail string, newEmail string) error {
+	j.renamesReceived = append(j.renamesReceived, renameRecipientParams{oldEmail: oldEmail, newEmail: newEmail})
+	if j.onRenameRecipient == nil {
+		return nil
+	}
+	return j.onRenameRecipient(oldEmail, newEmail)
+}
+
+func (j *testJournal) ForEachListRecipient(listID string, pageSize int,
+	action func([]listRecipientComposite) error) error {
+	return forEachTestPage(func() ([]listRecipientComposite, error) {
+		return j.onGetListRecipients(listID)
+	}, action)
+}
+
+func (j *testJournal) GetListRecipientsByEmail(listID string, emails []string) ([]listRecipientComposite, error) {
+	local, err := j.onGetListRecipients(listID)
+	if err != nil {
+		return nil, err
+	}
+	var result []listRecipientComposite
+	for _, lr := range local {
+		for _, email := range emails {
+			if normalizeEmail(lr.email) == email {
+				result = append(result, lr)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (j *testJournal) ForEachPendingDelivery(pageSize int, maxItems int,
+	action func([]listRecipientComposite) error) error {
+	if j.onGetPendingDeliveries == nil {
+		return nil
+	}
+	return forEachTestPage(j.onGetPendingDeliveries, action)
+}
+
+func forEachTestPage(get func() ([]listRecipientComposite, error), action func([]listRecipientComposite) error) error {
+	rs, err := get()
+	if err != nil || len(rs) == 0 {
+		return err
+	}
+	err = action(rs)
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+func (j *testJournal) UpdateDelivery(deliveryID int, status RecipientStatus, cause error) error {
+	j.updateDeliveryReceived = append(j.updateDeliveryReceived, updateListRecipientParams{
+		listRecipientID: deliveryID,
+		status:          status,
+		cause:           cause,
+	})
+	if j.onUpdateDelivery == nil {
+		return nil
+	}
+	return j.onUpdateDelivery(deliveryID, status, cause)
+}
+
+func (j *testJournal) ReleaseLeases() error {
+	j.releaseLeasesInvoked = true
+	return nil
+}
+
+type listRecipientStatusParams struct {
+	email  string
+	listID string
+	status RecipientStatus
+	reason string
+	source string
+}
+
+type listRecipientAttributesParams struct {
+	email   string
+	listID  string
+	attribs map[string]string
+}
+
+type renameRecipientParams struct {
+	oldEmail string
+	newEmail string
+}
+
+type rejectedMessageParams struct {
+	text   string
+	reason string
+}
+
 type notifyParams struct {
 	subscription  subscription
 	currentStatus RecipientStatus
@@ -475,6 +1348,12 @@ type notifyParams struct {
 type testClientNotifier struct {
 	received []notifyParams
 	onNotify func(s subscription, currentStatus RecipientStatus) (RecipientStatus, error)
+
+	onCheckConfirmation func(s subscription) (RecipientStatus, error)
+}
+
+func (n *testClientNotifier) CheckConfirmation(s subscription) (RecipientStatus, error) {
+	return n.onCheckConfirmation(s)
 }
 
 func (n *testClientNotifier) Notify(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
@@ -482,7 +1361,44 @@ func (n *testClientNotifier) Notify(s subscription, currentStatus RecipientStatu
 	return n.onNotify(s, currentStatus)
 }
 
+// concurrentTestNotifier records the lists each recipient is notified of, and how many notifications are in progress
+// at once, taking the delay to notify.
+type concurrentTestNotifier struct {
+	delay time.Duration
+
+	mu            sync.Mutex
+	listIDs       map[string][]string
+	inProgress    int
+	maxInProgress int
+}
+
+func (n *concurrentTestNotifier) CheckConfirmation(s subscription) (RecipientStatus, error) {
+	panic("not implemented")
+}
+
+func (n *concurrentTestNotifier) Notify(s subscription, currentStatus RecipientStatus) (RecipientStatus, error) {
+	n.mu.Lock()
+	if n.listIDs == nil {
+		n.listIDs = make(map[string][]string)
+	}
+	n.listIDs[s.email] = append(n.listIDs[s.email], s.listID)
+	n.inProgress++
+	if n.inProgress > n.maxInProgress {
+		n.maxInProgress = n.inProgress
+	}
+	n.mu.Unlock()
+
+	time.Sleep(n.delay)
+
+	n.mu.Lock()
+	n.inProgress--
+	n.mu.Unlock()
+
+	return RecipientStatuses.Get("subscribed"), nil
+}
+
 type updateListRecipientParams struct {
 	listRecipientID int
 	status          RecipientStatus
+	cause           error
 }
@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// mailgunClient manages members of Mailgun mailing lists, identified by their addresses, see
+// https://documentation.mailgun.com/en/latest/api-mailinglists.html
+type mailgunClient struct {
+	ops         *providerOperations
+	mergeFields MergeFields
+}
+
+type mailgunMemberResponse struct {
+	Member struct {
+		Subscribed bool `json:"subscribed"`
+	} `json:"member"`
+}
+
+func newMailgunClient(log *Loggers, listID string, config ProviderConfig) (Client, error) {
+	if config.doubleOptIn {
+		return nil, errDoubleOptInNotSupported
+	}
+	return &mailgunClient{
+		ops: &providerOperations{
+			provider: "mailgun",
+			ops:      &http.Client{},
+			baseURL:  getBaseURL(config, "https://api.mailgun.net/v3"),
+			authorize: func(req *http.Request, body []byte) {
+				req.SetBasicAuth("api", config.apiKey)
+			},
+		},
+		mergeFields: config.mergeFields,
+	}, nil
+}
+
+func (c *mailgunClient) Subscribe(s subscription) (RecipientStatus, error) {
+	values := url.Values{
+		"address":    {s.email},
+		"subscribed": {"yes"},
+		"upsert":     {"yes"},
+	}
+
+	if attribs := getProviderAttributes(s, c.mergeFields); len(attribs) > 0 {
+		b, err := json.Marshal(attribs)
+		if err != nil {
+			panic(err)
+		}
+		values.Set("vars", string(b))
+	}
+
+	path := fmt.Sprintf("/lists/%s/members", url.PathEscape(s.listID))
+
+	err := c.ops.executeForm("POST", path, values, nil)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	return RecipientStatuses.Get("subscribed"), nil
+}
+
+// Unsubscribe marks the member unsubscribed. A member that isn't found is already not in the list, so is
+// unsubscribed too.
+func (c *mailgunClient) Unsubscribe(s subscription) error {
+	path := fmt.Sprintf("/lists/%s/members/%s", url.PathEscape(s.listID), url.PathEscape(s.email))
+
+	err := c.ops.executeForm("PUT", path, url.Values{"subscribed": {"no"}}, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// GetStatus gets whether the member is subscribed, members no longer in the list being unsubscribed.
+func (c *mailgunClient) GetStatus(s subscription) (RecipientStatus, error) {
+	path := fmt.Sprintf("/lists/%s/members/%s", url.PathEscape(s.listID), url.PathEscape(s.email))
+
+	var member mailgunMemberResponse
+	err := c.ops.executeJSON("GET", path, nil, &member)
+	if isNotFound(err) {
+		return RecipientStatuses.Get("unsubscribed"), nil
+	} else if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	if member.Member.Subscribed {
+		return RecipientStatuses.Get("subscribed"), nil
+	}
+	return RecipientStatuses.Get("unsubscribed"), nil
+}
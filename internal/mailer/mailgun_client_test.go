@@ -0,0 +1,157 @@
+package mailer
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMailgunClient(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		testMethod   func(c Client, s subscription) (RecipientStatus, error)
+		subscription subscription
+		responses    []providerTestResponse
+
+		expectedRequests  []providerTestRequest
+		expectedStatus    RecipientStatus
+		expectedError     string
+		expectedPermanent bool
+	}{
+		{
+			label: "subscribe upserts member",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com", attribs: map[string]string{"first_name": "Ron"}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/lists/news@mg.example.com/members",
+					body: "address=a%40b.com&subscribed=yes&upsert=yes&vars=%7B%22FIRSTNAME%22%3A%22Ron%22%7D"},
+			},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "subscribe returns error on error",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com"},
+			responses:    []providerTestResponse{{status: 500}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/lists/news@mg.example.com/members",
+					body: "address=a%40b.com&subscribed=yes&upsert=yes"},
+			},
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "HTTP status 500",
+		},
+		{
+			label: "subscribe returns transient error on list not found",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com"},
+			responses:    []providerTestResponse{{status: 404, body: `{"message":"Mailing list not found"}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/lists/news@mg.example.com/members",
+					body: "address=a%40b.com&subscribed=yes&upsert=yes"},
+			},
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "HTTP status 404",
+		},
+		{
+			label: "unsubscribe updates member",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com"},
+
+			expectedRequests: []providerTestRequest{
+				{method: "PUT", uri: "/lists/news@mg.example.com/members/a@b.com", body: "subscribed=no"},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "unsubscribe succeeds on member not found",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com"},
+			responses:    []providerTestResponse{{status: 404, body: `{"message":"Member not found"}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "PUT", uri: "/lists/news@mg.example.com/members/a@b.com", body: "subscribed=no"},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "get status of subscribed member",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com"},
+			responses:    []providerTestResponse{{status: 200, body: `{"member":{"address":"a@b.com","subscribed":true}}`}},
+
+			expectedRequests: []providerTestRequest{{method: "GET", uri: "/lists/news@mg.example.com/members/a@b.com"}},
+			expectedStatus:   RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "get status of unsubscribed member",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com"},
+			responses:    []providerTestResponse{{status: 200, body: `{"member":{"address":"a@b.com","subscribed":false}}`}},
+
+			expectedRequests: []providerTestRequest{{method: "GET", uri: "/lists/news@mg.example.com/members/a@b.com"}},
+			expectedStatus:   RecipientStatuses.Get("unsubscribed"),
+		},
+		{
+			label: "get status of unknown member",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "news@mg.example.com"},
+			responses:    []providerTestResponse{{status: 404, body: `{"message":"Member a@b.com not found"}`}},
+
+			expectedRequests: []providerTestRequest{{method: "GET", uri: "/lists/news@mg.example.com/members/a@b.com"}},
+			expectedStatus:   RecipientStatuses.Get("unsubscribed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		s := newProviderTestServer(tc.responses...)
+		c := newProviderTestClient(t, "mailgun", "news@mg.example.com", s)
+
+		status, err := tc.testMethod(c, tc.subscription)
+		s.Close()
+
+		assertProviderTestRequests(t, tc.label, s.requests, tc.expectedRequests)
+		if status != tc.expectedStatus {
+			t.Errorf("%v: result status got %v, want %v", tc.label, status, tc.expectedStatus)
+		}
+		assertProviderTestError(t, tc.label, err, tc.expectedError)
+		if isPermanent(err) != tc.expectedPermanent {
+			t.Errorf("%v: permanent got %v, want %v", tc.label, isPermanent(err), tc.expectedPermanent)
+		}
+	}
+
+	s := newProviderTestServer()
+	defer s.Close()
+	newProviderTestClient(t, "mailgun", "news@mg.example.com", s).Unsubscribe(subscription{email: "a@b.com", listID: "news@mg.example.com"})
+
+	req := &http.Request{Header: s.requests[0].header}
+	if user, password, ok := req.BasicAuth(); !ok || user != "api" || password != "key" {
+		t.Errorf("basic auth got %q, %q, want %q, %q", user, password, "api", "key")
+	}
+}
@@ -0,0 +1,549 @@
+package mailer
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepository is a Repository held in memory, for tests that don't need a database. It enforces the same
+// uniqueness and foreign key constraints as the database schema.
+//
+// Its methods must be called from actions passed to DoInTx, which are run one at a time, and are passed a nil
+// *sql.Tx. If an action returns an error or panics, its changes are rolled back.
+type MemoryRepository struct {
+	mu    sync.Mutex
+	state memoryRepositoryState
+}
+
+type memoryRepositoryState struct {
+	recipients       map[int]Recipient
+	listRecipients   map[int]ListRecipient
+	deliveries       map[int]ListRecipientDelivery
+	events           map[int]ListRecipientEvent
+	rejectedMessages map[int]RejectedMessage
+	batches          map[int]Batch
+	taskRuns         map[string]time.Time
+	lastID           int
+}
+
+// NewMemoryRepository creates an empty repository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{state: memoryRepositoryState{
+		recipients:       make(map[int]Recipient),
+		listRecipients:   make(map[int]ListRecipient),
+		deliveries:       make(map[int]ListRecipientDelivery),
+		events:           make(map[int]ListRecipientEvent),
+		rejectedMessages: make(map[int]RejectedMessage),
+		batches:          make(map[int]Batch),
+		taskRuns:         make(map[string]time.Time),
+	}}
+}
+
+// GetRecipientDataByStatus gets a page of list recipients in any of the statuses, other than those in a batch.
+func (r *MemoryRepository) GetRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) (
+	[]listRecipientComposite, error) {
+	return r.getRecipientPage(page, func(lr ListRecipient) (RecipientStatus, bool) {
+		return lr.status, containsStatus(statuses, lr.status) && lr.batchID == 0
+	}), nil
+}
+
+// GetRecipientDataForRetry gets a page of failed list recipients whose next attempt is due, other than those in a
+// batch, with the status they were in before they failed.
+func (r *MemoryRepository) GetRecipientDataForRetry(tx *sql.Tx, now time.Time, page Page) ([]listRecipientComposite,
+	error) {
+	return r.getRecipientPage(page, func(lr ListRecipient) (RecipientStatus, bool) {
+		return lr.retryStatus, isDueForRetry(lr.status, lr.nextAttempt, now) && lr.batchID == 0
+	}), nil
+}
+
+// GetRecipientDataByListID gets a page of the recipients of a list.
+func (r *MemoryRepository) GetRecipientDataByListID(tx *sql.Tx, listID string, page Page) ([]listRecipientComposite,
+	error) {
+	return r.getRecipientPage(page, func(lr ListRecipient) (RecipientStatus, bool) {
+		return lr.status, lr.listID == listID
+	}), nil
+}
+
+// GetRecipientDataByEmails gets the recipients of a list with any of the emails, in ID order.
+func (r *MemoryRepository) GetRecipientDataByEmails(tx *sql.Tx, listID string, emails []string) (
+	[]listRecipientComposite, error) {
+	byEmail := make(map[string]bool)
+	for _, email := range emails {
+		byEmail[email] = true
+	}
+	return r.getRecipientData(func(lr ListRecipient) (RecipientStatus, bool) {
+		return lr.status, lr.listID == listID && byEmail[r.state.recipients[lr.recipientID].Email]
+	}), nil
+}
+
+// GetDeliveryDataByStatus gets a page of deliveries to destinations in any of the statuses, with their list
+// recipients.
+func (r *MemoryRepository) GetDeliveryDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) (
+	[]listRecipientComposite, error) {
+	return r.getDeliveryPage(page, func(d ListRecipientDelivery) (RecipientStatus, bool) {
+		return d.status, containsStatus(statuses, d.status)
+	}), nil
+}
+
+// GetDeliveryDataForRetry gets a page of failed deliveries whose next attempt is due, with the status they were in
+// before they failed.
+func (r *MemoryRepository) GetDeliveryDataForRetry(tx *sql.Tx, now time.Time, page Page) ([]listRecipientComposite,
+	error) {
+	return r.getDeliveryPage(page, func(d ListRecipientDelivery) (RecipientStatus, bool) {
+		return d.retryStatus, isDueForRetry(d.status, d.nextAttempt, now)
+	}), nil
+}
+
+// ClaimRecipientDataByStatus leases a page of list recipients in any of the statuses, other than those in a batch or
+// leased by others until after now, getting those leased.
+func (r *MemoryRepository) ClaimRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus, now time.Time,
+	lease Lease, page Page) ([]listRecipientComposite, error) {
+	return r.claimRecipientData(lease, r.getRecipientPage(page, func(lr ListRecipient) (RecipientStatus, bool) {
+		return lr.status, containsStatus(statuses, lr.status) && lr.batchID == 0 && isLeaseFree(lr.leaseExpiry, now)
+	})), nil
+}
+
+// ClaimRecipientDataForRetry leases a page of failed list recipients whose next attempt is due, other than those in
+// a batch or leased by others until after now, getting those leased with the status they were in before they failed.
+func (r *MemoryRepository) ClaimRecipientDataForRetry(tx *sql.Tx, now time.Time, lease Lease, page Page) (
+	[]listRecipientComposite, error) {
+	return r.claimRecipientData(lease, r.getRecipientPage(page, func(lr ListRecipient) (RecipientStatus, bool) {
+		return lr.retryStatus, isDueForRetry(lr.status, lr.nextAttempt, now) && lr.batchID == 0 &&
+			isLeaseFree(lr.leaseExpiry, now)
+	})), nil
+}
+
+// ClaimDeliveryDataByStatus leases a page of deliveries to destinations in any of the statuses, other than those
+// leased by others until after now, getting those leased with their list recipients.
+func (r *MemoryRepository) ClaimDeliveryDataByStatus(tx *sql.Tx, statuses []RecipientStatus, now time.Time,
+	lease Lease, page Page) ([]listRecipientComposite, error) {
+	return r.claimDeliveryData(lease, r.getDeliveryPage(page, func(d ListRecipientDelivery) (RecipientStatus, bool) {
+		return d.status, containsStatus(statuses, d.status) && isLeaseFree(d.leaseExpiry, now)
+	})), nil
+}
+
+// ClaimDeliveryDataForRetry leases a page of failed deliveries whose next attempt is due, other than those leased by
+// others until after now, getting those leased with the status they were in before they failed.
+func (r *MemoryRepository) ClaimDeliveryDataForRetry(tx *sql.Tx, now time.Time, lease Lease, page Page) (
+	[]listRecipientComposite, error) {
+	return r.claimDeliveryData(lease, r.getDeliveryPage(page, func(d ListRecipientDelivery) (RecipientStatus, bool) {
+		return d.retryStatus, isDueForRetry(d.status, d.nextAttempt, now) && isLeaseFree(d.leaseExpiry, now)
+	})), nil
+}
+
+func (r *MemoryRepository) claimRecipientData(lease Lease, rs []listRecipientComposite) []listRecipientComposite {
+	for _, c := range rs {
+		lr := r.state.listRecipients[c.listRecipientID]
+		lr.leaseOwner = lease.owner
+		lr.leaseExpiry = lease.expiry
+		r.state.listRecipients[lr.id] = lr
+	}
+	return rs
+}
+
+func (r *MemoryRepository) claimDeliveryData(lease Lease, ds []listRecipientComposite) []listRecipientComposite {
+	for _, c := range ds {
+		d := r.state.deliveries[c.deliveryID]
+		d.leaseOwner = lease.owner
+		d.leaseExpiry = lease.expiry
+		r.state.deliveries[d.id] = d
+	}
+	return ds
+}
+
+// ReleaseLeases releases the owner's leases of any list recipients and deliveries it hasn't finished with.
+func (r *MemoryRepository) ReleaseLeases(tx *sql.Tx, owner string) error {
+	for id, lr := range r.state.listRecipients {
+		if lr.leaseOwner == owner {
+			lr.leaseOwner = ""
+			lr.leaseExpiry = time.Time{}
+			r.state.listRecipients[id] = lr
+		}
+	}
+	for id, d := range r.state.deliveries {
+		if d.leaseOwner == owner {
+			d.leaseOwner = ""
+			d.leaseExpiry = time.Time{}
+			r.state.deliveries[id] = d
+		}
+	}
+	return nil
+}
+
+// getRecipientData gets the list recipients matched by the filter, in ID order, with the status it gets.
+func (r *MemoryRepository) getRecipientData(filter func(ListRecipient) (RecipientStatus, bool)) (
+	result []listRecipientComposite) {
+	for _, lr := range r.state.listRecipients {
+		if status, ok := filter(lr); ok {
+			result = append(result, r.toComposite(lr, status))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].listRecipientID < result[j].listRecipientID
+	})
+	return result
+}
+
+// getRecipientPage gets a page of the list recipients matched by the filter.
+func (r *MemoryRepository) getRecipientPage(page Page,
+	filter func(ListRecipient) (RecipientStatus, bool)) []listRecipientComposite {
+	return limitPage(page, r.getRecipientData(func(lr ListRecipient) (RecipientStatus, bool) {
+		status, ok := filter(lr)
+		return status, ok && lr.id > page.afterID
+	}))
+}
+
+// getDeliveryData gets the deliveries matched by the filter, in ID order, with the status it gets.
+func (r *MemoryRepository) getDeliveryData(filter func(ListRecipientDelivery) (RecipientStatus, bool)) (
+	result []listRecipientComposite) {
+	for _, d := range r.state.deliveries {
+		if status, ok := filter(d); ok {
+			c := r.toComposite(r.state.listRecipients[d.listRecipientID], status)
+			c.lastModified = d.lastModified
+			c.deliveryID = d.id
+			c.destination = d.destination
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].deliveryID < result[j].deliveryID
+	})
+	return result
+}
+
+// getDeliveryPage gets a page of the deliveries matched by the filter.
+func (r *MemoryRepository) getDeliveryPage(page Page,
+	filter func(ListRecipientDelivery) (RecipientStatus, bool)) []listRecipientComposite {
+	return limitPage(page, r.getDeliveryData(func(d ListRecipientDelivery) (RecipientStatus, bool) {
+		status, ok := filter(d)
+		return status, ok && d.id > page.afterID
+	}))
+}
+
+func limitPage(page Page, rs []listRecipientComposite) []listRecipientComposite {
+	if page.limit > 0 && len(rs) > page.limit {
+		return rs[:page.limit]
+	}
+	return rs
+}
+
+func (r *MemoryRepository) toComposite(lr ListRecipient, status RecipientStatus) listRecipientComposite {
+	return listRecipientComposite{
+		listRecipientID: lr.id,
+		recipientID:     lr.recipientID,
+		email:           r.state.recipients[lr.recipientID].Email,
+		listID:          lr.listID,
+		status:          status,
+		attribs:         copyAttribs(lr.attribs),
+		lastModified:    lr.lastModified,
+	}
+}
+
+func (r *MemoryRepository) GetRecipientByEmail(tx *sql.Tx, email string) (Recipient, bool, error) {
+	for _, recipient := range r.state.recipients {
+		if recipient.Email == email {
+			return recipient, true, nil
+		}
+	}
+	return Recipient{}, false, nil
+}
+
+func (r *MemoryRepository) InsertRecipient(tx *sql.Tx, recipient Recipient) (int, error) {
+	if _, found, _ := r.GetRecipientByEmail(tx, recipient.Email); found {
+		return 0, fmt.Errorf("couldn't perform insert: duplicate recipient email %s", recipient.Email)
+	}
+	recipient.ID = r.nextID()
+	r.state.recipients[recipient.ID] = recipient
+	return recipient.ID, nil
+}
+
+func (r *MemoryRepository) UpdateRecipient(tx *sql.Tx, recipient Recipient) error {
+	if _, ok := r.state.recipients[recipient.ID]; !ok {
+		return nil
+	}
+	if existing, found, _ := r.GetRecipientByEmail(tx, recipient.Email); found && existing.ID != recipient.ID {
+		return fmt.Errorf("couldn't perform update: duplicate recipient email %s", recipient.Email)
+	}
+	r.state.recipients[recipient.ID] = recipient
+	return nil
+}
+
+func (r *MemoryRepository) GetListRecipient(tx *sql.Tx, id int) (ListRecipient, error) {
+	lr, ok := r.state.listRecipients[id]
+	if !ok {
+		return ListRecipient{}, fmt.Errorf("recipient #%d not found", id)
+	}
+	return copyListRecipient(lr), nil
+}
+
+func (r *MemoryRepository) GetListRecipientByEmailAndListID(tx *sql.Tx, email string, listID string) (
+	ListRecipient, bool, error) {
+	recipient, found, _ := r.GetRecipientByEmail(tx, email)
+	if !found {
+		return ListRecipient{}, false, nil
+	}
+	lr, found := r.getListRecipientByListID(recipient.ID, listID)
+	if !found {
+		return ListRecipient{}, false, nil
+	}
+	return copyListRecipient(lr), true, nil
+}
+
+func (r *MemoryRepository) getListRecipientByListID(recipientID int, listID string) (ListRecipient, bool) {
+	for _, lr := range r.state.listRecipients {
+		if lr.recipientID == recipientID && lr.listID == listID {
+			return lr, true
+		}
+	}
+	return ListRecipient{}, false
+}
+
+// InsertListRecipient inserts the list recipient with only the columns DBRepository inserts, i.e. not its status
+// reason or retry state.
+func (r *MemoryRepository) InsertListRecipient(tx *sql.Tx, listRecipient ListRecipient) (int, error) {
+	if _, ok := r.state.recipients[listRecipient.recipientID]; !ok {
+		return 0, fmt.Errorf("couldn't perform insert: recipient #%d not found", listRecipient.recipientID)
+	}
+	if _, found := r.getListRecipientByListID(listRecipient.recipientID, listRecipient.listID); found {
+		return 0, fmt.Errorf("couldn't perform insert: duplicate list recipient of recipient #%d and list %s",
+			listRecipient.recipientID, listRecipient.listID)
+	}
+	lr := ListRecipient{id: r.nextID(), listID: listRecipient.listID, recipientID: listRecipient.recipientID,
+		status: listRecipient.status, attribs: copyAttribs(listRecipient.attribs),
+		lastModified: listRecipient.lastModified}
+	r.state.listRecipients[lr.id] = lr
+	return lr.id, nil
+}
+
+func (r *MemoryRepository) UpdateListRecipient(tx *sql.Tx, listRecipient ListRecipient) error {
+	existing, ok := r.state.listRecipients[listRecipient.id]
+	if !ok {
+		return nil
+	}
+	existing.status = listRecipient.status
+	existing.statusReason = listRecipient.statusReason
+	existing.lastModified = listRecipient.lastModified
+	existing.retryStatus = listRecipient.retryStatus
+	existing.attempts = listRecipient.attempts
+	existing.nextAttempt = listRecipient.nextAttempt
+	existing.attribs = copyAttribs(listRecipient.attribs)
+	if listRecipient.batchID != 0 {
+		if _, ok := r.state.batches[listRecipient.batchID]; !ok {
+			return fmt.Errorf("couldn't perform update: batch #%d not found", listRecipient.batchID)
+		}
+	}
+	existing.batchID = listRecipient.batchID
+	existing.leaseOwner = listRecipient.leaseOwner
+	existing.leaseExpiry = listRecipient.leaseExpiry
+	r.state.listRecipients[existing.id] = existing
+	return nil
+}
+
+func (r *MemoryRepository) GetListRecipientDelivery(tx *sql.Tx, id int) (ListRecipientDelivery, error) {
+	d, ok := r.state.deliveries[id]
+	if !ok {
+		return ListRecipientDelivery{}, fmt.Errorf("delivery #%d not found", id)
+	}
+	return d, nil
+}
+
+func (r *MemoryRepository) GetListRecipientDeliveryByDestination(tx *sql.Tx, listRecipientID int, destination string) (
+	ListRecipientDelivery, bool, error) {
+	for _, d := range r.state.deliveries {
+		if d.listRecipientID == listRecipientID && d.destination == destination {
+			return d, true, nil
+		}
+	}
+	return ListRecipientDelivery{}, false, nil
+}
+
+// InsertListRecipientDelivery inserts the delivery with only the columns DBRepository inserts, i.e. not its status
+// reason or retry state.
+func (r *MemoryRepository) InsertListRecipientDelivery(tx *sql.Tx, delivery ListRecipientDelivery) (int, error) {
+	if _, ok := r.state.listRecipients[delivery.listRecipientID]; !ok {
+		return 0, fmt.Errorf("couldn't perform insert: list recipient #%d not found", delivery.listRecipientID)
+	}
+	if _, found, _ := r.GetListRecipientDeliveryByDestination(tx, delivery.listRecipientID, delivery.destination); found {
+		return 0, fmt.Errorf("couldn't perform insert: duplicate delivery of list recipient #%d to %s",
+			delivery.listRecipientID, delivery.destination)
+	}
+	d := ListRecipientDelivery{id: r.nextID(), listRecipientID: delivery.listRecipientID,
+		destination: delivery.destination, status: delivery.status, lastModified: delivery.lastModified}
+	r.state.deliveries[d.id] = d
+	return d.id, nil
+}
+
+func (r *MemoryRepository) UpdateListRecipientDelivery(tx *sql.Tx, delivery ListRecipientDelivery) error {
+	existing, ok := r.state.deliveries[delivery.id]
+	if !ok {
+		return nil
+	}
+	existing.status = delivery.status
+	existing.statusReason = delivery.statusReason
+	existing.lastModified = delivery.lastModified
+	existing.retryStatus = delivery.retryStatus
+	existing.attempts = delivery.attempts
+	existing.nextAttempt = delivery.nextAttempt
+	existing.leaseOwner = delivery.leaseOwner
+	existing.leaseExpiry = delivery.leaseExpiry
+	r.state.deliveries[existing.id] = existing
+	return nil
+}
+
+func (r *MemoryRepository) InsertListRecipientEvent(tx *sql.Tx, event ListRecipientEvent) (int, error) {
+	if _, ok := r.state.listRecipients[event.listRecipientID]; !ok {
+		return 0, fmt.Errorf("couldn't perform insert: list recipient #%d not found", event.listRecipientID)
+	}
+	event.id = r.nextID()
+	r.state.events[event.id] = event
+	return event.id, nil
+}
+
+func (r *MemoryRepository) InsertRejectedMessage(tx *sql.Tx, msg RejectedMessage) (int, error) {
+	msg.id = r.nextID()
+	r.state.rejectedMessages[msg.id] = msg
+	return msg.id, nil
+}
+
+// GetBatches gets all the batches, in ID order.
+func (r *MemoryRepository) GetBatches(tx *sql.Tx) ([]Batch, error) {
+	var result []Batch
+	for _, b := range r.state.batches {
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+	return result, nil
+}
+
+func (r *MemoryRepository) InsertBatch(tx *sql.Tx, batch Batch) (int, error) {
+	batch.id = r.nextID()
+	r.state.batches[batch.id] = batch
+	return batch.id, nil
+}
+
+// DeleteBatch deletes a batch, removing any list recipients still in it from it.
+func (r *MemoryRepository) DeleteBatch(tx *sql.Tx, id int) error {
+	for lrID, lr := range r.state.listRecipients {
+		if lr.batchID == id {
+			lr.batchID = 0
+			r.state.listRecipients[lrID] = lr
+		}
+	}
+	delete(r.state.batches, id)
+	return nil
+}
+
+// GetTaskLastRun gets when the named task last ran, if it has.
+func (r *MemoryRepository) GetTaskLastRun(tx *sql.Tx, name string) (time.Time, bool, error) {
+	lastRun, found := r.state.taskRuns[name]
+	return lastRun, found, nil
+}
+
+// SetTaskLastRun sets when the named task last ran.
+func (r *MemoryRepository) SetTaskLastRun(tx *sql.Tx, name string, lastRun time.Time) error {
+	r.state.taskRuns[name] = lastRun
+	return nil
+}
+
+// DoInTx runs the action with the repository to itself, restoring the state it was in beforehand if the action
+// returns an error or panics.
+func (r *MemoryRepository) DoInTx(action func(tx *sql.Tx) error) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := r.state.copy()
+
+	defer func() {
+		if p := recover(); p != nil {
+			r.state = snapshot
+			panic(p)
+		} else if err != nil {
+			r.state = snapshot
+		}
+	}()
+
+	return action(nil)
+}
+
+func (r *MemoryRepository) Close() error {
+	return nil
+}
+
+// nextID gets a new ID, unique across all tables, which is fine since IDs are only ever compared within a table.
+func (r *MemoryRepository) nextID() int {
+	r.state.lastID++
+	return r.state.lastID
+}
+
+func (s memoryRepositoryState) copy() memoryRepositoryState {
+	result := memoryRepositoryState{
+		recipients:       make(map[int]Recipient, len(s.recipients)),
+		listRecipients:   make(map[int]ListRecipient, len(s.listRecipients)),
+		deliveries:       make(map[int]ListRecipientDelivery, len(s.deliveries)),
+		events:           make(map[int]ListRecipientEvent, len(s.events)),
+		rejectedMessages: make(map[int]RejectedMessage, len(s.rejectedMessages)),
+		batches:          make(map[int]Batch, len(s.batches)),
+		taskRuns:         make(map[string]time.Time, len(s.taskRuns)),
+		lastID:           s.lastID,
+	}
+	for id, v := range s.recipients {
+		result.recipients[id] = v
+	}
+	for id, v := range s.listRecipients {
+		result.listRecipients[id] = copyListRecipient(v)
+	}
+	for id, v := range s.deliveries {
+		result.deliveries[id] = v
+	}
+	for id, v := range s.events {
+		result.events[id] = v
+	}
+	for id, v := range s.rejectedMessages {
+		result.rejectedMessages[id] = v
+	}
+	for id, v := range s.batches {
+		result.batches[id] = v
+	}
+	for name, v := range s.taskRuns {
+		result.taskRuns[name] = v
+	}
+	return result
+}
+
+func containsStatus(statuses []RecipientStatus, status RecipientStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func isDueForRetry(status RecipientStatus, nextAttempt time.Time, now time.Time) bool {
+	return status == RecipientStatuses.Get("failed") && !nextAttempt.IsZero() && !nextAttempt.After(now)
+}
+
+// isLeaseFree is true if a row isn't leased, or its lease has expired.
+func isLeaseFree(leaseExpiry time.Time, now time.Time) bool {
+	return leaseExpiry.IsZero() || !leaseExpiry.After(now)
+}
+
+func copyListRecipient(lr ListRecipient) ListRecipient {
+	lr.attribs = copyAttribs(lr.attribs)
+	return lr
+}
+
+// copyAttribs copies attributes, so that they can't be changed other than by updating the list recipient. Like
+// DBRepository, it gets an empty map rather than nil.
+func copyAttribs(attribs map[string]string) map[string]string {
+	result := make(map[string]string, len(attribs))
+	for k, v := range attribs {
+		result[k] = v
+	}
+	return result
+}
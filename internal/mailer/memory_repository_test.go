@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
+
+func TestMemoryRepository_CopiesAttributes(t *testing.T) {
+	repo := NewMemoryRepository()
+	attribs := map[string]string{"a": "b"}
+
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		id, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), attribs: attribs, lastModified: time.Now()})
+		attribs["a"] = "c"
+
+		lr, _ := repo.GetListRecipient(tx, id)
+		lr.attribs["a"] = "d"
+
+		lr, _ = repo.GetListRecipient(tx, id)
+		if expected := map[string]string{"a": "b"}; !reflect.DeepEqual(lr.attribs, expected) {
+			t.Errorf("attribs got %v, want %v", lr.attribs, expected)
+		}
+	})
+}
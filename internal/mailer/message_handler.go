@@ -0,0 +1,119 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxMessageSize = 64 * 1024
+
+	signatureHeader = "X-Mailsling-Signature"
+	signaturePrefix = "sha256="
+)
+
+// messageHandler accepts messages posted over HTTP, journaling them directly as an alternative to polling
+// a MessageSource.
+type messageHandler struct {
+	log    *Loggers
+	mailer *Mailer
+	secret []byte
+}
+
+// NewMessageHandler creates a handler accepting messages as the body of POST requests. Requests must be
+// authenticated, either with the secret as a bearer token in the Authorization header, or with the
+// hex-encoded HMAC-SHA256 of the body, keyed with the secret, in the X-Mailsling-Signature header, e.g.
+// "sha256=0123...".
+func NewMessageHandler(log *Loggers, m *Mailer, secret string) (http.Handler, error) {
+	if secret == "" {
+		return nil, errors.New("secret is required")
+	}
+	return &messageHandler{log: log, mailer: m, secret: []byte(secret)}, nil
+}
+
+func (h *messageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("couldn't read body: %v", err))
+		return
+	}
+	if len(body) > maxMessageSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body exceeds %d bytes", maxMessageSize))
+		return
+	}
+
+	if !h.authenticate(r, body) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	err = h.mailer.journalMessage(string(body))
+	if rejection, ok := err.(*messageRejection); ok {
+		writeError(w, http.StatusBadRequest, rejection.reason)
+		return
+	} else if err != nil {
+		h.log.Error.Printf("couldn't accept message %q: %v", body, err)
+		writeError(w, http.StatusInternalServerError, "couldn't accept message")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *messageHandler) authenticate(r *http.Request, body []byte) bool {
+	if sig := r.Header.Get(signatureHeader); sig != "" {
+		return isValidSignature(h.secret, body, sig)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	return subtle.ConstantTimeCompare(token, h.secret) == 1
+}
+
+// signBody gets the signature of the body with the secret, sent in the signature header: its hex HMAC-SHA256,
+// prefixed with the algorithm, e.g. "sha256=ab12...".
+func signBody(secret []byte, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(getBodyMAC(secret, body))
+}
+
+// isValidSignature is true if the signature is that of the body with the secret.
+func isValidSignature(secret []byte, body []byte, sig string) bool {
+	if !strings.HasPrefix(sig, signaturePrefix) {
+		return false
+	}
+	actual, err := hex.DecodeString(strings.TrimPrefix(sig, signaturePrefix))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(actual, getBodyMAC(secret, body))
+}
+
+func getBodyMAC(secret []byte, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, msg)
+}
@@ -0,0 +1,152 @@
+package mailer
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewMessageHandler(t *testing.T) {
+	_, err := NewMessageHandler(NOOPLog, &Mailer{}, "")
+
+	if !errorMessageEquals(err, "secret is required") {
+		t.Errorf("result error got %q, want %q", err, "secret is required")
+	}
+}
+
+func TestMessageHandler_ServeHTTP(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		method  string
+		body    string
+		headers map[string]string
+
+		pendingStateResults func(email string, lists []string) error
+
+		expectedPendingState []journalPendingState
+		expectedStatus       int
+		expectedBody         string
+	}{
+		{
+			label:   "on valid message with bearer token",
+			method:  "POST",
+			body:    `{"type":"subscribe","email":"x@a.com"}`,
+			headers: map[string]string{"Authorization": "Bearer secret"},
+
+			expectedPendingState: []journalPendingState{
+				{email: "x@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("new"), source: `{"type":"subscribe","email":"x@a.com"}`},
+			},
+			expectedStatus: 202,
+			expectedBody:   "",
+		},
+		{
+			label:  "on valid message with signature",
+			method: "POST",
+			body:   `{"type":"subscribe","email":"x@a.com"}`,
+			headers: map[string]string{
+				// HMAC-SHA256 of body keyed with "secret"
+				"X-Mailsling-Signature": "sha256=9b734c5ade1be5106989fc21f3f0ad32dc63a1681a65320cb22cefc8e39a13e5",
+			},
+
+			expectedPendingState: []journalPendingState{
+				{email: "x@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("new"), source: `{"type":"subscribe","email":"x@a.com"}`},
+			},
+			expectedStatus: 202,
+			expectedBody:   "",
+		},
+		{
+			label:   "on wrong method",
+			method:  "GET",
+			headers: map[string]string{"Authorization": "Bearer secret"},
+
+			expectedStatus: 405,
+			expectedBody:   "method not allowed\n",
+		},
+		{
+			label:  "on no authentication",
+			method: "POST",
+			body:   `{"type":"subscribe","email":"x@a.com"}`,
+
+			expectedStatus: 401,
+			expectedBody:   "unauthorized\n",
+		},
+		{
+			label:   "on wrong bearer token",
+			method:  "POST",
+			body:    `{"type":"subscribe","email":"x@a.com"}`,
+			headers: map[string]string{"Authorization": "Bearer x"},
+
+			expectedStatus: 401,
+			expectedBody:   "unauthorized\n",
+		},
+		{
+			label:   "on wrong signature",
+			method:  "POST",
+			body:    `{"type":"subscribe","email":"x@a.com"}`,
+			headers: map[string]string{"X-Mailsling-Signature": "sha256=00"},
+
+			expectedStatus: 401,
+			expectedBody:   "unauthorized\n",
+		},
+		{
+			label:   "on body too large",
+			method:  "POST",
+			body:    strings.Repeat("x", maxMessageSize+1),
+			headers: map[string]string{"Authorization": "Bearer secret"},
+
+			expectedStatus: 413,
+			expectedBody:   "body exceeds 65536 bytes\n",
+		},
+		{
+			label:   "on invalid message",
+			method:  "POST",
+			body:    `{"type":"subscribe"}`,
+			headers: map[string]string{"Authorization": "Bearer secret"},
+
+			expectedStatus: 400,
+			expectedBody:   "couldn't parse sign up: message has no email\n",
+		},
+		{
+			label:   "on journal error",
+			method:  "POST",
+			body:    `{"type":"subscribe","email":"x@a.com"}`,
+			headers: map[string]string{"Authorization": "Bearer secret"},
+
+			pendingStateResults: func(email string, lists []string) error {
+				return errors.New("x")
+			},
+
+			expectedPendingState: []journalPendingState{
+				{email: "x@a.com", lists: []string{"a"}, status: RecipientStatuses.Get("new"), source: `{"type":"subscribe","email":"x@a.com"}`},
+			},
+			expectedStatus: 500,
+			expectedBody:   "couldn't accept message\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		j := &testJournal{pendingStateResults: tc.pendingStateResults}
+		h, _ := NewMessageHandler(NOOPLog, &Mailer{log: NOOPLog, defaultlistID: "a", journal: j}, "secret")
+
+		req := httptest.NewRequest(tc.method, "/messages", strings.NewReader(tc.body))
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+
+		h.ServeHTTP(rec, req)
+
+		if !reflect.DeepEqual(j.pendingStateReceived, tc.expectedPendingState) {
+			t.Errorf("%v: invoked SetRecipientPendingState got %v, want %v", tc.label, j.pendingStateReceived, tc.expectedPendingState)
+		}
+		if rec.Code != tc.expectedStatus {
+			t.Errorf("%v: response status got %d, want %d", tc.label, rec.Code, tc.expectedStatus)
+		}
+		if body := rec.Body.String(); body != tc.expectedBody {
+			t.Errorf("%v: response body got %q, want %q", tc.label, body, tc.expectedBody)
+		}
+	}
+}
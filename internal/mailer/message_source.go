@@ -2,7 +2,10 @@ package mailer
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
@@ -11,25 +14,58 @@ import (
 type MessageSource interface {
 	GetNextMessage() (Message, error)
 	MessageProcessed(message Message) error
+	// MessageRejected removes a message that can never be processed.
+	MessageRejected(message Message, reason string) error
+	// Flush completes any pending acknowledgement of processed messages.
+	Flush() error
 }
 
 type Message interface {
 	GetText() string
 }
 
+// maximum number of messages SQS will receive or delete in a single request
+const sqsMaxBatchSize = 10
+
+type SQSConfig struct {
+	url               string
+	deadLetterURL     string
+	maxMessages       int64
+	waitTimeSeconds   int64
+	visibilityTimeout int64
+}
+
+// NewSQSConfig creates config for receiving up to maxMessages messages at a time from an SQS queue, long
+// polling for up to waitTimeSeconds for them if non-zero, and overriding the queue's visibility timeout if
+// non-zero. Rejected messages are moved to the dead-letter queue if its URL is non-empty.
+func NewSQSConfig(queueUrl string, deadLetterQueueUrl string, maxMessages int64, waitTimeSeconds int64,
+	visibilityTimeout int64) SQSConfig {
+	return SQSConfig{
+		url:               queueUrl,
+		deadLetterURL:     deadLetterQueueUrl,
+		maxMessages:       maxMessages,
+		waitTimeSeconds:   waitTimeSeconds,
+		visibilityTimeout: visibilityTimeout,
+	}
+}
+
 type SQSMessageSource struct {
 	log       *Loggers
 	sqsClient sqsiface.SQSAPI
-	url       string
+	config    SQSConfig
 	messages  []Message
+	processed []*sqsMessage
 }
 
-func NewSQSMessageSource(log *Loggers, queueUrl string) (*SQSMessageSource, error) {
+func NewSQSMessageSource(log *Loggers, config SQSConfig) (*SQSMessageSource, error) {
+	if config.maxMessages < 1 || config.maxMessages > sqsMaxBatchSize {
+		return nil, fmt.Errorf("max messages must be between 1 and %d", sqsMaxBatchSize)
+	}
 	sess, err := session.NewSession()
 	if err != nil {
 		return nil, fmt.Errorf("couldn't configure AWS client: %v", err)
 	}
-	ms := &SQSMessageSource{log: log, sqsClient: sqs.New(sess), url: queueUrl}
+	ms := &SQSMessageSource{log: log, sqsClient: sqs.New(sess), config: config}
 	return ms, nil
 }
 
@@ -37,7 +73,12 @@ func (ms *SQSMessageSource) GetNextMessage() (Message, error) {
 	if next := ms.dequeue(); next != nil {
 		return next, nil
 	}
-	out, err := ms.sqsClient.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: &ms.url})
+	// acknowledge what we've got before getting any more, so it's not redelivered if we're slow to get
+	// round to it
+	if err := ms.Flush(); err != nil {
+		ms.log.Error.Printf("%v", err)
+	}
+	out, err := ms.sqsClient.ReceiveMessage(ms.newReceiveMessageInput())
 	if err != nil {
 		return nil, fmt.Errorf("error receiving SQS message: %v", err)
 	}
@@ -51,13 +92,89 @@ func (ms *SQSMessageSource) GetNextMessage() (Message, error) {
 	return ms.dequeue(), nil
 }
 
+func (ms *SQSMessageSource) newReceiveMessageInput() *sqs.ReceiveMessageInput {
+	input := &sqs.ReceiveMessageInput{QueueUrl: &ms.config.url}
+	if ms.config.maxMessages > 1 {
+		input.MaxNumberOfMessages = &ms.config.maxMessages
+	}
+	if ms.config.waitTimeSeconds > 0 {
+		input.WaitTimeSeconds = &ms.config.waitTimeSeconds
+	}
+	if ms.config.visibilityTimeout > 0 {
+		input.VisibilityTimeout = &ms.config.visibilityTimeout
+	}
+	return input
+}
+
+// MessageProcessed queues the message for deletion, deleting the queued messages once there are enough to fill
+// a batch.
 func (ms *SQSMessageSource) MessageProcessed(message Message) error {
-	handle := message.(*sqsMessage).delegate.ReceiptHandle
-	_, err := ms.sqsClient.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: &ms.url, ReceiptHandle: handle})
+	ms.processed = append(ms.processed, message.(*sqsMessage))
+	if len(ms.processed) < sqsMaxBatchSize {
+		return nil
+	}
+	return ms.Flush()
+}
+
+// MessageRejected sends the message to the dead-letter queue, if there is one, with the reason as an attribute,
+// then queues it for deletion.
+func (ms *SQSMessageSource) MessageRejected(message Message, reason string) error {
+	if ms.config.deadLetterURL != "" {
+		_, err := ms.sqsClient.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:    &ms.config.deadLetterURL,
+			MessageBody: aws.String(message.GetText()),
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				"RejectedReason": {DataType: aws.String("String"), StringValue: aws.String(reason)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error sending SQS message to dead-letter queue: %v", err)
+		}
+	}
+	return ms.MessageProcessed(message)
+}
+
+// Flush deletes all messages queued for deletion.
+func (ms *SQSMessageSource) Flush() error {
+	if len(ms.processed) == 0 {
+		return nil
+	}
+
+	batch := ms.processed
+	ms.processed = nil
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(batch))
+	for i, msg := range batch {
+		entries[i] = &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: msg.delegate.ReceiptHandle,
+		}
+	}
+
+	out, err := ms.sqsClient.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{QueueUrl: &ms.config.url, Entries: entries})
 	if err != nil {
-		err = fmt.Errorf("error deleting SQS message: %v", err)
+		return fmt.Errorf("error deleting SQS messages: %v", err)
+	}
+
+	if len(out.Failed) > 0 {
+		failures := make([]string, len(out.Failed))
+		for i, f := range out.Failed {
+			failures[i] = fmt.Sprintf("%s (%s: %s)", getFailedMessageID(batch, f), aws.StringValue(f.Code),
+				aws.StringValue(f.Message))
+		}
+		return fmt.Errorf("error deleting %d of %d SQS messages: %s", len(out.Failed), len(batch),
+			strings.Join(failures, ", "))
+	}
+
+	return nil
+}
+
+func getFailedMessageID(batch []*sqsMessage, f *sqs.BatchResultErrorEntry) string {
+	i, err := strconv.Atoi(aws.StringValue(f.Id))
+	if err != nil || i < 0 || i >= len(batch) {
+		return fmt.Sprintf("entry %s", aws.StringValue(f.Id))
 	}
-	return err
+	return aws.StringValue(batch[i].delegate.MessageId)
 }
 
 func (ms *SQSMessageSource) dequeue() Message {
@@ -1,6 +1,8 @@
 package mailer
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -9,15 +11,33 @@ import (
 )
 
 func TestSqsMessageSource_GetNextMessageInvokesAWSApi(t *testing.T) {
-	client := &testSqsClient{}
-	ms := SQSMessageSource{log: NOOPLog, url: "http://x", sqsClient: client}
+	testCases := []struct {
+		label    string
+		config   SQSConfig
+		expected sqs.ReceiveMessageInput
+	}{
+		{
+			label:    "with defaults",
+			config:   NewSQSConfig("http://x", "", 1, 0, 0),
+			expected: sqs.ReceiveMessageInput{QueueUrl: strptr("http://x")},
+		},
+		{
+			label:  "with batch receive, long polling and visibility timeout",
+			config: NewSQSConfig("http://x", "", 10, 20, 60),
+			expected: sqs.ReceiveMessageInput{QueueUrl: strptr("http://x"), MaxNumberOfMessages: int64ptr(10),
+				WaitTimeSeconds: int64ptr(20), VisibilityTimeout: int64ptr(60)},
+		},
+	}
 
-	_, _ = ms.GetNextMessage()
+	for _, tc := range testCases {
+		client := &testSqsClient{}
+		ms := SQSMessageSource{log: NOOPLog, config: tc.config, sqsClient: client}
 
-	expected := sqs.ReceiveMessageInput{QueueUrl: strptr("http://x")}
+		_, _ = ms.GetNextMessage()
 
-	if received := client.receiveMessageReceived; !reflect.DeepEqual(*received, expected) {
-		t.Fatalf("invoked ReceiveMessage got %v, want %v", *received, expected)
+		if received := client.receiveMessageReceived; !reflect.DeepEqual(*received, tc.expected) {
+			t.Errorf("%v: invoked ReceiveMessage got %v, want %v", tc.label, *received, tc.expected)
+		}
 	}
 }
 
@@ -49,19 +69,183 @@ func TestSqsMessageSource_GetNextMessageReturnsMessages(t *testing.T) {
 	}
 }
 
-func TestSqsMessageSource_MessageProcessed(t *testing.T) {
+func TestSqsMessageSource_GetNextMessageFlushesBeforeReceiving(t *testing.T) {
+	client := &testSqsClient{}
+	ms := SQSMessageSource{log: NOOPLog, config: NewSQSConfig("http://x", "", 10, 0, 0), sqsClient: client}
+
+	ms.MessageProcessed(&sqsMessage{delegate: &sqs.Message{ReceiptHandle: strptr("y")}})
+
+	if received := client.deleteMessageBatchReceived; received != nil {
+		t.Fatalf("invoked DeleteMessageBatch before GetNextMessage got %v, want nil", received)
+	}
+
+	_, _ = ms.GetNextMessage()
+
+	if received := client.deleteMessageBatchReceived; len(received) != 1 {
+		t.Fatalf("invoked DeleteMessageBatch %d times, want 1", len(received))
+	}
+}
+
+func TestSqsMessageSource_MessageProcessedAndFlush(t *testing.T) {
 	client := &testSqsClient{}
-	ms := SQSMessageSource{log: NOOPLog, url: "http://x", sqsClient: client}
+	ms := SQSMessageSource{log: NOOPLog, config: NewSQSConfig("http://x", "", 10, 0, 0), sqsClient: client}
+
+	for i := 0; i < 11; i++ {
+		res := ms.MessageProcessed(&sqsMessage{delegate: &sqs.Message{ReceiptHandle: strptr(fmt.Sprintf("h%d", i))}})
 
-	res := ms.MessageProcessed(&sqsMessage{delegate: &sqs.Message{ReceiptHandle: strptr("y")}})
+		if res != nil {
+			t.Errorf("error got %q, want nil", res)
+		}
+	}
+
+	if received := client.deleteMessageBatchReceived; len(received) != 1 {
+		t.Fatalf("invoked DeleteMessageBatch %d times, want 1", len(received))
+	} else if entries := received[0].Entries; len(entries) != 10 {
+		t.Errorf("invoked DeleteMessageBatch with %d entries, want 10", len(entries))
+	}
+
+	res := ms.Flush()
+
+	expected := sqs.DeleteMessageBatchInput{QueueUrl: strptr("http://x"), Entries: []*sqs.DeleteMessageBatchRequestEntry{
+		{Id: strptr("0"), ReceiptHandle: strptr("h10")},
+	}}
+
+	if res != nil {
+		t.Errorf("error got %q, want nil", res)
+	}
+	if received := client.deleteMessageBatchReceived; len(received) != 2 {
+		t.Fatalf("invoked DeleteMessageBatch %d times, want 2", len(received))
+	} else if !reflect.DeepEqual(*received[1], expected) {
+		t.Errorf("invoked DeleteMessageBatch got %v, want %v", *received[1], expected)
+	}
 
-	expected := sqs.DeleteMessageInput{QueueUrl: strptr("http://x"), ReceiptHandle: strptr("y")}
+	res = ms.Flush()
 
 	if res != nil {
 		t.Errorf("error got %q, want nil", res)
 	}
-	if received := client.deleteMessageReceived; !reflect.DeepEqual(*received, expected) {
-		t.Errorf("invoked DeleteMessage got %v, want %v", *received, expected)
+	if received := client.deleteMessageBatchReceived; len(received) != 2 {
+		t.Errorf("invoked DeleteMessageBatch %d times with nothing to flush, want 2", len(received))
+	}
+}
+
+func TestSqsMessageSource_MessageRejected(t *testing.T) {
+	testCases := []struct {
+		label         string
+		deadLetterURL string
+
+		onSendMessage func(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error)
+
+		expectedSendMessage []*sqs.SendMessageInput
+		expectedDeleted     int
+		expected            string
+	}{
+		{
+			label:         "without dead-letter queue",
+			deadLetterURL: "",
+
+			expectedSendMessage: nil,
+			expectedDeleted:     1,
+			expected:            "",
+		},
+		{
+			label:         "with dead-letter queue",
+			deadLetterURL: "http://dlq",
+
+			expectedSendMessage: []*sqs.SendMessageInput{{
+				QueueUrl:    strptr("http://dlq"),
+				MessageBody: strptr("x"),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{
+					"RejectedReason": {DataType: strptr("String"), StringValue: strptr("y")},
+				},
+			}},
+			expectedDeleted: 1,
+			expected:        "",
+		},
+		{
+			label:         "on send error",
+			deadLetterURL: "http://dlq",
+
+			onSendMessage: func(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+				return nil, errors.New("z")
+			},
+
+			expectedSendMessage: []*sqs.SendMessageInput{{
+				QueueUrl:    strptr("http://dlq"),
+				MessageBody: strptr("x"),
+				MessageAttributes: map[string]*sqs.MessageAttributeValue{
+					"RejectedReason": {DataType: strptr("String"), StringValue: strptr("y")},
+				},
+			}},
+			expectedDeleted: 0,
+			expected:        "error sending SQS message to dead-letter queue: z",
+		},
+	}
+
+	for _, tc := range testCases {
+		client := &testSqsClient{onSendMessage: tc.onSendMessage}
+		ms := SQSMessageSource{log: NOOPLog, config: NewSQSConfig("http://x", tc.deadLetterURL, 10, 0, 0), sqsClient: client}
+
+		res := ms.MessageRejected(&sqsMessage{delegate: &sqs.Message{Body: strptr("x"), ReceiptHandle: strptr("h")}}, "y")
+		ms.Flush()
+
+		if !reflect.DeepEqual(client.sendMessageReceived, tc.expectedSendMessage) {
+			t.Errorf("%v: invoked SendMessage got %v, want %v", tc.label, client.sendMessageReceived, tc.expectedSendMessage)
+		}
+		deleted := 0
+		for _, input := range client.deleteMessageBatchReceived {
+			deleted += len(input.Entries)
+		}
+		if deleted != tc.expectedDeleted {
+			t.Errorf("%v: deleted %d messages, want %d", tc.label, deleted, tc.expectedDeleted)
+		}
+		if !errorMessageEquals(res, tc.expected) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, res, tc.expected)
+		}
+	}
+}
+
+func TestSqsMessageSource_FlushErrors(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		onDeleteMessageBatch func(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+
+		expected string
+	}{
+		{
+			label: "on error",
+			onDeleteMessageBatch: func(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+				return nil, errors.New("x")
+			},
+			expected: "error deleting SQS messages: x",
+		},
+		{
+			label: "on failed entries",
+			onDeleteMessageBatch: func(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+				return &sqs.DeleteMessageBatchOutput{
+					Successful: []*sqs.DeleteMessageBatchResultEntry{{Id: strptr("0")}},
+					Failed: []*sqs.BatchResultErrorEntry{
+						{Id: strptr("1"), Code: strptr("ReceiptHandleIsInvalid"), Message: strptr("y")},
+					},
+				}, nil
+			},
+			expected: "error deleting 1 of 2 SQS messages: m1 (ReceiptHandleIsInvalid: y)",
+		},
+	}
+
+	for _, tc := range testCases {
+		client := &testSqsClient{onDeleteMessageBatch: tc.onDeleteMessageBatch}
+		ms := SQSMessageSource{log: NOOPLog, config: NewSQSConfig("http://x", "", 10, 0, 0), sqsClient: client}
+
+		ms.MessageProcessed(&sqsMessage{delegate: &sqs.Message{MessageId: strptr("m0"), ReceiptHandle: strptr("h0")}})
+		ms.MessageProcessed(&sqsMessage{delegate: &sqs.Message{MessageId: strptr("m1"), ReceiptHandle: strptr("h1")}})
+
+		res := ms.Flush()
+
+		if !errorMessageEquals(res, tc.expected) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, res, tc.expected)
+		}
 	}
 }
 
@@ -72,7 +256,10 @@ type testSqsClient struct {
 	receiveMessageRequestIndex   int
 	receiveMessageResultMessages [][]sqs.Message
 	receiveMessageReceived       *sqs.ReceiveMessageInput
-	deleteMessageReceived        *sqs.DeleteMessageInput
+	deleteMessageBatchReceived   []*sqs.DeleteMessageBatchInput
+	onDeleteMessageBatch         func(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+	sendMessageReceived          []*sqs.SendMessageInput
+	onSendMessage                func(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error)
 }
 
 func (c *testSqsClient) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
@@ -88,11 +275,26 @@ func (c *testSqsClient) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.Rec
 	return &sqs.ReceiveMessageOutput{Messages: result}, nil
 }
 
-func (c *testSqsClient) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
-	c.deleteMessageReceived = input
-	return nil, nil
+func (c *testSqsClient) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	c.deleteMessageBatchReceived = append(c.deleteMessageBatchReceived, input)
+	if c.onDeleteMessageBatch != nil {
+		return c.onDeleteMessageBatch(input)
+	}
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func (c *testSqsClient) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	c.sendMessageReceived = append(c.sendMessageReceived, input)
+	if c.onSendMessage != nil {
+		return c.onSendMessage(input)
+	}
+	return &sqs.SendMessageOutput{}, nil
 }
 
 func strptr(in string) *string {
 	return &in
 }
+
+func int64ptr(in int64) *int64 {
+	return &in
+}
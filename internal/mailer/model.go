@@ -26,8 +26,19 @@ func (r recipientStatusSet) Get(name string) RecipientStatus {
 }
 
 var RecipientStatuses = recipientStatusSet{
-	statuses: []RecipientStatus{"new", "subscribed", "failed", "unsubscribing", "unsubscribed"},
-	None:     RecipientStatus(""),
+	statuses: []RecipientStatus{
+		"new",
+		"subscribed",
+		"failed",
+		"unsubscribing",
+		"unsubscribed",
+		"abandoned",
+		"rejected",
+		"pending",
+		"expired",
+		"cleaned",
+	},
+	None: RecipientStatus(""),
 }
 
 type ListRecipient struct {
@@ -35,6 +46,59 @@ type ListRecipient struct {
 	listID       string
 	recipientID  int
 	status       RecipientStatus
+	statusReason string
 	attribs      map[string]string
 	lastModified time.Time
+	retryStatus  RecipientStatus
+	attempts     int
+	nextAttempt  time.Time
+	batchID      int
+	leaseOwner   string
+	leaseExpiry  time.Time
+}
+
+// ListRecipientDelivery is the state of notifying one of the destinations of a list recipient's list, other than
+// the list's own client.
+type ListRecipientDelivery struct {
+	id              int
+	listRecipientID int
+	destination     string
+	status          RecipientStatus
+	statusReason    string
+	lastModified    time.Time
+	retryStatus     RecipientStatus
+	attempts        int
+	nextAttempt     time.Time
+	leaseOwner      string
+	leaseExpiry     time.Time
+}
+
+// ListRecipientEvent records a change in a list recipient's status, or that of its delivery to a destination.
+type ListRecipientEvent struct {
+	id              int
+	listRecipientID int
+	destination     string
+	created         time.Time
+	fromStatus      RecipientStatus
+	toStatus        RecipientStatus
+	sourceMessage   string
+	httpStatus      int
+	detail          string
+}
+
+// RejectedMessage is a message that couldn't be accepted, e.g. because it was invalid.
+type RejectedMessage struct {
+	id      int
+	created time.Time
+	text    string
+	reason  string
+}
+
+// Batch is a batch of operations submitted to the client of a list, whose list recipients are updated with its
+// results once it has finished.
+type Batch struct {
+	id       int
+	listID   string
+	remoteID string
+	created  time.Time
 }
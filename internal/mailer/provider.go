@@ -0,0 +1,288 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProviderConfig configures the client of an email provider for a single list.
+type ProviderConfig struct {
+	provider    string
+	apiKey      string
+	baseURL     string
+	mergeFields MergeFields
+	doubleOptIn bool
+	webhook     WebhookConfig
+}
+
+// NewProviderConfig creates provider configuration. The base URL overrides the provider's default API URL,
+// or is the URL events are posted to for the webhook provider. Merge fields map list recipient attributes to
+// the provider's contact attributes. The webhook configuration is only used by the webhook provider.
+func NewProviderConfig(provider string, apiKey string, baseURL string, mergeFields MergeFields,
+	doubleOptIn bool, webhook WebhookConfig) ProviderConfig {
+	return ProviderConfig{provider: provider, apiKey: apiKey, baseURL: baseURL, mergeFields: mergeFields,
+		doubleOptIn: doubleOptIn, webhook: webhook}
+}
+
+// ProviderFactory creates a client for a list from its provider configuration.
+type ProviderFactory func(log *Loggers, listID string, config ProviderConfig) (Client, error)
+
+var providers = map[string]ProviderFactory{
+	"mailchimp": newMailChimpProviderClient,
+	"brevo":     newBrevoClient,
+	"mailgun":   newMailgunClient,
+	"sendgrid":  newSendGridClient,
+	"webhook":   newWebhookClient,
+}
+
+// RegisterProvider makes a provider available by name, replacing any existing provider of the same name.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providers[name] = factory
+}
+
+// NewProviderClient creates a client for the list using its configured provider.
+func NewProviderClient(log *Loggers, listID string, config ProviderConfig) (Client, error) {
+	factory, ok := providers[config.provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", config.provider)
+	}
+	return factory(log, listID, config)
+}
+
+func newMailChimpProviderClient(log *Loggers, listID string, config ProviderConfig) (Client, error) {
+	var doubleOptInLists []string
+	if config.doubleOptIn {
+		doubleOptInLists = []string{listID}
+	}
+	mergeFields := map[string]MergeFields{listID: config.mergeFields}
+	return NewClient(log, NewClientConfig(config.apiKey, mergeFields, doubleOptInLists)), nil
+}
+
+type providerConfigJSON struct {
+	Provider    string              `json:"provider"`
+	APIKey      string              `json:"apiKey"`
+	BaseURL     string              `json:"baseUrl"`
+	MergeFields MergeFields         `json:"mergeFields"`
+	DoubleOptIn bool                `json:"doubleOptIn"`
+	Webhook     webhookConfigFields `json:"webhook"`
+}
+
+type webhookConfigFields struct {
+	Secret      string `json:"secret" yaml:"secret"`
+	Template    string `json:"template" yaml:"template"`
+	MaxAttempts int    `json:"maxAttempts" yaml:"maxAttempts"`
+}
+
+// ParseProviders parses per-list provider configuration from JSON of the form
+// {"listID": {"provider": "brevo", "apiKey": "...", "baseUrl": "...", "mergeFields": {"attribute": "FIELD"},
+// "doubleOptIn": false, "webhook": {"secret": "...", "template": "...", "maxAttempts": 3}}}.
+func ParseProviders(str string) (map[string]ProviderConfig, error) {
+	result := make(map[string]ProviderConfig)
+	if str == "" {
+		return result, nil
+	}
+
+	var parsed map[string]providerConfigJSON
+	err := json.Unmarshal([]byte(str), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+
+	for listID, c := range parsed {
+		if _, ok := providers[c.Provider]; !ok {
+			return nil, fmt.Errorf("list %s: unknown provider %q", listID, c.Provider)
+		}
+		result[listID] = NewProviderConfig(c.Provider, c.APIKey, c.BaseURL, c.MergeFields, c.DoubleOptIn,
+			NewWebhookConfig(c.Webhook.Secret, c.Webhook.Template, c.Webhook.MaxAttempts))
+	}
+
+	return result, nil
+}
+
+// routingClient routes each subscription to the client of its destination, or of its list, or the fallback client
+// if the list has none.
+type routingClient struct {
+	clients      map[string]Client
+	destinations map[string]map[string]Client
+	fallback     Client
+}
+
+// NewRoutingClient creates a client routing subscriptions to the clients of their lists, or of their destinations,
+// keyed by list ID then destination name.
+func NewRoutingClient(clients map[string]Client, destinations map[string]map[string]Client, fallback Client) Client {
+	return &routingClient{clients: clients, destinations: destinations, fallback: fallback}
+}
+
+func (c *routingClient) get(listID string) Client {
+	if client, ok := c.clients[listID]; ok {
+		return client
+	}
+	return c.fallback
+}
+
+func (c *routingClient) getForSubscription(s subscription) (Client, error) {
+	if s.destination == "" {
+		return c.get(s.listID), nil
+	}
+	client, ok := c.destinations[s.listID][s.destination]
+	if !ok {
+		return nil, fmt.Errorf("unknown destination %s of list %s", s.destination, s.listID)
+	}
+	return client, nil
+}
+
+func (c *routingClient) Subscribe(s subscription) (RecipientStatus, error) {
+	client, err := c.getForSubscription(s)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+	return client.Subscribe(s)
+}
+
+func (c *routingClient) Unsubscribe(s subscription) error {
+	client, err := c.getForSubscription(s)
+	if err != nil {
+		return err
+	}
+	return client.Unsubscribe(s)
+}
+
+func (c *routingClient) GetStatus(s subscription) (RecipientStatus, error) {
+	client, err := c.getForSubscription(s)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+	return client.GetStatus(s)
+}
+
+func (c *routingClient) ForEachMember(listID string, action func([]listMember) error) error {
+	members, ok := c.get(listID).(memberLister)
+	if !ok {
+		return fmt.Errorf("client of list %s can't list members", listID)
+	}
+	return members.ForEachMember(listID, action)
+}
+
+// ProviderError is an unsuccessful response from an email provider's API.
+type ProviderError struct {
+	Provider string
+	URL      string
+	Status   int
+	Body     string
+}
+
+func (e *ProviderError) Error() string {
+	msg := fmt.Sprintf("error received from %s: HTTP status %d", e.URL, e.Status)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Permanent is true if the request was rejected as invalid. Not finding the list is transient, as for MailChimp, so
+// its recipients are retried once it's fixed.
+func (e *ProviderError) Permanent() bool {
+	return e.Status == http.StatusBadRequest
+}
+
+func (e *ProviderError) HTTPStatus() int {
+	return e.Status
+}
+
+// providerOperations executes requests against an email provider's API.
+type providerOperations struct {
+	provider  string
+	ops       clientOperations
+	baseURL   string
+	authorize func(req *http.Request, body []byte)
+}
+
+// executeJSON sends the entity, if any, as JSON, decoding the JSON response into the result, if any.
+func (o *providerOperations) executeJSON(method string, path string, entity interface{}, result interface{}) error {
+	var body []byte
+	contentType := ""
+	if entity != nil {
+		b, err := json.Marshal(entity)
+		if err != nil {
+			panic(err)
+		}
+		body = b
+		contentType = "application/json"
+	}
+	return o.execute(method, path, body, contentType, result)
+}
+
+// executeForm sends the values as a form, decoding the JSON response into the result, if any.
+func (o *providerOperations) executeForm(method string, path string, values url.Values, result interface{}) error {
+	return o.execute(method, path, []byte(values.Encode()), "application/x-www-form-urlencoded", result)
+}
+
+func (o *providerOperations) execute(method string, path string, body []byte, contentType string,
+	result interface{}) error {
+	u := strings.TrimSuffix(o.baseURL, "/") + path
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	if o.authorize != nil {
+		o.authorize(req, body)
+	}
+
+	resp, err := o.ops.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b := &bytes.Buffer{}
+		b.ReadFrom(io.LimitReader(resp.Body, maxEventDetailLength))
+		return &ProviderError{Provider: o.provider, URL: u, Status: resp.StatusCode, Body: strings.TrimSpace(b.String())}
+	}
+
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(resp.Body).Decode(result)
+		if err != nil {
+			return fmt.Errorf("error decoding response: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// getProviderAttributes maps the subscription's attributes to provider contact attributes.
+func getProviderAttributes(s subscription, fields MergeFields) map[string]string {
+	result := make(map[string]string)
+	for key, value := range s.attribs {
+		if name, ok := fields[key]; ok {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+var errDoubleOptInNotSupported = errors.New("double opt-in isn't supported")
+
+func getBaseURL(config ProviderConfig, defaultURL string) string {
+	if config.baseURL != "" {
+		return config.baseURL
+	}
+	return defaultURL
+}
@@ -0,0 +1,169 @@
+package mailer
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseProviders(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		str string
+
+		expected      map[string]ProviderConfig
+		expectedError string
+	}{
+		{
+			label: "on empty",
+
+			str: "",
+
+			expected: map[string]ProviderConfig{},
+		},
+		{
+			label: "on valid providers",
+
+			str: `{"12": {"provider": "brevo", "apiKey": "k", "mergeFields": {"first_name": "FIRSTNAME"}},
+				"list@mg.example.com": {"provider": "mailgun", "apiKey": "l", "baseUrl": "https://api.eu.mailgun.net/v3"},
+				"crm": {"provider": "webhook", "baseUrl": "https://crm", "webhook": {"secret": "s", "template": "{}", "maxAttempts": 5}}}`,
+
+			expected: map[string]ProviderConfig{
+				"12":                  {provider: "brevo", apiKey: "k", mergeFields: MergeFields{"first_name": "FIRSTNAME"}},
+				"list@mg.example.com": {provider: "mailgun", apiKey: "l", baseURL: "https://api.eu.mailgun.net/v3"},
+				"crm": {provider: "webhook", baseURL: "https://crm",
+					webhook: WebhookConfig{secret: "s", template: "{}", maxAttempts: 5}},
+			},
+		},
+		{
+			label: "on unknown provider",
+
+			str: `{"a": {"provider": "x"}}`,
+
+			expectedError: `list a: unknown provider "x"`,
+		},
+		{
+			label: "on invalid json",
+
+			str: `x`,
+
+			expectedError: "invalid json: invalid character 'x' looking for beginning of value",
+		},
+	}
+
+	for _, tc := range testCases {
+		res, err := ParseProviders(tc.str)
+
+		if !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("%v: result got %v, want %v", tc.label, res, tc.expected)
+		}
+		if tc.expectedError == "" && err != nil || tc.expectedError != "" && !errorMessageEquals(err, tc.expectedError) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestNewProviderClient(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		listID string
+		config ProviderConfig
+
+		expectedError string
+	}{
+		{label: "on mailchimp", listID: "a", config: NewProviderConfig("mailchimp", "k-dc", "", nil, true, WebhookConfig{})},
+		{label: "on brevo", listID: "12", config: NewProviderConfig("brevo", "k", "", nil, false, WebhookConfig{})},
+		{label: "on mailgun", listID: "a@b.com", config: NewProviderConfig("mailgun", "k", "", nil, false, WebhookConfig{})},
+		{label: "on sendgrid", listID: "a", config: NewProviderConfig("sendgrid", "k", "", nil, false, WebhookConfig{})},
+		{label: "on webhook", listID: "a", config: NewProviderConfig("webhook", "", "http://x", nil, false, WebhookConfig{})},
+		{
+			label:         "on unknown provider",
+			config:        NewProviderConfig("x", "", "", nil, false, WebhookConfig{}),
+			expectedError: `unknown provider "x"`,
+		},
+		{
+			label:         "on invalid brevo list ID",
+			listID:        "a",
+			config:        NewProviderConfig("brevo", "k", "", nil, false, WebhookConfig{}),
+			expectedError: `invalid Brevo list ID "a"`,
+		},
+		{
+			label:         "on double opt-in unsupported",
+			listID:        "a",
+			config:        NewProviderConfig("sendgrid", "k", "", nil, true, WebhookConfig{}),
+			expectedError: "double opt-in isn't supported",
+		},
+		{
+			label:         "on webhook without URL",
+			listID:        "a",
+			config:        NewProviderConfig("webhook", "", "", nil, false, WebhookConfig{}),
+			expectedError: "webhook URL is required",
+		},
+	}
+
+	for _, tc := range testCases {
+		c, err := NewProviderClient(NOOPLog, tc.listID, tc.config)
+
+		if tc.expectedError == "" && (err != nil || c == nil) {
+			t.Errorf("%v: result got %v, %v, want client, nil", tc.label, c, err)
+		}
+		if tc.expectedError != "" && !errorMessageEquals(err, tc.expectedError) {
+			t.Errorf("%v: result error got %q, want %q", tc.label, err, tc.expectedError)
+		}
+	}
+}
+
+func TestRoutingClient(t *testing.T) {
+	var routed []string
+	newClient := func(name string) Client {
+		return newNotifierTestClient(func(s subscription) (RecipientStatus, error) {
+			routed = append(routed, name)
+			return RecipientStatuses.Get("subscribed"), nil
+		}, nil)
+	}
+
+	c := NewRoutingClient(map[string]Client{"a": newClient("a")},
+		map[string]map[string]Client{"a": {"crm": newClient("a/crm")}}, newClient("fallback"))
+
+	c.Subscribe(subscription{email: "x", listID: "a"})
+	c.Subscribe(subscription{email: "x", listID: "b"})
+	c.Subscribe(subscription{email: "x", listID: "a", destination: "crm"})
+
+	if expected := []string{"a", "fallback", "a/crm"}; !reflect.DeepEqual(routed, expected) {
+		t.Errorf("routed got %v, want %v", routed, expected)
+	}
+
+	_, err := c.Subscribe(subscription{email: "x", listID: "b", destination: "crm"})
+
+	if expected := "unknown destination crm of list b"; !errorMessageEquals(err, expected) {
+		t.Errorf("Subscribe to unknown destination error got %q, want %q", err, expected)
+	}
+
+	err = c.(memberLister).ForEachMember("a", nil)
+
+	if expected := "client of list a can't list members"; !errorMessageEquals(err, expected) {
+		t.Errorf("ForEachMember error got %q, want %q", err, expected)
+	}
+}
+
+func TestProviderOperations_ExecuteError(t *testing.T) {
+	s := newProviderTestServer(providerTestResponse{status: 404, body: "not found\n"})
+	defer s.Close()
+
+	ops := &providerOperations{provider: "x", ops: &http.Client{}, baseURL: s.URL + "/"}
+
+	err := ops.executeJSON("GET", "/path", nil, nil)
+
+	expected := &ProviderError{Provider: "x", URL: s.URL + "/path", Status: 404, Body: "not found"}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("result error got %#v, want %#v", err, expected)
+	}
+	if isPermanent(err) {
+		t.Errorf("permanent got true, want false")
+	}
+	if expected := "error received from " + s.URL + "/path: HTTP status 404: not found"; !errorMessageEquals(err, expected) {
+		t.Errorf("result error got %q, want %q", err, expected)
+	}
+}
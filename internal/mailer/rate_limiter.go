@@ -0,0 +1,132 @@
+package mailer
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MailChimp allows each account 10 simultaneous connections, see
+// https://developer.mailchimp.com/documentation/mailchimp/guides/get-started-with-mailchimp-api-3/#throttling
+const (
+	mailChimpMaxConnections     = 10
+	mailChimpRequestsPerSecond  = 10
+	mailChimpMaxRetryAfterDelay = time.Minute
+)
+
+// rateLimiter limits requests to an API to a number in progress at once, and to a rate, with a token bucket
+// refilled at the rate up to the burst. Requests can also be paused, e.g. until the time a Retry-After header says.
+type rateLimiter struct {
+	slots chan struct{}
+	clock clock
+	sleep func(time.Duration)
+
+	mu          sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newRateLimiter(maxConcurrent int, rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		slots:  make(chan struct{}, maxConcurrent),
+		clock:  &stdClock{},
+		sleep:  time.Sleep,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// acquire waits until a request can be made, returning a function to call once it has completed.
+func (l *rateLimiter) acquire() (release func()) {
+	l.slots <- struct{}{}
+
+	for {
+		delay := l.take()
+		if delay == 0 {
+			return func() {
+				<-l.slots
+			}
+		}
+		l.sleep(delay)
+	}
+}
+
+// take takes a token if one is available and requests aren't paused, otherwise getting how long to wait before
+// trying again.
+func (l *rateLimiter) take() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.now()
+
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// pause stops requests being made until the delay has passed.
+func (l *rateLimiter) pause(delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := l.clock.now().Add(delay); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+var (
+	mailChimpLimitersMu sync.Mutex
+	mailChimpLimiters   = make(map[string]*rateLimiter)
+)
+
+// getMailChimpLimiter gets the limiter shared by all the clients of a MailChimp API key, since MailChimp limits
+// each account.
+func getMailChimpLimiter(apiKey string) *rateLimiter {
+	mailChimpLimitersMu.Lock()
+	defer mailChimpLimitersMu.Unlock()
+
+	l, ok := mailChimpLimiters[apiKey]
+	if !ok {
+		l = newRateLimiter(mailChimpMaxConnections, mailChimpRequestsPerSecond, mailChimpMaxConnections)
+		mailChimpLimiters[apiKey] = l
+	}
+	return l
+}
+
+// getRetryAfter gets the delay a response's Retry-After header says to wait before retrying, as either a number of
+// seconds or an HTTP date.
+func getRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if t.Before(now) {
+			return 0, true
+		}
+		return t.Sub(now), true
+	}
+	return 0, false
+}
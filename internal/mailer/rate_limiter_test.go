@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Acquire(t *testing.T) {
+	clock := &testClock{time: time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)}
+	l := newRateLimiter(2, 2, 2)
+	l.clock = clock
+	var slept []time.Duration
+	l.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		clock.time = clock.time.Add(d)
+	}
+
+	// the burst is available at once, then a token every half second
+	for i := 0; i < 3; i++ {
+		l.acquire()()
+	}
+
+	if expected := []time.Duration{500 * time.Millisecond}; !reflect.DeepEqual(slept, expected) {
+		t.Errorf("slept got %v, want %v", slept, expected)
+	}
+
+	slept = nil
+	l.pause(3 * time.Second)
+	l.pause(time.Second)
+	l.acquire()()
+
+	if expected := []time.Duration{3 * time.Second}; !reflect.DeepEqual(slept, expected) {
+		t.Errorf("slept after pause got %v, want %v", slept, expected)
+	}
+}
+
+func TestRateLimiter_AcquireLimitsConcurrency(t *testing.T) {
+	l := newRateLimiter(1, 1000, 1000)
+
+	release := l.acquire()
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire()()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("acquired while in use, want to wait")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("not acquired once released")
+	}
+}
+
+func TestGetMailChimpLimiter(t *testing.T) {
+	if a, b := getMailChimpLimiter("a-dc"), getMailChimpLimiter("a-dc"); a != b {
+		t.Errorf("limiters of same API key got different, want same")
+	}
+	if a, b := getMailChimpLimiter("a-dc"), getMailChimpLimiter("b-dc"); a == b {
+		t.Errorf("limiters of different API keys got same, want different")
+	}
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	now := time.Date(2018, 03, 28, 1, 2, 3, 0, time.UTC)
+
+	testCases := []struct {
+		header        string
+		expectedDelay time.Duration
+		expectedOK    bool
+	}{
+		{header: ""},
+		{header: "x"},
+		{header: "-1"},
+		{header: "0", expectedOK: true},
+		{header: "30", expectedDelay: 30 * time.Second, expectedOK: true},
+		{header: "Wed, 28 Mar 2018 01:02:13 GMT", expectedDelay: 10 * time.Second, expectedOK: true},
+		{header: "Wed, 28 Mar 2018 01:01:00 GMT", expectedOK: true},
+	}
+
+	for _, tc := range testCases {
+		resp := &http.Response{Header: http.Header{}}
+		if tc.header != "" {
+			resp.Header.Set("Retry-After", tc.header)
+		}
+
+		delay, ok := getRetryAfter(resp, now)
+
+		if delay != tc.expectedDelay || ok != tc.expectedOK {
+			t.Errorf("on %q: result got %v, %v, want %v, %v", tc.header, delay, ok, tc.expectedDelay, tc.expectedOK)
+		}
+	}
+}
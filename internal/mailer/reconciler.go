@@ -0,0 +1,273 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Kinds of ReconciliationDifference.
+const (
+	MissingLocally    = "missing_locally"
+	MissingRemotely   = "missing_remotely"
+	StatusMismatch    = "status_mismatch"
+	AttributeMismatch = "attribute_mismatch"
+	// EndedLocally is a member subscribed, or pending, remotely, whose list recipient has ended locally, i.e. expired,
+	// or been rejected or abandoned. It's only reported, not applied, since those statuses are never notified again.
+	EndedLocally = "ended_locally"
+)
+
+var reconciliationKinds = []string{MissingLocally, MissingRemotely, StatusMismatch, AttributeMismatch, EndedLocally}
+
+// ReconciliationReport describes the differences between the list recipients in the repository and the members
+// of the list known by the client.
+type ReconciliationReport struct {
+	ListID      string                     `json:"listId"`
+	Local       int                        `json:"local"`
+	Remote      int                        `json:"remote"`
+	Differences []ReconciliationDifference `json:"differences"`
+	Summary     map[string]int             `json:"summary"`
+	Applied     bool                       `json:"applied"`
+}
+
+// ReconciliationDifference is a difference in a single recipient's list membership.
+type ReconciliationDifference struct {
+	Kind             string            `json:"kind"`
+	Email            string            `json:"email"`
+	LocalStatus      RecipientStatus   `json:"localStatus,omitempty"`
+	RemoteStatus     RecipientStatus   `json:"remoteStatus,omitempty"`
+	LocalAttributes  map[string]string `json:"localAttributes,omitempty"`
+	RemoteAttributes map[string]string `json:"remoteAttributes,omitempty"`
+}
+
+// WriteText writes the report in a human-readable form.
+func (r *ReconciliationReport) WriteText(w io.Writer) error {
+	lines := []string{fmt.Sprintf("list %s: %d local, %d remote", r.ListID, r.Local, r.Remote)}
+
+	for _, d := range r.Differences {
+		var detail string
+		switch d.Kind {
+		case MissingLocally:
+			detail = fmt.Sprintf("remote %s", d.RemoteStatus)
+		case MissingRemotely:
+			detail = fmt.Sprintf("local %s", d.LocalStatus)
+		case StatusMismatch, EndedLocally:
+			detail = fmt.Sprintf("local %s, remote %s", d.LocalStatus, d.RemoteStatus)
+		case AttributeMismatch:
+			detail = fmt.Sprintf("local %v, remote %v", d.LocalAttributes, d.RemoteAttributes)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s (%s)", strings.Replace(d.Kind, "_", " ", -1), d.Email, detail))
+	}
+
+	counts := make([]string, len(reconciliationKinds))
+	for i, kind := range reconciliationKinds {
+		counts[i] = fmt.Sprintf("%d %s", r.Summary[kind], strings.Replace(kind, "_", " ", -1))
+	}
+	lines = append(lines, "summary: "+strings.Join(counts, ", "))
+
+	if r.Applied {
+		lines = append(lines, "applied")
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// Reconciler detects, and optionally fixes, drift between the list recipients in the repository and the
+// members of the list known by the client. The client is taken to be correct, since recipients may change
+// their membership there directly.
+type Reconciler struct {
+	log     *Loggers
+	journal journal
+	members memberLister
+}
+
+func NewReconciler(log *Loggers, repo Repository, client Client) (*Reconciler, error) {
+	members, ok := client.(memberLister)
+	if !ok {
+		return nil, errors.New("client can't list members")
+	}
+	return &Reconciler{
+		log:     log,
+		journal: &repositoryJournal{log: log, repo: repo, clock: &stdClock{}},
+		members: members,
+	}, nil
+}
+
+// reconcilePageSize is the number of list recipients Reconcile gets in each page.
+const reconcilePageSize = 1000
+
+// Reconcile compares the list recipients of the list with its members, applying the members' status and
+// attributes to the list recipients if apply is true. List recipients yet to be notified of their pending
+// state are ignored, and those that have ended are only reported. The members, then the list recipients, are got a page at a time, the list recipients of each
+// page of members being got by their emails, so only the emails of the members are held at once.
+func (r *Reconciler) Reconcile(listID string, apply bool) (*ReconciliationReport, error) {
+	report := &ReconciliationReport{ListID: listID, Summary: make(map[string]int)}
+
+	remoteEmails := make(map[string]bool)
+	var localErr error
+
+	err := r.members.ForEachMember(listID, func(members []listMember) error {
+		report.Remote += len(members)
+
+		emails := make([]string, len(members))
+		for i, m := range members {
+			emails[i] = normalizeEmail(m.email)
+			remoteEmails[emails[i]] = true
+		}
+
+		var local []listRecipientComposite
+		local, localErr = r.journal.GetListRecipientsByEmail(listID, emails)
+		if localErr != nil {
+			return localErr
+		}
+
+		localByEmail := make(map[string]listRecipientComposite)
+		for _, lr := range local {
+			localByEmail[normalizeEmail(lr.email)] = lr
+		}
+
+		for _, m := range members {
+			if lr, found := localByEmail[normalizeEmail(m.email)]; found {
+				report.compare(lr, m)
+			} else {
+				report.add(ReconciliationDifference{Kind: MissingLocally, Email: m.email, RemoteStatus: m.status,
+					RemoteAttributes: m.attribs})
+			}
+		}
+
+		return nil
+	})
+
+	if localErr != nil {
+		return nil, fmt.Errorf("couldn't get list recipients: %v", localErr)
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't get list members: %v", err)
+	}
+
+	err = r.journal.ForEachListRecipient(listID, reconcilePageSize, func(local []listRecipientComposite) error {
+		report.Local += len(local)
+
+		for _, lr := range local {
+			if remoteEmails[normalizeEmail(lr.email)] {
+				continue
+			}
+			if lr.status == RecipientStatuses.Get("subscribed") || lr.status == RecipientStatuses.Get("pending") {
+				report.add(ReconciliationDifference{Kind: MissingRemotely, Email: lr.email, LocalStatus: lr.status})
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get list recipients: %v", err)
+	}
+
+	sort.SliceStable(report.Differences, func(i, j int) bool {
+		a, b := report.Differences[i], report.Differences[j]
+		if a.Kind != b.Kind {
+			return kindIndex(a.Kind) < kindIndex(b.Kind)
+		}
+		return a.Email < b.Email
+	})
+
+	if apply {
+		err = r.apply(listID, report)
+		if err != nil {
+			return report, err
+		}
+		report.Applied = true
+	}
+
+	return report, nil
+}
+
+func (r *Reconciler) apply(listID string, report *ReconciliationReport) error {
+	source := fmt.Sprintf("reconciliation of list %s", listID)
+
+	for _, d := range report.Differences {
+		var err error
+
+		switch d.Kind {
+		case MissingLocally:
+			err = r.journal.SetListRecipientStatus(d.Email, listID, d.RemoteStatus, "reconciled", source)
+			if err == nil && len(d.RemoteAttributes) > 0 {
+				err = r.journal.UpdateListRecipientAttributes(d.Email, listID, d.RemoteAttributes)
+			}
+		case MissingRemotely:
+			err = r.journal.SetListRecipientStatus(d.Email, listID, RecipientStatuses.Get("unsubscribed"),
+				"reconciled: not a member of the list", source)
+		case StatusMismatch:
+			err = r.journal.SetListRecipientStatus(d.Email, listID, d.RemoteStatus, "reconciled", source)
+		case AttributeMismatch:
+			err = r.journal.UpdateListRecipientAttributes(d.Email, listID, d.RemoteAttributes)
+		case EndedLocally:
+			// left as it is, keeping why it ended
+		}
+
+		if err != nil {
+			return fmt.Errorf("couldn't apply %s of %s: %v", d.Kind, d.Email, err)
+		}
+	}
+
+	return nil
+}
+
+// compare adds any difference between the list recipient and the member of the list with its email.
+func (r *ReconciliationReport) compare(lr listRecipientComposite, m listMember) {
+	switch {
+	case isInProgress(lr.status):
+	case isEnded(lr.status):
+		if m.status == RecipientStatuses.Get("subscribed") || m.status == RecipientStatuses.Get("pending") {
+			r.add(ReconciliationDifference{Kind: EndedLocally, Email: lr.email, LocalStatus: lr.status,
+				RemoteStatus: m.status})
+		}
+	case lr.status != m.status:
+		r.add(ReconciliationDifference{Kind: StatusMismatch, Email: lr.email, LocalStatus: lr.status,
+			RemoteStatus: m.status})
+	case !attributesEqual(lr.attribs, m.attribs):
+		r.add(ReconciliationDifference{Kind: AttributeMismatch, Email: lr.email, LocalAttributes: lr.attribs,
+			RemoteAttributes: m.attribs})
+	}
+}
+
+func (r *ReconciliationReport) add(d ReconciliationDifference) {
+	r.Differences = append(r.Differences, d)
+	r.Summary[d.Kind]++
+}
+
+// isInProgress is true if the list recipient has yet to be notified of its pending state.
+func isInProgress(status RecipientStatus) bool {
+	return status == RecipientStatuses.Get("new") || status == RecipientStatuses.Get("unsubscribing") ||
+		status == RecipientStatuses.Get("failed")
+}
+
+// isEnded is true if the list recipient will never be notified again: its confirmation expired, or notifying it was
+// rejected, or abandoned after too many attempts.
+func isEnded(status RecipientStatus) bool {
+	return status == RecipientStatuses.Get("expired") || status == RecipientStatuses.Get("rejected") ||
+		status == RecipientStatuses.Get("abandoned")
+}
+
+// attributesEqual is true if the local attributes have the same values as all the remote attributes, missing
+// local attributes being equal to empty remote ones.
+func attributesEqual(local map[string]string, remote map[string]string) bool {
+	for k, v := range remote {
+		if local[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func kindIndex(kind string) int {
+	for i, k := range reconciliationKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return len(reconciliationKinds)
+}
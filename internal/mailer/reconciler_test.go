@@ -0,0 +1,258 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReconciler_Reconcile(t *testing.T) {
+	subscribed := RecipientStatuses.Get("subscribed")
+	unsubscribed := RecipientStatuses.Get("unsubscribed")
+
+	local := []listRecipientComposite{
+		{email: "a@b.com", status: subscribed, attribs: map[string]string{"first_name": "Ron"}},
+		{email: "c@d.com", status: subscribed},
+		{email: "e@f.com", status: subscribed},
+		{email: "g@h.com", status: subscribed, attribs: map[string]string{"first_name": "Ron", "other": "x"}},
+		{email: "i@j.com", status: RecipientStatuses.Get("new")},
+		{email: "k@l.com", status: RecipientStatuses.Get("rejected")},
+		{email: "o@p.com", status: RecipientStatuses.Get("expired")},
+		{email: "q@r.com", status: RecipientStatuses.Get("abandoned")},
+	}
+	remote := []listMember{
+		{email: "A@B.com", status: subscribed, attribs: map[string]string{"first_name": "Ron"}},
+		{email: "c@d.com", status: unsubscribed},
+		{email: "g@h.com", status: subscribed, attribs: map[string]string{"first_name": "Hellboy"}},
+		{email: "i@j.com", status: unsubscribed},
+		{email: "m@n.com", status: subscribed, attribs: map[string]string{"first_name": "Abe"}},
+		{email: "k@l.com", status: subscribed},
+		{email: "o@p.com", status: unsubscribed},
+	}
+
+	expectedDifferences := []ReconciliationDifference{
+		{Kind: MissingLocally, Email: "m@n.com", RemoteStatus: subscribed, RemoteAttributes: map[string]string{"first_name": "Abe"}},
+		{Kind: MissingRemotely, Email: "e@f.com", LocalStatus: subscribed},
+		{Kind: StatusMismatch, Email: "c@d.com", LocalStatus: subscribed, RemoteStatus: unsubscribed},
+		{Kind: AttributeMismatch, Email: "g@h.com",
+			LocalAttributes:  map[string]string{"first_name": "Ron", "other": "x"},
+			RemoteAttributes: map[string]string{"first_name": "Hellboy"}},
+		{Kind: EndedLocally, Email: "k@l.com", LocalStatus: RecipientStatuses.Get("rejected"), RemoteStatus: subscribed},
+	}
+
+	testCases := []struct {
+		label string
+
+		apply bool
+
+		expectedStatusReceived     []listRecipientStatusParams
+		expectedAttributesReceived []listRecipientAttributesParams
+	}{
+		{
+			label: "reports differences",
+
+			apply: false,
+		},
+		{
+			label: "applies differences",
+
+			apply: true,
+
+			expectedStatusReceived: []listRecipientStatusParams{
+				{email: "m@n.com", listID: "x", status: subscribed, reason: "reconciled", source: "reconciliation of list x"},
+				{email: "e@f.com", listID: "x", status: unsubscribed, reason: "reconciled: not a member of the list",
+					source: "reconciliation of list x"},
+				{email: "c@d.com", listID: "x", status: unsubscribed, reason: "reconciled", source: "reconciliation of list x"},
+			},
+			expectedAttributesReceived: []listRecipientAttributesParams{
+				{email: "m@n.com", listID: "x", attribs: map[string]string{"first_name": "Abe"}},
+				{email: "g@h.com", listID: "x", attribs: map[string]string{"first_name": "Hellboy"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		j := &testJournal{onGetListRecipients: func(listID string) ([]listRecipientComposite, error) {
+			return local, nil
+		}}
+		members := &testMemberLister{onGetMembers: func(listID string) ([]listMember, error) {
+			return remote, nil
+		}}
+		r := &Reconciler{log: NOOPLog, journal: j, members: members}
+
+		report, err := r.Reconcile("x", tc.apply)
+
+		if err != nil {
+			t.Fatalf("%v: result error got %v, want nil", tc.label, err)
+		}
+		if !reflect.DeepEqual(report.Differences, expectedDifferences) {
+			t.Errorf("%v: differences got %v, want %v", tc.label, report.Differences, expectedDifferences)
+		}
+		expectedSummary := map[string]int{MissingLocally: 1, MissingRemotely: 1, StatusMismatch: 1, AttributeMismatch: 1,
+			EndedLocally: 1}
+		if !reflect.DeepEqual(report.Summary, expectedSummary) {
+			t.Errorf("%v: summary got %v, want %v", tc.label, report.Summary, expectedSummary)
+		}
+		if report.Local != 8 || report.Remote != 7 {
+			t.Errorf("%v: local, remote got %d, %d, want 8, 7", tc.label, report.Local, report.Remote)
+		}
+		if report.Applied != tc.apply {
+			t.Errorf("%v: applied got %v, want %v", tc.label, report.Applied, tc.apply)
+		}
+		if !reflect.DeepEqual(j.listRecipientStatusReceived, tc.expectedStatusReceived) {
+			t.Errorf("%v: invoked SetListRecipientStatus got %v, want %v", tc.label, j.listRecipientStatusReceived, tc.expectedStatusReceived)
+		}
+		if !reflect.DeepEqual(j.attributesReceived, tc.expectedAttributesReceived) {
+			t.Errorf("%v: invoked UpdateListRecipientAttributes got %v, want %v", tc.label, j.attributesReceived, tc.expectedAttributesReceived)
+		}
+	}
+}
+
+func TestReconciler_ReconcileMixedCaseMember(t *testing.T) {
+	subscribed := RecipientStatuses.Get("subscribed")
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: &testClock{}}
+	j.SetListRecipientStatus("a@b.com", "x", subscribed, "", "{}")
+
+	members := &testMemberLister{onGetMembers: func(listID string) ([]listMember, error) {
+		return []listMember{{email: "A@B.com", status: subscribed}, {email: "Ron@Perlman.face", status: subscribed}}, nil
+	}}
+	r := &Reconciler{log: NOOPLog, journal: j, members: members}
+
+	if _, err := r.Reconcile("x", true); err != nil {
+		t.Fatalf("result error got %v, want nil", err)
+	}
+
+	local, _ := getListRecipients(j, "x")
+	if len(local) != 2 || local[0].email != "a@b.com" || local[1].email != "ron@perlman.face" {
+		t.Errorf("list recipients got %v, want a@b.com and normalized ron@perlman.face", local)
+	}
+
+	report, err := r.Reconcile("x", false)
+	if err != nil || len(report.Differences) != 0 {
+		t.Errorf("reconciling again got %v, %v, want no differences", report, err)
+	}
+}
+
+func TestReconciler_ReconcileErrors(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		onGetListRecipients func(listID string) ([]listRecipientComposite, error)
+		onGetMembers        func(listID string) ([]listMember, error)
+
+		expected error
+	}{
+		{
+			label: "on get list recipients of members error",
+
+			onGetListRecipients: func(listID string) ([]listRecipientComposite, error) {
+				return nil, errors.New("x")
+			},
+			onGetMembers: func(listID string) ([]listMember, error) {
+				return []listMember{{email: "a@b.com"}}, nil
+			},
+
+			expected: errors.New("couldn't get list recipients: x"),
+		},
+		{
+			label: "on get list recipients error",
+
+			onGetListRecipients: func(listID string) ([]listRecipientComposite, error) {
+				return nil, errors.New("x")
+			},
+			onGetMembers: func(listID string) ([]listMember, error) {
+				return nil, nil
+			},
+
+			expected: errors.New("couldn't get list recipients: x"),
+		},
+		{
+			label: "on get members error",
+
+			onGetListRecipients: func(listID string) ([]listRecipientComposite, error) {
+				return nil, nil
+			},
+			onGetMembers: func(listID string) ([]listMember, error) {
+				return nil, errors.New("x")
+			},
+
+			expected: errors.New("couldn't get list members: x"),
+		},
+	}
+
+	for _, tc := range testCases {
+		j := &testJournal{onGetListRecipients: tc.onGetListRecipients}
+		r := &Reconciler{log: NOOPLog, journal: j, members: &testMemberLister{onGetMembers: tc.onGetMembers}}
+
+		_, err := r.Reconcile("x", false)
+
+		if !errorEquals(err, tc.expected) {
+			t.Errorf("%v: result error got %v, want %v", tc.label, err, tc.expected)
+		}
+	}
+}
+
+func TestReconciliationReport_WriteText(t *testing.T) {
+	report := &ReconciliationReport{
+		ListID: "x",
+		Local:  2,
+		Remote: 1,
+		Differences: []ReconciliationDifference{
+			{Kind: MissingRemotely, Email: "a@b.com", LocalStatus: RecipientStatuses.Get("subscribed")},
+			{Kind: StatusMismatch, Email: "c@d.com", LocalStatus: RecipientStatuses.Get("subscribed"),
+				RemoteStatus: RecipientStatuses.Get("cleaned")},
+			{Kind: EndedLocally, Email: "e@f.com", LocalStatus: RecipientStatuses.Get("expired"),
+				RemoteStatus: RecipientStatuses.Get("subscribed")},
+		},
+		Summary: map[string]int{MissingRemotely: 1, StatusMismatch: 1, EndedLocally: 1},
+		Applied: true,
+	}
+
+	b := &bytes.Buffer{}
+	err := report.WriteText(b)
+
+	expected := "list x: 2 local, 1 remote\n" +
+		"missing remotely: a@b.com (local subscribed)\n" +
+		"status mismatch: c@d.com (local subscribed, remote cleaned)\n" +
+		"ended locally: e@f.com (local expired, remote subscribed)\n" +
+		"summary: 0 missing locally, 1 missing remotely, 1 status mismatch, 0 attribute mismatch, 1 ended locally\n" +
+		"applied\n"
+
+	if err != nil {
+		t.Errorf("result error got %v, want nil", err)
+	}
+	if b.String() != expected {
+		t.Errorf("result got %q, want %q", b.String(), expected)
+	}
+}
+
+func TestNewReconciler(t *testing.T) {
+	_, err := NewReconciler(NOOPLog, nil, newNotifierTestClient(nil, nil))
+
+	if !errorMessageEquals(err, "client can't list members") {
+		t.Errorf("result error got %q, want %q", err, "client can't list members")
+	}
+}
+
+type testMemberLister struct {
+	onGetMembers func(listID string) ([]listMember, error)
+}
+
+// ForEachMember passes the members got to the action in pages of two, so more than one page is reconciled.
+func (l *testMemberLister) ForEachMember(listID string, action func([]listMember) error) error {
+	members, err := l.onGetMembers(listID)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(members); start += 2 {
+		end := start + 2
+		if end > len(members) {
+			end = len(members)
+		}
+		if err := action(members[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
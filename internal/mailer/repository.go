@@ -2,14 +2,16 @@ package mailer
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/hdpe/mailsling/internal/mailer/schema"
 	"github.com/mattes/migrate"
-	"github.com/mattes/migrate/database/mysql"
+	"github.com/mattes/migrate/source"
 
 	"github.com/mattes/migrate/source/go-bindata"
 )
@@ -20,31 +22,375 @@ type listRecipientComposite struct {
 	email           string
 	listID          string
 	status          RecipientStatus
+	attribs         map[string]string
+	lastModified    time.Time
+	deliveryID      int
+	destination     string
 }
 
 type Repository interface {
-	GetRecipientDataByStatus(*sql.Tx, []RecipientStatus) ([]listRecipientComposite, error)
+	GetRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) ([]listRecipientComposite, error)
+	GetRecipientDataForRetry(tx *sql.Tx, now time.Time, page Page) ([]listRecipientComposite, error)
+	GetRecipientDataByListID(tx *sql.Tx, listID string, page Page) ([]listRecipientComposite, error)
+	GetRecipientDataByEmails(tx *sql.Tx, listID string, emails []string) ([]listRecipientComposite, error)
+	ClaimRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus, now time.Time, lease Lease, page Page) (
+		[]listRecipientComposite, error)
+	ClaimRecipientDataForRetry(tx *sql.Tx, now time.Time, lease Lease, page Page) ([]listRecipientComposite, error)
 	GetRecipientByEmail(*sql.Tx, string) (recipient Recipient, found bool, err error)
 	InsertRecipient(*sql.Tx, Recipient) (int, error)
+	UpdateRecipient(*sql.Tx, Recipient) error
 	GetListRecipient(*sql.Tx, int) (ListRecipient, error)
 	GetListRecipientByEmailAndListID(tx *sql.Tx, email string, listID string) (listRecipient ListRecipient, found bool, err error)
 	InsertListRecipient(*sql.Tx, ListRecipient) (int, error)
 	UpdateListRecipient(*sql.Tx, ListRecipient) error
+	GetDeliveryDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) ([]listRecipientComposite, error)
+	GetDeliveryDataForRetry(tx *sql.Tx, now time.Time, page Page) ([]listRecipientComposite, error)
+	ClaimDeliveryDataByStatus(tx *sql.Tx, statuses []RecipientStatus, now time.Time, lease Lease, page Page) (
+		[]listRecipientComposite, error)
+	ClaimDeliveryDataForRetry(tx *sql.Tx, now time.Time, lease Lease, page Page) ([]listRecipientComposite, error)
+	ReleaseLeases(tx *sql.Tx, owner string) error
+	GetListRecipientDelivery(*sql.Tx, int) (ListRecipientDelivery, error)
+	GetListRecipientDeliveryByDestination(tx *sql.Tx, listRecipientID int, destination string) (
+		delivery ListRecipientDelivery, found bool, err error)
+	InsertListRecipientDelivery(*sql.Tx, ListRecipientDelivery) (int, error)
+	UpdateListRecipientDelivery(*sql.Tx, ListRecipientDelivery) error
+	InsertListRecipientEvent(*sql.Tx, ListRecipientEvent) (int, error)
+	InsertRejectedMessage(*sql.Tx, RejectedMessage) (int, error)
+	GetBatches(*sql.Tx) ([]Batch, error)
+	InsertBatch(*sql.Tx, Batch) (int, error)
+	DeleteBatch(*sql.Tx, int) error
+	GetTaskLastRun(tx *sql.Tx, name string) (lastRun time.Time, found bool, err error)
+	SetTaskLastRun(tx *sql.Tx, name string, lastRun time.Time) error
 	DoInTx(func(*sql.Tx) error) error
 	Close() error
 }
 
+// Lease is a claim by its owner on list recipients or deliveries, so no one else notifies them until it expires.
+type Lease struct {
+	owner  string
+	expiry time.Time
+}
+
+// Page is a page of list recipients or deliveries, in ID order: those with IDs after an ID, up to a limit of them.
+// A zero limit gets all of them.
+type Page struct {
+	afterID int
+	limit   int
+}
+
+// sql gets the SQL ending a select's where clause with the page's conditions, ordered by the ID column, and its
+// arguments.
+func (p Page) sql(idColumn string) (string, []interface{}) {
+	query := " and " + idColumn + " > ? order by " + idColumn
+	args := []interface{}{p.afterID}
+	if p.limit > 0 {
+		query += " limit ?"
+		args = append(args, p.limit)
+	}
+	return query, args
+}
+
+// errStopIteration is returned by an action passed pages by forEachPage to stop getting them, and by forEachPage.
+var errStopIteration = errors.New("stop iteration")
+
+// forEachPage gets pages of list recipients or deliveries with getPage, in order of the ID each has, passing each
+// to the action, until there are no more, the action returns an error such as errStopIteration, or the max items
+// have been got. A zero page size gets them all in one page, and zero max items gets them all. It gets how many it
+// passed.
+func forEachPage(pageSize int, maxItems int, id func(listRecipientComposite) int,
+	getPage func(Page) ([]listRecipientComposite, error), action func([]listRecipientComposite) error) (int, error) {
+	page := Page{limit: pageSize}
+	got := 0
+
+	for {
+		if maxItems > 0 && (page.limit == 0 || maxItems-got < page.limit) {
+			page.limit = maxItems - got
+		}
+
+		rs, err := getPage(page)
+		if err != nil {
+			return got, err
+		}
+		if len(rs) == 0 {
+			return got, nil
+		}
+
+		got += len(rs)
+
+		err = action(rs)
+		if err != nil {
+			return got, err
+		}
+
+		if page.limit == 0 || len(rs) < page.limit || got == maxItems {
+			return got, nil
+		}
+
+		page.afterID = id(rs[len(rs)-1])
+	}
+}
+
 type DBRepository struct {
-	Db *sql.DB
+	Db      *sql.DB
+	dialect dialect
+}
+
+// GetRecipientDataByStatus gets a page of list recipients in any of the statuses, other than those in a batch.
+func (r *DBRepository) GetRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) (
+	[]listRecipientComposite, error) {
+	in, args := toStatusInClause("lr.status", statuses)
+	pageSQL, pageArgs := page.sql("lr.id")
+	return r.getRecipientData(tx, `
+		select lr.id, r.id, r.email, lr.list_id, lr.status, lr.last_modified
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+		where `+in+` and lr.batch_id is null`+pageSQL, append(args, pageArgs...)...)
+}
+
+// GetRecipientDataForRetry gets a page of failed list recipients whose next attempt is due, other than those in a
+// batch, with the status they were in before they failed.
+func (r *DBRepository) GetRecipientDataForRetry(tx *sql.Tx, now time.Time, page Page) ([]listRecipientComposite,
+	error) {
+	pageSQL, pageArgs := page.sql("lr.id")
+	return r.getRecipientData(tx, `
+		select lr.id, r.id, r.email, lr.list_id, lr.retry_status, lr.last_modified
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+		where lr.status = ? and lr.next_attempt <= ? and lr.batch_id is null`+pageSQL,
+		append([]interface{}{RecipientStatuses.Get("failed"), now}, pageArgs...)...)
+}
+
+// GetRecipientDataByListID gets a page of the recipients of a list.
+func (r *DBRepository) GetRecipientDataByListID(tx *sql.Tx, listID string, page Page) ([]listRecipientComposite,
+	error) {
+	pageSQL, pageArgs := page.sql("lr.id")
+	return r.getRecipientData(tx, `
+		select lr.id, r.id, r.email, lr.list_id, lr.status, lr.last_modified
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+		where lr.list_id = ?`+pageSQL, append([]interface{}{listID}, pageArgs...)...)
+}
+
+// emailsPerQuery limits the emails GetRecipientDataByEmails gets in each query, keeping its parameters within
+// SQLite's limit of 999.
+const emailsPerQuery = 500
+
+// GetRecipientDataByEmails gets the recipients of a list with any of the emails, in ID order.
+func (r *DBRepository) GetRecipientDataByEmails(tx *sql.Tx, listID string, emails []string) (
+	[]listRecipientComposite, error) {
+	var result []listRecipientComposite
+
+	for start := 0; start < len(emails); start += emailsPerQuery {
+		end := start + emailsPerQuery
+		if end > len(emails) {
+			end = len(emails)
+		}
+
+		placeholders := make([]string, end-start)
+		args := []interface{}{listID}
+		for i, email := range emails[start:end] {
+			placeholders[i] = "?"
+			args = append(args, email)
+		}
+
+		data, err := r.getRecipientData(tx, `
+			select lr.id, r.id, r.email, lr.list_id, lr.status, lr.last_modified
+			from recipients r
+				inner join list_recipients lr
+					on r.id = lr.recipient_id
+			where lr.list_id = ? and r.email in (`+strings.Join(placeholders, ", ")+`)
+			order by lr.id`, args...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, data...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].listRecipientID < result[j].listRecipientID
+	})
+
+	return result, nil
+}
+
+// GetDeliveryDataByStatus gets a page of deliveries to destinations in any of the statuses, with their list
+// recipients.
+func (r *DBRepository) GetDeliveryDataByStatus(tx *sql.Tx, statuses []RecipientStatus, page Page) (
+	[]listRecipientComposite, error) {
+	in, args := toStatusInClause("d.status", statuses)
+	pageSQL, pageArgs := page.sql("d.id")
+	return r.getDeliveryData(tx, `
+		select lr.id, r.id, r.email, lr.list_id, d.status, d.last_modified, d.id, d.destination
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+			inner join list_recipient_deliveries d
+				on lr.id = d.list_recipient_id
+		where `+in+pageSQL, append(args, pageArgs...)...)
+}
+
+// GetDeliveryDataForRetry gets a page of failed deliveries whose next attempt is due, with the status they were in
+// before they failed.
+func (r *DBRepository) GetDeliveryDataForRetry(tx *sql.Tx, now time.Time, page Page) ([]listRecipientComposite,
+	error) {
+	pageSQL, pageArgs := page.sql("d.id")
+	return r.getDeliveryData(tx, `
+		select lr.id, r.id, r.email, lr.list_id, d.retry_status, d.last_modified, d.id, d.destination
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+			inner join list_recipient_deliveries d
+				on lr.id = d.list_recipient_id
+		where d.status = ? and d.next_attempt <= ?`+pageSQL,
+		append([]interface{}{RecipientStatuses.Get("failed"), now}, pageArgs...)...)
+}
+
+// ClaimRecipientDataByStatus leases a page of list recipients in any of the statuses, other than those in a batch or
+// leased by others until after now, getting those leased.
+func (r *DBRepository) ClaimRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus, now time.Time,
+	lease Lease, page Page) ([]listRecipientComposite, error) {
+	in, args := toStatusInClause("lr.status", statuses)
+	pageSQL, pageArgs := page.sql("lr.id")
+	return r.claimRecipientData(tx, now, lease, `
+		select lr.id, r.id, r.email, lr.list_id, lr.status, lr.last_modified
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+		where `+in+` and lr.batch_id is null and (lr.lease_expiry is null or lr.lease_expiry <= ?)`+pageSQL+
+		r.dialect.lockRows("lr"), append(append(args, now), pageArgs...)...)
+}
+
+// ClaimRecipientDataForRetry leases a page of failed list recipients whose next attempt is due, other than those in
+// a batch or leased by others until after now, getting those leased with the status they were in before they failed.
+func (r *DBRepository) ClaimRecipientDataForRetry(tx *sql.Tx, now time.Time, lease Lease, page Page) (
+	[]listRecipientComposite, error) {
+	pageSQL, pageArgs := page.sql("lr.id")
+	return r.claimRecipientData(tx, now, lease, `
+		select lr.id, r.id, r.email, lr.list_id, lr.retry_status, lr.last_modified
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+		where lr.status = ? and lr.next_attempt <= ? and lr.batch_id is null
+			and (lr.lease_expiry is null or lr.lease_expiry <= ?)`+pageSQL+r.dialect.lockRows("lr"),
+		append([]interface{}{RecipientStatuses.Get("failed"), now, now}, pageArgs...)...)
 }
 
-func (r *DBRepository) GetRecipientDataByStatus(tx *sql.Tx, statuses []RecipientStatus) (result []listRecipientComposite, err error) {
-	rows, err := tx.Query(fmt.Sprintf(`
-		select lr.id, r.id, r.email, lr.list_id, lr.status 
-		from recipients r 
+// ClaimDeliveryDataByStatus leases a page of deliveries to destinations in any of the statuses, other than those
+// leased by others until after now, getting those leased with their list recipients.
+func (r *DBRepository) ClaimDeliveryDataByStatus(tx *sql.Tx, statuses []RecipientStatus, now time.Time,
+	lease Lease, page Page) ([]listRecipientComposite, error) {
+	in, args := toStatusInClause("d.status", statuses)
+	pageSQL, pageArgs := page.sql("d.id")
+	return r.claimDeliveryData(tx, now, lease, `
+		select lr.id, r.id, r.email, lr.list_id, d.status, d.last_modified, d.id, d.destination
+		from recipients r
 			inner join list_recipients lr
 				on r.id = lr.recipient_id
-		where %v`, toStatusInFragment(statuses)))
+			inner join list_recipient_deliveries d
+				on lr.id = d.list_recipient_id
+		where `+in+` and (d.lease_expiry is null or d.lease_expiry <= ?)`+pageSQL+r.dialect.lockRows("d"),
+		append(append(args, now), pageArgs...)...)
+}
+
+// ClaimDeliveryDataForRetry leases a page of failed deliveries whose next attempt is due, other than those leased by
+// others until after now, getting those leased with the status they were in before they failed.
+func (r *DBRepository) ClaimDeliveryDataForRetry(tx *sql.Tx, now time.Time, lease Lease, page Page) (
+	[]listRecipientComposite, error) {
+	pageSQL, pageArgs := page.sql("d.id")
+	return r.claimDeliveryData(tx, now, lease, `
+		select lr.id, r.id, r.email, lr.list_id, d.retry_status, d.last_modified, d.id, d.destination
+		from recipients r
+			inner join list_recipients lr
+				on r.id = lr.recipient_id
+			inner join list_recipient_deliveries d
+				on lr.id = d.list_recipient_id
+		where d.status = ? and d.next_attempt <= ? and (d.lease_expiry is null or d.lease_expiry <= ?)`+pageSQL+
+		r.dialect.lockRows("d"), append([]interface{}{RecipientStatuses.Get("failed"), now, now}, pageArgs...)...)
+}
+
+// claimRecipientData leases the list recipients the query selects, getting those leased.
+func (r *DBRepository) claimRecipientData(tx *sql.Tx, now time.Time, lease Lease, query string,
+	args ...interface{}) ([]listRecipientComposite, error) {
+	selected, err := r.getRecipientData(tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var result []listRecipientComposite
+	for _, rec := range selected {
+		leased, err := r.lease(tx, "list_recipients", rec.listRecipientID, now, lease)
+		if err != nil {
+			return nil, err
+		}
+		if leased {
+			result = append(result, rec)
+		}
+	}
+	return result, nil
+}
+
+// claimDeliveryData leases the deliveries the query selects, getting those leased.
+func (r *DBRepository) claimDeliveryData(tx *sql.Tx, now time.Time, lease Lease, query string,
+	args ...interface{}) ([]listRecipientComposite, error) {
+	selected, err := r.getDeliveryData(tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var result []listRecipientComposite
+	for _, rec := range selected {
+		leased, err := r.lease(tx, "list_recipient_deliveries", rec.deliveryID, now, lease)
+		if err != nil {
+			return nil, err
+		}
+		if leased {
+			result = append(result, rec)
+		}
+	}
+	return result, nil
+}
+
+// lease leases the row of the table, unless others have leased it until after now since it was selected, which
+// dialects that can't skip rows locked by others rely on, getting whether it did.
+func (r *DBRepository) lease(tx *sql.Tx, table string, id int, now time.Time, lease Lease) (bool, error) {
+	res, err := r.exec(tx, "update "+table+" set lease_owner = ?, lease_expiry = ? "+
+		"where id = ? and (lease_expiry is null or lease_expiry <= ?)", lease.owner, lease.expiry, id, now)
+	if err != nil {
+		return false, fmt.Errorf("couldn't lease row: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("couldn't get leased rows: %v", err)
+	}
+	return n > 0, nil
+}
+
+// ReleaseLeases releases the owner's leases of any list recipients and deliveries it hasn't finished with.
+func (r *DBRepository) ReleaseLeases(tx *sql.Tx, owner string) error {
+	for _, table := range []string{"list_recipients", "list_recipient_deliveries"} {
+		_, err := r.exec(tx, "update "+table+" set lease_owner = null, lease_expiry = null where lease_owner = ?",
+			owner)
+		if err != nil {
+			return fmt.Errorf("couldn't perform update: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r *DBRepository) getRecipientData(tx *sql.Tx, query string, args ...interface{}) ([]listRecipientComposite, error) {
+	return r.getCompositeData(tx, mapListRecipientCompositeRow, query, args...)
+}
+
+func (r *DBRepository) getDeliveryData(tx *sql.Tx, query string, args ...interface{}) ([]listRecipientComposite, error) {
+	return r.getCompositeData(tx, mapDeliveryCompositeRow, query, args...)
+}
+
+func (r *DBRepository) getCompositeData(tx *sql.Tx, mapRow func(*sql.Rows) (listRecipientComposite, error),
+	query string, args ...interface{}) (result []listRecipientComposite, err error) {
+	rows, err := r.query(tx, query, args...)
 
 	if err != nil {
 		err = fmt.Errorf("couldn't get row: %v", err)
@@ -55,7 +401,7 @@ func (r *DBRepository) GetRecipientDataByStatus(tx *sql.Tx, statuses []Recipient
 
 	for rows.Next() {
 		var rec listRecipientComposite
-		rec, err = mapListRecipientCompositeRow(rows)
+		rec, err = mapRow(rows)
 		if err != nil {
 			err = fmt.Errorf("error retrieving row: %v", err)
 			return
@@ -64,18 +410,46 @@ func (r *DBRepository) GetRecipientDataByStatus(tx *sql.Tx, statuses []Recipient
 	}
 	if err = rows.Err(); err != nil {
 		err = fmt.Errorf("error iterating rows: %v", err)
+		return
+	}
+
+	rows.Close()
+
+	for i := range result {
+		result[i].attribs, err = r.getListRecipientAttributes(tx, result[i].listRecipientID)
+		if err != nil {
+			err = fmt.Errorf("couldn't get attributes: %v", err)
+			return
+		}
 	}
 
 	return result, err
 }
 
-func toStatusInFragment(statuses []RecipientStatus) string {
-	strs := make([]string, len(statuses))
+func (r *DBRepository) query(tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Query(r.dialect.rebind(query), r.dialect.bind(args)...)
+}
+
+func (r *DBRepository) exec(tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Exec(r.dialect.rebind(query), r.dialect.bind(args)...)
+}
+
+func (r *DBRepository) insert(tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	id, err := r.dialect.insert(tx, query, r.dialect.bind(args)...)
+	return int(id), err
+}
+
+// toStatusInClause gets a condition that the column is any of the statuses, with a placeholder for each, and its
+// arguments.
+func toStatusInClause(column string, statuses []RecipientStatus) (string, []interface{}) {
+	placeholders := make([]string, len(statuses))
+	args := make([]interface{}, len(statuses))
 	for i, s := range statuses {
-		strs[i] = fmt.Sprintf("'%s'", s)
+		placeholders[i] = "?"
+		args[i] = s
 	}
 
-	return fmt.Sprintf("status in (%s)", strings.Join(strs, ", "))
+	return column + " in (" + strings.Join(placeholders, ", ") + ")", args
 }
 
 func (r *DBRepository) GetListRecipient(tx *sql.Tx, id int) (lr ListRecipient, err error) {
@@ -93,7 +467,11 @@ func (r *DBRepository) GetListRecipient(tx *sql.Tx, id int) (lr ListRecipient, e
 }
 
 func (r *DBRepository) getListRecipientInternal(tx *sql.Tx, id int) (result ListRecipient, err error) {
-	rows, err := tx.Query("select id, list_id, recipient_id, status, last_modified from list_recipients where id = ?", id)
+	rows, err := r.query(tx, `
+		select id, list_id, recipient_id, status, status_reason, last_modified, retry_status, attempts,
+			next_attempt, batch_id, lease_owner, lease_expiry
+		from list_recipients
+		where id = ?`, id)
 
 	if err != nil {
 		err = fmt.Errorf("couldn't get row: %v", err)
@@ -121,7 +499,7 @@ func (r *DBRepository) getListRecipientInternal(tx *sql.Tx, id int) (result List
 }
 
 func (r *DBRepository) GetRecipientByEmail(tx *sql.Tx, email string) (result Recipient, found bool, err error) {
-	rows, err := tx.Query("select id, email from recipients where email = ?", email)
+	rows, err := r.query(tx, "select id, email from recipients where email = ?", email)
 
 	if err != nil {
 		err = fmt.Errorf("couldn't get row: %v", err)
@@ -146,15 +524,15 @@ func (r *DBRepository) GetRecipientByEmail(tx *sql.Tx, email string) (result Rec
 }
 
 func (r *DBRepository) InsertRecipient(tx *sql.Tx, recipient Recipient) (int, error) {
-	res, err := tx.Exec("insert into recipients (email) values (?)", recipient.Email)
-	if err != nil {
-		return 0, fmt.Errorf("couldn't perform insert: %v", err)
-	}
-	id, err := res.LastInsertId()
+	return r.insert(tx, "insert into recipients (email) values (?)", recipient.Email)
+}
+
+func (r *DBRepository) UpdateRecipient(tx *sql.Tx, recipient Recipient) error {
+	_, err := r.exec(tx, "update recipients set email = ? where id = ?", recipient.Email, recipient.ID)
 	if err != nil {
-		return 0, fmt.Errorf("couldn't get inserted row ID: %v", err)
+		return fmt.Errorf("couldn't perform update: %v", err)
 	}
-	return int(id), nil
+	return nil
 }
 
 func (r *DBRepository) GetListRecipientByEmailAndListID(tx *sql.Tx, email string, listID string) (
@@ -174,8 +552,9 @@ func (r *DBRepository) GetListRecipientByEmailAndListID(tx *sql.Tx, email string
 
 func (r *DBRepository) getListRecipientByEmailAndListIDInternal(tx *sql.Tx, email string, listID string) (
 	result ListRecipient, found bool, err error) {
-	rows, err := tx.Query(`
-		select lr.id, lr.list_id, lr.recipient_id, lr.status, lr.last_modified
+	rows, err := r.query(tx, `
+		select lr.id, lr.list_id, lr.recipient_id, lr.status, lr.status_reason, lr.last_modified, lr.retry_status,
+			lr.attempts, lr.next_attempt, lr.batch_id, lr.lease_owner, lr.lease_expiry
 		from list_recipients lr
 			inner join recipients r 
 				on lr.recipient_id = r.id
@@ -205,22 +584,24 @@ func (r *DBRepository) getListRecipientByEmailAndListIDInternal(tx *sql.Tx, emai
 }
 
 func (r *DBRepository) InsertListRecipient(tx *sql.Tx, listRecipient ListRecipient) (int, error) {
-	res, err := tx.Exec("insert into list_recipients (list_id, recipient_id, status, last_modified) values (?, ?, ?, ?)",
-		listRecipient.listID, listRecipient.recipientID, RecipientStatuses.Get("new"), listRecipient.lastModified)
+	id, err := r.insert(tx, "insert into list_recipients (list_id, recipient_id, status, last_modified) values (?, ?, ?, ?)",
+		listRecipient.listID, listRecipient.recipientID, listRecipient.status, listRecipient.lastModified)
 	if err != nil {
-		return 0, fmt.Errorf("couldn't perform insert: %v", err)
+		return 0, err
 	}
-	id, err := res.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("couldn't get inserted row ID: %v", err)
-	}
-	err = r.updateListRecipientAttributes(tx, int(id), listRecipient.attribs)
-	return int(id), err
+	err = r.updateListRecipientAttributes(tx, id, listRecipient.attribs)
+	return id, err
 }
 
 func (r *DBRepository) UpdateListRecipient(tx *sql.Tx, listRecipient ListRecipient) error {
-	_, err := tx.Exec("update list_recipients set status = ?, last_modified = ? where id = ?",
-		listRecipient.status, listRecipient.lastModified, listRecipient.id)
+	_, err := r.exec(tx, `
+		update list_recipients
+		set status = ?, status_reason = ?, last_modified = ?, retry_status = ?, attempts = ?, next_attempt = ?,
+			batch_id = ?, lease_owner = ?, lease_expiry = ?
+		where id = ?`,
+		listRecipient.status, toNullString(listRecipient.statusReason), listRecipient.lastModified, toNullString(string(listRecipient.retryStatus)),
+		listRecipient.attempts, toNullTime(listRecipient.nextAttempt), toNullInt(listRecipient.batchID),
+		toNullString(listRecipient.leaseOwner), toNullTime(listRecipient.leaseExpiry), listRecipient.id)
 	if err != nil {
 		return fmt.Errorf("couldn't perform update: %v", err)
 	}
@@ -228,13 +609,186 @@ func (r *DBRepository) UpdateListRecipient(tx *sql.Tx, listRecipient ListRecipie
 	return err
 }
 
+func (r *DBRepository) GetListRecipientDelivery(tx *sql.Tx, id int) (result ListRecipientDelivery, err error) {
+	result, found, err := r.getListRecipientDelivery(tx, "where id = ?", id)
+	if err == nil && !found {
+		err = fmt.Errorf("delivery #%d not found", id)
+	}
+	return result, err
+}
+
+func (r *DBRepository) GetListRecipientDeliveryByDestination(tx *sql.Tx, listRecipientID int, destination string) (
+	ListRecipientDelivery, bool, error) {
+	return r.getListRecipientDelivery(tx, "where list_recipient_id = ? and destination = ?", listRecipientID,
+		destination)
+}
+
+func (r *DBRepository) getListRecipientDelivery(tx *sql.Tx, where string, args ...interface{}) (
+	result ListRecipientDelivery, found bool, err error) {
+	rows, err := r.query(tx, `
+		select id, list_recipient_id, destination, status, status_reason, last_modified, retry_status, attempts,
+			next_attempt, lease_owner, lease_expiry
+		from list_recipient_deliveries
+		`+where, args...)
+
+	if err != nil {
+		err = fmt.Errorf("couldn't get row: %v", err)
+		return
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		found = true
+		result, err = mapListRecipientDeliveryRow(rows)
+		if err != nil {
+			err = fmt.Errorf("error retrieving row: %v", err)
+			return
+		}
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return result, found, err
+}
+
+func (r *DBRepository) InsertListRecipientDelivery(tx *sql.Tx, delivery ListRecipientDelivery) (int, error) {
+	return r.insert(tx, `
+		insert into list_recipient_deliveries (list_recipient_id, destination, status, last_modified)
+		values (?, ?, ?, ?)`,
+		delivery.listRecipientID, delivery.destination, delivery.status, delivery.lastModified)
+}
+
+func (r *DBRepository) UpdateListRecipientDelivery(tx *sql.Tx, delivery ListRecipientDelivery) error {
+	_, err := r.exec(tx, `
+		update list_recipient_deliveries
+		set status = ?, status_reason = ?, last_modified = ?, retry_status = ?, attempts = ?, next_attempt = ?,
+			lease_owner = ?, lease_expiry = ?
+		where id = ?`,
+		delivery.status, toNullString(delivery.statusReason), delivery.lastModified, toNullString(string(delivery.retryStatus)),
+		delivery.attempts, toNullTime(delivery.nextAttempt), toNullString(delivery.leaseOwner),
+		toNullTime(delivery.leaseExpiry), delivery.id)
+	if err != nil {
+		return fmt.Errorf("couldn't perform update: %v", err)
+	}
+	return nil
+}
+
+func (r *DBRepository) InsertListRecipientEvent(tx *sql.Tx, event ListRecipientEvent) (int, error) {
+	var httpStatus sql.NullInt64
+	if event.httpStatus != 0 {
+		httpStatus = sql.NullInt64{Int64: int64(event.httpStatus), Valid: true}
+	}
+	return r.insert(tx, `
+		insert into list_recipient_events (list_recipient_id, destination, created, from_status, to_status,
+			source_message, http_status, detail)
+		values (?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.listRecipientID, toNullString(event.destination), event.created, toNullString(string(event.fromStatus)),
+		event.toStatus, toNullString(event.sourceMessage), httpStatus, toNullString(event.detail))
+}
+
+func (r *DBRepository) InsertRejectedMessage(tx *sql.Tx, msg RejectedMessage) (int, error) {
+	return r.insert(tx, "insert into rejected_messages (created, message, reason) values (?, ?, ?)",
+		msg.created, msg.text, msg.reason)
+}
+
+func (r *DBRepository) GetBatches(tx *sql.Tx) (result []Batch, err error) {
+	rows, err := r.query(tx, "select id, list_id, remote_id, created from batches order by id")
+
+	if err != nil {
+		err = fmt.Errorf("couldn't get row: %v", err)
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Batch
+		err = rows.Scan(&b.id, &b.listID, &b.remoteID, &b.created)
+		if err != nil {
+			err = fmt.Errorf("error retrieving row: %v", err)
+			return
+		}
+		result = append(result, b)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return result, err
+}
+
+func (r *DBRepository) InsertBatch(tx *sql.Tx, batch Batch) (int, error) {
+	return r.insert(tx, "insert into batches (list_id, remote_id, created) values (?, ?, ?)",
+		batch.listID, batch.remoteID, batch.created)
+}
+
+// DeleteBatch deletes a batch, removing any list recipients still in it from it.
+func (r *DBRepository) DeleteBatch(tx *sql.Tx, id int) error {
+	_, err := r.exec(tx, "update list_recipients set batch_id = null where batch_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("couldn't perform update: %v", err)
+	}
+	_, err = r.exec(tx, "delete from batches where id = ?", id)
+	if err != nil {
+		return fmt.Errorf("couldn't perform delete: %v", err)
+	}
+	return nil
+}
+
+// GetTaskLastRun gets when the named task last ran, if it has.
+func (r *DBRepository) GetTaskLastRun(tx *sql.Tx, name string) (result time.Time, found bool, err error) {
+	rows, err := r.query(tx, "select last_run from task_runs where name = ?", name)
+
+	if err != nil {
+		err = fmt.Errorf("couldn't get row: %v", err)
+		return
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		found = true
+		err = rows.Scan(&result)
+		if err != nil {
+			err = fmt.Errorf("error retrieving row: %v", err)
+			return
+		}
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return result, found, err
+}
+
+// SetTaskLastRun sets when the named task last ran.
+func (r *DBRepository) SetTaskLastRun(tx *sql.Tx, name string, lastRun time.Time) error {
+	_, found, err := r.GetTaskLastRun(tx, name)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		_, err = r.exec(tx, "update task_runs set last_run = ? where name = ?", lastRun, name)
+	} else {
+		_, err = r.exec(tx, "insert into task_runs (name, last_run) values (?, ?)", name, lastRun)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't perform update: %v", err)
+	}
+	return nil
+}
+
 func (r *DBRepository) updateListRecipientAttributes(tx *sql.Tx, listRecipientID int, attribs map[string]string) error {
-	_, err := tx.Exec("delete from list_recipient_attributes where list_recipient_id = ?", listRecipientID)
+	_, err := r.exec(tx, "delete from list_recipient_attributes where list_recipient_id = ?", listRecipientID)
 	if err != nil {
 		return fmt.Errorf("couldn't delete existing attributes: %v", err)
 	}
 	for k, v := range attribs {
-		_, err := tx.Exec("insert into list_recipient_attributes (list_recipient_id, `key`, `value`) values (?, ?, ?)",
+		_, err := r.exec(tx, "insert into list_recipient_attributes (list_recipient_id, "+r.dialect.quote("key")+", "+r.dialect.quote("value")+
+			") values (?, ?, ?)",
 			listRecipientID, k, v)
 		if err != nil {
 			return fmt.Errorf("couldn't insert attribute: %v", err)
@@ -245,7 +799,8 @@ func (r *DBRepository) updateListRecipientAttributes(tx *sql.Tx, listRecipientID
 
 func (r *DBRepository) getListRecipientAttributes(tx *sql.Tx, listRecipientID int) (result map[string]string, err error) {
 	result = make(map[string]string)
-	rows, err := tx.Query("select `key`, `value` from list_recipient_attributes where list_recipient_id = ?", listRecipientID)
+	rows, err := r.query(tx, "select "+r.dialect.quote("key")+", "+r.dialect.quote("value")+
+		" from list_recipient_attributes where list_recipient_id = ?", listRecipientID)
 	if err != nil {
 		err = fmt.Errorf("couldn't get row: %v", err)
 		return
@@ -325,15 +880,34 @@ func mapListRecipientRow(rows *sql.Rows) (ListRecipient, error) {
 		listID       string
 		recipientID  int
 		status       string
+		statusReason sql.NullString
 		lastModified time.Time
+		retryStatus  sql.NullString
+		attempts     int
+		nextAttempt  *time.Time
+		batchID      sql.NullInt64
+		leaseOwner   sql.NullString
+		leaseExpiry  *time.Time
 
 		r ListRecipient
 	)
 
-	err := rows.Scan(&id, &listID, &recipientID, &status, &lastModified)
+	err := rows.Scan(&id, &listID, &recipientID, &status, &statusReason, &lastModified, &retryStatus, &attempts, &nextAttempt,
+		&batchID, &leaseOwner, &leaseExpiry)
 
 	if err == nil {
-		r = ListRecipient{id: id, listID: listID, recipientID: recipientID, status: RecipientStatuses.Get(status), lastModified: lastModified}
+		r = ListRecipient{id: id, listID: listID, recipientID: recipientID, status: RecipientStatuses.Get(status),
+			statusReason: statusReason.String, lastModified: lastModified, attempts: attempts,
+			batchID: int(batchID.Int64), leaseOwner: leaseOwner.String}
+		if retryStatus.Valid {
+			r.retryStatus = RecipientStatuses.Get(retryStatus.String)
+		}
+		if nextAttempt != nil {
+			r.nextAttempt = *nextAttempt
+		}
+		if leaseExpiry != nil {
+			r.leaseExpiry = *leaseExpiry
+		}
 	}
 
 	return r, err
@@ -346,11 +920,44 @@ func mapListRecipientCompositeRow(rows *sql.Rows) (listRecipientComposite, error
 		email           string
 		listID          string
 		status          string
+		lastModified    *time.Time
+
+		r listRecipientComposite
+	)
+
+	err := rows.Scan(&listRecipientID, &recipientID, &email, &listID, &status, &lastModified)
+
+	if err == nil {
+		r = listRecipientComposite{
+			listRecipientID: listRecipientID,
+			recipientID:     recipientID,
+			email:           email,
+			listID:          listID,
+			status:          RecipientStatuses.Get(status),
+		}
+		if lastModified != nil {
+			r.lastModified = *lastModified
+		}
+	}
+
+	return r, err
+}
+
+func mapDeliveryCompositeRow(rows *sql.Rows) (listRecipientComposite, error) {
+	var (
+		listRecipientID int
+		recipientID     int
+		email           string
+		listID          string
+		status          string
+		lastModified    *time.Time
+		deliveryID      int
+		destination     string
 
 		r listRecipientComposite
 	)
 
-	err := rows.Scan(&listRecipientID, &recipientID, &email, &listID, &status)
+	err := rows.Scan(&listRecipientID, &recipientID, &email, &listID, &status, &lastModified, &deliveryID, &destination)
 
 	if err == nil {
 		r = listRecipientComposite{
@@ -359,53 +966,189 @@ func mapListRecipientCompositeRow(rows *sql.Rows) (listRecipientComposite, error
 			email:           email,
 			listID:          listID,
 			status:          RecipientStatuses.Get(status),
+			deliveryID:      deliveryID,
+			destination:     destination,
+		}
+		if lastModified != nil {
+			r.lastModified = *lastModified
 		}
 	}
 
 	return r, err
 }
 
-func NewRepository(dsn string) (*DBRepository, error) {
-	db, err := sql.Open("mysql", dsn)
+func mapListRecipientDeliveryRow(rows *sql.Rows) (ListRecipientDelivery, error) {
+	var (
+		id              int
+		listRecipientID int
+		destination     string
+		status          string
+		statusReason    sql.NullString
+		lastModified    time.Time
+		retryStatus     sql.NullString
+		attempts        int
+		nextAttempt     *time.Time
+		leaseOwner      sql.NullString
+		leaseExpiry     *time.Time
+
+		d ListRecipientDelivery
+	)
+
+	err := rows.Scan(&id, &listRecipientID, &destination, &status, &statusReason, &lastModified, &retryStatus, &attempts,
+		&nextAttempt, &leaseOwner, &leaseExpiry)
+
+	if err == nil {
+		d = ListRecipientDelivery{id: id, listRecipientID: listRecipientID, destination: destination,
+			status: RecipientStatuses.Get(status), statusReason: statusReason.String, lastModified: lastModified,
+			attempts: attempts, leaseOwner: leaseOwner.String}
+		if retryStatus.Valid {
+			d.retryStatus = RecipientStatuses.Get(retryStatus.String)
+		}
+		if nextAttempt != nil {
+			d.nextAttempt = *nextAttempt
+		}
+		if leaseExpiry != nil {
+			d.leaseExpiry = *leaseExpiry
+		}
+	}
+
+	return d, err
+}
+
+func toNullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func toNullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+func toNullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// NewRepository opens a repository in the database of the DSN, the dialect of which is chosen from its scheme (see
+// parseDSN). If migrateSchema is set, it applies any migrations the database hasn't had; otherwise, e.g. for a dry
+// run, which mustn't change the database, it fails if there are any.
+func NewRepository(dsn string, migrateSchema bool) (*DBRepository, error) {
+	d, dataSourceName := parseDSN(dsn)
+
+	db, err := d.open(dataSourceName)
 
 	if err != nil {
 		return nil, fmt.Errorf("couldn't open connection to %q: %v", dsn, err)
 	}
 
-	err = applyMigrations(db)
+	if migrateSchema {
+		err = applyMigrations(db, d)
+
+		if err != nil {
+			return nil, fmt.Errorf("couldn't apply migrations: %v", err)
+		}
+	} else {
+		err = checkMigrations(db, d)
+
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check migrations: %v", err)
+		}
+	}
+
+	return &DBRepository{Db: db, dialect: d}, nil
+}
+
+// applyMigrations applies the migrations in the schema directory of the dialect.
+func applyMigrations(db *sql.DB, d dialect) error {
+	m, _, err := newMigrate(db, d)
 
 	if err != nil {
-		return nil, fmt.Errorf("couldn't apply migrations: %v", err)
+		return err
 	}
 
-	return &DBRepository{Db: db}, err
+	migrErr := m.Up()
+
+	if migrErr != nil && migrErr != migrate.ErrNoChange {
+		err = fmt.Errorf("couldn't update database: %v", migrErr)
+	}
+
+	return err
 }
 
-func applyMigrations(db *sql.DB) error {
-	s := bindata.Resource(schema.AssetNames(),
+// checkMigrations checks the database has had all the migrations in the schema directory of the dialect, without
+// applying any.
+func checkMigrations(db *sql.DB, d dialect) error {
+	m, sourceDrv, err := newMigrate(db, d)
+
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+
+	if err == migrate.ErrNilVersion {
+		return errors.New("database has had no migrations")
+	} else if err != nil {
+		return fmt.Errorf("couldn't get database version: %v", err)
+	} else if dirty {
+		return fmt.Errorf("database version %d is dirty", version)
+	}
+
+	latest, err := sourceDrv.First()
+
+	for err == nil {
+		var next uint
+		next, err = sourceDrv.Next(latest)
+		if err == nil {
+			latest = next
+		}
+	}
+
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("couldn't read migrations: %v", err)
+	}
+
+	if version < latest {
+		return fmt.Errorf("database version %d is behind the latest migration, %d", version, latest)
+	}
+
+	return nil
+}
+
+// newMigrate creates a migrate instance for the migrations in the schema directory of the dialect, and its source.
+func newMigrate(db *sql.DB, d dialect) (*migrate.Migrate, source.Driver, error) {
+	prefix := d.name() + "/"
+
+	var names []string
+	for _, name := range schema.AssetNames() {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, strings.TrimPrefix(name, prefix))
+		}
+	}
+
+	s := bindata.Resource(names,
 		func(name string) ([]byte, error) {
-			return schema.Asset(name)
+			return schema.Asset(prefix + name)
 		})
 
 	sourceDrv, err := bindata.WithInstance(s)
 
 	if err != nil {
-		return fmt.Errorf("couldn't read migrations: %v", err)
+		return nil, nil, fmt.Errorf("couldn't read migrations: %v", err)
 	}
 
-	dbDrv, err := mysql.WithInstance(db, &mysql.Config{})
+	dbDrv, err := d.newMigrationDriver(db)
 
 	if err != nil {
-		return fmt.Errorf("couldn't open connection for migrations: %v", err)
+		return nil, nil, fmt.Errorf("couldn't open connection for migrations: %v", err)
 	}
 
-	m, _ := migrate.NewWithInstance("go-bindata", sourceDrv, "mysql", dbDrv)
-
-	migrErr := m.Up()
+	m, err := migrate.NewWithInstance("go-bindata", sourceDrv, d.name(), dbDrv)
 
-	if migrErr != nil && migrErr != migrate.ErrNoChange {
-		err = fmt.Errorf("couldn't update database: %v", migrErr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't create migrations: %v", err)
 	}
 
-	return err
+	return m, sourceDrv, nil
 }
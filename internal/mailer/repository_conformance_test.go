@@ -0,0 +1,568 @@
+package mailer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRepositoryConformance tests that a Repository implementation behaves like the database schema, with each
+// test run against a new, empty repository.
+func testRepositoryConformance(t *testing.T, newRepository func(t *testing.T) Repository) {
+	// whole seconds, in a zone other than UTC, since MySQL timestamps have no fraction or zone
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo Repository, now time.Time)
+	}{
+		{"Recipients", testRepositoryRecipients},
+		{"ListRecipients", testRepositoryListRecipients},
+		{"RecipientData", testRepositoryRecipientData},
+		{"Deliveries", testRepositoryDeliveries},
+		{"EventsAndRejectedMessages", testRepositoryEventsAndRejectedMessages},
+		{"Batches", testRepositoryBatches},
+		{"Leases", testRepositoryLeases},
+		{"Pages", testRepositoryPages},
+		{"TaskRuns", testRepositoryTaskRuns},
+		{"DoInTx", testRepositoryDoInTx},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newRepository(t)
+			defer repo.Close()
+			tc.test(t, repo, now)
+		})
+	}
+}
+
+func testRepositoryRecipients(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		id, err := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		if err != nil {
+			t.Fatalf("InsertRecipient() error got %q, want nil", err)
+		}
+
+		if r, found, err := repo.GetRecipientByEmail(tx, "a@b.com"); !found || err != nil ||
+			!reflect.DeepEqual(r, Recipient{ID: id, Email: "a@b.com"}) {
+			t.Errorf("GetRecipientByEmail() got %v, %v, %v, want recipient #%d", r, found, err, id)
+		}
+		if _, found, err := repo.GetRecipientByEmail(tx, "x@y.com"); found || err != nil {
+			t.Errorf("GetRecipientByEmail() of unknown email got %v, %v, want false, nil", found, err)
+		}
+
+		if err := repo.UpdateRecipient(tx, Recipient{ID: id, Email: "c@d.com"}); err != nil {
+			t.Errorf("UpdateRecipient() error got %q, want nil", err)
+		}
+		if r, found, _ := repo.GetRecipientByEmail(tx, "c@d.com"); !found || r.ID != id {
+			t.Errorf("GetRecipientByEmail() of updated email got %v, %v, want recipient #%d", r, found, id)
+		}
+	})
+
+	expectErrorInTx(t, repo, "InsertRecipient() of duplicate email", func(tx *sql.Tx) error {
+		_, err := repo.InsertRecipient(tx, Recipient{Email: "c@d.com"})
+		return err
+	})
+}
+
+func testRepositoryListRecipients(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+
+		id, err := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), attribs: map[string]string{"a": "b"}, lastModified: now})
+		if err != nil {
+			t.Fatalf("InsertListRecipient() error got %q, want nil", err)
+		}
+
+		lr, err := repo.GetListRecipient(tx, id)
+		expected := ListRecipient{id: id, listID: "l", recipientID: recipientID, status: RecipientStatuses.Get("new"),
+			attribs: map[string]string{"a": "b"}, lastModified: now}
+		if err != nil || !listRecipientEquals(lr, expected) {
+			t.Errorf("GetListRecipient() got %v, %v, want %v", lr, err, expected)
+		}
+
+		lr.status = RecipientStatuses.Get("failed")
+		lr.statusReason = "timeout"
+		lr.retryStatus = RecipientStatuses.Get("new")
+		lr.attempts = 1
+		lr.nextAttempt = now.Add(time.Minute)
+		lr.lastModified = now.Add(time.Second)
+		lr.attribs = map[string]string{"c": "d"}
+		if err := repo.UpdateListRecipient(tx, lr); err != nil {
+			t.Errorf("UpdateListRecipient() error got %q, want nil", err)
+		}
+
+		updated, found, err := repo.GetListRecipientByEmailAndListID(tx, "a@b.com", "l")
+		if !found || err != nil || !listRecipientEquals(updated, lr) {
+			t.Errorf("GetListRecipientByEmailAndListID() got %v, %v, %v, want %v", updated, found, err, lr)
+		}
+
+		if _, found, err := repo.GetListRecipientByEmailAndListID(tx, "a@b.com", "m"); found || err != nil {
+			t.Errorf("GetListRecipientByEmailAndListID() of unknown list got %v, %v, want false, nil", found, err)
+		}
+		if _, err := repo.GetListRecipient(tx, id+1000); err == nil {
+			t.Errorf("GetListRecipient() of unknown ID error got nil, want error")
+		}
+	})
+
+	expectErrorInTx(t, repo, "InsertListRecipient() of duplicate list and recipient", func(tx *sql.Tx) error {
+		r, _, _ := repo.GetRecipientByEmail(tx, "a@b.com")
+		_, err := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: r.ID,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+		return err
+	})
+
+	expectErrorInTx(t, repo, "InsertListRecipient() of unknown recipient", func(tx *sql.Tx) error {
+		_, err := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: 1000,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+		return err
+	})
+}
+
+func testRepositoryRecipientData(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		var ids []int
+		for _, email := range []string{"c@d.com", "a@b.com", "e@f.com"} {
+			recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: email})
+			id, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+				status: RecipientStatuses.Get("new"), attribs: map[string]string{"a": email}, lastModified: now})
+			ids = append(ids, id)
+		}
+
+		lr, _ := repo.GetListRecipient(tx, ids[1])
+		lr.status = RecipientStatuses.Get("failed")
+		lr.retryStatus = RecipientStatuses.Get("unsubscribing")
+		lr.nextAttempt = now.Add(time.Minute)
+		repo.UpdateListRecipient(tx, lr)
+
+		lr, _ = repo.GetListRecipient(tx, ids[2])
+		lr.status = RecipientStatuses.Get("subscribed")
+		repo.UpdateListRecipient(tx, lr)
+
+		data, err := repo.GetRecipientDataByStatus(tx, []RecipientStatus{RecipientStatuses.Get("new"),
+			RecipientStatuses.Get("subscribed")}, Page{})
+		if err != nil || len(data) != 2 || data[0].email != "c@d.com" || data[1].email != "e@f.com" ||
+			data[1].status != RecipientStatuses.Get("subscribed") || data[0].listID != "l" ||
+			!reflect.DeepEqual(data[0].attribs, map[string]string{"a": "c@d.com"}) || !data[0].lastModified.Equal(now) {
+			t.Errorf("GetRecipientDataByStatus() got %v, %v, want new c@d.com and subscribed e@f.com", data, err)
+		}
+
+		if data, err := repo.GetRecipientDataForRetry(tx, now.Add(59*time.Second), Page{}); err != nil || len(data) != 0 {
+			t.Errorf("GetRecipientDataForRetry() before next attempt got %v, %v, want none", data, err)
+		}
+		data, err = repo.GetRecipientDataForRetry(tx, now.Add(time.Minute).UTC(), Page{})
+		if err != nil || len(data) != 1 || data[0].listRecipientID != ids[1] ||
+			data[0].status != RecipientStatuses.Get("unsubscribing") {
+			t.Errorf("GetRecipientDataForRetry() got %v, %v, want unsubscribing a@b.com", data, err)
+		}
+
+		data, err = repo.GetRecipientDataByListID(tx, "l", Page{afterID: ids[0], limit: 1})
+		if err != nil || len(data) != 1 || data[0].email != "a@b.com" ||
+			!reflect.DeepEqual(data[0].attribs, map[string]string{"a": "a@b.com"}) {
+			t.Errorf("GetRecipientDataByListID() got %v, %v, want a@b.com", data, err)
+		}
+		if data, err := repo.GetRecipientDataByListID(tx, "m", Page{}); err != nil || len(data) != 0 {
+			t.Errorf("GetRecipientDataByListID() of unknown list got %v, %v, want none", data, err)
+		}
+
+		// more emails than fit in one query
+		emails := []string{"e@f.com"}
+		for i := 0; i < 1000; i++ {
+			emails = append(emails, fmt.Sprintf("%d@y.com", i))
+		}
+		data, err = repo.GetRecipientDataByEmails(tx, "l", append(emails, "c@d.com"))
+		if err != nil || len(data) != 2 || data[0].email != "c@d.com" || data[1].email != "e@f.com" {
+			t.Errorf("GetRecipientDataByEmails() got %v, %v, want c@d.com, e@f.com", data, err)
+		}
+		if data, err := repo.GetRecipientDataByEmails(tx, "m", []string{"c@d.com"}); err != nil || len(data) != 0 {
+			t.Errorf("GetRecipientDataByEmails() of unknown list got %v, %v, want none", data, err)
+		}
+	})
+}
+
+func testRepositoryDeliveries(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		listRecipientID, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+			status: RecipientStatuses.Get("subscribed"), lastModified: now})
+
+		id, err := repo.InsertListRecipientDelivery(tx, ListRecipientDelivery{listRecipientID: listRecipientID,
+			destination: "crm", status: RecipientStatuses.Get("new"), lastModified: now})
+		if err != nil {
+			t.Fatalf("InsertListRecipientDelivery() error got %q, want nil", err)
+		}
+		otherID, _ := repo.InsertListRecipientDelivery(tx, ListRecipientDelivery{listRecipientID: listRecipientID,
+			destination: "slack", status: RecipientStatuses.Get("subscribed"), lastModified: now})
+
+		d, err := repo.GetListRecipientDelivery(tx, id)
+		expected := ListRecipientDelivery{id: id, listRecipientID: listRecipientID, destination: "crm",
+			status: RecipientStatuses.Get("new"), lastModified: now}
+		if err != nil || !deliveryEquals(d, expected) {
+			t.Errorf("GetListRecipientDelivery() got %v, %v, want %v", d, err, expected)
+		}
+
+		d.status = RecipientStatuses.Get("failed")
+		d.statusReason = "timeout"
+		d.retryStatus = RecipientStatuses.Get("new")
+		d.attempts = 2
+		d.nextAttempt = now
+		if err := repo.UpdateListRecipientDelivery(tx, d); err != nil {
+			t.Errorf("UpdateListRecipientDelivery() error got %q, want nil", err)
+		}
+
+		updated, found, err := repo.GetListRecipientDeliveryByDestination(tx, listRecipientID, "crm")
+		if !found || err != nil || !deliveryEquals(updated, d) {
+			t.Errorf("GetListRecipientDeliveryByDestination() got %v, %v, %v, want %v", updated, found, err, d)
+		}
+		if _, found, err := repo.GetListRecipientDeliveryByDestination(tx, listRecipientID, "x"); found || err != nil {
+			t.Errorf("GetListRecipientDeliveryByDestination() of unknown destination got %v, %v, want false, nil",
+				found, err)
+		}
+		if _, err := repo.GetListRecipientDelivery(tx, id+1000); err == nil {
+			t.Errorf("GetListRecipientDelivery() of unknown ID error got nil, want error")
+		}
+
+		data, err := repo.GetDeliveryDataByStatus(tx, []RecipientStatus{RecipientStatuses.Get("subscribed")}, Page{})
+		if err != nil || len(data) != 1 || data[0].deliveryID != otherID || data[0].destination != "slack" ||
+			data[0].listRecipientID != listRecipientID || data[0].email != "a@b.com" {
+			t.Errorf("GetDeliveryDataByStatus() got %v, %v, want delivery to slack", data, err)
+		}
+
+		data, err = repo.GetDeliveryDataForRetry(tx, now, Page{})
+		if err != nil || len(data) != 1 || data[0].deliveryID != id || data[0].status != RecipientStatuses.Get("new") {
+			t.Errorf("GetDeliveryDataForRetry() got %v, %v, want new delivery to crm", data, err)
+		}
+	})
+
+	expectErrorInTx(t, repo, "InsertListRecipientDelivery() of duplicate destination", func(tx *sql.Tx) error {
+		lr, _, _ := repo.GetListRecipientByEmailAndListID(tx, "a@b.com", "l")
+		_, err := repo.InsertListRecipientDelivery(tx, ListRecipientDelivery{listRecipientID: lr.id,
+			destination: "crm", status: RecipientStatuses.Get("new"), lastModified: now})
+		return err
+	})
+}
+
+func testRepositoryEventsAndRejectedMessages(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		listRecipientID, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+
+		first, err := repo.InsertListRecipientEvent(tx, ListRecipientEvent{listRecipientID: listRecipientID,
+			created: now, toStatus: RecipientStatuses.Get("new"), sourceMessage: "{}"})
+		if err != nil {
+			t.Errorf("InsertListRecipientEvent() error got %q, want nil", err)
+		}
+		second, err := repo.InsertListRecipientEvent(tx, ListRecipientEvent{listRecipientID: listRecipientID,
+			destination: "crm", created: now, fromStatus: RecipientStatuses.Get("new"),
+			toStatus: RecipientStatuses.Get("failed"), httpStatus: 500, detail: "error"})
+		if err != nil || second == first {
+			t.Errorf("InsertListRecipientEvent() got %v, %v, want new ID", second, err)
+		}
+
+		if _, err := repo.InsertRejectedMessage(tx, RejectedMessage{created: now, text: "x", reason: "y"}); err != nil {
+			t.Errorf("InsertRejectedMessage() error got %q, want nil", err)
+		}
+	})
+
+	expectErrorInTx(t, repo, "InsertListRecipientEvent() of unknown list recipient", func(tx *sql.Tx) error {
+		_, err := repo.InsertListRecipientEvent(tx, ListRecipientEvent{listRecipientID: 1000, created: now,
+			toStatus: RecipientStatuses.Get("new")})
+		return err
+	})
+}
+
+func testRepositoryBatches(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		lrID, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+
+		id, err := repo.InsertBatch(tx, Batch{listID: "l", remoteID: "abc", created: now})
+		if err != nil {
+			t.Fatalf("InsertBatch() error got %q, want nil", err)
+		}
+		otherID, _ := repo.InsertBatch(tx, Batch{listID: "m", remoteID: "def", created: now})
+
+		batches, err := repo.GetBatches(tx)
+		if err != nil || len(batches) != 2 || batches[0].id != id || batches[0].listID != "l" ||
+			batches[0].remoteID != "abc" || !batches[0].created.Equal(now) || batches[1].id != otherID {
+			t.Errorf("GetBatches() got %v, %v, want batches #%d and #%d", batches, err, id, otherID)
+		}
+
+		lr, _ := repo.GetListRecipient(tx, lrID)
+		lr.batchID = id
+		if err := repo.UpdateListRecipient(tx, lr); err != nil {
+			t.Errorf("UpdateListRecipient() error got %q, want nil", err)
+		}
+		if lr, _ := repo.GetListRecipient(tx, lrID); lr.batchID != id {
+			t.Errorf("GetListRecipient() batch got #%d, want #%d", lr.batchID, id)
+		}
+
+		data, err := repo.GetRecipientDataByStatus(tx, []RecipientStatus{RecipientStatuses.Get("new")}, Page{})
+		if err != nil || len(data) != 0 {
+			t.Errorf("GetRecipientDataByStatus() of list recipient in batch got %v, %v, want none", data, err)
+		}
+
+		if err := repo.DeleteBatch(tx, id); err != nil {
+			t.Errorf("DeleteBatch() error got %q, want nil", err)
+		}
+		if lr, _ := repo.GetListRecipient(tx, lrID); lr.batchID != 0 {
+			t.Errorf("GetListRecipient() batch after DeleteBatch() got #%d, want none", lr.batchID)
+		}
+		if batches, err := repo.GetBatches(tx); err != nil || len(batches) != 1 || batches[0].id != otherID {
+			t.Errorf("GetBatches() after DeleteBatch() got %v, %v, want batch #%d", batches, err, otherID)
+		}
+	})
+
+	expectErrorInTx(t, repo, "UpdateListRecipient() of unknown batch", func(tx *sql.Tx) error {
+		lr, _, _ := repo.GetListRecipientByEmailAndListID(tx, "a@b.com", "l")
+		lr.batchID = 1000
+		return repo.UpdateListRecipient(tx, lr)
+	})
+}
+
+func testRepositoryTaskRuns(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		if _, found, err := repo.GetTaskLastRun(tx, "a"); found || err != nil {
+			t.Errorf("GetTaskLastRun() of task never run got %v, %v, want false, nil", found, err)
+		}
+
+		if err := repo.SetTaskLastRun(tx, "a", now); err != nil {
+			t.Errorf("SetTaskLastRun() error got %q, want nil", err)
+		}
+		if lastRun, found, err := repo.GetTaskLastRun(tx, "a"); !found || err != nil || !lastRun.Equal(now) {
+			t.Errorf("GetTaskLastRun() got %v, %v, %v, want %v", lastRun, found, err, now)
+		}
+
+		later := now.Add(time.Hour)
+		if err := repo.SetTaskLastRun(tx, "a", later); err != nil {
+			t.Errorf("SetTaskLastRun() again error got %q, want nil", err)
+		}
+		if lastRun, _, _ := repo.GetTaskLastRun(tx, "a"); !lastRun.Equal(later) {
+			t.Errorf("GetTaskLastRun() after SetTaskLastRun() again got %v, want %v", lastRun, later)
+		}
+		if _, found, _ := repo.GetTaskLastRun(tx, "b"); found {
+			t.Errorf("GetTaskLastRun() of other task got found, want not found")
+		}
+	})
+}
+
+func testRepositoryPages(t *testing.T, repo Repository, now time.Time) {
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		var ids, deliveryIDs []int
+		for _, email := range []string{"a@b.com", "c@d.com", "e@f.com"} {
+			recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: email})
+			id, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+				status: RecipientStatuses.Get("new"), lastModified: now})
+			lr, _ := repo.GetListRecipient(tx, id)
+			lr.status = RecipientStatuses.Get("failed")
+			lr.retryStatus = RecipientStatuses.Get("new")
+			lr.nextAttempt = now
+			repo.UpdateListRecipient(tx, lr)
+			deliveryID, _ := repo.InsertListRecipientDelivery(tx, ListRecipientDelivery{listRecipientID: id,
+				destination: "crm", status: RecipientStatuses.Get("new"), lastModified: now})
+			ids = append(ids, id)
+			deliveryIDs = append(deliveryIDs, deliveryID)
+		}
+		statuses := []RecipientStatus{RecipientStatuses.Get("failed")}
+		lease := Lease{owner: "a", expiry: now.Add(time.Minute)}
+
+		data, err := repo.GetRecipientDataByStatus(tx, statuses, Page{limit: 2})
+		if err != nil || len(data) != 2 || data[0].listRecipientID != ids[0] || data[1].listRecipientID != ids[1] {
+			t.Errorf("GetRecipientDataByStatus() of first page got %v, %v, want list recipients #%d, #%d", data, err,
+				ids[0], ids[1])
+		}
+		data, err = repo.GetRecipientDataByStatus(tx, statuses, Page{afterID: ids[1], limit: 2})
+		if err != nil || len(data) != 1 || data[0].listRecipientID != ids[2] {
+			t.Errorf("GetRecipientDataByStatus() of last page got %v, %v, want list recipient #%d", data, err, ids[2])
+		}
+		data, err = repo.GetRecipientDataForRetry(tx, now, Page{afterID: ids[0], limit: 1})
+		if err != nil || len(data) != 1 || data[0].listRecipientID != ids[1] {
+			t.Errorf("GetRecipientDataForRetry() of page got %v, %v, want list recipient #%d", data, err, ids[1])
+		}
+		data, err = repo.GetDeliveryDataByStatus(tx, []RecipientStatus{RecipientStatuses.Get("new")},
+			Page{afterID: deliveryIDs[0], limit: 1})
+		if err != nil || len(data) != 1 || data[0].deliveryID != deliveryIDs[1] {
+			t.Errorf("GetDeliveryDataByStatus() of page got %v, %v, want delivery #%d", data, err, deliveryIDs[1])
+		}
+
+		data, err = repo.ClaimRecipientDataForRetry(tx, now, lease, Page{afterID: ids[0], limit: 1})
+		if err != nil || len(data) != 1 || data[0].listRecipientID != ids[1] {
+			t.Errorf("ClaimRecipientDataForRetry() of page got %v, %v, want list recipient #%d", data, err, ids[1])
+		}
+		if lr, _ := repo.GetListRecipient(tx, ids[2]); lr.leaseOwner != "" {
+			t.Errorf("list recipient #%d after claiming page got lease of %q, want none", ids[2], lr.leaseOwner)
+		}
+		data, err = repo.ClaimRecipientDataByStatus(tx, statuses, now, lease, Page{limit: 5})
+		if err != nil || len(data) != 2 || data[0].listRecipientID != ids[0] || data[1].listRecipientID != ids[2] {
+			t.Errorf("ClaimRecipientDataByStatus() of page got %v, %v, want list recipients #%d, #%d", data, err,
+				ids[0], ids[2])
+		}
+	})
+}
+
+func testRepositoryLeases(t *testing.T, repo Repository, now time.Time) {
+	statuses := []RecipientStatus{RecipientStatuses.Get("new")}
+	lease := Lease{owner: "a", expiry: now.Add(time.Minute)}
+	otherLease := Lease{owner: "b", expiry: now.Add(2 * time.Minute)}
+
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		newID, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+		failedID, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "m", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+		failed, _ := repo.GetListRecipient(tx, failedID)
+		failed.status = RecipientStatuses.Get("failed")
+		failed.retryStatus = RecipientStatuses.Get("new")
+		failed.nextAttempt = now
+		repo.UpdateListRecipient(tx, failed)
+		deliveryID, _ := repo.InsertListRecipientDelivery(tx, ListRecipientDelivery{listRecipientID: newID,
+			destination: "crm", status: RecipientStatuses.Get("new"), lastModified: now})
+
+		data, err := repo.ClaimRecipientDataByStatus(tx, statuses, now, lease, Page{})
+		if err != nil || len(data) != 1 || data[0].listRecipientID != newID {
+			t.Errorf("ClaimRecipientDataByStatus() got %v, %v, want list recipient #%d", data, err, newID)
+		}
+		data, err = repo.ClaimRecipientDataForRetry(tx, now, lease, Page{})
+		if err != nil || len(data) != 1 || data[0].listRecipientID != failedID {
+			t.Errorf("ClaimRecipientDataForRetry() got %v, %v, want list recipient #%d", data, err, failedID)
+		}
+		data, err = repo.ClaimDeliveryDataByStatus(tx, statuses, now, lease, Page{})
+		if err != nil || len(data) != 1 || data[0].deliveryID != deliveryID {
+			t.Errorf("ClaimDeliveryDataByStatus() got %v, %v, want delivery #%d", data, err, deliveryID)
+		}
+
+		if lr, _ := repo.GetListRecipient(tx, newID); lr.leaseOwner != "a" || !lr.leaseExpiry.Equal(lease.expiry) {
+			t.Errorf("GetListRecipient() lease got %q until %v, want %q until %v", lr.leaseOwner, lr.leaseExpiry,
+				lease.owner, lease.expiry)
+		}
+		if d, _ := repo.GetListRecipientDelivery(tx, deliveryID); d.leaseOwner != "a" ||
+			!d.leaseExpiry.Equal(lease.expiry) {
+			t.Errorf("GetListRecipientDelivery() lease got %q until %v, want %q until %v", d.leaseOwner,
+				d.leaseExpiry, lease.owner, lease.expiry)
+		}
+
+		if data, err := repo.ClaimRecipientDataByStatus(tx, statuses, now, otherLease, Page{}); err != nil || len(data) != 0 {
+			t.Errorf("ClaimRecipientDataByStatus() of leased list recipient got %v, %v, want none", data, err)
+		}
+		if data, err := repo.ClaimRecipientDataForRetry(tx, now, otherLease, Page{}); err != nil || len(data) != 0 {
+			t.Errorf("ClaimRecipientDataForRetry() of leased list recipient got %v, %v, want none", data, err)
+		}
+		if data, err := repo.ClaimDeliveryDataByStatus(tx, statuses, now, otherLease, Page{}); err != nil || len(data) != 0 {
+			t.Errorf("ClaimDeliveryDataByStatus() of leased delivery got %v, %v, want none", data, err)
+		}
+
+		later := lease.expiry
+		if data, err := repo.ClaimRecipientDataByStatus(tx, statuses, later, otherLease, Page{}); err != nil ||
+			len(data) != 1 {
+			t.Errorf("ClaimRecipientDataByStatus() of expired lease got %v, %v, want list recipient #%d", data, err,
+				newID)
+		}
+		if lr, _ := repo.GetListRecipient(tx, newID); lr.leaseOwner != "b" {
+			t.Errorf("GetListRecipient() lease owner after expiry got %q, want %q", lr.leaseOwner, "b")
+		}
+
+		lr, _ := repo.GetListRecipient(tx, newID)
+		lr.leaseOwner = ""
+		lr.leaseExpiry = time.Time{}
+		repo.UpdateListRecipient(tx, lr)
+		if lr, _ := repo.GetListRecipient(tx, newID); lr.leaseOwner != "" || !lr.leaseExpiry.IsZero() {
+			t.Errorf("GetListRecipient() lease after update got %q until %v, want none", lr.leaseOwner,
+				lr.leaseExpiry)
+		}
+
+		if err := repo.ReleaseLeases(tx, "a"); err != nil {
+			t.Errorf("ReleaseLeases() error got %q, want nil", err)
+		}
+		if lr, _ := repo.GetListRecipient(tx, failedID); lr.leaseOwner != "" || !lr.leaseExpiry.IsZero() {
+			t.Errorf("GetListRecipient() lease after ReleaseLeases() got %q until %v, want none", lr.leaseOwner,
+				lr.leaseExpiry)
+		}
+		if data, err := repo.ClaimDeliveryDataByStatus(tx, statuses, now, otherLease, Page{}); err != nil || len(data) != 1 {
+			t.Errorf("ClaimDeliveryDataByStatus() after ReleaseLeases() got %v, %v, want delivery #%d", data, err,
+				deliveryID)
+		}
+	})
+}
+
+func testRepositoryDoInTx(t *testing.T, repo Repository, now time.Time) {
+	errRollback := errors.New("rollback")
+
+	err := repo.DoInTx(func(tx *sql.Tx) error {
+		repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		return errRollback
+	})
+	if err != errRollback {
+		t.Errorf("DoInTx() error got %q, want %q", err, errRollback)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "panic" {
+				t.Errorf("DoInTx() panic got %v, want panic", p)
+			}
+		}()
+		repo.DoInTx(func(tx *sql.Tx) error {
+			repo.InsertRecipient(tx, Recipient{Email: "c@d.com"})
+			panic("panic")
+		})
+	}()
+
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		repo.InsertRecipient(tx, Recipient{Email: "e@f.com"})
+	})
+
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		for email, expected := range map[string]bool{"a@b.com": false, "c@d.com": false, "e@f.com": true} {
+			if _, found, err := repo.GetRecipientByEmail(tx, email); found != expected || err != nil {
+				t.Errorf("GetRecipientByEmail(%v) got %v, %v, want %v, nil", email, found, err, expected)
+			}
+		}
+	})
+}
+
+// expectErrorInTx expects the action to fail, returning its error so that DoInTx rolls back, since some databases
+// can't commit a transaction after an error.
+func expectErrorInTx(t *testing.T, repo Repository, label string, action func(tx *sql.Tx) error) {
+	if err := repo.DoInTx(action); err == nil {
+		t.Errorf("%v error got nil, want error", label)
+	}
+}
+
+func mustDoInTx(t *testing.T, repo Repository, action func(tx *sql.Tx)) {
+	err := repo.DoInTx(func(tx *sql.Tx) error {
+		action(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DoInTx() error got %q, want nil", err)
+	}
+}
+
+// listRecipientEquals compares list recipients with their times as instants, since they may be read in another zone.
+func listRecipientEquals(a ListRecipient, b ListRecipient) bool {
+	if !a.lastModified.Equal(b.lastModified) || !a.nextAttempt.Equal(b.nextAttempt) ||
+		!a.leaseExpiry.Equal(b.leaseExpiry) {
+		return false
+	}
+	a.lastModified, a.nextAttempt, a.leaseExpiry = time.Time{}, time.Time{}, time.Time{}
+	b.lastModified, b.nextAttempt, b.leaseExpiry = time.Time{}, time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+func deliveryEquals(a ListRecipientDelivery, b ListRecipientDelivery) bool {
+	if !a.lastModified.Equal(b.lastModified) || !a.nextAttempt.Equal(b.nextAttempt) ||
+		!a.leaseExpiry.Equal(b.leaseExpiry) {
+		return false
+	}
+	a.lastModified, a.nextAttempt, a.leaseExpiry = time.Time{}, time.Time{}, time.Time{}
+	b.lastModified, b.nextAttempt, b.leaseExpiry = time.Time{}, time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
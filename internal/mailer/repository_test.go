@@ -1,14 +1,266 @@
 package mailer
 
-import "testing"
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
 
-func TestToStatusInFragment(t *testing.T) {
-	str := toStatusInFragment([]RecipientStatus{
+	"github.com/hdpe/mailsling/internal/mailer/schema"
+)
+
+func TestToStatusInClause(t *testing.T) {
+	clause, args := toStatusInClause("lr.status", []RecipientStatus{
 		RecipientStatuses.Get("new"),
 		RecipientStatuses.Get("unsubscribing"),
 	})
 
-	if expected := "status in ('new', 'unsubscribing')"; str != expected {
-		t.Errorf("got %q, want %q", str, expected)
+	if expected := "lr.status in (?, ?)"; clause != expected {
+		t.Errorf("clause got %q, want %q", clause, expected)
+	}
+	expectedArgs := []interface{}{RecipientStatuses.Get("new"), RecipientStatuses.Get("unsubscribing")}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("args got %v, want %v", args, expectedArgs)
+	}
+}
+
+// TestDBRepository tests the repository in an in-memory SQLite database, and in the database of MAILER_TEST_DB_DSN
+// if set, e.g. that of start-test-mysql-server.sh, which is emptied before each test.
+func TestDBRepository(t *testing.T) {
+	t.Run("SQLite", func(t *testing.T) {
+		testRepositoryConformance(t, func(t *testing.T) Repository {
+			repo, err := NewRepository("sqlite://:memory:", true)
+			if err != nil {
+				t.Fatalf("NewRepository() error got %q, want nil", err)
+			}
+			return repo
+		})
+	})
+
+	dsn := os.Getenv("MAILER_TEST_DB_DSN")
+	if dsn == "" {
+		return
+	}
+
+	t.Run("MAILER_TEST_DB_DSN", func(t *testing.T) {
+		testRepositoryConformance(t, func(t *testing.T) Repository {
+			repo, err := NewRepository(dsn, true)
+			if err != nil {
+				t.Fatalf("NewRepository() error got %q, want nil", err)
+			}
+			for _, table := range []string{"list_recipient_deliveries", "list_recipient_events",
+				"list_recipient_attributes", "list_recipients", "batches", "recipients", "rejected_messages",
+				"task_runs"} {
+				if _, err := repo.Db.Exec("delete from " + table); err != nil {
+					t.Fatalf("couldn't empty %s: %v", table, err)
+				}
+			}
+			return repo
+		})
+	})
+}
+
+// TestDBRepository_TryLock tests locks in an SQLite database file, and in the database of MAILER_TEST_DB_DSN if
+// set.
+func TestDBRepository_TryLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailsling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("SQLite", func(t *testing.T) {
+		testTryLock(t, "sqlite://"+filepath.Join(dir, "test.db"), true)
+	})
+
+	if dsn := os.Getenv("MAILER_TEST_DB_DSN"); dsn != "" {
+		t.Run("MAILER_TEST_DB_DSN", func(t *testing.T) {
+			d, _ := parseDSN(dsn)
+			testTryLock(t, dsn, d.name() == "sqlite3")
+		})
+	}
+}
+
+// testTryLock tests that a lock can't be taken by another repository until released, or, if locks expire, until
+// it expires.
+func testTryLock(t *testing.T, dsn string, expires bool) {
+	repo, err := NewRepository(dsn, true)
+	if err != nil {
+		t.Fatalf("NewRepository() error got %q, want nil", err)
+	}
+	defer repo.Close()
+	other, err := NewRepository(dsn, true)
+	if err != nil {
+		t.Fatalf("NewRepository() error got %q, want nil", err)
+	}
+	defer other.Close()
+
+	unlock, locked, err := repo.TryLock("test", time.Hour)
+	if !locked || err != nil {
+		t.Fatalf("TryLock() got %v, %v, want true, nil", locked, err)
+	}
+	if _, locked, err := other.TryLock("test", time.Hour); locked || err != nil {
+		t.Errorf("TryLock() of held lock got %v, %v, want false, nil", locked, err)
+	}
+	if err := unlock(); err != nil {
+		t.Errorf("unlock() error got %q, want nil", err)
+	}
+
+	unlock, locked, err = other.TryLock("test", 0)
+	if !locked || err != nil {
+		t.Fatalf("TryLock() of released lock got %v, %v, want true, nil", locked, err)
+	}
+	defer unlock()
+
+	if _, locked, _ := repo.TryLock("test", time.Hour); locked != expires {
+		t.Errorf("TryLock() of lock with zero TTL got %v, want %v", locked, expires)
+	}
+}
+
+// TestNewRepository_WithoutMigrations tests that a repository opened without migrating its schema, as for a dry run,
+// fails unless the database has had all the migrations, and doesn't apply any.
+func TestNewRepository_WithoutMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailsling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn := "sqlite://" + filepath.Join(dir, "test.db")
+
+	if _, err := NewRepository(dsn, false); !errorMessageStartsWith(err,
+		"couldn't check migrations: database has had no migrations") {
+		t.Errorf("NewRepository() of new database error got %q, want no migrations", err)
+	}
+
+	repo, err := NewRepository(dsn, true)
+	if err != nil {
+		t.Fatalf("NewRepository() error got %q, want nil", err)
+	}
+	repo.Close()
+
+	repo, err = NewRepository(dsn, false)
+	if err != nil {
+		t.Fatalf("NewRepository() of migrated database error got %q, want nil", err)
+	}
+	if _, err := repo.Db.Exec("update schema_migrations set version = 13"); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	if _, err := NewRepository(dsn, false); !errorMessageStartsWith(err,
+		"couldn't check migrations: database version 13 is behind") {
+		t.Errorf("NewRepository() of database behind error got %q, want behind", err)
+	}
+}
+
+// TestDBRepository_ClaimSkipsRowsLeasedSinceSelected tests that claims skip rows others lease between selecting and
+// leasing them, as they can in MySQL, which doesn't lock the rows it selects.
+func TestDBRepository_ClaimSkipsRowsLeasedSinceSelected(t *testing.T) {
+	repo, err := NewRepository("sqlite://:memory:", true)
+	if err != nil {
+		t.Fatalf("NewRepository() error got %q, want nil", err)
+	}
+	defer repo.Close()
+
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	lease := Lease{owner: "a", expiry: now.Add(time.Minute)}
+
+	mustDoInTx(t, repo, func(tx *sql.Tx) {
+		recipientID, _ := repo.InsertRecipient(tx, Recipient{Email: "a@b.com"})
+		freeID, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "l", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+		leasedID, _ := repo.InsertListRecipient(tx, ListRecipient{listID: "m", recipientID: recipientID,
+			status: RecipientStatuses.Get("new"), lastModified: now})
+		leased, _ := repo.GetListRecipient(tx, leasedID)
+		leased.leaseOwner = "b"
+		leased.leaseExpiry = now.Add(time.Hour)
+		repo.UpdateListRecipient(tx, leased)
+
+		// selects both, as if the second were leased after the select
+		data, err := repo.claimRecipientData(tx, now, lease, `
+			select lr.id, r.id, r.email, lr.list_id, lr.status, lr.last_modified
+			from recipients r
+				inner join list_recipients lr
+					on r.id = lr.recipient_id
+			order by lr.id`)
+
+		if err != nil || len(data) != 1 || data[0].listRecipientID != freeID {
+			t.Errorf("claimRecipientData() got %v, %v, want list recipient #%d", data, err, freeID)
+		}
+		if lr, _ := repo.GetListRecipient(tx, leasedID); lr.leaseOwner != "b" {
+			t.Errorf("GetListRecipient() lease owner of other's list recipient got %q, want %q", lr.leaseOwner, "b")
+		}
+	})
+}
+
+func TestMergeCaseVariantRecipientsMigration(t *testing.T) {
+	repo, err := NewRepository("sqlite://:memory:", true)
+	if err != nil {
+		t.Fatalf("NewRepository() error got %q, want nil", err)
+	}
+	defer repo.Close()
+
+	for _, stmt := range []string{
+		"insert into recipients (id, email) values (1, 'a@b.com'), (2, ' A@B.com'), (3, 'c@d.com')",
+		"insert into list_recipients (id, list_id, recipient_id, status, last_modified) values " +
+			"(1, 'x', 1, 'subscribed', '2020-01-01 00:00:00'), (2, 'x', 2, 'unsubscribed', '2020-02-01 00:00:00'), " +
+			"(3, 'y', 2, 'subscribed', '2020-01-01 00:00:00'), (4, 'x', 3, 'subscribed', '2020-01-01 00:00:00')",
+		`insert into list_recipient_attributes (list_recipient_id, "key", "value") values (1, 'k', 'v1'), (2, 'k', 'v2')`,
+		"insert into list_recipient_deliveries (id, list_recipient_id, destination, status, last_modified) values " +
+			"(1, 1, 'crm', 'subscribed', '2020-01-01 00:00:00'), (2, 1, 'slack', 'subscribed', '2020-01-01 00:00:00'), " +
+			"(3, 2, 'slack', 'unsubscribed', '2020-02-01 00:00:00')",
+		"insert into list_recipient_events (list_recipient_id, created, to_status) values " +
+			"(1, '2020-01-01 00:00:00', 'subscribed'), (2, '2020-02-01 00:00:00', 'unsubscribed')",
+	} {
+		if _, err := repo.Db.Exec(stmt); err != nil {
+			t.Fatalf("couldn't insert fixture: %v", err)
+		}
+	}
+
+	migration, err := schema.Asset("sqlite3/0009_merge_case_variant_recipients.up.sql")
+	if err != nil {
+		t.Fatalf("couldn't read migration: %v", err)
+	}
+	if _, err := repo.Db.Exec(string(migration)); err != nil {
+		t.Fatalf("migration error got %q, want nil", err)
+	}
+
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"select id || ' ' || email from recipients order by id", []string{"1 a@b.com", "3 c@d.com"}},
+		{"select id || ' ' || list_id || ' ' || recipient_id || ' ' || status from list_recipients order by id",
+			[]string{"2 x 1 unsubscribed", "3 y 1 subscribed", "4 x 3 subscribed"}},
+		{`select list_recipient_id || ' ' || "value" from list_recipient_attributes order by list_recipient_id`,
+			[]string{"2 v2"}},
+		{"select id || ' ' || list_recipient_id || ' ' || destination || ' ' || status from list_recipient_deliveries " +
+			"order by id", []string{"1 2 crm subscribed", "3 2 slack unsubscribed"}},
+		{"select list_recipient_id || ' ' || to_status from list_recipient_events order by id",
+			[]string{"2 subscribed", "2 unsubscribed"}},
+	}
+
+	for _, tc := range testCases {
+		rows, err := repo.Db.Query(tc.query)
+		if err != nil {
+			t.Fatalf("couldn't query %q: %v", tc.query, err)
+		}
+		var actual []string
+		for rows.Next() {
+			var row string
+			if err := rows.Scan(&row); err != nil {
+				t.Fatalf("couldn't scan %q: %v", tc.query, err)
+			}
+			actual = append(actual, row)
+		}
+		rows.Close()
+
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%q got %v, want %v", tc.query, actual, tc.expected)
+		}
 	}
 }
@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// runLockName is the name of the lock held by a mailer while it polls and processes.
+const runLockName = "mailsling"
+
+// RunLocker locks the runs of mailers sharing it, so that they don't overlap.
+type RunLocker interface {
+	// TryLock takes the named lock, unless another holds it, getting a function to release it. If the lock isn't
+	// released when its holder exits, e.g. because it crashed, it expires after the TTL.
+	TryLock(name string, ttl time.Duration) (unlock func() error, locked bool, err error)
+}
+
+// TryLock takes the named lock with the dialect's named locks, or a row of the run_locks table where there are none.
+// Named locks are held by a connection of their own, so are released if the process exits, whatever the TTL.
+func (r *DBRepository) TryLock(name string, ttl time.Duration) (func() error, bool, error) {
+	return r.dialect.tryLock(r.Db, name, newOwnerID(), time.Now(), ttl)
+}
+
+// trySessionLock takes a lock held by a connection of its own with the lock query, which selects 1 or true if the
+// lock was taken, getting a function to release it with the unlock query, then close the connection.
+func trySessionLock(db *sql.DB, lockQuery string, unlockQuery string, args ...interface{}) (func() error, bool,
+	error) {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't get connection: %v", err)
+	}
+
+	var locked sql.NullBool
+	err = conn.QueryRowContext(ctx, lockQuery, args...).Scan(&locked)
+	if err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("couldn't take lock: %v", err)
+	}
+	if !locked.Bool {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	return func() error {
+		defer conn.Close()
+
+		var unlocked sql.NullBool
+		err := conn.QueryRowContext(ctx, unlockQuery, args...).Scan(&unlocked)
+		if err != nil {
+			return fmt.Errorf("couldn't release lock: %v", err)
+		}
+		return nil
+	}, true, nil
+}
+
+func (mysqlDialect) tryLock(db *sql.DB, name string, owner string, now time.Time, ttl time.Duration) (func() error,
+	bool, error) {
+	return trySessionLock(db, "select get_lock(?, 0)", "select release_lock(?)", name)
+}
+
+// tryLock takes an advisory lock, the key of which is a hash of the name, since they're identified by numbers.
+func (postgresDialect) tryLock(db *sql.DB, name string, owner string, now time.Time, ttl time.Duration) (
+	func() error, bool, error) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	key := int64(h.Sum64())
+
+	return trySessionLock(db, "select pg_try_advisory_lock($1)", "select pg_advisory_unlock($1)", key)
+}
+
+// tryLock inserts a row for the lock, replacing any that has expired. It's deleted to release the lock.
+func (d sqliteDialect) tryLock(db *sql.DB, name string, owner string, now time.Time, ttl time.Duration) (
+	func() error, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't begin tx: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec("delete from run_locks where name = ? and expiry <= ?", d.bind([]interface{}{name, now})...)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't delete expired lock: %v", err)
+	}
+
+	res, err := tx.Exec("insert or ignore into run_locks (name, owner, expiry) values (?, ?, ?)",
+		d.bind([]interface{}{name, owner, now.Add(ttl)})...)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't insert lock: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't get inserted rows: %v", err)
+	} else if n == 0 {
+		return nil, false, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't commit tx: %v", err)
+	}
+
+	return func() error {
+		_, err := db.Exec("delete from run_locks where name = ? and owner = ?", name, owner)
+		if err != nil {
+			return fmt.Errorf("couldn't delete lock: %v", err)
+		}
+		return nil
+	}, true, nil
+}
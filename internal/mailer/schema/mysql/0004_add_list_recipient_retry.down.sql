@@ -0,0 +1,4 @@
+ALTER TABLE list_recipients
+  DROP COLUMN retry_status,
+  DROP COLUMN attempts,
+  DROP COLUMN next_attempt;
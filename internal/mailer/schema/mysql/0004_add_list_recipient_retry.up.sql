@@ -0,0 +1,4 @@
+ALTER TABLE list_recipients
+  ADD COLUMN retry_status VARCHAR(32) NULL,
+  ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN next_attempt TIMESTAMP NULL;
@@ -0,0 +1 @@
+ALTER TABLE list_recipients DROP COLUMN status_reason;
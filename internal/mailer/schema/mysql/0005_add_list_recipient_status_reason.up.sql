@@ -0,0 +1 @@
+ALTER TABLE list_recipients ADD COLUMN status_reason VARCHAR(1024) NULL;
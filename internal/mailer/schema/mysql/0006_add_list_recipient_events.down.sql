@@ -0,0 +1 @@
+DROP TABLE list_recipient_events;
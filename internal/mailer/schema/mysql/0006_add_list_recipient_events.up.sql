@@ -0,0 +1,11 @@
+CREATE TABLE list_recipient_events (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  list_recipient_id INTEGER NOT NULL,
+  created TIMESTAMP NOT NULL,
+  from_status VARCHAR(32) NULL,
+  to_status VARCHAR(32) NOT NULL,
+  source_message TEXT NULL,
+  http_status INTEGER NULL,
+  detail VARCHAR(4096) NULL,
+  CONSTRAINT fk_list_recipient_events_list_recipient_id FOREIGN KEY (list_recipient_id) REFERENCES list_recipients (id)
+);
@@ -0,0 +1 @@
+DROP TABLE rejected_messages;
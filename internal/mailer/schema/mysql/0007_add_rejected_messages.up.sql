@@ -0,0 +1,6 @@
+CREATE TABLE rejected_messages (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  created TIMESTAMP NOT NULL,
+  message TEXT NOT NULL,
+  reason VARCHAR(1024) NOT NULL
+);
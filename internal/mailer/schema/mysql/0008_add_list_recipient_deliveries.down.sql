@@ -0,0 +1,3 @@
+ALTER TABLE list_recipient_events DROP COLUMN destination;
+
+DROP TABLE list_recipient_deliveries;
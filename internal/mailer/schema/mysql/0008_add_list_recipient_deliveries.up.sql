@@ -0,0 +1,15 @@
+CREATE TABLE list_recipient_deliveries (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  list_recipient_id INTEGER NOT NULL,
+  destination VARCHAR(128) NOT NULL,
+  status VARCHAR(32) NOT NULL,
+  status_reason VARCHAR(1024) NULL,
+  last_modified TIMESTAMP NOT NULL,
+  retry_status VARCHAR(32) NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt TIMESTAMP NULL,
+  UNIQUE KEY uq_list_recipient_deliveries_list_recipient_id_destination (list_recipient_id, destination),
+  CONSTRAINT fk_list_recipient_deliveries_list_recipient_id FOREIGN KEY (list_recipient_id) REFERENCES list_recipients (id)
+);
+
+ALTER TABLE list_recipient_events ADD COLUMN destination VARCHAR(128) NULL;
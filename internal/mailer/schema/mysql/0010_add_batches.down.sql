@@ -0,0 +1,5 @@
+ALTER TABLE list_recipients
+  DROP FOREIGN KEY fk_list_recipients_batch_id,
+  DROP COLUMN batch_id;
+
+DROP TABLE batches;
@@ -0,0 +1,7 @@
+ALTER TABLE list_recipient_deliveries
+  DROP COLUMN lease_owner,
+  DROP COLUMN lease_expiry;
+
+ALTER TABLE list_recipients
+  DROP COLUMN lease_owner,
+  DROP COLUMN lease_expiry;
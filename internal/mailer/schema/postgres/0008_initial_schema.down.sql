@@ -0,0 +1,6 @@
+DROP TABLE list_recipient_deliveries;
+DROP TABLE rejected_messages;
+DROP TABLE list_recipient_events;
+DROP TABLE list_recipient_attributes;
+DROP TABLE list_recipients;
+DROP TABLE recipients;
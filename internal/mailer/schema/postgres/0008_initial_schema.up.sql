@@ -0,0 +1,61 @@
+CREATE TABLE recipients (
+  id SERIAL PRIMARY KEY,
+  email VARCHAR(254) NOT NULL,
+  CONSTRAINT uq_recipients_email UNIQUE (email)
+);
+
+CREATE TABLE list_recipients (
+  id SERIAL PRIMARY KEY,
+  list_id VARCHAR(128) NULL,
+  recipient_id INTEGER NOT NULL,
+  status VARCHAR(32) NOT NULL,
+  status_reason VARCHAR(1024) NULL,
+  last_modified TIMESTAMP WITH TIME ZONE NOT NULL,
+  retry_status VARCHAR(32) NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt TIMESTAMP WITH TIME ZONE NULL,
+  CONSTRAINT uq_list_recipients_list_id_recipient_id UNIQUE (list_id, recipient_id),
+  CONSTRAINT fk_list_recipients_recipient_id FOREIGN KEY (recipient_id) REFERENCES recipients (id)
+);
+
+CREATE TABLE list_recipient_attributes (
+  list_recipient_id INTEGER NOT NULL,
+  "key" VARCHAR(128) NOT NULL,
+  "value" VARCHAR(4096) NOT NULL,
+  PRIMARY KEY (list_recipient_id, "key"),
+  CONSTRAINT fk_list_recipient_attributes_list_recipient_id FOREIGN KEY (list_recipient_id) REFERENCES list_recipients (id)
+);
+
+CREATE TABLE list_recipient_events (
+  id SERIAL PRIMARY KEY,
+  list_recipient_id INTEGER NOT NULL,
+  destination VARCHAR(128) NULL,
+  created TIMESTAMP WITH TIME ZONE NOT NULL,
+  from_status VARCHAR(32) NULL,
+  to_status VARCHAR(32) NOT NULL,
+  source_message TEXT NULL,
+  http_status INTEGER NULL,
+  detail VARCHAR(4096) NULL,
+  CONSTRAINT fk_list_recipient_events_list_recipient_id FOREIGN KEY (list_recipient_id) REFERENCES list_recipients (id)
+);
+
+CREATE TABLE rejected_messages (
+  id SERIAL PRIMARY KEY,
+  created TIMESTAMP WITH TIME ZONE NOT NULL,
+  message TEXT NOT NULL,
+  reason VARCHAR(1024) NOT NULL
+);
+
+CREATE TABLE list_recipient_deliveries (
+  id SERIAL PRIMARY KEY,
+  list_recipient_id INTEGER NOT NULL,
+  destination VARCHAR(128) NOT NULL,
+  status VARCHAR(32) NOT NULL,
+  status_reason VARCHAR(1024) NULL,
+  last_modified TIMESTAMP WITH TIME ZONE NOT NULL,
+  retry_status VARCHAR(32) NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt TIMESTAMP WITH TIME ZONE NULL,
+  CONSTRAINT uq_list_recipient_deliveries_list_recipient_id_destination UNIQUE (list_recipient_id, destination),
+  CONSTRAINT fk_list_recipient_deliveries_list_recipient_id FOREIGN KEY (list_recipient_id) REFERENCES list_recipients (id)
+);
@@ -0,0 +1,2 @@
+-- merged recipients can't be split again
+SELECT 1;
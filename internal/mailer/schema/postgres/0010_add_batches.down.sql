@@ -0,0 +1,3 @@
+ALTER TABLE list_recipients DROP COLUMN batch_id;
+
+DROP TABLE batches;
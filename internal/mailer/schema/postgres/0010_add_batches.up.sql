@@ -0,0 +1,10 @@
+CREATE TABLE batches (
+  id SERIAL PRIMARY KEY,
+  list_id VARCHAR(128) NOT NULL,
+  remote_id VARCHAR(64) NOT NULL,
+  created TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+ALTER TABLE list_recipients
+  ADD COLUMN batch_id INTEGER NULL,
+  ADD CONSTRAINT fk_list_recipients_batch_id FOREIGN KEY (batch_id) REFERENCES batches (id);
@@ -0,0 +1,7 @@
+ALTER TABLE list_recipients
+  ADD COLUMN lease_owner VARCHAR(64) NULL,
+  ADD COLUMN lease_expiry TIMESTAMP WITH TIME ZONE NULL;
+
+ALTER TABLE list_recipient_deliveries
+  ADD COLUMN lease_owner VARCHAR(64) NULL,
+  ADD COLUMN lease_expiry TIMESTAMP WITH TIME ZONE NULL;
@@ -0,0 +1 @@
+DROP INDEX list_recipients_status_idx;
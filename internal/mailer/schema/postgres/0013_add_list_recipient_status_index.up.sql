@@ -0,0 +1 @@
+CREATE INDEX list_recipients_status_idx ON list_recipients (status);
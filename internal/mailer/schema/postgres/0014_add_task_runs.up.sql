@@ -0,0 +1,4 @@
+CREATE TABLE task_runs (
+  name VARCHAR(64) NOT NULL PRIMARY KEY,
+  last_run TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,90 @@
+CREATE TABLE recipient_merges (
+  old_id INTEGER NOT NULL PRIMARY KEY,
+  new_id INTEGER NOT NULL
+);
+
+INSERT INTO recipient_merges (old_id, new_id)
+SELECT r.id, c.id
+FROM recipients r
+INNER JOIN (
+  SELECT LOWER(TRIM(email)) AS email, MIN(id) AS id
+  FROM recipients
+  GROUP BY LOWER(TRIM(email))
+) c ON c.email = LOWER(TRIM(r.email));
+
+CREATE TABLE list_recipient_merges (
+  old_id INTEGER NOT NULL PRIMARY KEY,
+  new_id INTEGER NOT NULL
+);
+
+INSERT INTO list_recipient_merges (old_id, new_id)
+SELECT lr.id, k.id
+FROM list_recipients lr
+INNER JOIN recipient_merges rm ON rm.old_id = lr.recipient_id
+INNER JOIN (
+  SELECT rm.new_id AS recipient_id, COALESCE(lr.list_id, '') AS list_id, MIN(lr.id) AS id
+  FROM list_recipients lr
+  INNER JOIN recipient_merges rm ON rm.old_id = lr.recipient_id
+  INNER JOIN (
+    SELECT rm.new_id AS recipient_id, COALESCE(lr.list_id, '') AS list_id, MAX(lr.last_modified) AS last_modified
+    FROM list_recipients lr
+    INNER JOIN recipient_merges rm ON rm.old_id = lr.recipient_id
+    GROUP BY rm.new_id, COALESCE(lr.list_id, '')
+  ) m ON m.recipient_id = rm.new_id AND m.list_id = COALESCE(lr.list_id, '') AND m.last_modified = lr.last_modified
+  GROUP BY rm.new_id, COALESCE(lr.list_id, '')
+) k ON k.recipient_id = rm.new_id AND k.list_id = COALESCE(lr.list_id, '');
+
+UPDATE list_recipient_events
+SET list_recipient_id = (
+  SELECT new_id FROM list_recipient_merges WHERE old_id = list_recipient_events.list_recipient_id
+)
+WHERE list_recipient_id IN (SELECT old_id FROM list_recipient_merges WHERE old_id <> new_id);
+
+-- deliveries of merged list recipients are moved to the one they're merged into, unless it has one to the
+-- destination already, the latest of them being moved where several have one to the same destination
+CREATE TABLE delivery_merges (
+  id INTEGER NOT NULL PRIMARY KEY,
+  new_list_recipient_id INTEGER NOT NULL
+);
+
+INSERT INTO delivery_merges (id, new_list_recipient_id)
+SELECT MAX(d.id), lrm.new_id
+FROM list_recipient_deliveries d
+INNER JOIN list_recipient_merges lrm ON lrm.old_id = d.list_recipient_id
+WHERE lrm.old_id <> lrm.new_id
+  AND NOT EXISTS (
+    SELECT 1
+    FROM list_recipient_deliveries w
+    WHERE w.list_recipient_id = lrm.new_id AND w.destination = d.destination
+  )
+GROUP BY lrm.new_id, d.destination;
+
+UPDATE list_recipient_deliveries
+SET list_recipient_id = (
+  SELECT new_list_recipient_id FROM delivery_merges WHERE id = list_recipient_deliveries.id
+)
+WHERE id IN (SELECT id FROM delivery_merges);
+
+DELETE FROM list_recipient_deliveries
+WHERE list_recipient_id IN (SELECT old_id FROM list_recipient_merges WHERE old_id <> new_id);
+
+DELETE FROM list_recipient_attributes
+WHERE list_recipient_id IN (SELECT old_id FROM list_recipient_merges WHERE old_id <> new_id);
+
+DELETE FROM list_recipients
+WHERE id IN (SELECT old_id FROM list_recipient_merges WHERE old_id <> new_id);
+
+UPDATE list_recipients
+SET recipient_id = (
+  SELECT new_id FROM recipient_merges WHERE old_id = list_recipients.recipient_id
+)
+WHERE recipient_id IN (SELECT old_id FROM recipient_merges WHERE old_id <> new_id);
+
+DELETE FROM recipients
+WHERE id IN (SELECT old_id FROM recipient_merges WHERE old_id <> new_id);
+
+UPDATE recipients SET email = LOWER(TRIM(email));
+
+DROP TABLE delivery_merges;
+DROP TABLE list_recipient_merges;
+DROP TABLE recipient_merges;
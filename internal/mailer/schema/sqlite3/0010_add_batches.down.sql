@@ -0,0 +1,4 @@
+-- the column is left unused, since older versions of SQLite can't drop columns
+UPDATE list_recipients SET batch_id = NULL;
+
+DROP TABLE batches;
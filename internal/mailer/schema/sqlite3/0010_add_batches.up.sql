@@ -0,0 +1,9 @@
+CREATE TABLE batches (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  list_id VARCHAR(128) NOT NULL,
+  remote_id VARCHAR(64) NOT NULL,
+  created TIMESTAMP NOT NULL
+);
+
+ALTER TABLE list_recipients ADD COLUMN batch_id INTEGER NULL
+  CONSTRAINT fk_list_recipients_batch_id REFERENCES batches (id);
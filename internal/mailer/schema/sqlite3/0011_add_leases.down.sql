@@ -0,0 +1,3 @@
+-- the columns are left unused, since older versions of SQLite can't drop columns
+UPDATE list_recipient_deliveries SET lease_owner = NULL, lease_expiry = NULL;
+UPDATE list_recipients SET lease_owner = NULL, lease_expiry = NULL;
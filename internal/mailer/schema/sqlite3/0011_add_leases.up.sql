@@ -0,0 +1,5 @@
+ALTER TABLE list_recipients ADD COLUMN lease_owner VARCHAR(64) NULL;
+ALTER TABLE list_recipients ADD COLUMN lease_expiry TIMESTAMP NULL;
+
+ALTER TABLE list_recipient_deliveries ADD COLUMN lease_owner VARCHAR(64) NULL;
+ALTER TABLE list_recipient_deliveries ADD COLUMN lease_expiry TIMESTAMP NULL;
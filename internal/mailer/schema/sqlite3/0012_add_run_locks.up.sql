@@ -0,0 +1,6 @@
+-- only SQLite, which has no named locks held by a connection, locks runs with rows of this table
+CREATE TABLE run_locks (
+  name VARCHAR(64) NOT NULL PRIMARY KEY,
+  owner VARCHAR(64) NOT NULL,
+  expiry TIMESTAMP NOT NULL
+);
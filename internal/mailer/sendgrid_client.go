@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// sendGridClient manages contacts of SendGrid Marketing Campaigns lists, see
+// https://www.twilio.com/docs/sendgrid/api-reference/contacts
+type sendGridClient struct {
+	ops         *providerOperations
+	mergeFields MergeFields
+}
+
+type sendGridUpsertContactsRequest struct {
+	ListIDs  []string            `json:"list_ids"`
+	Contacts []map[string]string `json:"contacts"`
+}
+
+type sendGridSearchEmailsRequest struct {
+	Emails []string `json:"emails"`
+}
+
+type sendGridSearchEmailsResponse struct {
+	Result map[string]struct {
+		Contact sendGridContact `json:"contact"`
+	} `json:"result"`
+}
+
+type sendGridContact struct {
+	ID      string   `json:"id"`
+	ListIDs []string `json:"list_ids"`
+}
+
+func newSendGridClient(log *Loggers, listID string, config ProviderConfig) (Client, error) {
+	if config.doubleOptIn {
+		return nil, errDoubleOptInNotSupported
+	}
+	return &sendGridClient{
+		ops: &providerOperations{
+			provider: "sendgrid",
+			ops:      &http.Client{},
+			baseURL:  getBaseURL(config, "https://api.sendgrid.com/v3"),
+			authorize: func(req *http.Request, body []byte) {
+				req.Header.Set("Authorization", "Bearer "+config.apiKey)
+			},
+		},
+		mergeFields: config.mergeFields,
+	}, nil
+}
+
+// Subscribe upserts the contact, adding it to the list. SendGrid processes the upsert asynchronously.
+func (c *sendGridClient) Subscribe(s subscription) (RecipientStatus, error) {
+	contact := getProviderAttributes(s, c.mergeFields)
+	contact["email"] = s.email
+
+	request := sendGridUpsertContactsRequest{ListIDs: []string{s.listID}, Contacts: []map[string]string{contact}}
+
+	err := c.ops.executeJSON("PUT", "/marketing/contacts", request, nil)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	return RecipientStatuses.Get("subscribed"), nil
+}
+
+// Unsubscribe removes the contact from the list, if it exists and is still in it.
+func (c *sendGridClient) Unsubscribe(s subscription) error {
+	contact, found, err := c.findContact(s.email)
+	if err != nil || !found {
+		return err
+	}
+
+	path := fmt.Sprintf("/marketing/lists/%s/contacts?contact_ids=%s", url.PathEscape(s.listID),
+		url.QueryEscape(contact.ID))
+
+	err = c.ops.executeJSON("DELETE", path, nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// GetStatus gets whether the contact is in the list.
+func (c *sendGridClient) GetStatus(s subscription) (RecipientStatus, error) {
+	contact, found, err := c.findContact(s.email)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+
+	if found {
+		for _, id := range contact.ListIDs {
+			if id == s.listID {
+				return RecipientStatuses.Get("subscribed"), nil
+			}
+		}
+	}
+
+	return RecipientStatuses.Get("unsubscribed"), nil
+}
+
+func (c *sendGridClient) findContact(email string) (contact sendGridContact, found bool, err error) {
+	var response sendGridSearchEmailsResponse
+	err = c.ops.executeJSON("POST", "/marketing/contacts/search/emails",
+		sendGridSearchEmailsRequest{Emails: []string{email}}, &response)
+	if isNotFound(err) {
+		return contact, false, nil
+	} else if err != nil {
+		return contact, false, err
+	}
+
+	for _, r := range response.Result {
+		if r.Contact.ID != "" {
+			return r.Contact, true, nil
+		}
+	}
+
+	return contact, false, nil
+}
@@ -0,0 +1,173 @@
+package mailer
+
+import (
+	"testing"
+)
+
+func TestSendGridClient(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		testMethod   func(c Client, s subscription) (RecipientStatus, error)
+		subscription subscription
+		responses    []providerTestResponse
+
+		expectedRequests  []providerTestRequest
+		expectedStatus    RecipientStatus
+		expectedError     string
+		expectedPermanent bool
+	}{
+		{
+			label: "subscribe upserts contact",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc", attribs: map[string]string{"first_name": "Ron"}},
+			responses:    []providerTestResponse{{status: 202, body: `{"job_id":"1"}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "PUT", uri: "/marketing/contacts",
+					body: `{"list_ids":["abc"],"contacts":[{"FIRSTNAME":"Ron","email":"a@b.com"}]}`},
+			},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "subscribe returns transient error on list not found",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses:    []providerTestResponse{{status: 404, body: `{"errors":[{"message":"list not found"}]}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "PUT", uri: "/marketing/contacts", body: `{"list_ids":["abc"],"contacts":[{"email":"a@b.com"}]}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "HTTP status 404",
+		},
+		{
+			label: "unsubscribe removes found contact from list",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses: []providerTestResponse{
+				{status: 200, body: `{"result":{"a@b.com":{"contact":{"id":"c1","list_ids":["abc"]}}}}`},
+				{status: 202, body: `{"job_id":"2"}`},
+			},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/marketing/contacts/search/emails", body: `{"emails":["a@b.com"]}`},
+				{method: "DELETE", uri: "/marketing/lists/abc/contacts?contact_ids=c1"},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "unsubscribe succeeds on contact not found in list",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses: []providerTestResponse{
+				{status: 200, body: `{"result":{"a@b.com":{"contact":{"id":"c1","list_ids":["abc"]}}}}`},
+				{status: 404, body: `{"errors":[{"message":"list not found"}]}`},
+			},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/marketing/contacts/search/emails", body: `{"emails":["a@b.com"]}`},
+				{method: "DELETE", uri: "/marketing/lists/abc/contacts?contact_ids=c1"},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "unsubscribe does nothing for unknown contact",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses:    []providerTestResponse{{status: 404, body: `{"errors":[]}`}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/marketing/contacts/search/emails", body: `{"emails":["a@b.com"]}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "unsubscribe returns error on search error",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses:    []providerTestResponse{{status: 500}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/marketing/contacts/search/emails", body: `{"emails":["a@b.com"]}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "HTTP status 500",
+		},
+		{
+			label: "get status of contact in list",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses: []providerTestResponse{
+				{status: 200, body: `{"result":{"a@b.com":{"contact":{"id":"c1","list_ids":["xyz","abc"]}}}}`},
+			},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/marketing/contacts/search/emails", body: `{"emails":["a@b.com"]}`},
+			},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "get status of contact not in list",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses: []providerTestResponse{
+				{status: 200, body: `{"result":{"a@b.com":{"contact":{"id":"c1","list_ids":["xyz"]}}}}`},
+			},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/marketing/contacts/search/emails", body: `{"emails":["a@b.com"]}`},
+			},
+			expectedStatus: RecipientStatuses.Get("unsubscribed"),
+		},
+	}
+
+	for _, tc := range testCases {
+		s := newProviderTestServer(tc.responses...)
+		c := newProviderTestClient(t, "sendgrid", "abc", s)
+
+		status, err := tc.testMethod(c, tc.subscription)
+		s.Close()
+
+		assertProviderTestRequests(t, tc.label, s.requests, tc.expectedRequests)
+		if status != tc.expectedStatus {
+			t.Errorf("%v: result status got %v, want %v", tc.label, status, tc.expectedStatus)
+		}
+		assertProviderTestError(t, tc.label, err, tc.expectedError)
+		if isPermanent(err) != tc.expectedPermanent {
+			t.Errorf("%v: permanent got %v, want %v", tc.label, isPermanent(err), tc.expectedPermanent)
+		}
+	}
+
+	s := newProviderTestServer()
+	defer s.Close()
+	newProviderTestClient(t, "sendgrid", "abc", s).Subscribe(subscription{email: "a@b.com", listID: "abc"})
+
+	if actual := s.requests[0].header.Get("Authorization"); actual != "Bearer key" {
+		t.Errorf("Authorization header got %q, want %q", actual, "Bearer key")
+	}
+}
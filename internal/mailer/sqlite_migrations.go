@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+
+	"github.com/mattes/migrate/database"
+)
+
+const sqliteMigrationsTable = "schema_migrations"
+
+// sqliteMigrationDriver applies migrations to an SQLite database, for which migrate has no driver of its own.
+type sqliteMigrationDriver struct {
+	db       *sql.DB
+	isLocked bool
+}
+
+func newSQLiteMigrationDriver(db *sql.DB) (database.Driver, error) {
+	_, err := db.Exec(`create table if not exists ` + sqliteMigrationsTable + ` (version bigint not null primary key,
+		dirty boolean not null)`)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Err: "couldn't create migrations table"}
+	}
+	return &sqliteMigrationDriver{db: db}, nil
+}
+
+// Open isn't supported, since the driver is only created with an existing connection.
+func (d *sqliteMigrationDriver) Open(url string) (database.Driver, error) {
+	return nil, &database.Error{Err: "sqlite migration driver can't open " + url}
+}
+
+func (d *sqliteMigrationDriver) Close() error {
+	return nil
+}
+
+// Lock only guards against concurrent migrations by this driver, since SQLite locks the whole database on write
+// anyway.
+func (d *sqliteMigrationDriver) Lock() error {
+	if d.isLocked {
+		return database.ErrLocked
+	}
+	d.isLocked = true
+	return nil
+}
+
+func (d *sqliteMigrationDriver) Unlock() error {
+	d.isLocked = false
+	return nil
+}
+
+func (d *sqliteMigrationDriver) Run(migration io.Reader) error {
+	migr, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(string(migr)); err != nil {
+		return database.Error{OrigErr: err, Err: "migration failed", Query: migr}
+	}
+	return nil
+}
+
+func (d *sqliteMigrationDriver) SetVersion(version int, dirty bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction start failed"}
+	}
+
+	query := `delete from ` + sqliteMigrationsTable
+	if _, err := tx.Exec(query); err != nil {
+		tx.Rollback()
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	if version >= 0 {
+		query = `insert into ` + sqliteMigrationsTable + ` (version, dirty) values (?, ?)`
+		if _, err := tx.Exec(query, version, dirty); err != nil {
+			tx.Rollback()
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+	}
+	return nil
+}
+
+func (d *sqliteMigrationDriver) Version() (version int, dirty bool, err error) {
+	query := `select version, dirty from ` + sqliteMigrationsTable + ` limit 1`
+	err = d.db.QueryRow(query).Scan(&version, &dirty)
+	switch {
+	case err == sql.ErrNoRows:
+		return database.NilVersion, false, nil
+	case err != nil:
+		return 0, false, &database.Error{OrigErr: err, Query: []byte(query)}
+	default:
+		return version, dirty, nil
+	}
+}
+
+func (d *sqliteMigrationDriver) Drop() error {
+	query := `select name from sqlite_master where type = 'table' and name not like 'sqlite_%'`
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if _, err := d.db.Exec(`pragma foreign_keys = off`); err != nil {
+		return err
+	}
+	defer d.db.Exec(`pragma foreign_keys = on`)
+
+	for _, table := range tables {
+		query = `drop table if exists "` + table + `"`
+		if _, err := d.db.Exec(query); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+	}
+
+	_, err = newSQLiteMigrationDriver(d.db)
+	return err
+}
@@ -3,6 +3,10 @@ package mailer
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -170,3 +174,131 @@ func TestErrorMessageStartsWith(t *testing.T) {
 		}
 	}
 }
+
+func errorMessageContains(err error, substr string) bool {
+	if err == nil {
+		return substr == ""
+	}
+	if substr == "" {
+		return false
+	}
+	return strings.Contains(fmt.Sprintf("%v", err), substr)
+}
+
+func TestErrorMessageContains(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		err    error
+		substr string
+
+		expected bool
+	}{
+		{
+			label:    "err is nil and substr is empty",
+			err:      nil,
+			substr:   "",
+			expected: true,
+		},
+		{
+			label:    "err is nil and substr is not empty",
+			err:      nil,
+			substr:   "x",
+			expected: false,
+		},
+		{
+			label:    "err is not nil and substr is empty",
+			err:      errors.New("x"),
+			substr:   "",
+			expected: false,
+		},
+		{
+			label:    "err is not nil and err message doesn't contain substr",
+			err:      errors.New("x: 1"),
+			substr:   "y",
+			expected: false,
+		},
+		{
+			label:    "err is not nil and err message contains substr",
+			err:      errors.New("x: 1"),
+			substr:   "1",
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		res := errorMessageContains(tc.err, tc.substr)
+
+		if res != tc.expected {
+			t.Errorf("%v: result got %v, want %v", tc.label, res, tc.expected)
+		}
+	}
+}
+
+type providerTestRequest struct {
+	method string
+	uri    string
+	header http.Header
+	body   string
+}
+
+type providerTestResponse struct {
+	status int
+	body   string
+}
+
+// providerTestServer stands in for a provider API, recording requests and serving responses in order, then
+// 200 responses with an empty JSON object.
+type providerTestServer struct {
+	*httptest.Server
+	requests  []providerTestRequest
+	responses []providerTestResponse
+}
+
+func newProviderTestServer(responses ...providerTestResponse) *providerTestServer {
+	s := &providerTestServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.requests = append(s.requests, providerTestRequest{method: r.Method, uri: r.URL.RequestURI(),
+			header: r.Header, body: string(body)})
+
+		resp := providerTestResponse{status: 200, body: "{}"}
+		if len(s.responses) > 0 {
+			resp, s.responses = s.responses[0], s.responses[1:]
+		}
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	return s
+}
+
+func newProviderTestClient(t *testing.T, provider string, listID string, s *providerTestServer) Client {
+	c, err := NewProviderClient(NOOPLog, listID, NewProviderConfig(provider, "key", s.URL,
+		MergeFields{"first_name": "FIRSTNAME"}, false, WebhookConfig{}))
+	if err != nil {
+		t.Fatalf("couldn't create %s client: %v", provider, err)
+	}
+	return c
+}
+
+func assertProviderTestRequests(t *testing.T, label string, actual []providerTestRequest, expected []providerTestRequest) {
+	if len(actual) != len(expected) {
+		t.Errorf("%v: requests got %d, want %d", label, len(actual), len(expected))
+		return
+	}
+	for i := range actual {
+		a := actual[i]
+		a.header = nil
+		if !reflect.DeepEqual(a, expected[i]) {
+			t.Errorf("%v: request %d got %v, want %v", label, i, a, expected[i])
+		}
+	}
+}
+
+func assertProviderTestError(t *testing.T, label string, err error, expected string) {
+	if expected == "" && err != nil {
+		t.Errorf("%v: result error got %q, want nil", label, err)
+	} else if expected != "" && !errorMessageContains(err, expected) {
+		t.Errorf("%v: result error got %q, want containing %q", label, err, expected)
+	}
+}
@@ -0,0 +1,172 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// WebhookConfig configures the webhook provider: the secret its requests are signed with, the template of their
+// bodies, and the maximum number of attempts to send each event.
+type WebhookConfig struct {
+	secret      string
+	template    string
+	maxAttempts int
+}
+
+// NewWebhookConfig creates webhook configuration. Without a secret, requests aren't signed; without a template,
+// events are posted as JSON objects with type, email, listId and attributes; and a max attempts of zero is the
+// default, 3.
+func NewWebhookConfig(secret string, template string, maxAttempts int) WebhookConfig {
+	return WebhookConfig{secret: secret, template: template, maxAttempts: maxAttempts}
+}
+
+const (
+	webhookDefaultMaxAttempts = 3
+	webhookRetryDelay         = time.Second
+)
+
+// webhookClient posts subscribe and unsubscribe events to a URL, e.g. of an internal system.
+type webhookClient struct {
+	ops         *providerOperations
+	mergeFields MergeFields
+	template    *template.Template
+	maxAttempts int
+	sleep       func(d time.Duration)
+}
+
+// webhookClientEvent is posted as the request body, or is the data of the body template.
+type webhookClientEvent struct {
+	Type       string            `json:"type"`
+	Email      string            `json:"email"`
+	ListID     string            `json:"listId"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// newWebhookClient creates a client posting events to the configured base URL, with the API key, if any, as a
+// bearer token. Attributes are posted unchanged unless merge fields are configured.
+func newWebhookClient(log *Loggers, listID string, config ProviderConfig) (Client, error) {
+	if config.doubleOptIn {
+		return nil, errDoubleOptInNotSupported
+	}
+	if config.baseURL == "" {
+		return nil, errors.New("webhook URL is required")
+	}
+
+	var tmpl *template.Template
+	if config.webhook.template != "" {
+		var err error
+		tmpl, err = template.New("webhook").Funcs(webhookTemplateFuncs).Option("missingkey=zero").
+			Parse(config.webhook.template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: %v", err)
+		}
+	}
+
+	maxAttempts := config.webhook.maxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = webhookDefaultMaxAttempts
+	}
+
+	return &webhookClient{
+		ops: &providerOperations{
+			provider: "webhook",
+			ops:      &http.Client{},
+			baseURL:  config.baseURL,
+			authorize: func(req *http.Request, body []byte) {
+				if config.apiKey != "" {
+					req.Header.Set("Authorization", "Bearer "+config.apiKey)
+				}
+				if config.webhook.secret != "" {
+					req.Header.Set(signatureHeader, signBody([]byte(config.webhook.secret), body))
+				}
+			},
+		},
+		mergeFields: config.mergeFields,
+		template:    tmpl,
+		maxAttempts: maxAttempts,
+		sleep:       time.Sleep,
+	}, nil
+}
+
+func (c *webhookClient) Subscribe(s subscription) (RecipientStatus, error) {
+	err := c.post("subscribe", s)
+	if err != nil {
+		return RecipientStatuses.None, err
+	}
+	return RecipientStatuses.Get("subscribed"), nil
+}
+
+func (c *webhookClient) Unsubscribe(s subscription) error {
+	return c.post("unsubscribe", s)
+}
+
+// GetStatus isn't supported, since webhook recipients are never pending.
+func (c *webhookClient) GetStatus(s subscription) (RecipientStatus, error) {
+	return RecipientStatuses.None, errors.New("webhook client can't get status")
+}
+
+// post posts the event, retrying with a doubling delay while the error is transient, up to the maximum number of
+// attempts.
+func (c *webhookClient) post(eventType string, s subscription) error {
+	attribs := s.attribs
+	if len(c.mergeFields) > 0 {
+		attribs = getProviderAttributes(s, c.mergeFields)
+	}
+
+	body, err := c.getBody(webhookClientEvent{Type: eventType, Email: s.email, ListID: s.listID, Attributes: attribs})
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = c.ops.execute("POST", "", body, "application/json", nil)
+		if err == nil || attempt >= c.maxAttempts || !isTransientWebhookError(err) {
+			return err
+		}
+		c.sleep(delay)
+		delay *= 2
+	}
+}
+
+func (c *webhookClient) getBody(event webhookClientEvent) ([]byte, error) {
+	if c.template == nil {
+		b, err := json.Marshal(event)
+		if err != nil {
+			panic(err)
+		}
+		return b, nil
+	}
+
+	b := &bytes.Buffer{}
+	err := c.template.Execute(b, event)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute webhook template: %v", err)
+	}
+	if !json.Valid(b.Bytes()) {
+		return nil, fmt.Errorf("webhook template produced invalid json: %s", b.String())
+	}
+	return b.Bytes(), nil
+}
+
+// isTransientWebhookError is true if the request couldn't be sent, or the response was a server error, timeout
+// or rate limit.
+func isTransientWebhookError(err error) bool {
+	e, ok := err.(*ProviderError)
+	if !ok {
+		return true
+	}
+	return e.Status >= 500 || e.Status == http.StatusRequestTimeout || e.Status == http.StatusTooManyRequests
+}
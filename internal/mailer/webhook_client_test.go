@@ -0,0 +1,199 @@
+package mailer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWebhookClient(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		config       WebhookConfig
+		testMethod   func(c Client, s subscription) (RecipientStatus, error)
+		subscription subscription
+		responses    []providerTestResponse
+
+		expectedRequests []providerTestRequest
+		expectedSleeps   []time.Duration
+		expectedStatus   RecipientStatus
+		expectedError    string
+	}{
+		{
+			label: "subscribe posts event",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc", attribs: map[string]string{"first_name": "Ron"}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/",
+					body: `{"type":"subscribe","email":"a@b.com","listId":"abc","attributes":{"FIRSTNAME":"Ron"}}`},
+			},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "subscribe posts templated event",
+
+			config: NewWebhookConfig("", `{"text": {{printf "%s subscribed to %s" .Email .ListID | json}}, `+
+				`"name": {{index .Attributes "FIRSTNAME" | json}}}`, 0),
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc", attribs: map[string]string{"first_name": "R\"on"}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/", body: `{"text": "a@b.com subscribed to abc", "name": "R\"on"}`},
+			},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "subscribe returns error on template producing invalid json",
+
+			config: NewWebhookConfig("", `{{.Email}}`, 0),
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "webhook template produced invalid json: a@b.com",
+		},
+		{
+			label: "subscribe retries on transient error",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses:    []providerTestResponse{{status: 503}, {status: 429}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/", body: `{"type":"subscribe","email":"a@b.com","listId":"abc"}`},
+				{method: "POST", uri: "/", body: `{"type":"subscribe","email":"a@b.com","listId":"abc"}`},
+				{method: "POST", uri: "/", body: `{"type":"subscribe","email":"a@b.com","listId":"abc"}`},
+			},
+			expectedSleeps: []time.Duration{time.Second, 2 * time.Second},
+			expectedStatus: RecipientStatuses.Get("subscribed"),
+		},
+		{
+			label: "subscribe returns error after max attempts",
+
+			config: NewWebhookConfig("", "", 2),
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses:    []providerTestResponse{{status: 503}, {status: 500}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/", body: `{"type":"subscribe","email":"a@b.com","listId":"abc"}`},
+				{method: "POST", uri: "/", body: `{"type":"subscribe","email":"a@b.com","listId":"abc"}`},
+			},
+			expectedSleeps: []time.Duration{time.Second},
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "HTTP status 500",
+		},
+		{
+			label: "subscribe doesn't retry on client error",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.Subscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+			responses:    []providerTestResponse{{status: 400}},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/", body: `{"type":"subscribe","email":"a@b.com","listId":"abc"}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "HTTP status 400",
+		},
+		{
+			label: "unsubscribe posts event",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return RecipientStatuses.None, c.Unsubscribe(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+
+			expectedRequests: []providerTestRequest{
+				{method: "POST", uri: "/", body: `{"type":"unsubscribe","email":"a@b.com","listId":"abc"}`},
+			},
+			expectedStatus: RecipientStatuses.None,
+		},
+		{
+			label: "get status isn't supported",
+
+			testMethod: func(c Client, s subscription) (RecipientStatus, error) {
+				return c.GetStatus(s)
+			},
+			subscription: subscription{email: "a@b.com", listID: "abc"},
+
+			expectedStatus: RecipientStatuses.None,
+			expectedError:  "can't get status",
+		},
+	}
+
+	for _, tc := range testCases {
+		s := newProviderTestServer(tc.responses...)
+		c, err := newWebhookClient(NOOPLog, "abc", NewProviderConfig("webhook", "key", s.URL,
+			MergeFields{"first_name": "FIRSTNAME"}, false, tc.config))
+		if err != nil {
+			t.Fatalf("%v: couldn't create client: %v", tc.label, err)
+		}
+		var sleeps []time.Duration
+		c.(*webhookClient).sleep = func(d time.Duration) {
+			sleeps = append(sleeps, d)
+		}
+
+		status, err := tc.testMethod(c, tc.subscription)
+		s.Close()
+
+		assertProviderTestRequests(t, tc.label, s.requests, tc.expectedRequests)
+		if !reflect.DeepEqual(sleeps, tc.expectedSleeps) {
+			t.Errorf("%v: sleeps got %v, want %v", tc.label, sleeps, tc.expectedSleeps)
+		}
+		if status != tc.expectedStatus {
+			t.Errorf("%v: result status got %v, want %v", tc.label, status, tc.expectedStatus)
+		}
+		assertProviderTestError(t, tc.label, err, tc.expectedError)
+	}
+
+	s := newProviderTestServer()
+	defer s.Close()
+	newProviderTestClient(t, "webhook", "abc", s).Unsubscribe(subscription{email: "a@b.com", listID: "abc"})
+
+	if actual := s.requests[0].header.Get("Authorization"); actual != "Bearer key" {
+		t.Errorf("Authorization header got %q, want %q", actual, "Bearer key")
+	}
+	if actual := s.requests[0].header.Get("X-Mailsling-Signature"); actual != "" {
+		t.Errorf("X-Mailsling-Signature header without secret got %q, want none", actual)
+	}
+}
+
+func TestWebhookClient_Signature(t *testing.T) {
+	s := newProviderTestServer()
+	defer s.Close()
+
+	c, _ := newWebhookClient(NOOPLog, "abc", NewProviderConfig("webhook", "", s.URL, nil, false,
+		NewWebhookConfig("secret", "", 0)))
+	c.Unsubscribe(subscription{email: "a@b.com", listID: "abc"})
+
+	// echo -n '{"type":"unsubscribe","email":"a@b.com","listId":"abc"}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=eb96205ff6649510b31e34947ea5d43d8daf87c6e9b30cb8385d87953c41a370"
+	if actual := s.requests[0].header.Get("X-Mailsling-Signature"); actual != expected {
+		t.Errorf("X-Mailsling-Signature header got %q, want %q", actual, expected)
+	}
+}
+
+func TestNewWebhookClient_InvalidTemplate(t *testing.T) {
+	_, err := newWebhookClient(NOOPLog, "abc", NewProviderConfig("webhook", "", "http://x", nil, false,
+		NewWebhookConfig("", "{{", 0)))
+
+	if !errorMessageStartsWith(err, "invalid webhook template: ") {
+		t.Errorf("result error got %q, want prefix %q", err, "invalid webhook template: ")
+	}
+}
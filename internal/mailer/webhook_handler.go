@@ -0,0 +1,146 @@
+package mailer
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// webhookHandler receives MailChimp list webhooks, mirroring changes made in MailChimp itself, e.g. recipients
+// unsubscribing from a campaign, or addresses being cleaned after bouncing, in the journal. See
+// https://developer.mailchimp.com/documentation/mailchimp/guides/about-webhooks/
+type webhookHandler struct {
+	log         *Loggers
+	mailer      *Mailer
+	secret      []byte
+	mergeFields map[string]MergeFields
+}
+
+// NewWebhookHandler creates a handler for MailChimp list webhooks. The webhook URL must include the secret as
+// its "secret" query parameter, e.g. "https://example.com/webhooks/mailchimp?secret=...". Merge fields are
+// mapped back to list recipient attributes using the same mappings as subscribing.
+func NewWebhookHandler(log *Loggers, m *Mailer, secret string, mergeFields map[string]MergeFields) (http.Handler, error) {
+	if secret == "" {
+		return nil, errors.New("secret is required")
+	}
+	return &webhookHandler{log: log, mailer: m, secret: []byte(secret), mergeFields: mergeFields}, nil
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := []byte(r.URL.Query().Get("secret"))
+	if subtle.ConstantTimeCompare(token, h.secret) != 1 {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	// MailChimp validates the webhook URL with a GET request
+	if r.Method == http.MethodGet {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("couldn't read body: %v", err))
+		return
+	}
+	if len(body) > maxMessageSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body exceeds %d bytes", maxMessageSize))
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("couldn't parse body: %v", err))
+		return
+	}
+
+	err = h.handle(form, string(body))
+	if rejection, ok := err.(*messageRejection); ok {
+		writeError(w, http.StatusBadRequest, rejection.reason)
+		return
+	} else if err != nil {
+		h.log.Error.Printf("couldn't handle webhook %q: %v", body, err)
+		writeError(w, http.StatusInternalServerError, "couldn't handle webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *webhookHandler) handle(form url.Values, source string) error {
+	eventType := form.Get("type")
+	listID := form.Get("data[list_id]")
+
+	if listID == "" {
+		return &messageRejection{"webhook has no list ID"}
+	}
+
+	if eventType == "upemail" {
+		oldEmail, newEmail := normalizeEmail(form.Get("data[old_email]")), normalizeEmail(form.Get("data[new_email]"))
+		if oldEmail == "" || newEmail == "" {
+			return &messageRejection{"webhook has no old or new email"}
+		}
+		return h.mailer.journal.RenameRecipient(oldEmail, newEmail)
+	}
+
+	email := normalizeEmail(form.Get("data[email]"))
+
+	if email == "" {
+		return &messageRejection{"webhook has no email"}
+	}
+
+	switch eventType {
+	case "subscribe":
+		err := h.mailer.journal.SetListRecipientStatus(email, listID, RecipientStatuses.Get("subscribed"), "", source)
+		if err != nil {
+			return err
+		}
+		return h.updateAttributes(form, email, listID)
+	case "unsubscribe":
+		reason := getWebhookReason("unsubscribed in MailChimp", form)
+		return h.mailer.journal.SetListRecipientStatus(email, listID, RecipientStatuses.Get("unsubscribed"), reason, source)
+	case "cleaned":
+		reason := getWebhookReason("cleaned in MailChimp", form)
+		return h.mailer.journal.SetListRecipientStatus(email, listID, RecipientStatuses.Get("cleaned"), reason, source)
+	case "profile":
+		return h.updateAttributes(form, email, listID)
+	}
+
+	h.log.Info.Printf("ignoring webhook of type %q", eventType)
+	return nil
+}
+
+func getWebhookReason(description string, form url.Values) string {
+	if reason := form.Get("data[reason]"); reason != "" {
+		return description + ": " + reason
+	}
+	return description
+}
+
+// updateAttributes updates the attributes mapped to the merge fields in the webhook, if any.
+func (h *webhookHandler) updateAttributes(form url.Values, email string, listID string) error {
+	attribs := make(map[string]string)
+	for key, tag := range h.mergeFields[listID] {
+		name := fmt.Sprintf("data[merges][%s]", tag)
+		if values, ok := form[name]; ok && len(values) > 0 {
+			attribs[key] = values[0]
+		}
+	}
+
+	if len(attribs) == 0 {
+		return nil
+	}
+
+	return h.mailer.journal.UpdateListRecipientAttributes(email, listID, attribs)
+}
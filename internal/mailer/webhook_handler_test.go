@@ -0,0 +1,225 @@
+package mailer
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewWebhookHandler(t *testing.T) {
+	_, err := NewWebhookHandler(NOOPLog, &Mailer{}, "", nil)
+
+	if !errorMessageEquals(err, "secret is required") {
+		t.Errorf("result error got %q, want %q", err, "secret is required")
+	}
+}
+
+func TestWebhookHandler_ServeHTTP(t *testing.T) {
+	testCases := []struct {
+		label string
+
+		method string
+		target string
+		body   string
+
+		onRenameRecipient func(oldEmail string, newEmail string) error
+
+		expectedStatusReceived     []listRecipientStatusParams
+		expectedAttributesReceived []listRecipientAttributesParams
+		expectedRenamesReceived    []renameRecipientParams
+		expectedStatus             int
+		expectedBody               string
+	}{
+		{
+			label:  "on validation",
+			method: "GET",
+			target: "/webhooks/mailchimp?secret=secret",
+
+			expectedStatus: 200,
+		},
+		{
+			label:  "on wrong secret",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=x",
+			body:   "type=unsubscribe&data[list_id]=a&data[email]=x",
+
+			expectedStatus: 401,
+			expectedBody:   "unauthorized\n",
+		},
+		{
+			label:  "on wrong method",
+			method: "PUT",
+			target: "/webhooks/mailchimp?secret=secret",
+
+			expectedStatus: 405,
+			expectedBody:   "method not allowed\n",
+		},
+		{
+			label:  "on subscribe",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=subscribe&data[list_id]=a&data[email]=x&data[merges][FNAME]=Ron&data[merges][LNAME]=Perlman",
+
+			expectedStatusReceived: []listRecipientStatusParams{
+				{email: "x", listID: "a", status: RecipientStatuses.Get("subscribed"),
+					source: "type=subscribe&data[list_id]=a&data[email]=x&data[merges][FNAME]=Ron&data[merges][LNAME]=Perlman"},
+			},
+			expectedAttributesReceived: []listRecipientAttributesParams{
+				{email: "x", listID: "a", attribs: map[string]string{"first_name": "Ron"}},
+			},
+			expectedStatus: 200,
+		},
+		{
+			label:  "on unsubscribe",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=unsubscribe&data[list_id]=a&data[email]=x&data[action]=unsub&data[reason]=manual",
+
+			expectedStatusReceived: []listRecipientStatusParams{
+				{email: "x", listID: "a", status: RecipientStatuses.Get("unsubscribed"), reason: "unsubscribed in MailChimp: manual",
+					source: "type=unsubscribe&data[list_id]=a&data[email]=x&data[action]=unsub&data[reason]=manual"},
+			},
+			expectedStatus: 200,
+		},
+		{
+			label:  "on cleaned",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=cleaned&data[list_id]=a&data[email]=x&data[reason]=hard",
+
+			expectedStatusReceived: []listRecipientStatusParams{
+				{email: "x", listID: "a", status: RecipientStatuses.Get("cleaned"), reason: "cleaned in MailChimp: hard",
+					source: "type=cleaned&data[list_id]=a&data[email]=x&data[reason]=hard"},
+			},
+			expectedStatus: 200,
+		},
+		{
+			label:  "on profile",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=profile&data[list_id]=a&data[email]=x&data[merges][FNAME]=Ron",
+
+			expectedAttributesReceived: []listRecipientAttributesParams{
+				{email: "x", listID: "a", attribs: map[string]string{"first_name": "Ron"}},
+			},
+			expectedStatus: 200,
+		},
+		{
+			label:  "on profile without mapped merge fields",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=profile&data[list_id]=b&data[email]=x&data[merges][FNAME]=Ron",
+
+			expectedStatus: 200,
+		},
+		{
+			label:  "on upemail",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=upemail&data[list_id]=a&data[old_email]=x&data[new_email]=y",
+
+			expectedRenamesReceived: []renameRecipientParams{{oldEmail: "x", newEmail: "y"}},
+			expectedStatus:          200,
+		},
+		{
+			label:  "on upemail to normalize",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=upemail&data[list_id]=a&data[old_email]=X%40A.com&data[new_email]=+Y%40A.com",
+
+			expectedRenamesReceived: []renameRecipientParams{{oldEmail: "x@a.com", newEmail: "y@a.com"}},
+			expectedStatus:          200,
+		},
+		{
+			label:  "on unknown type",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=campaign&data[list_id]=a&data[email]=x",
+
+			expectedStatus: 200,
+		},
+		{
+			label:  "on no email",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=unsubscribe&data[list_id]=a",
+
+			expectedStatus: 400,
+			expectedBody:   "webhook has no email\n",
+		},
+		{
+			label:  "on no list ID",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=unsubscribe&data[email]=x",
+
+			expectedStatus: 400,
+			expectedBody:   "webhook has no list ID\n",
+		},
+		{
+			label:  "on journal error",
+			method: "POST",
+			target: "/webhooks/mailchimp?secret=secret",
+			body:   "type=upemail&data[list_id]=a&data[old_email]=x&data[new_email]=y",
+
+			onRenameRecipient: func(oldEmail string, newEmail string) error {
+				return errors.New("x")
+			},
+
+			expectedRenamesReceived: []renameRecipientParams{{oldEmail: "x", newEmail: "y"}},
+			expectedStatus:          500,
+			expectedBody:            "couldn't handle webhook\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		j := &testJournal{onRenameRecipient: tc.onRenameRecipient}
+		mergeFields := map[string]MergeFields{"a": {"first_name": "FNAME"}}
+		h, _ := NewWebhookHandler(NOOPLog, &Mailer{log: NOOPLog, journal: j}, "secret", mergeFields)
+
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		rec := httptest.NewRecorder()
+
+		h.ServeHTTP(rec, req)
+
+		if !reflect.DeepEqual(j.listRecipientStatusReceived, tc.expectedStatusReceived) {
+			t.Errorf("%v: invoked SetListRecipientStatus got %v, want %v", tc.label, j.listRecipientStatusReceived, tc.expectedStatusReceived)
+		}
+		if !reflect.DeepEqual(j.attributesReceived, tc.expectedAttributesReceived) {
+			t.Errorf("%v: invoked UpdateListRecipientAttributes got %v, want %v", tc.label, j.attributesReceived, tc.expectedAttributesReceived)
+		}
+		if !reflect.DeepEqual(j.renamesReceived, tc.expectedRenamesReceived) {
+			t.Errorf("%v: invoked RenameRecipient got %v, want %v", tc.label, j.renamesReceived, tc.expectedRenamesReceived)
+		}
+		if rec.Code != tc.expectedStatus {
+			t.Errorf("%v: response status got %d, want %d", tc.label, rec.Code, tc.expectedStatus)
+		}
+		if body := rec.Body.String(); body != tc.expectedBody {
+			t.Errorf("%v: response body got %q, want %q", tc.label, body, tc.expectedBody)
+		}
+	}
+}
+
+func TestWebhookHandler_ServeHTTPUpemailToExistingRecipient(t *testing.T) {
+	subscribed := RecipientStatuses.Get("subscribed")
+	j := &repositoryJournal{log: NOOPLog, repo: NewMemoryRepository(), clock: &testClock{}}
+	j.SetListRecipientStatus("x@a.com", "a", subscribed, "", "{}")
+	j.SetListRecipientStatus("y@a.com", "a", subscribed, "", "{}")
+	h, _ := NewWebhookHandler(NOOPLog, &Mailer{log: NOOPLog, journal: j}, "secret", nil)
+
+	req := httptest.NewRequest("POST", "/webhooks/mailchimp?secret=secret",
+		strings.NewReader("type=upemail&data[list_id]=a&data[old_email]=x%40a.com&data[new_email]=y%40a.com"))
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != 200 {
+		t.Errorf("response status got %d, want 200, so that MailChimp doesn't retry", rec.Code)
+	}
+	if local, _ := getListRecipients(j, "a"); len(local) != 2 || local[0].email != "x@a.com" ||
+		local[1].email != "y@a.com" {
+		t.Errorf("list recipients got %v, want x@a.com and y@a.com unchanged", local)
+	}
+}